@@ -7,16 +7,21 @@ import (
 	"strconv"
 
 	"github.com/getsentry/raven-go"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/kiwicom/iam/api/grpc/v1"
 	"github.com/kiwicom/iam/internal/security"
-	"github.com/kiwicom/iam/internal/services/okta"
+	"github.com/kiwicom/iam/internal/services/directory"
 )
 
+var errUpstreamUnavailable = status.Errorf(codes.Unavailable, "identity provider is unavailable")
+
 type userDataService interface {
-	GetUser(string) (okta.User, error)
-	AddPermissions(*okta.User, string) error
+	GetUser(string) (directory.User, error)
+	AddPermissions(*directory.User, string) error
 }
 
 // Server is an instance of the GRPC server struct which includes all dependencies
@@ -32,6 +37,9 @@ func CreateServer(userServiceClient userDataService) (*Server, error) {
 // User returns a single user based on email
 func (s *Server) User(ctx context.Context, in *pb.UserRequest) (*pb.UserResponse, error) {
 	user, userErr := s.userService.GetUser(in.Email)
+	if errors.Is(userErr, directory.ErrUpstreamUnavailable) {
+		return nil, errUpstreamUnavailable
+	}
 	if userErr != nil {
 		return nil, userErr
 	}
@@ -47,9 +55,9 @@ func (s *Server) User(ctx context.Context, in *pb.UserRequest) (*pb.UserResponse
 	}
 
 	serviceName := in.Service
+	service, getServiceErr := security.GetService(md[metadataUserAgent][0])
 
 	if serviceName == "" {
-		service, getServiceErr := security.GetService(md[metadataUserAgent][0])
 		if getServiceErr != nil {
 			return nil, errBadUA
 		}
@@ -57,7 +65,11 @@ func (s *Server) User(ctx context.Context, in *pb.UserRequest) (*pb.UserResponse
 		serviceName = service.Name
 	}
 
-	permErr := s.userService.AddPermissions(&user, serviceName)
+	// Permissions are scoped to the environment of the caller
+	permErr := s.userService.AddPermissions(&user, directory.ScopedService(serviceName, service.Environment))
+	if errors.Is(permErr, directory.ErrUpstreamUnavailable) {
+		return nil, errUpstreamUnavailable
+	}
 	if permErr != nil {
 		log.Println("[ERROR]", permErr.Error())
 		raven.CaptureError(permErr, nil)
@@ -96,5 +108,47 @@ func (s *Server) User(ctx context.Context, in *pb.UserRequest) (*pb.UserResponse
 		Permissions:    user.Permissions,
 		Boocsek:        &attributes,
 		OrgStructure:   user.OrganizationStructure,
+		Status:         user.Status,
+		Attributes:     attributeValues(user.Attributes),
 	}, nil
 }
+
+// attributeValues converts user attributes to protobuf values
+func attributeValues(attributes map[string]interface{}) map[string]*structpb.Value {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	values := make(map[string]*structpb.Value, len(attributes))
+	for name, attribute := range attributes {
+		values[name] = attributeValue(attribute)
+	}
+	return values
+}
+
+func attributeValue(attribute interface{}) *structpb.Value {
+	switch v := attribute.(type) {
+	case string:
+		return &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: v}}
+	case bool:
+		return &structpb.Value{Kind: &structpb.Value_BoolValue{BoolValue: v}}
+	case float64:
+		return &structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: v}}
+	case int64:
+		return &structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: float64(v)}}
+	case []string:
+		list := make([]*structpb.Value, len(v))
+		for i := range v {
+			list[i] = attributeValue(v[i])
+		}
+		return &structpb.Value{Kind: &structpb.Value_ListValue{ListValue: &structpb.ListValue{Values: list}}}
+	case []interface{}:
+		list := make([]*structpb.Value, len(v))
+		for i := range v {
+			list[i] = attributeValue(v[i])
+		}
+		return &structpb.Value{Kind: &structpb.Value_ListValue{ListValue: &structpb.ListValue{Values: list}}}
+	default:
+		return &structpb.Value{Kind: &structpb.Value_NullValue{}}
+	}
+}
@@ -6,27 +6,29 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/kiwicom/iam/api/grpc/v1"
-	"github.com/kiwicom/iam/internal/services/okta"
+	"github.com/kiwicom/iam/internal/services/directory"
 )
 
-type mockOktaService struct {
+type mockDirectoryService struct {
 	mock.Mock
 }
 
-func (o *mockOktaService) AddPermissions(user *okta.User, service string) error {
+func (o *mockDirectoryService) AddPermissions(user *directory.User, service string) error {
 	argsToReturn := o.Called(user, service)
 	return argsToReturn.Error(0)
 }
 
-func (o *mockOktaService) GetUser(email string) (okta.User, error) {
+func (o *mockDirectoryService) GetUser(email string) (directory.User, error) {
 	argsToReturn := o.Called(email)
-	return argsToReturn.Get(0).(okta.User), argsToReturn.Error(1)
+	return argsToReturn.Get(0).(directory.User), argsToReturn.Error(1)
 }
 
-var testUser = okta.User{
+var testUser = directory.User{
 	EmployeeNumber: "1",
 	Email:          "test@test.com",
 	FirstName:      "Test",
@@ -46,11 +48,11 @@ var wantUser = &pb.UserResponse{
 }
 
 func TestHappyPath(t *testing.T) {
-	userService := &mockOktaService{}
+	userService := &mockDirectoryService{}
 	server := &Server{userService: userService}
 
 	userService.On("GetUser", "test@test.com").Once().Return(testUser, nil)
-	userService.On("AddPermissions", &testUser, "service").Once().Return(nil)
+	userService.On("AddPermissions", &testUser, "service@test").Once().Return(nil)
 
 	ctx := context.Background()
 	md := metadata.New(map[string]string{"service-agent": "service/0 (Kiwi.com test)"})
@@ -64,3 +66,46 @@ func TestHappyPath(t *testing.T) {
 	assert.Equal(t, wantUser, gotUser, "Returns correct body")
 	userService.AssertExpectations(t)
 }
+
+func TestUpstreamUnavailable(t *testing.T) {
+	userService := &mockDirectoryService{}
+	server := &Server{userService: userService}
+
+	userService.On("GetUser", "test@test.com").Once().Return(directory.User{}, directory.ErrUpstreamUnavailable)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{
+		"service-agent": "service/0 (Kiwi.com test)",
+	}))
+
+	_, err := server.User(ctx, &pb.UserRequest{Email: "test@test.com"})
+
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	userService.AssertExpectations(t)
+}
+
+func TestAttributes(t *testing.T) {
+	userService := &mockDirectoryService{}
+	server := &Server{userService: userService}
+
+	user := testUser
+	user.Attributes = map[string]interface{}{
+		"costCenter": float64(1234),
+		"remote":     true,
+		"languages":  []interface{}{"en", "cs"},
+	}
+	userService.On("GetUser", "test@test.com").Once().Return(user, nil)
+	userService.On("AddPermissions", mock.Anything, "service@test").Once().Return(nil)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{
+		"service-agent": "service/0 (Kiwi.com test)",
+	}))
+
+	gotUser, err := server.User(ctx, &pb.UserRequest{Email: "test@test.com"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1234), gotUser.Attributes["costCenter"].GetNumberValue())
+	assert.True(t, gotUser.Attributes["remote"].GetBoolValue())
+	languages := gotUser.Attributes["languages"].GetListValue().GetValues()
+	assert.Len(t, languages, 2)
+	assert.Equal(t, "cs", languages[1].GetStringValue())
+}
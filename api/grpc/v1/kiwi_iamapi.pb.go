@@ -7,6 +7,7 @@ import (
 	context "context"
 	fmt "fmt"
 	proto "github.com/golang/protobuf/proto"
+	_struct "github.com/golang/protobuf/ptypes/struct"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
@@ -191,22 +192,27 @@ func (m *BoocsekAttributes) GetSkills() []string {
 }
 
 type UserResponse struct {
-	EmployeeNumber       int64              `protobuf:"varint,1,opt,name=employee_number,json=employeeNumber,proto3" json:"employee_number,omitempty"`
-	Email                string             `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`
-	FirstName            string             `protobuf:"bytes,3,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
-	LastName             string             `protobuf:"bytes,4,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
-	Position             string             `protobuf:"bytes,5,opt,name=position,proto3" json:"position,omitempty"`
-	Department           string             `protobuf:"bytes,6,opt,name=department,proto3" json:"department,omitempty"`
-	Location             string             `protobuf:"bytes,7,opt,name=location,proto3" json:"location,omitempty"`
-	IsVendor             bool               `protobuf:"varint,8,opt,name=is_vendor,json=isVendor,proto3" json:"is_vendor,omitempty"`
-	Manager              string             `protobuf:"bytes,9,opt,name=manager,proto3" json:"manager,omitempty"`
-	TeamMembership       []string           `protobuf:"bytes,10,rep,name=team_membership,json=teamMembership,proto3" json:"team_membership,omitempty"`
-	Boocsek              *BoocsekAttributes `protobuf:"bytes,11,opt,name=boocsek,proto3" json:"boocsek,omitempty"`
-	Permissions          []string           `protobuf:"bytes,12,rep,name=permissions,proto3" json:"permissions,omitempty"`
-	OrgStructure         string             `protobuf:"bytes,13,opt,name=org_structure,json=orgStructure,proto3" json:"org_structure,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
-	XXX_unrecognized     []byte             `json:"-"`
-	XXX_sizecache        int32              `json:"-"`
+	EmployeeNumber int64              `protobuf:"varint,1,opt,name=employee_number,json=employeeNumber,proto3" json:"employee_number,omitempty"`
+	Email          string             `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`
+	FirstName      string             `protobuf:"bytes,3,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
+	LastName       string             `protobuf:"bytes,4,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
+	Position       string             `protobuf:"bytes,5,opt,name=position,proto3" json:"position,omitempty"`
+	Department     string             `protobuf:"bytes,6,opt,name=department,proto3" json:"department,omitempty"`
+	Location       string             `protobuf:"bytes,7,opt,name=location,proto3" json:"location,omitempty"`
+	IsVendor       bool               `protobuf:"varint,8,opt,name=is_vendor,json=isVendor,proto3" json:"is_vendor,omitempty"`
+	Manager        string             `protobuf:"bytes,9,opt,name=manager,proto3" json:"manager,omitempty"`
+	TeamMembership []string           `protobuf:"bytes,10,rep,name=team_membership,json=teamMembership,proto3" json:"team_membership,omitempty"`
+	Boocsek        *BoocsekAttributes `protobuf:"bytes,11,opt,name=boocsek,proto3" json:"boocsek,omitempty"`
+	Permissions    []string           `protobuf:"bytes,12,rep,name=permissions,proto3" json:"permissions,omitempty"`
+	OrgStructure   string             `protobuf:"bytes,13,opt,name=org_structure,json=orgStructure,proto3" json:"org_structure,omitempty"`
+	// Okta user status, ie. ACTIVE, SUSPENDED or DEPROVISIONED. Users which are
+	// not ACTIVE have no permissions.
+	Status string `protobuf:"bytes,14,opt,name=status,proto3" json:"status,omitempty"`
+	// Okta profile attributes configured in OKTA_PROFILE_ATTRIBUTES.
+	Attributes           map[string]*_struct.Value `protobuf:"bytes,15,rep,name=attributes,proto3" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}                  `json:"-"`
+	XXX_unrecognized     []byte                    `json:"-"`
+	XXX_sizecache        int32                     `json:"-"`
 }
 
 func (m *UserResponse) Reset()         { *m = UserResponse{} }
@@ -325,53 +331,74 @@ func (m *UserResponse) GetOrgStructure() string {
 	return ""
 }
 
+func (m *UserResponse) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *UserResponse) GetAttributes() map[string]*_struct.Value {
+	if m != nil {
+		return m.Attributes
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*UserRequest)(nil), "kiwi.iam.user.v1.UserRequest")
 	proto.RegisterType((*BoocsekAttributes)(nil), "kiwi.iam.user.v1.BoocsekAttributes")
 	proto.RegisterType((*UserResponse)(nil), "kiwi.iam.user.v1.UserResponse")
+	proto.RegisterMapType((map[string]*_struct.Value)(nil), "kiwi.iam.user.v1.UserResponse.AttributesEntry")
 }
 
 func init() { proto.RegisterFile("api/grpc/v1/kiwi_iamapi.proto", fileDescriptor_1c6f8aa01589961e) }
 
 var fileDescriptor_1c6f8aa01589961e = []byte{
-	// 585 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x94, 0x4f, 0x4f, 0xdb, 0x30,
-	0x18, 0xc6, 0xd5, 0x96, 0xfe, 0xc9, 0x9b, 0xf2, 0x67, 0x16, 0x9a, 0x2c, 0x26, 0x58, 0x07, 0x87,
-	0xf5, 0x14, 0x54, 0x76, 0xe6, 0x00, 0xd2, 0x0e, 0x11, 0x02, 0xa1, 0x20, 0x38, 0x4c, 0x4c, 0x91,
-	0x93, 0xbe, 0x14, 0x8b, 0x38, 0xce, 0x6c, 0xa7, 0x68, 0x5f, 0x67, 0xb7, 0xed, 0x8b, 0x4c, 0xda,
-	0xa7, 0x9a, 0x6c, 0x27, 0xac, 0xa2, 0xda, 0xa9, 0x79, 0x7e, 0x6f, 0xfc, 0x3a, 0xef, 0xf3, 0xd8,
-	0x85, 0x7d, 0x56, 0xf1, 0xe3, 0x85, 0xaa, 0xf2, 0xe3, 0xe5, 0xec, 0xf8, 0x89, 0x3f, 0xf3, 0x94,
-	0x33, 0xc1, 0x2a, 0x1e, 0x55, 0x4a, 0x1a, 0x49, 0x76, 0x2c, 0x8a, 0x38, 0x13, 0x51, 0xad, 0x51,
-	0x45, 0xcb, 0xd9, 0xe1, 0x29, 0x84, 0xb7, 0x1a, 0x55, 0x82, 0xdf, 0x6a, 0xd4, 0x86, 0xec, 0x42,
-	0x1f, 0x05, 0xe3, 0x05, 0xed, 0x4c, 0x3a, 0xd3, 0x20, 0xf1, 0x82, 0x50, 0x18, 0x6a, 0x54, 0x4b,
-	0x9e, 0x23, 0xed, 0x3a, 0xde, 0xca, 0xc3, 0x9f, 0x5d, 0x78, 0x73, 0x2e, 0x65, 0xae, 0xf1, 0xe9,
-	0xcc, 0x18, 0xc5, 0xb3, 0xda, 0xa0, 0x26, 0x04, 0x36, 0x34, 0x37, 0xd8, 0x34, 0x71, 0xcf, 0x64,
-	0x0f, 0x46, 0x95, 0xd4, 0xdc, 0x70, 0x59, 0x36, 0x4d, 0x5e, 0xb4, 0xed, 0x9f, 0x3f, 0xb2, 0xb2,
-	0xc4, 0x82, 0xf6, 0x7c, 0xff, 0x46, 0xda, 0x4e, 0x86, 0xa3, 0xa2, 0x1b, 0xbe, 0x93, 0x7d, 0x76,
-	0x0c, 0x99, 0xa0, 0xfd, 0x86, 0x21, 0x13, 0xe4, 0x03, 0x8c, 0xed, 0x6f, 0x2a, 0x58, 0xc9, 0x16,
-	0xa8, 0xe8, 0xc0, 0xd5, 0x42, 0xcb, 0x2e, 0x3d, 0xb2, 0xa3, 0x69, 0xc3, 0x1e, 0x1e, 0xe8, 0xd0,
-	0x8f, 0xe6, 0x44, 0x43, 0x0d, 0xd2, 0xd1, 0x0b, 0x35, 0x48, 0xde, 0x43, 0x68, 0x9d, 0xca, 0x98,
-	0xc6, 0x94, 0xcf, 0x69, 0x30, 0xe9, 0x4c, 0xfb, 0x09, 0xb4, 0x28, 0x9e, 0xdb, 0x69, 0x74, 0x9d,
-	0xf9, 0x95, 0xe0, 0xa7, 0x69, 0x35, 0x79, 0x0b, 0x03, 0xfd, 0xc4, 0x8b, 0x42, 0xd3, 0x70, 0xd2,
-	0x9b, 0x06, 0x49, 0xa3, 0x0e, 0x7f, 0xf7, 0x60, 0xec, 0xbd, 0xd6, 0x95, 0x2c, 0x35, 0x92, 0x8f,
-	0xb0, 0x8d, 0xa2, 0x2a, 0xe4, 0x77, 0xc4, 0xb4, 0xac, 0x45, 0x86, 0xca, 0x39, 0xd6, 0x4b, 0xb6,
-	0x5a, 0x7c, 0xe5, 0xe8, 0xbf, 0x54, 0xba, 0xab, 0xa9, 0xec, 0x03, 0x3c, 0x70, 0xa5, 0x4d, 0x5a,
-	0x32, 0x81, 0x8d, 0x71, 0x81, 0x23, 0x57, 0x4c, 0x20, 0x79, 0x07, 0x41, 0xc1, 0xda, 0xaa, 0xf7,
-	0x6f, 0x64, 0x81, 0x2b, 0xae, 0xa6, 0xd1, 0x7f, 0x95, 0xc6, 0x01, 0xc0, 0x1c, 0x2b, 0xa6, 0x8c,
-	0xc0, 0xd2, 0x34, 0x4e, 0xae, 0x10, 0xbb, 0xb6, 0x90, 0x39, 0x73, 0x6b, 0x87, 0x4d, 0xdf, 0x46,
-	0xdb, 0x4d, 0xb9, 0x4e, 0x97, 0x58, 0xce, 0xa5, 0x72, 0x96, 0x8e, 0x92, 0x11, 0xd7, 0x77, 0x4e,
-	0xdb, 0x98, 0xdb, 0x7c, 0x02, 0x1f, 0x73, 0x23, 0xad, 0x13, 0x3e, 0x3e, 0xb4, 0xf3, 0xea, 0x47,
-	0x5e, 0x51, 0x70, 0xde, 0x6d, 0xb9, 0x04, 0x5f, 0x28, 0x39, 0x85, 0x61, 0xe6, 0x8f, 0x1b, 0x0d,
-	0x27, 0x9d, 0x69, 0x78, 0x72, 0x14, 0xbd, 0x3e, 0xd2, 0xd1, 0xda, 0x79, 0x4c, 0xda, 0x35, 0x64,
-	0x02, 0x61, 0x85, 0x4a, 0x70, 0xad, 0xb9, 0x2c, 0x35, 0x1d, 0xbb, 0x3d, 0x56, 0x11, 0x39, 0x82,
-	0x4d, 0xa9, 0x16, 0xa9, 0x36, 0xaa, 0xce, 0x4d, 0xad, 0x90, 0x6e, 0xba, 0x2f, 0x1d, 0x4b, 0xb5,
-	0xb8, 0x69, 0xd9, 0xc9, 0x0d, 0xc0, 0x05, 0x7f, 0xe6, 0xf1, 0xd9, 0xe5, 0xd9, 0x75, 0x4c, 0x3e,
-	0xc3, 0x86, 0x8d, 0x95, 0xec, 0xaf, 0x7f, 0xca, 0xca, 0xd5, 0xda, 0x3b, 0xf8, 0x5f, 0xd9, 0x9f,
-	0x86, 0xf3, 0xaf, 0xb0, 0x9b, 0x4b, 0xb1, 0xf6, 0xd2, 0xf9, 0xb6, 0xdb, 0xca, 0xdd, 0xe2, 0x6b,
-	0x7b, 0x89, 0xaf, 0x3b, 0x5f, 0x06, 0xb6, 0xb6, 0x9c, 0xfd, 0xe8, 0xf6, 0x2e, 0xe2, 0xdb, 0x5f,
-	0xdd, 0x1d, 0xfb, 0x46, 0x14, 0x33, 0xe1, 0x1a, 0x46, 0x77, 0xb3, 0x3f, 0x1e, 0xdd, 0xc7, 0x4c,
-	0xdc, 0x5b, 0x74, 0x7f, 0x37, 0xcb, 0x06, 0xee, 0x1f, 0xe0, 0xd3, 0xdf, 0x00, 0x00, 0x00, 0xff,
-	0xff, 0x8a, 0x28, 0x5d, 0xd7, 0x22, 0x04, 0x00, 0x00,
+	// 680 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x54, 0xcd, 0x4e, 0x1b, 0x3b,
+	0x14, 0x56, 0x12, 0xf2, 0x77, 0x26, 0x10, 0xae, 0x85, 0x90, 0x95, 0x7b, 0xe1, 0xe6, 0xc2, 0xe2,
+	0x66, 0x51, 0x4d, 0x94, 0x74, 0x53, 0x55, 0x62, 0x01, 0x12, 0x8b, 0x08, 0x81, 0xd0, 0x20, 0xb2,
+	0xa8, 0xa8, 0x22, 0x27, 0x39, 0x09, 0x56, 0xc6, 0xe3, 0xa9, 0xed, 0x09, 0xe2, 0x05, 0xfa, 0x20,
+	0xdd, 0xb5, 0x8f, 0xd2, 0xa7, 0xaa, 0x6c, 0xcf, 0x84, 0x08, 0xd4, 0xae, 0xc6, 0xdf, 0x77, 0xec,
+	0xe3, 0x73, 0xbe, 0xcf, 0x67, 0xe0, 0x88, 0xa5, 0xbc, 0xbf, 0x54, 0xe9, 0xac, 0xbf, 0x1e, 0xf4,
+	0x57, 0xfc, 0x89, 0x4f, 0x38, 0x13, 0x2c, 0xe5, 0x61, 0xaa, 0xa4, 0x91, 0x64, 0xdf, 0x52, 0x21,
+	0x67, 0x22, 0xcc, 0x34, 0xaa, 0x70, 0x3d, 0xe8, 0xfc, 0xb3, 0x94, 0x72, 0x19, 0x63, 0xdf, 0xc5,
+	0xa7, 0xd9, 0xa2, 0xaf, 0x8d, 0xca, 0x66, 0xc6, 0xef, 0x3f, 0x39, 0x83, 0xe0, 0x5e, 0xa3, 0x8a,
+	0xf0, 0x4b, 0x86, 0xda, 0x90, 0x03, 0xa8, 0xa2, 0x60, 0x3c, 0xa6, 0xa5, 0x6e, 0xa9, 0xd7, 0x8c,
+	0x3c, 0x20, 0x14, 0xea, 0x1a, 0xd5, 0x9a, 0xcf, 0x90, 0x96, 0x1d, 0x5f, 0xc0, 0x93, 0xef, 0x65,
+	0xf8, 0xeb, 0x42, 0xca, 0x99, 0xc6, 0xd5, 0xb9, 0x31, 0x8a, 0x4f, 0x33, 0x83, 0x9a, 0x10, 0xd8,
+	0xd1, 0xdc, 0x60, 0x9e, 0xc4, 0xad, 0x49, 0x07, 0x1a, 0xa9, 0xd4, 0xdc, 0x70, 0x99, 0xe4, 0x49,
+	0x36, 0xd8, 0xe6, 0x9f, 0x3d, 0xb2, 0x24, 0xc1, 0x98, 0x56, 0x7c, 0xfe, 0x1c, 0xda, 0x4c, 0x86,
+	0xa3, 0xa2, 0x3b, 0x3e, 0x93, 0x5d, 0x3b, 0x0e, 0x99, 0xa0, 0xd5, 0x9c, 0x43, 0x26, 0xc8, 0x7f,
+	0xd0, 0xb2, 0xdf, 0x89, 0x60, 0x09, 0x5b, 0xa2, 0xa2, 0x35, 0x17, 0x0b, 0x2c, 0x77, 0xed, 0x29,
+	0xdb, 0x9a, 0x36, 0x6c, 0xb1, 0xa0, 0x75, 0xdf, 0x9a, 0x03, 0x39, 0x6b, 0x90, 0x36, 0x36, 0xac,
+	0x41, 0xf2, 0x2f, 0x04, 0x56, 0xc7, 0x29, 0xd3, 0x38, 0xe1, 0x73, 0xda, 0xec, 0x96, 0x7a, 0xd5,
+	0x08, 0x0a, 0x6a, 0x34, 0xb7, 0xdd, 0xe8, 0x6c, 0xea, 0x4f, 0x82, 0xef, 0xa6, 0xc0, 0xe4, 0x10,
+	0x6a, 0x7a, 0xc5, 0xe3, 0x58, 0xd3, 0xa0, 0x5b, 0xe9, 0x35, 0xa3, 0x1c, 0x9d, 0x7c, 0xad, 0x42,
+	0xcb, 0x6b, 0xad, 0x53, 0x99, 0x68, 0x24, 0xff, 0x43, 0x1b, 0x45, 0x1a, 0xcb, 0x67, 0xc4, 0x49,
+	0x92, 0x89, 0x29, 0x2a, 0xa7, 0x58, 0x25, 0xda, 0x2b, 0xe8, 0x1b, 0xc7, 0xbe, 0xb8, 0x52, 0xde,
+	0x76, 0xe5, 0x08, 0x60, 0xc1, 0x95, 0x36, 0x93, 0x84, 0x09, 0xcc, 0x85, 0x6b, 0x3a, 0xe6, 0x86,
+	0x09, 0x24, 0x7f, 0x43, 0x33, 0x66, 0x45, 0xd4, 0xeb, 0xd7, 0x88, 0x59, 0x1e, 0xdc, 0x76, 0xa3,
+	0xfa, 0xca, 0x8d, 0x63, 0x80, 0x39, 0xa6, 0x4c, 0x19, 0x81, 0x89, 0xc9, 0x95, 0xdc, 0x62, 0xec,
+	0xd9, 0x58, 0xce, 0x98, 0x3b, 0x5b, 0xcf, 0xf3, 0xe6, 0xd8, 0x5e, 0xca, 0xf5, 0x64, 0x8d, 0xc9,
+	0x5c, 0x2a, 0x27, 0x69, 0x23, 0x6a, 0x70, 0x3d, 0x76, 0xd8, 0xda, 0x5c, 0xf8, 0xd3, 0xf4, 0x36,
+	0xe7, 0xd0, 0x2a, 0xe1, 0xed, 0x43, 0xdb, 0xaf, 0x7e, 0xe4, 0x29, 0x05, 0xa7, 0xdd, 0x9e, 0x73,
+	0x70, 0xc3, 0x92, 0x33, 0xa8, 0x4f, 0xfd, 0x73, 0xa3, 0x41, 0xb7, 0xd4, 0x0b, 0x86, 0xa7, 0xe1,
+	0xeb, 0x07, 0x1f, 0xbe, 0x79, 0x8f, 0x51, 0x71, 0x86, 0x74, 0x21, 0x48, 0x51, 0x09, 0xae, 0x35,
+	0x97, 0x89, 0xa6, 0x2d, 0x77, 0xc7, 0x36, 0x45, 0x4e, 0x61, 0x57, 0xaa, 0xe5, 0xc4, 0xcf, 0x48,
+	0xa6, 0x90, 0xee, 0xba, 0x4a, 0x5b, 0x52, 0x2d, 0xef, 0x0a, 0xce, 0x39, 0x6c, 0x98, 0xc9, 0x34,
+	0xdd, 0x73, 0xd1, 0x1c, 0x91, 0x1b, 0x00, 0xb6, 0xb9, 0x95, 0xb6, 0xbb, 0x95, 0x5e, 0x30, 0x0c,
+	0xdf, 0x16, 0xb8, 0xfd, 0x08, 0xc2, 0x97, 0x32, 0x2f, 0x13, 0xa3, 0x9e, 0xa3, 0xad, 0x0c, 0x9d,
+	0x7b, 0x68, 0xbf, 0x0a, 0x93, 0x7d, 0xa8, 0xac, 0xf0, 0x39, 0x9f, 0x2c, 0xbb, 0x24, 0xef, 0xa0,
+	0xba, 0x66, 0x71, 0xe6, 0x47, 0x33, 0x18, 0x1e, 0x86, 0x7e, 0xde, 0xc3, 0x62, 0xde, 0xc3, 0xb1,
+	0x8d, 0x46, 0x7e, 0xd3, 0xc7, 0xf2, 0x87, 0xd2, 0xf0, 0x0e, 0xe0, 0x8a, 0x3f, 0xf1, 0xd1, 0xf9,
+	0xf5, 0xf9, 0xed, 0x88, 0x5c, 0xc2, 0x8e, 0x2d, 0x88, 0x1c, 0xfd, 0xae, 0x50, 0xf7, 0x67, 0xe8,
+	0x1c, 0xff, 0xb9, 0x8f, 0x8b, 0xcf, 0x70, 0x30, 0x93, 0xe2, 0xcd, 0xa6, 0x8b, 0xb6, 0xbb, 0xca,
+	0xfd, 0xa2, 0x6e, 0x6d, 0x45, 0xb7, 0xa5, 0x4f, 0x35, 0x1b, 0x5b, 0x0f, 0xbe, 0x95, 0x2b, 0x57,
+	0xa3, 0xfb, 0x1f, 0xe5, 0x7d, 0xbb, 0x23, 0x1c, 0x31, 0xe1, 0x12, 0x86, 0xe3, 0xc1, 0x4f, 0x4f,
+	0x3d, 0x8c, 0x98, 0x78, 0xb0, 0xd4, 0xc3, 0x78, 0x30, 0xad, 0xb9, 0x76, 0xde, 0xff, 0x1a, 0x00,
+	0x7e, 0xfb, 0x4d, 0xea, 0xff, 0x04, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
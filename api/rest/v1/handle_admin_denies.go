@@ -0,0 +1,105 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+type denyService interface {
+	ListDenies(service string) ([]directory.Deny, error)
+	CreateDeny(deny directory.Deny, actor string) (directory.Deny, error)
+	DeleteDeny(id, actor string) error
+}
+
+// denyRequest is the body of requests creating deny entries, exactly one of
+// Email and Group is required
+type denyRequest struct {
+	Service     string `json:"service"`
+	Permission  string `json:"permission"`
+	Email       string `json:"email"`
+	Group       string `json:"group"`
+	Environment string `json:"environment"`
+	Reason      string `json:"reason"`
+}
+
+// handleAdminDenies lists deny entries with GET, filtered by the service
+// parameter, creates an entry with POST and deletes the entry of the id
+// parameter with DELETE
+func (s *Server) handleAdminDenies() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.listDenies(w, r)
+		case http.MethodPost:
+			s.createDeny(w, r)
+		case http.MethodDelete:
+			s.deleteDeny(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (s *Server) listDenies(w http.ResponseWriter, r *http.Request) {
+	denies, err := s.Denies.ListDenies(r.URL.Query().Get("service"))
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, denies)
+}
+
+func (s *Server) createDeny(w http.ResponseWriter, r *http.Request) {
+	var body denyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodySize)).Decode(&body); err != nil {
+		http.Error(w, "invalid deny request", http.StatusBadRequest)
+		return
+	}
+
+	deny, err := s.Denies.CreateDeny(directory.Deny{
+		Service:     body.Service,
+		Permission:  body.Permission,
+		Email:       body.Email,
+		Group:       body.Group,
+		Environment: body.Environment,
+		Reason:      body.Reason,
+	}, adminActor(r))
+	if _, ok := err.(directory.InvalidDenyError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, deny)
+}
+
+func (s *Server) deleteDeny(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	err := s.Denies.DeleteDeny(id, adminActor(r))
+	if err == directory.ErrDenyNotFound {
+		http.Error(w, "Deny entry "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+type mockDenyService struct {
+	mock.Mock
+}
+
+func (o *mockDenyService) ListDenies(service string) ([]directory.Deny, error) {
+	argsToReturn := o.Called(service)
+	return argsToReturn.Get(0).([]directory.Deny), argsToReturn.Error(1)
+}
+
+func (o *mockDenyService) CreateDeny(deny directory.Deny, actor string) (directory.Deny, error) {
+	argsToReturn := o.Called(deny, actor)
+	return argsToReturn.Get(0).(directory.Deny), argsToReturn.Error(1)
+}
+
+func (o *mockDenyService) DeleteDeny(id, actor string) error {
+	argsToReturn := o.Called(id, actor)
+	return argsToReturn.Error(0)
+}
+
+func TestAdminDenies(t *testing.T) {
+	denies := &mockDenyService{}
+	server := setupServer()
+	server.Denies = denies
+
+	created := directory.Deny{ID: "1", Service: "service", Permission: "orders.refund", Group: "iam-service.contractors"}
+	denies.On("ListDenies", "service").Return([]directory.Deny{created}, nil)
+	denies.On("ListDenies", "").Return([]directory.Deny(nil), errors.New("cache unavailable"))
+	denies.On("CreateDeny", directory.Deny{
+		Service: "service", Permission: "orders.refund", Group: "iam-service.contractors", Environment: "production", Reason: "Policy",
+	}, "admin-tool").Return(created, nil)
+	denies.On("CreateDeny", mock.MatchedBy(func(d directory.Deny) bool { return d.Reason == "" }), "admin-tool").
+		Return(directory.Deny{}, directory.InvalidDenyError{Reason: "missing reason"})
+	denies.On("DeleteDeny", "1", "admin-tool").Return(nil)
+	denies.On("DeleteDeny", "2", "admin-tool").Return(directory.ErrDenyNotFound)
+
+	cases := []struct {
+		request *http.Request
+		status  int
+	}{
+		{adminRequest("GET", "/?service=service", ""), 200},
+		{adminRequest("GET", "/", ""), 500},
+		{adminRequest("POST", "/", `{"service": "service", "permission": "orders.refund", "group": "iam-service.contractors", "environment": "production", "reason": "Policy"}`), 201},
+		{adminRequest("POST", "/", `{"service": "service"}`), 400},
+		{adminRequest("POST", "/", `[]`), 400},
+		{adminRequest("DELETE", "/?id=1", ""), 204},
+		{adminRequest("DELETE", "/?id=2", ""), 404},
+		{adminRequest("DELETE", "/", ""), 400},
+		{adminRequest("PATCH", "/", ""), 405},
+	}
+	for _, c := range cases {
+		response := httptest.NewRecorder()
+		server.handleAdminDenies().ServeHTTP(response, c.request)
+		assert.Equal(t, c.status, response.Code, c.request.Method+" "+c.request.URL.String())
+		if c.status == 200 || c.status == 201 {
+			assert.Contains(t, response.Body.String(), `"id":"1"`)
+		}
+	}
+}
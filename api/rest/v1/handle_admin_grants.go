@@ -0,0 +1,132 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// maxAdminBodySize limits the size of admin API requests
+const maxAdminBodySize = 1 << 16
+
+type grantService interface {
+	ListGrants(email, service string) ([]directory.Grant, error)
+	CreateGrant(grant directory.Grant, actor string) (directory.Grant, error)
+	RevokeGrant(id, actor string) error
+}
+
+// grantRequest is the body of requests creating temporary grants. The grant
+// expires after Duration, or at ExpiresAt if the duration is missing.
+type grantRequest struct {
+	Email       string    `json:"email"`
+	Service     string    `json:"service"`
+	Permission  string    `json:"permission"`
+	Environment string    `json:"environment"`
+	Reason      string    `json:"reason"`
+	Approver    string    `json:"approver"`
+	Duration    string    `json:"duration"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// handleAdminGrants lists active temporary grants with GET, filtered by the
+// email and service parameters, creates a grant with POST and revokes the
+// grant of the id parameter with DELETE
+func (s *Server) handleAdminGrants() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.listGrants(w, r)
+		case http.MethodPost:
+			s.createGrant(w, r)
+		case http.MethodDelete:
+			s.revokeGrant(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (s *Server) listGrants(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	grants, err := s.Grants.ListGrants(query.Get("email"), query.Get("service"))
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, grants)
+}
+
+func (s *Server) createGrant(w http.ResponseWriter, r *http.Request) {
+	var body grantRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodySize)).Decode(&body); err != nil {
+		http.Error(w, "invalid grant request", http.StatusBadRequest)
+		return
+	}
+	grant := directory.Grant{
+		Email:       body.Email,
+		Service:     body.Service,
+		Permission:  body.Permission,
+		Environment: body.Environment,
+		Reason:      body.Reason,
+		Approver:    body.Approver,
+		ExpiresAt:   body.ExpiresAt,
+	}
+	if body.Duration != "" {
+		duration, err := time.ParseDuration(body.Duration)
+		if err != nil {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		grant.ExpiresAt = time.Now().Add(duration)
+	}
+
+	grant, err := s.Grants.CreateGrant(grant, adminActor(r))
+	if _, ok := err.(directory.InvalidGrantError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, grant)
+}
+
+func (s *Server) revokeGrant(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	err := s.Grants.RevokeGrant(id, adminActor(r))
+	if err == directory.ErrGrantNotFound {
+		http.Error(w, "Grant "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes a JSON response with the status
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+	}
+}
@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+type mockGrantService struct {
+	mock.Mock
+}
+
+func (o *mockGrantService) ListGrants(email, service string) ([]directory.Grant, error) {
+	argsToReturn := o.Called(email, service)
+	return argsToReturn.Get(0).([]directory.Grant), argsToReturn.Error(1)
+}
+
+func (o *mockGrantService) CreateGrant(grant directory.Grant, actor string) (directory.Grant, error) {
+	argsToReturn := o.Called(grant, actor)
+	return argsToReturn.Get(0).(directory.Grant), argsToReturn.Error(1)
+}
+
+func (o *mockGrantService) RevokeGrant(id, actor string) error {
+	argsToReturn := o.Called(id, actor)
+	return argsToReturn.Error(0)
+}
+
+func adminRequest(method, target, body string) *http.Request {
+	request, _ := http.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("User-Agent", "admin-tool/0 (Kiwi.com test)")
+	request.Header.Set("Authorization", "Bearer valid token")
+	return request
+}
+
+func TestAdminAuth(t *testing.T) {
+	grants := &mockGrantService{}
+	server := setupServer()
+	server.SecretManager = createFakeManager()
+	metrics := &mockedMetricsService{}
+	metrics.On("Incr", mock.Anything, mock.Anything)
+	server.MetricClient = metrics
+	server.Grants = grants
+	grants.On("ListGrants", "", "").Return([]directory.Grant{}, nil)
+	handler := server.middlewareAdmin(server.handleAdminGrants())
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, adminRequest("GET", "/", ""))
+	assert.Equal(t, 403, response.Code, "Admin API is disabled without admin services")
+
+	server.AdminServices = []string{"Admin-Tool"}
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, adminRequest("GET", "/", ""))
+	assert.Equal(t, 200, response.Code)
+
+	request := adminRequest("GET", "/", "")
+	request.Header.Set("User-Agent", "service/0 (Kiwi.com test)")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.Equal(t, 403, response.Code)
+
+	server.AdminServices = []string{"admin-tool", "other-admin"}
+	request = adminRequest("GET", "/", "")
+	request.Header.Set("User-Agent", "other-admin/0 (Kiwi.com test)")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.Equal(t, 403, response.Code, "Tokens of other services don't grant admin rights")
+
+	request = adminRequest("GET", "/", "")
+	request.Header.Set("Authorization", "Bearer invalid token")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.Equal(t, 401, response.Code)
+}
+
+func TestAdminGrants(t *testing.T) {
+	grants := &mockGrantService{}
+	server := setupServer()
+	server.Grants = grants
+
+	created := directory.Grant{ID: "1", Email: "oncall@kiwi.com", Service: "service", Permission: "orders.refund"}
+	grants.On("ListGrants", "oncall@kiwi.com", "").Return([]directory.Grant{created}, nil)
+	grants.On("CreateGrant", mock.MatchedBy(func(g directory.Grant) bool {
+		return g.Reason == "INC-1" && g.Environment == "sandbox" && time.Until(g.ExpiresAt) > 3*time.Hour && time.Until(g.ExpiresAt) <= 4*time.Hour
+	}), "admin-tool").Return(created, nil)
+	grants.On("CreateGrant", mock.MatchedBy(func(g directory.Grant) bool { return g.Reason == "" }), "admin-tool").
+		Return(directory.Grant{}, directory.InvalidGrantError{Reason: "missing reason"})
+	grants.On("RevokeGrant", "1", "admin-tool").Return(nil)
+	grants.On("RevokeGrant", "2", "admin-tool").Return(directory.ErrGrantNotFound)
+	grants.On("RevokeGrant", "3", "admin-tool").Return(errors.New("cache unavailable"))
+
+	cases := []struct {
+		request *http.Request
+		status  int
+	}{
+		{adminRequest("GET", "/?email=oncall@kiwi.com", ""), 200},
+		{adminRequest("POST", "/", `{"email": "oncall@kiwi.com", "environment": "sandbox", "reason": "INC-1", "duration": "4h"}`), 201},
+		{adminRequest("POST", "/", `{"email": "oncall@kiwi.com", "duration": "4h"}`), 400},
+		{adminRequest("POST", "/", `{"duration": "4 hours"}`), 400},
+		{adminRequest("POST", "/", `{`), 400},
+		{adminRequest("DELETE", "/?id=1", ""), 204},
+		{adminRequest("DELETE", "/?id=2", ""), 404},
+		{adminRequest("DELETE", "/?id=3", ""), 500},
+		{adminRequest("DELETE", "/", ""), 400},
+		{adminRequest("PUT", "/", ""), 405},
+	}
+	for _, c := range cases {
+		response := httptest.NewRecorder()
+		server.handleAdminGrants().ServeHTTP(response, c.request)
+		assert.Equal(t, c.status, response.Code, c.request.Method+" "+c.request.URL.String())
+		if c.status == 200 || c.status == 201 {
+			assert.Contains(t, response.Body.String(), `"id":"1"`)
+		}
+	}
+}
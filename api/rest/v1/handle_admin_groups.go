@@ -0,0 +1,44 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+type groupSnapshotService interface {
+	RollbackGroups(actor string) (int64, error)
+}
+
+// rollbackResponse is the body of responses to groups rollbacks
+type rollbackResponse struct {
+	Generation int64 `json:"generation"`
+}
+
+// handleAdminGroupsRollback publishes the groups snapshot written by the sync
+// before the published one
+func (s *Server) handleAdminGroupsRollback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		generation, err := s.GroupSnapshots.RollbackGroups(adminActor(r))
+		if err == storage.ErrNoSnapshot {
+			http.Error(w, "No groups snapshot to roll back to", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+			http.Error(w, "Service unavailable", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, rollbackResponse{Generation: generation})
+	}
+}
@@ -0,0 +1,46 @@
+package rest
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+type mockGroupSnapshotService struct {
+	mock.Mock
+}
+
+func (o *mockGroupSnapshotService) RollbackGroups(actor string) (int64, error) {
+	argsToReturn := o.Called(actor)
+	return argsToReturn.Get(0).(int64), argsToReturn.Error(1)
+}
+
+func TestAdminGroupsRollback(t *testing.T) {
+	cases := []struct {
+		method string
+		err    error
+		status int
+		body   string
+	}{
+		{"POST", nil, 200, `{"generation":4}`},
+		{"POST", storage.ErrNoSnapshot, 409, "No groups snapshot"},
+		{"POST", errors.New("cache unavailable"), 500, ""},
+		{"GET", nil, 405, ""},
+	}
+	for _, c := range cases {
+		snapshots := &mockGroupSnapshotService{}
+		snapshots.On("RollbackGroups", "admin-tool").Return(int64(4), c.err)
+		server := setupServer()
+		server.GroupSnapshots = snapshots
+
+		response := httptest.NewRecorder()
+		server.handleAdminGroupsRollback().ServeHTTP(response, adminRequest(c.method, "/", ""))
+		assert.Equal(t, c.status, response.Code, c.method)
+		assert.Contains(t, response.Body.String(), c.body)
+	}
+}
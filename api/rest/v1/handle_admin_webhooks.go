@@ -0,0 +1,154 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+type webhookService interface {
+	ListWebhooks(service string) ([]directory.Webhook, error)
+	CreateWebhook(webhook directory.Webhook, actor string) (directory.Webhook, error)
+	DeleteWebhook(id, actor string) error
+	ListDeliveries(webhookID, status string) ([]directory.WebhookDelivery, error)
+	ReplayDelivery(id, actor string) (directory.WebhookDelivery, error)
+}
+
+// webhookRequest is the body of requests creating webhooks
+type webhookRequest struct {
+	Service string   `json:"service"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	Events  []string `json:"events"`
+}
+
+// handleAdminWebhooks lists webhooks with GET, filtered by the service
+// parameter, creates a webhook with POST and deletes the webhook of the id
+// parameter with DELETE
+func (s *Server) handleAdminWebhooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.listWebhooks(w, r)
+		case http.MethodPost:
+			s.createWebhook(w, r)
+		case http.MethodDelete:
+			s.deleteWebhook(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (s *Server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.Webhooks.ListWebhooks(r.URL.Query().Get("service"))
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var body webhookRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodySize)).Decode(&body); err != nil {
+		http.Error(w, "invalid webhook request", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := s.Webhooks.CreateWebhook(directory.Webhook{
+		Service: body.Service,
+		URL:     body.URL,
+		Secret:  body.Secret,
+		Events:  body.Events,
+	}, adminActor(r))
+	if _, ok := err.(directory.InvalidWebhookError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	err := s.Webhooks.DeleteWebhook(id, adminActor(r))
+	if err == directory.ErrWebhookNotFound {
+		http.Error(w, "Webhook "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminWebhookDeliveries lists the delivery log, newest first, filtered
+// by the webhook and status parameters
+func (s *Server) handleAdminWebhookDeliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		deliveries, err := s.Webhooks.ListDeliveries(query.Get("webhook"), query.Get("status"))
+		if err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+			http.Error(w, "Service unavailable", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, deliveries)
+	}
+}
+
+// handleAdminWebhookReplay queues a new delivery of the payload of the delivery
+// of the id parameter
+func (s *Server) handleAdminWebhookReplay() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "missing id", http.StatusBadRequest)
+			return
+		}
+
+		replay, err := s.Webhooks.ReplayDelivery(id, adminActor(r))
+		if err == directory.ErrDeliveryNotFound {
+			http.Error(w, "Delivery "+id+" not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+			http.Error(w, "Service unavailable", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, replay)
+	}
+}
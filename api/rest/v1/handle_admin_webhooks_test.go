@@ -0,0 +1,116 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+type mockWebhookService struct {
+	mock.Mock
+}
+
+func (o *mockWebhookService) ListWebhooks(service string) ([]directory.Webhook, error) {
+	argsToReturn := o.Called(service)
+	return argsToReturn.Get(0).([]directory.Webhook), argsToReturn.Error(1)
+}
+
+func (o *mockWebhookService) CreateWebhook(webhook directory.Webhook, actor string) (directory.Webhook, error) {
+	argsToReturn := o.Called(webhook, actor)
+	return argsToReturn.Get(0).(directory.Webhook), argsToReturn.Error(1)
+}
+
+func (o *mockWebhookService) DeleteWebhook(id, actor string) error {
+	argsToReturn := o.Called(id, actor)
+	return argsToReturn.Error(0)
+}
+
+func (o *mockWebhookService) ListDeliveries(webhookID, status string) ([]directory.WebhookDelivery, error) {
+	argsToReturn := o.Called(webhookID, status)
+	return argsToReturn.Get(0).([]directory.WebhookDelivery), argsToReturn.Error(1)
+}
+
+func (o *mockWebhookService) ReplayDelivery(id, actor string) (directory.WebhookDelivery, error) {
+	argsToReturn := o.Called(id, actor)
+	return argsToReturn.Get(0).(directory.WebhookDelivery), argsToReturn.Error(1)
+}
+
+func TestAdminWebhooks(t *testing.T) {
+	webhooks := &mockWebhookService{}
+	server := setupServer()
+	server.Webhooks = webhooks
+
+	created := directory.Webhook{ID: "1", Service: "service", URL: "https://service.kiwi.com/iam", Events: []string{"permissions.changed"}}
+	webhooks.On("ListWebhooks", "service").Return([]directory.Webhook{created}, nil)
+	webhooks.On("ListWebhooks", "").Return([]directory.Webhook(nil), errors.New("cache unavailable"))
+	webhooks.On("CreateWebhook", directory.Webhook{
+		Service: "service", URL: "https://service.kiwi.com/iam", Secret: "0123456789abcdef", Events: []string{"permissions.changed"},
+	}, "admin-tool").Return(created, nil)
+	webhooks.On("CreateWebhook", mock.MatchedBy(func(w directory.Webhook) bool { return w.URL == "" }), "admin-tool").
+		Return(directory.Webhook{}, directory.InvalidWebhookError{Reason: "missing url"})
+	webhooks.On("DeleteWebhook", "1", "admin-tool").Return(nil)
+	webhooks.On("DeleteWebhook", "2", "admin-tool").Return(directory.ErrWebhookNotFound)
+
+	cases := []struct {
+		request *http.Request
+		status  int
+	}{
+		{adminRequest("GET", "/?service=service", ""), 200},
+		{adminRequest("GET", "/", ""), 500},
+		{adminRequest("POST", "/", `{"service": "service", "url": "https://service.kiwi.com/iam", "secret": "0123456789abcdef", "events": ["permissions.changed"]}`), 201},
+		{adminRequest("POST", "/", `{"service": "service"}`), 400},
+		{adminRequest("POST", "/", `[]`), 400},
+		{adminRequest("DELETE", "/?id=1", ""), 204},
+		{adminRequest("DELETE", "/?id=2", ""), 404},
+		{adminRequest("DELETE", "/", ""), 400},
+		{adminRequest("PATCH", "/", ""), 405},
+	}
+	for _, c := range cases {
+		response := httptest.NewRecorder()
+		server.handleAdminWebhooks().ServeHTTP(response, c.request)
+		assert.Equal(t, c.status, response.Code, c.request.Method+" "+c.request.URL.String())
+		if c.status == 200 || c.status == 201 {
+			assert.Contains(t, response.Body.String(), `"id":"1"`)
+		}
+	}
+}
+
+func TestAdminWebhookDeliveries(t *testing.T) {
+	webhooks := &mockWebhookService{}
+	server := setupServer()
+	server.Webhooks = webhooks
+
+	delivery := directory.WebhookDelivery{ID: "d1", WebhookID: "1", Status: directory.DeliveryFailed}
+	replay := directory.WebhookDelivery{ID: "d2", WebhookID: "1", Status: directory.DeliveryPending, ReplayOf: "d1"}
+	webhooks.On("ListDeliveries", "1", "failed").Return([]directory.WebhookDelivery{delivery}, nil)
+	webhooks.On("ListDeliveries", "", "").Return([]directory.WebhookDelivery(nil), errors.New("cache unavailable"))
+	webhooks.On("ReplayDelivery", "d1", "admin-tool").Return(replay, nil)
+	webhooks.On("ReplayDelivery", "d3", "admin-tool").Return(directory.WebhookDelivery{}, directory.ErrDeliveryNotFound)
+
+	cases := []struct {
+		handler http.HandlerFunc
+		request *http.Request
+		status  int
+		body    string
+	}{
+		{server.handleAdminWebhookDeliveries(), adminRequest("GET", "/?webhook=1&status=failed", ""), 200, `"id":"d1"`},
+		{server.handleAdminWebhookDeliveries(), adminRequest("GET", "/", ""), 500, ""},
+		{server.handleAdminWebhookDeliveries(), adminRequest("POST", "/", ""), 405, ""},
+		{server.handleAdminWebhookReplay(), adminRequest("POST", "/?id=d1", ""), 202, `"replayOf":"d1"`},
+		{server.handleAdminWebhookReplay(), adminRequest("POST", "/?id=d3", ""), 404, ""},
+		{server.handleAdminWebhookReplay(), adminRequest("POST", "/", ""), 400, ""},
+		{server.handleAdminWebhookReplay(), adminRequest("GET", "/?id=d1", ""), 405, ""},
+	}
+	for _, c := range cases {
+		response := httptest.NewRecorder()
+		c.handler.ServeHTTP(response, c.request)
+		assert.Equal(t, c.status, response.Code, c.request.Method+" "+c.request.URL.String())
+		assert.Contains(t, response.Body.String(), c.body)
+	}
+}
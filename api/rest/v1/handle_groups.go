@@ -6,17 +6,17 @@ import (
 
 	"github.com/getsentry/raven-go"
 
-	"github.com/kiwicom/iam/internal/services/okta"
+	"github.com/kiwicom/iam/internal/services/directory"
 	"github.com/kiwicom/iam/internal/storage"
 )
 
 type groupsGetter interface {
-	GetGroups() ([]okta.Group, error)
+	GetGroups() ([]directory.Group, error)
 }
 
 func (s *Server) handleGroupsGET() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		groups, err := s.OktaService.GetGroups()
+		groups, err := s.Directory.GetGroups()
 		if err == storage.ErrNotFound {
 			// No value available for groups yet
 			w.Header().Add("Retry-After", "30")
@@ -8,21 +8,21 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
-	"github.com/kiwicom/iam/internal/services/okta"
+	"github.com/kiwicom/iam/internal/services/directory"
 	"github.com/kiwicom/iam/internal/storage"
 )
 
 func TestGetGroupsErrors(t *testing.T) {
-	g := &mockOktaService{}
+	g := &mockDirectoryService{}
 	server := setupServer()
-	server.OktaService = g
+	server.Directory = g
 
 	request, _ := http.NewRequest("GET", "/", nil)
 	handler := server.handleGroupsGET()
 
 	// Generic error
 	errMessage := "internal error that shouldn't be exposed"
-	g.On("GetGroups").Return([]okta.Group{}, errors.New(errMessage)).Once()
+	g.On("GetGroups").Return([]directory.Group{}, errors.New(errMessage)).Once()
 	response := httptest.NewRecorder()
 	handler.ServeHTTP(response, request)
 
@@ -30,7 +30,7 @@ func TestGetGroupsErrors(t *testing.T) {
 	assert.NotEqual(t, errMessage, response.Body.String())
 
 	// No value found in cache
-	g.On("GetGroups").Return([]okta.Group{}, storage.ErrNotFound).Once()
+	g.On("GetGroups").Return([]directory.Group{}, storage.ErrNotFound).Once()
 	response = httptest.NewRecorder()
 	handler.ServeHTTP(response, request)
 
@@ -40,14 +40,14 @@ func TestGetGroupsErrors(t *testing.T) {
 }
 
 func TestGetGroups(t *testing.T) {
-	g := &mockOktaService{}
+	g := &mockDirectoryService{}
 	server := setupServer()
-	server.OktaService = g
+	server.Directory = g
 
 	request, _ := http.NewRequest("GET", "/", nil)
 	handler := server.handleGroupsGET()
 
-	groups := []okta.Group{{ID: "id1", Name: "Group 1"}}
+	groups := []directory.Group{{ID: "id1", Name: "Group 1"}}
 	g.On("GetGroups").Return(groups, nil)
 	response := httptest.NewRecorder()
 	handler.ServeHTTP(response, request)
@@ -0,0 +1,90 @@
+package rest
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+	"github.com/kiwicom/iam/internal/services/okta"
+)
+
+// maxEventHookBodySize limits the size of event batches, Okta sends at most 50
+// events in a request.
+const maxEventHookBodySize = 1 << 20
+
+type changeService interface {
+	ApplyChanges([]directory.Change) error
+}
+
+// eventHookRequest is the body of Okta Event Hook requests
+// https://developer.okta.com/docs/concepts/event-hooks/#sample-event-delivery-payload
+type eventHookRequest struct {
+	EventType string `json:"eventType"`
+	Data      struct {
+		Events []okta.Event `json:"events"`
+	} `json:"data"`
+}
+
+// handleOktaEvents implements the Okta Event Hook protocol. The hook is verified
+// by Okta with a GET request, event batches are delivered with POST requests.
+// Requests are authenticated with the secret configured in Okta as the value of
+// the Authorization header. The hook is not found unless events are applied
+// by Changes, which is set only with the Okta provider.
+func (s *Server) handleOktaEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Changes == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !s.checkEventHookAuth(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			challenge := r.Header.Get("X-Okta-Verification-Challenge")
+			if challenge == "" {
+				http.Error(w, "missing verification challenge", http.StatusBadRequest)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(map[string]string{"verification": challenge}); err != nil {
+				log.Println("[ERROR]", err.Error())
+				raven.CaptureError(err, nil)
+			}
+
+		case http.MethodPost:
+			var body eventHookRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEventHookBodySize)).Decode(&body); err != nil {
+				http.Error(w, "invalid event hook request", http.StatusBadRequest)
+				return
+			}
+
+			if err := s.Changes.ApplyChanges(okta.EventChanges(body.Data.Events)); err != nil {
+				http.Error(w, "Service unavailable", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// checkEventHookAuth checks the shared secret of the Okta Event Hook
+func (s *Server) checkEventHookAuth(r *http.Request) bool {
+	secret, err := s.SecretManager.GetSetting("OKTA_EVENT_HOOK_SECRET")
+	if err != nil || secret == "" {
+		log.Println("[ERROR] Okta event hook secret is not configured")
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(secret)) == 1
+}
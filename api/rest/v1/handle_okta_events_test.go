@@ -0,0 +1,132 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+type mockChangeService struct {
+	mock.Mock
+}
+
+func (o *mockChangeService) ApplyChanges(changes []directory.Change) error {
+	return o.Called(changes).Error(0)
+}
+
+type eventHookSecretManager struct {
+	mockedSecretManager
+}
+
+func (s *eventHookSecretManager) GetSetting(key string) (string, error) {
+	if key == "OKTA_EVENT_HOOK_SECRET" {
+		return "hook secret", nil
+	}
+	return "", errors.New("setting not found")
+}
+
+func setupEventHookServer() (*Server, *mockChangeService) {
+	events := &mockChangeService{}
+	server := setupServer()
+	server.SecretManager = &eventHookSecretManager{}
+	server.Changes = events
+	return server, events
+}
+
+func TestOktaEventHookVerification(t *testing.T) {
+	server, _ := setupEventHookServer()
+
+	request, _ := http.NewRequest("GET", "/v1/okta/events", nil)
+	request.Header.Set("Authorization", "hook secret")
+	request.Header.Set("X-Okta-Verification-Challenge", "challenge")
+	response := httptest.NewRecorder()
+	server.handleOktaEvents().ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"verification":"challenge"}`, response.Body.String())
+}
+
+func TestOktaEventHookUnauthorized(t *testing.T) {
+	server, events := setupEventHookServer()
+
+	for _, auth := range []string{"", "wrong secret"} {
+		request, _ := http.NewRequest("POST", "/v1/okta/events", strings.NewReader(`{}`))
+		request.Header.Set("Authorization", auth)
+		response := httptest.NewRecorder()
+		server.handleOktaEvents().ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	}
+	events.AssertNotCalled(t, "ApplyChanges", mock.Anything)
+
+	// Requests are rejected when no secret is configured
+	server.SecretManager = createFakeManager()
+	request, _ := http.NewRequest("POST", "/v1/okta/events", strings.NewReader(`{}`))
+	response := httptest.NewRecorder()
+	server.handleOktaEvents().ServeHTTP(response, request)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+}
+
+func TestOktaEventHookEvents(t *testing.T) {
+	server, events := setupEventHookServer()
+	body := `{
+		"eventType": "com.okta.event_hook",
+		"eventId": "b5a188b9-5ece-4636-b041-482ffda96311",
+		"data": {"events": [{
+			"uuid": "79fa6bf4-c6c1-11ea-a2d9-39d6ea1c3f0f",
+			"published": "2020-07-15T10:36:33.497Z",
+			"eventType": "group.user_membership.add",
+			"target": [
+				{"id": "00u1", "type": "User", "alternateId": "user@kiwi.com"},
+				{"id": "00g1", "type": "UserGroup", "alternateId": "unknown", "displayName": "iam-service.read"}
+			]
+		}]}
+	}`
+	events.On("ApplyChanges", mock.MatchedBy(func(c []directory.Change) bool {
+		return len(c) == 1 && c[0].ID == "79fa6bf4-c6c1-11ea-a2d9-39d6ea1c3f0f" && c[0].Email == "user@kiwi.com" &&
+			c[0].Type == directory.ChangeMembershipAdded && c[0].Group.Name == "iam-service.read"
+	})).Return(nil).Once()
+
+	request, _ := http.NewRequest("POST", "/v1/okta/events", strings.NewReader(body))
+	request.Header.Set("Authorization", "hook secret")
+	response := httptest.NewRecorder()
+	server.handleOktaEvents().ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusNoContent, response.Code)
+	events.AssertExpectations(t)
+
+	events.On("ApplyChanges", mock.Anything).Return(errors.New("cache unavailable"))
+	request, _ = http.NewRequest("POST", "/v1/okta/events", strings.NewReader(body))
+	request.Header.Set("Authorization", "hook secret")
+	response = httptest.NewRecorder()
+	server.handleOktaEvents().ServeHTTP(response, request)
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+
+	request, _ = http.NewRequest("POST", "/v1/okta/events", strings.NewReader(`{"data":`))
+	request.Header.Set("Authorization", "hook secret")
+	response = httptest.NewRecorder()
+	server.handleOktaEvents().ServeHTTP(response, request)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
+
+func TestOktaEventsRoute(t *testing.T) {
+	server := NewServer("test")
+	server.SecretManager = &eventHookSecretManager{}
+
+	request, _ := http.NewRequest("GET", "/v1/okta/events", nil)
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusNotFound, response.Code, "The hook is served only with the Okta provider")
+
+	server.Changes = &mockChangeService{}
+	response = httptest.NewRecorder()
+	server.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+}
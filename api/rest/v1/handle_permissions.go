@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/security"
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// permissionCatalog lists permissions of a service in an environment
+type permissionCatalog struct {
+	Service     string                   `json:"service"`
+	Environment string                   `json:"environment,omitempty"`
+	Permissions []directory.CatalogEntry `json:"permissions"`
+}
+
+// handlePermissionsGET lists the permissions of a service in an environment
+// with the groups granting them. The service and the environment of the caller
+// are used if the parameters are missing.
+func (s *Server) handlePermissionsGET() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		catalog := permissionCatalog{
+			Service:     query.Get("service"),
+			Environment: query.Get("environment"),
+		}
+
+		service, getServiceErr := security.GetService(r.Header.Get("User-Agent"))
+		if catalog.Service == "" {
+			if getServiceErr != nil {
+				http.Error(w, "Missing service and invalid user agent", http.StatusBadRequest)
+				return
+			}
+			catalog.Service = service.Name
+		}
+		if catalog.Environment == "" && getServiceErr == nil {
+			catalog.Environment = service.Environment
+		}
+
+		permissions, err := s.Directory.PermissionCatalog(catalog.Service, catalog.Environment)
+		if err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+			http.Error(w, "Service unavailable", http.StatusInternalServerError)
+			return
+		}
+		catalog.Permissions = permissions
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(catalog); err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+	}
+}
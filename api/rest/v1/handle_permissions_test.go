@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+func TestPermissions(t *testing.T) {
+	userService := &mockDirectoryService{}
+	server := setupServer()
+	server.Directory = userService
+
+	entries := []directory.CatalogEntry{
+		{Permission: "orders.read", Group: "iam-service.orders.read"},
+		{Permission: "orders.refund", Group: "iam-service@sandbox.orders.refund", Environment: "sandbox", Overrides: true},
+	}
+	userService.On("PermissionCatalog", "service", "sandbox").Return(entries, nil)
+	userService.On("PermissionCatalog", "other", "").Return([]directory.CatalogEntry(nil), errors.New("boom"))
+
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.Header.Set("User-Agent", "service/0 (Kiwi.com sandbox)")
+	response := httptest.NewRecorder()
+	server.handlePermissionsGET().ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+	var catalog permissionCatalog
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &catalog))
+	assert.Equal(t, permissionCatalog{Service: "service", Environment: "sandbox", Permissions: entries}, catalog,
+		"The service and the environment of the caller are used by default")
+
+	request, _ = http.NewRequest("GET", "/?service=other", nil)
+	response = httptest.NewRecorder()
+	server.handlePermissionsGET().ServeHTTP(response, request)
+	assert.Equal(t, 500, response.Code)
+
+	request, _ = http.NewRequest("GET", "/", nil)
+	response = httptest.NewRecorder()
+	server.handlePermissionsGET().ServeHTTP(response, request)
+	assert.Equal(t, 400, response.Code)
+}
@@ -0,0 +1,53 @@
+package rest
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/kiwicom/iam/internal/monitoring"
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// ReadinessChecker is a dependency reported by the readiness endpoint
+type ReadinessChecker interface {
+	// Ready returns an error when the dependency can't serve requests.
+	Ready() error
+}
+
+// handleReadiness reports the state of the dependencies in ReadinessChecks. It
+// responds with 503 when any of them is not ready. An unavailable identity
+// provider, ie. an open Okta circuit breaker, is reported as degraded without
+// failing readiness, as users and permissions are still served from cache and
+// all replicas would be taken out of rotation at once.
+func (s *Server) handleReadiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := http.StatusOK
+		states := make(map[string]string, len(s.ReadinessChecks))
+		for name, check := range s.ReadinessChecks {
+			err := check.Ready()
+			if err == nil {
+				states[name] = "ok"
+				continue
+			}
+
+			state := "unavailable"
+			if errors.Is(err, directory.ErrUpstreamUnavailable) {
+				state = "degraded"
+				states[name] = "degraded: " + err.Error()
+			} else {
+				states[name] = err.Error()
+				code = http.StatusServiceUnavailable
+			}
+			if s.MetricClient != nil {
+				s.MetricClient.Incr("readiness", monitoring.Tag("dependency", name), monitoring.Tag("state", state))
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(states); err != nil {
+			log.Println("[ERROR] Failed to return readiness: ", err)
+		}
+	}
+}
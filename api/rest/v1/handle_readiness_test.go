@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+type readinessCheckerFunc func() error
+
+func (f readinessCheckerFunc) Ready() error {
+	return f()
+}
+
+func TestReadiness(t *testing.T) {
+	s := Server{ReadinessChecks: map[string]ReadinessChecker{
+		"okta": readinessCheckerFunc(func() error { return nil }),
+	}}
+	req, _ := http.NewRequest("GET", "/readiness", nil)
+
+	w := httptest.NewRecorder()
+	s.handleReadiness().ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"okta\":\"ok\"}\n", w.Body.String())
+
+	s.ReadinessChecks["okta"] = readinessCheckerFunc(func() error { return errors.New("okta is unavailable") })
+	w = httptest.NewRecorder()
+	s.handleReadiness().ServeHTTP(w, req)
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "{\"okta\":\"okta is unavailable\"}\n", w.Body.String())
+
+	s.ReadinessChecks["okta"] = readinessCheckerFunc(func() error {
+		return fmt.Errorf("circuit breaker open: %w", directory.ErrUpstreamUnavailable)
+	})
+	w = httptest.NewRecorder()
+	s.handleReadiness().ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code, "Replicas serving from cache stay ready while the provider is unavailable")
+	assert.Equal(t, "{\"okta\":\"degraded: circuit breaker open: identity provider is unavailable\"}\n", w.Body.String())
+}
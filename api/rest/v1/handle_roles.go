@@ -0,0 +1,28 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/getsentry/raven-go"
+)
+
+// handleRolesGET lists the roles of a service, or of all services without the
+// service parameter, with their effective permissions
+func (s *Server) handleRolesGET() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roles, err := s.Directory.ListRoles(r.URL.Query().Get("service"))
+		if err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+			http.Error(w, "Service unavailable", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(roles); err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+func TestRoles(t *testing.T) {
+	userService := &mockDirectoryService{}
+	server := setupServer()
+	server.Directory = userService
+
+	roles := []directory.Role{{
+		Service:              "service",
+		Name:                 "support",
+		Permissions:          []string{"orders.*"},
+		EffectivePermissions: []string{"orders.*", "orders.refund"},
+	}}
+	userService.On("ListRoles", "service").Return(roles, nil)
+	userService.On("ListRoles", "").Return([]directory.Role(nil), errors.New("boom"))
+
+	request, _ := http.NewRequest("GET", "/?service=service", nil)
+	response := httptest.NewRecorder()
+	server.handleRolesGET().ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+	var body []directory.Role
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, roles, body)
+
+	request, _ = http.NewRequest("GET", "/", nil)
+	response = httptest.NewRecorder()
+	server.handleRolesGET().ServeHTTP(response, request)
+	assert.Equal(t, 500, response.Code)
+}
@@ -10,10 +10,10 @@ import (
 	"github.com/getsentry/raven-go"
 
 	"github.com/kiwicom/iam/internal/security"
-	"github.com/kiwicom/iam/internal/services/okta"
+	"github.com/kiwicom/iam/internal/services/directory"
 )
 
-// handleUser looks up an Okta user by email
+// handleUser looks up a user by email
 func (s *Server) handleUserGET() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params, paramErr := validateUsersParams(r.URL.RawQuery)
@@ -21,59 +21,20 @@ func (s *Server) handleUserGET() http.HandlerFunc {
 			http.Error(w, paramErr.Error(), http.StatusBadRequest)
 			return
 		}
-		email := params["email"]
-		serviceName := params["service"]
 
-		if serviceName == "" {
-			service, getServiceErr := security.GetService(r.Header.Get("User-Agent"))
-			if getServiceErr != nil {
-				http.Error(w, "Missing service and invalid user agent", http.StatusBadRequest)
-				return
-			}
-
-			serviceName = service.Name
-		}
-
-		// getUser just wraps GetUser in tracing
-		getUser := func() (*okta.User, error) {
-			span, _ := s.Tracer.StartSpanWithContext(r.Context(), "user-data", "okta-controller", "http")
-			defer s.Tracer.FinishSpan(span)
-			oktaUser, err := s.OktaService.GetUser(email)
-
-			return &oktaUser, err
-		}
-		oktaUser, err := getUser()
-		if err == okta.ErrUserNotFound {
-			http.Error(w, "User "+email+" not found", http.StatusNotFound)
-			return
-		}
-		if err != nil {
-			http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		user, ok := s.userWithPermissions(w, r, params)
+		if !ok {
 			return
 		}
 
-		// addPermissions just wraps AddPermissions with tracing
-		addPermissions := func() error {
-			span, _ := s.Tracer.StartSpanWithContext(r.Context(), "permissions", "okta-controller", "http")
-			defer s.Tracer.FinishSpan(span)
-			permErr := s.OktaService.AddPermissions(oktaUser, serviceName)
-
-			return permErr
-		}
-
-		permErr := addPermissions()
-		if permErr != nil {
-			log.Println("[ERROR]", permErr.Error())
-			raven.CaptureError(permErr, nil)
-		}
-
-		oktaUser.OktaID = ""           // OktaID is used only internally
-		oktaUser.GroupMembership = nil // GroupMembership is used only internally
+		user.ID = ""               // ID is used only internally
+		user.GroupMembership = nil // GroupMembership is used only internally
+		user.PrivateAttributes = nil
 
 		w.Header().Set("Content-Type", "application/json")
 		je := json.NewEncoder(w)
 
-		mapUser, err := formatUser(oktaUser)
+		mapUser, err := formatUser(user)
 		if err != nil {
 			http.Error(w, "Internal error", http.StatusInternalServerError)
 			return
@@ -86,6 +47,85 @@ func (s *Server) handleUserGET() http.HandlerFunc {
 	}
 }
 
+// userWithPermissions looks up the user requested by params with permissions
+// of the requested service, scoped to the requested environment or to the
+// environment of the caller. The service and the environment are set in params
+// when they're resolved from the user agent. Errors are written to w, and false
+// is returned.
+func (s *Server) userWithPermissions(w http.ResponseWriter, r *http.Request, params map[string]string) (*directory.User, bool) {
+	email := params["email"]
+	serviceName := params["service"]
+	environment := params["environment"]
+
+	service, getServiceErr := security.GetService(r.Header.Get("User-Agent"))
+	if serviceName == "" {
+		if getServiceErr != nil {
+			http.Error(w, "Missing service and invalid user agent", http.StatusBadRequest)
+			return nil, false
+		}
+
+		serviceName = service.Name
+		params["service"] = serviceName
+	}
+	if environment == "" && getServiceErr == nil {
+		// Permissions are scoped to the environment of the caller
+		environment = service.Environment
+		params["environment"] = environment
+	}
+	scopedService := directory.ScopedService(serviceName, environment)
+
+	// getUser just wraps GetUser in tracing
+	getUser := func() (*directory.User, error) {
+		span, _ := s.Tracer.StartSpanWithContext(r.Context(), "user-data", "directory", "http")
+		defer s.Tracer.FinishSpan(span)
+		user, err := s.Directory.GetUser(email)
+
+		return &user, err
+	}
+	user, err := getUser()
+	if errors.Is(err, directory.ErrUserNotFound) {
+		http.Error(w, "User "+email+" not found", http.StatusNotFound)
+		return nil, false
+	}
+	if errors.Is(err, directory.ErrUpstreamUnavailable) {
+		w.Header().Add("Retry-After", "30")
+		http.Error(w, "Identity provider is unavailable, try later", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	// addPermissions just wraps AddPermissions with tracing
+	addPermissions := func() error {
+		span, _ := s.Tracer.StartSpanWithContext(r.Context(), "permissions", "directory", "http")
+		defer s.Tracer.FinishSpan(span)
+		permErr := s.Directory.AddPermissions(user, scopedService)
+		if permErr == nil && params["expand"] == "true" {
+			permErr = s.Directory.ExpandPermissions(user, scopedService)
+		}
+
+		return permErr
+	}
+
+	permErr := addPermissions()
+	if errors.Is(permErr, directory.ErrUpstreamUnavailable) {
+		w.Header().Add("Retry-After", "30")
+		http.Error(w, "Identity provider is unavailable, try later", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if permErr != nil {
+		// Permissions are not served without all rules applied, denies
+		// included.
+		log.Println("[ERROR]", permErr.Error())
+		raven.CaptureError(permErr, nil)
+		http.Error(w, "Service unavailable", http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
 // validateUsersParams validates query parameters for the users endpoint.
 func validateUsersParams(rawQuery string) (map[string]string, error) {
 	values, err := url.ParseQuery(rawQuery)
@@ -94,8 +134,11 @@ func validateUsersParams(rawQuery string) (map[string]string, error) {
 	}
 
 	params := map[string]string{
-		"email":   values.Get("email"),
-		"service": values.Get("service"),
+		"email":       values.Get("email"),
+		"service":     values.Get("service"),
+		"expand":      values.Get("expand"),
+		"permission":  values.Get("permission"),
+		"environment": values.Get("environment"),
 	}
 
 	if params["email"] == "" {
@@ -109,7 +152,7 @@ func validateUsersParams(rawQuery string) (map[string]string, error) {
 }
 
 // formatUser converts the given user to map
-func formatUser(s *okta.User) (map[string]interface{}, error) {
+func formatUser(s *directory.User) (map[string]interface{}, error) {
 	str, err := json.Marshal(s)
 	if err != nil {
 		return nil, err
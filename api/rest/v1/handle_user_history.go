@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// permissionHistory lists changes of permissions of a user
+type permissionHistory struct {
+	Email   string                   `json:"email"`
+	Changes []directory.HistoryEntry `json:"changes"`
+}
+
+// handleUserHistoryGET lists changes of permissions of a user caused by
+// changes of members of groups, oldest first. Changes of all services are
+// listed if the service is missing.
+func (s *Server) handleUserHistoryGET() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := validateUsersParams(r.URL.RawQuery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		changes, err := s.Directory.History(params["email"], params["service"], params["permission"])
+		if err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+			http.Error(w, "Service unavailable", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(permissionHistory{params["email"], changes}); err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+	}
+}
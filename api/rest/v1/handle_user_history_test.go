@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+func TestUserHistory(t *testing.T) {
+	userService := &mockDirectoryService{}
+	server := setupServer()
+	server.Directory = userService
+
+	changes := []directory.HistoryEntry{{
+		Time:       time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC),
+		Service:    "service",
+		Permission: "orders.read",
+		Change:     directory.HistoryGranted,
+		Group:      "iam-service.orders.read",
+		Source:     directory.HistorySourceSync,
+	}}
+	userService.On("History", "user@kiwi.com", "service", "orders.read").Return(changes, nil)
+	userService.On("History", "broken@kiwi.com", "", "").Return([]directory.HistoryEntry(nil), errors.New("boom"))
+
+	request, _ := http.NewRequest("GET", "/?email=user@kiwi.com&service=service&permission=orders.read", nil)
+	response := httptest.NewRecorder()
+	server.handleUserHistoryGET().ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+	var history permissionHistory
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &history))
+	assert.Equal(t, permissionHistory{Email: "user@kiwi.com", Changes: changes}, history)
+
+	request, _ = http.NewRequest("GET", "/?email=broken@kiwi.com", nil)
+	response = httptest.NewRecorder()
+	server.handleUserHistoryGET().ServeHTTP(response, request)
+	assert.Equal(t, 500, response.Code)
+
+	request, _ = http.NewRequest("GET", "/?service=service", nil)
+	response = httptest.NewRecorder()
+	server.handleUserHistoryGET().ServeHTTP(response, request)
+	assert.Equal(t, 400, response.Code)
+}
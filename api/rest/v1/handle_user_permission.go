@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/getsentry/raven-go"
+)
+
+// permissionCheck is the result of checking a permission of a user
+type permissionCheck struct {
+	Permission string `json:"permission"`
+	Allowed    bool   `json:"allowed"`
+}
+
+// handleUserPermissionGET checks a permission of a user, matching it against
+// the hierarchical and wildcard permissions granted to the user
+func (s *Server) handleUserPermissionGET() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, paramErr := validateUsersParams(r.URL.RawQuery)
+		if paramErr != nil {
+			http.Error(w, paramErr.Error(), http.StatusBadRequest)
+			return
+		}
+		if params["permission"] == "" {
+			http.Error(w, "missing permission", http.StatusBadRequest)
+			return
+		}
+
+		user, ok := s.userWithPermissions(w, r, params)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		check := permissionCheck{
+			Permission: params["permission"],
+			Allowed:    user.HasPermission(params["permission"]),
+		}
+		if err := json.NewEncoder(w).Encode(check); err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+func TestUserPermission(t *testing.T) {
+	userService := &mockDirectoryService{}
+	server := setupServer()
+	server.Directory = userService
+
+	user := testUser
+	user.Permissions = []string{"orders.*", "users.read"}
+	userService.On("GetUser", "test@test.com").Return(user, nil)
+	userService.On("AddPermissions", mock.Anything, "service").Return(nil)
+
+	checks := map[string]bool{
+		"orders.refund.approve": true,
+		"users.read":            true,
+		"users.write":           false,
+	}
+	for permission, allowed := range checks {
+		request, _ := http.NewRequest("GET", "/?email=test@test.com&service=service&permission="+permission, nil)
+		response := httptest.NewRecorder()
+		server.handleUserPermissionGET().ServeHTTP(response, request)
+		assert.Equal(t, 200, response.Code)
+
+		var check permissionCheck
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &check))
+		assert.Equal(t, permissionCheck{Permission: permission, Allowed: allowed}, check)
+	}
+}
+
+func TestUserPermissionErrors(t *testing.T) {
+	userService := &mockDirectoryService{}
+	server := setupServer()
+	server.Directory = userService
+	userService.On("GetUser", "notfound@test.com").Return(directory.User{}, directory.ErrUserNotFound)
+
+	request, _ := http.NewRequest("GET", "/?email=test@test.com&service=service", nil)
+	response := httptest.NewRecorder()
+	server.handleUserPermissionGET().ServeHTTP(response, request)
+	assert.Equal(t, 400, response.Code, "Returns 400 without a permission")
+	assert.Equal(t, "missing permission\n", response.Body.String())
+
+	request, _ = http.NewRequest("GET", "/?email=notfound@test.com&service=service&permission=read", nil)
+	response = httptest.NewRecorder()
+	server.handleUserPermissionGET().ServeHTTP(response, request)
+	assert.Equal(t, 404, response.Code)
+	userService.AssertNotCalled(t, "AddPermissions")
+}
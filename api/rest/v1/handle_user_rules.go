@@ -0,0 +1,65 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// rulesEvaluation is the result of a dry run of the rules of a service's
+// policy for a user, explaining the permissions of the user
+type rulesEvaluation struct {
+	Email   string `json:"email"`
+	Service string `json:"service"`
+	// Environment scoping permissions granted by groups
+	Environment string                 `json:"environment,omitempty"`
+	Rules       []directory.RuleResult `json:"rules"`
+	// Denies are the deny entries applying to the user
+	Denies []directory.Deny `json:"denies"`
+	// Permissions are all permissions of the user, granted by groups and rules
+	// without the denied ones
+	Permissions []string `json:"permissions"`
+}
+
+// handleUserRulesGET evaluates the rules of a service's policy for a user,
+// showing which rules fired and which deny entries apply without granting
+// anything
+func (s *Server) handleUserRulesGET() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, paramErr := validateUsersParams(r.URL.RawQuery)
+		if paramErr != nil {
+			http.Error(w, paramErr.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user, ok := s.userWithPermissions(w, r, params)
+		if !ok {
+			return
+		}
+
+		denies, err := s.Directory.UserDenies(user, directory.ScopedService(params["service"], params["environment"]))
+		if err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+			http.Error(w, "Service unavailable", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		evaluation := rulesEvaluation{
+			Email:       user.Email,
+			Service:     params["service"],
+			Environment: params["environment"],
+			Rules:       s.Directory.EvaluateRules(user, params["service"]),
+			Denies:      denies,
+			Permissions: user.Permissions,
+		}
+		if err := json.NewEncoder(w).Encode(evaluation); err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+	}
+}
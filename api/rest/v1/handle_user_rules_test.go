@@ -0,0 +1,46 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+func TestUserRules(t *testing.T) {
+	userService := &mockDirectoryService{}
+	server := setupServer()
+	server.Directory = userService
+
+	results := []directory.RuleResult{
+		{Name: "refunds", Permissions: []string{"orders.refund"}, Fired: true},
+		{Name: "managers", Permissions: []string{"orders.approve"}, Fired: false},
+	}
+	userService.On("GetUser", "test@test.com").Return(testUser, nil)
+	userService.On("AddPermissions", mock.Anything, "service@test").Return(nil)
+	userService.On("EvaluateRules", mock.Anything, "service").Return(results)
+	denies := []directory.Deny{{ID: "1", Service: "service", Permission: "orders.approve", Email: "test@test.com"}}
+	userService.On("UserDenies", mock.Anything, "service@test").Return(denies, nil)
+
+	request, _ := http.NewRequest("GET", "/?email=test@test.com", nil)
+	request.Header.Set("User-Agent", "service/0 (Kiwi.com test)")
+	response := httptest.NewRecorder()
+	server.handleUserRulesGET().ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+
+	var evaluation rulesEvaluation
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &evaluation))
+	assert.Equal(t, "service", evaluation.Service, "The service is resolved from the user agent")
+	assert.Equal(t, "test", evaluation.Environment, "Permissions are scoped to the environment of the caller")
+	assert.Equal(t, results, evaluation.Rules)
+	assert.Equal(t, denies, evaluation.Denies)
+
+	request, _ = http.NewRequest("GET", "/?service=service", nil)
+	response = httptest.NewRecorder()
+	server.handleUserRulesGET().ServeHTTP(response, request)
+	assert.Equal(t, 400, response.Code)
+}
@@ -2,17 +2,19 @@ package rest
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 
 	"github.com/kiwicom/iam/internal/monitoring"
-	"github.com/kiwicom/iam/internal/services/okta"
+	"github.com/kiwicom/iam/internal/services/directory"
 )
 
-var testUser = okta.User{
+var testUser = directory.User{
 	FirstName:   "Test",
 	LastName:    "Tester",
 	Position:    "QA Tester",
@@ -34,11 +36,11 @@ func setupServer() *Server {
 }
 
 func TestMissingQuery(t *testing.T) {
-	userService := &mockOktaService{}
+	userService := &mockDirectoryService{}
 	request, _ := http.NewRequest("GET", "/", nil)
 	response := httptest.NewRecorder()
 	server := setupServer()
-	server.OktaService = userService
+	server.Directory = userService
 
 	handler := server.handleUserGET()
 
@@ -52,11 +54,11 @@ func TestMissingQuery(t *testing.T) {
 }
 
 func TestWrongEmail(t *testing.T) {
-	userService := &mockOktaService{}
+	userService := &mockDirectoryService{}
 	request, _ := http.NewRequest("GET", "/?email=testest", nil)
 	response := httptest.NewRecorder()
 	server := setupServer()
-	server.OktaService = userService
+	server.Directory = userService
 
 	handler := server.handleUserGET()
 
@@ -70,11 +72,11 @@ func TestWrongEmail(t *testing.T) {
 }
 
 func TestMissingUserAgent(t *testing.T) {
-	userService := &mockOktaService{}
+	userService := &mockDirectoryService{}
 	request, _ := http.NewRequest("GET", "/?email=test@test.com", nil)
 	response := httptest.NewRecorder()
 	server := setupServer()
-	server.OktaService = userService
+	server.Directory = userService
 
 	handler := server.handleUserGET()
 	handler.ServeHTTP(response, request)
@@ -88,22 +90,22 @@ func TestMissingUserAgent(t *testing.T) {
 
 func TestHappyPathWithPermissions(t *testing.T) {
 	// Success response
-	userService := &mockOktaService{}
+	userService := &mockDirectoryService{}
 	request, _ := http.NewRequest("GET", "/?email=test@test.com&service=service", nil)
 	request.Header.Set("User-Agent", "whatever/0 (Kiwi.com test)")
 	response := httptest.NewRecorder()
 	server := setupServer()
-	server.OktaService = userService
+	server.Directory = userService
 
 	handler := server.handleUserGET()
 	userService.On("GetUser", "test@test.com").Return(testUser, nil)
-	userService.On("AddPermissions", &testUser, "service").Return(nil)
+	userService.On("AddPermissions", &testUser, "service@test").Return(nil)
 
 	handler.ServeHTTP(response, request)
 	assert.Equal(t, 200, response.Code, "Returns 200 on success")
 
 	responseJSON := response.Body.Bytes()
-	var responseUser okta.User
+	var responseUser directory.User
 	_ = json.Unmarshal(responseJSON, &responseUser)
 
 	assert.Equal(t, testUser, responseUser, "Returns correct body")
@@ -116,13 +118,13 @@ func TestUserAgentFallback(t *testing.T) {
 	request.Header.Set("User-Agent", "service/0 (Kiwi.com test)")
 	response := httptest.NewRecorder()
 
-	userService := &mockOktaService{}
+	userService := &mockDirectoryService{}
 	server := setupServer()
-	server.OktaService = userService
+	server.Directory = userService
 
 	handler := server.handleUserGET()
 	userService.On("GetUser", "test@test.com").Return(testUser, nil)
-	userService.On("AddPermissions", &testUser, "service").Return(nil)
+	userService.On("AddPermissions", &testUser, "service@test").Return(nil)
 
 	handler.ServeHTTP(response, request)
 	assert.Equal(t, 200, response.Code, "Returns 200 on success")
@@ -140,17 +142,39 @@ func TestUserAgentFallback(t *testing.T) {
 	userService.AssertNumberOfCalls(t, "AddPermissions", 1)
 }
 
+func TestEnvironmentScopedPermissions(t *testing.T) {
+	userService := &mockDirectoryService{}
+	server := setupServer()
+	server.Directory = userService
+	userService.On("GetUser", "test@test.com").Return(testUser, nil)
+	userService.On("AddPermissions", mock.Anything, "service@sandbox").Return(nil)
+	userService.On("AddPermissions", mock.Anything, "service").Return(nil)
+
+	request, _ := http.NewRequest("GET", "/?email=test@test.com&environment=sandbox", nil)
+	request.Header.Set("User-Agent", "service/0 (Kiwi.com production)")
+	response := httptest.NewRecorder()
+	server.handleUserGET().ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+	userService.AssertCalled(t, "AddPermissions", mock.Anything, "service@sandbox")
+
+	request, _ = http.NewRequest("GET", "/?email=test@test.com&service=service", nil)
+	response = httptest.NewRecorder()
+	server.handleUserGET().ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+	userService.AssertCalled(t, "AddPermissions", mock.Anything, "service")
+}
+
 func TestControllerFailurePath(t *testing.T) {
 	request, _ := http.NewRequest("GET", "/?email=bs@test.com", nil)
 	request.Header.Set("User-Agent", "service/0 (Kiwi.com test)")
 	response := httptest.NewRecorder()
 
-	userService := &mockOktaService{}
+	userService := &mockDirectoryService{}
 	server := setupServer()
-	server.OktaService = userService
+	server.Directory = userService
 
 	handler := server.handleUserGET()
-	userService.On("GetUser", "bs@test.com").Return(okta.User{}, errors.New("boom"))
+	userService.On("GetUser", "bs@test.com").Return(directory.User{}, errors.New("boom"))
 	handler.ServeHTTP(response, request)
 	assert.Equal(t, 500, response.Code, "Returns 500 on controller failure")
 
@@ -165,12 +189,12 @@ func TestNotFoundPath(t *testing.T) {
 	request.Header.Set("User-Agent", "service/0 (Kiwi.com test)")
 	response := httptest.NewRecorder()
 
-	userService := &mockOktaService{}
+	userService := &mockDirectoryService{}
 	server := setupServer()
-	server.OktaService = userService
+	server.Directory = userService
 
 	handler := server.handleUserGET()
-	userService.On("GetUser", "notfound@test.com").Return(okta.User{}, okta.ErrUserNotFound)
+	userService.On("GetUser", "notfound@test.com").Return(directory.User{}, directory.ErrUserNotFound)
 	handler.ServeHTTP(response, request)
 	assert.Equal(t, 404, response.Code, "Returns 404 on user not found")
 
@@ -179,3 +203,92 @@ func TestNotFoundPath(t *testing.T) {
 	userService.AssertNumberOfCalls(t, "GetUser", 1)
 	userService.AssertNotCalled(t, "AddPermissions")
 }
+
+func TestUpstreamUnavailablePath(t *testing.T) {
+	request, _ := http.NewRequest("GET", "/?email=test@test.com", nil)
+	request.Header.Set("User-Agent", "service/0 (Kiwi.com test)")
+	response := httptest.NewRecorder()
+
+	userService := &mockDirectoryService{}
+	server := setupServer()
+	server.Directory = userService
+	handler := server.handleUserGET()
+
+	userService.On("GetUser", "test@test.com").Return(directory.User{}, directory.ErrUpstreamUnavailable)
+
+	handler.ServeHTTP(response, request)
+
+	assert.Equal(t, 503, response.Code, "Returns 503 when Okta is unavailable")
+	assert.NotEqual(t, "", response.Header().Get("Retry-After"))
+	userService.AssertNotCalled(t, "AddPermissions")
+}
+
+func TestUserAttributes(t *testing.T) {
+	userService := &mockDirectoryService{}
+	request, _ := http.NewRequest("GET", "/?email=test@test.com&service=service", nil)
+	response := httptest.NewRecorder()
+	server := setupServer()
+	server.Directory = userService
+
+	user := testUser
+	user.Attributes = map[string]interface{}{"costCenter": float64(1234)}
+	user.PrivateAttributes = map[string]interface{}{"nickName": "Tester"}
+	userService.On("GetUser", "test@test.com").Return(user, nil)
+	userService.On("AddPermissions", mock.Anything, "service").Return(nil)
+
+	server.handleUserGET().ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, map[string]interface{}{"costCenter": float64(1234)}, body["attributes"], "Attributes are a nested object")
+	assert.NotContains(t, body, "privateAttributes", "Private attributes are not exposed")
+}
+
+func TestExpandedPermissions(t *testing.T) {
+	userService := &mockDirectoryService{}
+	request, _ := http.NewRequest("GET", "/?email=test@test.com&service=service&expand=true", nil)
+	response := httptest.NewRecorder()
+	server := setupServer()
+	server.Directory = userService
+
+	userService.On("GetUser", "test@test.com").Return(testUser, nil)
+	userService.On("AddPermissions", mock.Anything, "service").Return(nil)
+	userService.On("ExpandPermissions", mock.Anything, "service").Return(nil).Run(func(args mock.Arguments) {
+		user := args.Get(0).(*directory.User)
+		user.Permissions = append(user.Permissions, "action:read.all")
+	})
+
+	server.handleUserGET().ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+
+	var responseUser directory.User
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &responseUser))
+	assert.Equal(t, []string{"action:read", "action:read.all"}, responseUser.Permissions, "Permissions are expanded")
+	userService.AssertNumberOfCalls(t, "ExpandPermissions", 1)
+}
+
+func TestUserPermissionsErrors(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{directory.ErrUpstreamUnavailable, 503},
+		{fmt.Errorf("loading denies: %w", directory.ErrUpstreamUnavailable), 503},
+		{errors.New("cache unavailable"), 500},
+	}
+	for _, c := range cases {
+		userService := &mockDirectoryService{}
+		request, _ := http.NewRequest("GET", "/?email=test@test.com&service=service", nil)
+		response := httptest.NewRecorder()
+		server := setupServer()
+		server.Directory = userService
+
+		userService.On("GetUser", "test@test.com").Return(testUser, nil)
+		userService.On("AddPermissions", mock.Anything, "service").Return(c.err)
+
+		server.handleUserGET().ServeHTTP(response, request)
+		assert.Equal(t, c.status, response.Code, "Users are not served without all their permissions, %v", c.err)
+		assert.NotContains(t, response.Body.String(), "test@test.com")
+	}
+}
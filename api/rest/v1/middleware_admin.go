@@ -0,0 +1,49 @@
+package rest
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kiwicom/iam/internal/security"
+)
+
+// middlewareAdmin authenticates requests like middlewareSecurity and allows
+// only services listed in AdminServices to call the handler
+func (s *Server) middlewareAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return s.middlewareSecurity(func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			http.Error(w, "Forbidden: admin API is not allowed for the service", http.StatusForbidden)
+			log.Println("[ERROR] admin API denied to", r.Header.Get("User-Agent"))
+			return
+		}
+
+		h(w, r)
+	})
+}
+
+// isAdmin reports whether the service of the request is an admin service, and
+// the token of the request was issued to that service
+func (s *Server) isAdmin(r *http.Request) bool {
+	service, err := security.GetService(r.Header.Get("User-Agent"))
+	if err != nil {
+		return false
+	}
+	token, err := security.GetToken(r.Header.Get("Authorization"))
+	if err != nil {
+		return false
+	}
+	for _, admin := range s.AdminServices {
+		if strings.EqualFold(admin, service.Name) {
+			return security.VerifyServiceToken(s.SecretManager, service, token) == nil
+		}
+	}
+	return false
+}
+
+// adminActor returns the name of the admin service making the request, recorded
+// by audit events
+func adminActor(r *http.Request) string {
+	service, _ := security.GetService(r.Header.Get("User-Agent"))
+	return service.Name
+}
@@ -21,6 +21,11 @@ func (s *mockedSecretManager) DoesTokenExist(token string) bool {
 	return false
 }
 
+// DoesTokenBelongTo accepts "valid token" for admin-tool only
+func (s *mockedSecretManager) DoesTokenBelongTo(token, app string) bool {
+	return token == "valid token" && app == "admin-tool"
+}
+
 func (s *mockedSecretManager) GetSetting(_ string) (string, error) {
 	return "", nil
 }
@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"github.com/stretchr/testify/mock"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+type mockDirectoryService struct {
+	mock.Mock
+}
+
+func (o *mockDirectoryService) AddPermissions(user *directory.User, service string) error {
+	argsToReturn := o.Called(user, service)
+	return argsToReturn.Error(0)
+}
+
+func (o *mockDirectoryService) ExpandPermissions(user *directory.User, service string) error {
+	argsToReturn := o.Called(user, service)
+	return argsToReturn.Error(0)
+}
+
+func (o *mockDirectoryService) GetUser(email string) (directory.User, error) {
+	argsToReturn := o.Called(email)
+	return argsToReturn.Get(0).(directory.User), argsToReturn.Error(1)
+}
+
+func (o *mockDirectoryService) GetGroups() ([]directory.Group, error) {
+	argsToReturn := o.Called()
+	return argsToReturn.Get(0).([]directory.Group), argsToReturn.Error(1)
+}
+
+func (o *mockDirectoryService) ListRoles(service string) ([]directory.Role, error) {
+	argsToReturn := o.Called(service)
+	return argsToReturn.Get(0).([]directory.Role), argsToReturn.Error(1)
+}
+
+func (o *mockDirectoryService) EvaluateRules(user *directory.User, service string) []directory.RuleResult {
+	argsToReturn := o.Called(user, service)
+	return argsToReturn.Get(0).([]directory.RuleResult)
+}
+
+func (o *mockDirectoryService) UserDenies(user *directory.User, service string) ([]directory.Deny, error) {
+	argsToReturn := o.Called(user, service)
+	return argsToReturn.Get(0).([]directory.Deny), argsToReturn.Error(1)
+}
+
+func (o *mockDirectoryService) History(email, service, permission string) ([]directory.HistoryEntry, error) {
+	argsToReturn := o.Called(email, service, permission)
+	return argsToReturn.Get(0).([]directory.HistoryEntry), argsToReturn.Error(1)
+}
+
+func (o *mockDirectoryService) PermissionCatalog(service, environment string) ([]directory.CatalogEntry, error) {
+	argsToReturn := o.Called(service, environment)
+	return argsToReturn.Get(0).([]directory.CatalogEntry), argsToReturn.Error(1)
+}
@@ -15,8 +15,21 @@ func (s *Server) routes() {
 
 	s.Router.HandleFunc("/", s.handleHello())
 	s.Router.HandleFunc("/healthcheck", s.handleHealthcheck())
+	s.Router.HandleFunc("/readiness", s.handleReadiness())
 	s.Router.HandleFunc("/v1/user", s.middlewareSecurity(s.handleUserGET()))
+	s.Router.HandleFunc("/v1/user/permission", s.middlewareSecurity(s.handleUserPermissionGET()))
+	s.Router.HandleFunc("/v1/user/rules", s.middlewareSecurity(s.handleUserRulesGET()))
+	s.Router.HandleFunc("/v1/user/history", s.middlewareSecurity(s.handleUserHistoryGET()))
 	s.Router.HandleFunc("/v1/groups", s.middlewareSecurity(s.handleGroupsGET()))
+	s.Router.HandleFunc("/v1/permissions", s.middlewareSecurity(s.handlePermissionsGET()))
+	s.Router.HandleFunc("/v1/roles", s.middlewareSecurity(s.handleRolesGET()))
+	s.Router.HandleFunc("/v1/admin/grants", s.middlewareAdmin(s.handleAdminGrants()))
+	s.Router.HandleFunc("/v1/admin/denies", s.middlewareAdmin(s.handleAdminDenies()))
+	s.Router.HandleFunc("/v1/admin/webhooks", s.middlewareAdmin(s.handleAdminWebhooks()))
+	s.Router.HandleFunc("/v1/admin/webhooks/deliveries", s.middlewareAdmin(s.handleAdminWebhookDeliveries()))
+	s.Router.HandleFunc("/v1/admin/webhooks/deliveries/replay", s.middlewareAdmin(s.handleAdminWebhookReplay()))
+	s.Router.HandleFunc("/v1/admin/groups/rollback", s.middlewareAdmin(s.handleAdminGroupsRollback()))
+	s.Router.HandleFunc("/v1/okta/events", s.handleOktaEvents())
 
 	s.Router.PathPrefix("/" + wellKnownFolder + "/").Handler(DisableDirectoryListingHandler(
 		http.StripPrefix("/"+wellKnownFolder+"/", http.FileServer(http.Dir(wellKnownFolder))),
@@ -8,15 +8,21 @@ import (
 
 	"github.com/kiwicom/iam/internal/monitoring"
 	"github.com/kiwicom/iam/internal/security/secrets"
-	"github.com/kiwicom/iam/internal/services/okta"
+	"github.com/kiwicom/iam/internal/services/directory"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
-type oktaService interface {
-	AddPermissions(*okta.User, string) error
-	GetUser(string) (okta.User, error)
-	GetGroups() ([]okta.Group, error)
+type directoryService interface {
+	AddPermissions(*directory.User, string) error
+	ExpandPermissions(*directory.User, string) error
+	GetUser(string) (directory.User, error)
+	GetGroups() ([]directory.Group, error)
+	ListRoles(string) ([]directory.Role, error)
+	EvaluateRules(*directory.User, string) []directory.RuleResult
+	UserDenies(*directory.User, string) ([]directory.Deny, error)
+	PermissionCatalog(string, string) ([]directory.CatalogEntry, error)
+	History(string, string, string) ([]directory.HistoryEntry, error)
 }
 
 type metricService interface {
@@ -30,8 +36,23 @@ type Server struct {
 	Router        *tracingRouter.Router
 	SecretManager secrets.SecretManager
 	MetricClient  metricService
-	OktaService   oktaService
+	Directory     directoryService
+	// Changes applies events delivered by the Okta Event Hook, the hook is
+	// not found without it
+	Changes changeService
+	// Grants manages temporary grants through the admin API
+	Grants grantService
+	// Denies manages deny entries through the admin API
+	Denies denyService
+	// Webhooks manages webhook subscriptions and deliveries through the admin API
+	Webhooks webhookService
+	// GroupSnapshots rolls back groups syncs through the admin API
+	GroupSnapshots groupSnapshotService
+	// AdminServices are the services allowed to call the admin API
+	AdminServices []string
 	Tracer        *monitoring.Tracer
+	// ReadinessChecks are the dependencies reported by the readiness endpoint
+	ReadinessChecks map[string]ReadinessChecker
 	// ServiceName is used for tracing purposes
 	ServiceName string
 }
@@ -12,10 +12,22 @@ func TestProtectedRoutes(t *testing.T) {
 	srv := NewServer("test")
 
 	tests := map[string]int{
-		"/":            http.StatusOK,
-		"/healthcheck": http.StatusOK,
-		"/v1/user":     http.StatusUnauthorized,
-		"/v1/groups":   http.StatusUnauthorized,
+		"/":                                    http.StatusOK,
+		"/healthcheck":                         http.StatusOK,
+		"/readiness":                           http.StatusOK,
+		"/v1/user":                             http.StatusUnauthorized,
+		"/v1/user/permission":                  http.StatusUnauthorized,
+		"/v1/user/rules":                       http.StatusUnauthorized,
+		"/v1/user/history":                     http.StatusUnauthorized,
+		"/v1/groups":                           http.StatusUnauthorized,
+		"/v1/permissions":                      http.StatusUnauthorized,
+		"/v1/roles":                            http.StatusUnauthorized,
+		"/v1/admin/grants":                     http.StatusUnauthorized,
+		"/v1/admin/denies":                     http.StatusUnauthorized,
+		"/v1/admin/webhooks":                   http.StatusUnauthorized,
+		"/v1/admin/webhooks/deliveries":        http.StatusUnauthorized,
+		"/v1/admin/webhooks/deliveries/replay": http.StatusUnauthorized,
+		"/v1/admin/groups/rollback":            http.StatusUnauthorized,
 	}
 
 	for route, code := range tests {
@@ -26,6 +38,6 @@ func TestProtectedRoutes(t *testing.T) {
 
 		srv.ServeHTTP(w, req)
 
-		assert.Equal(t, code, w.Code)
+		assert.Equal(t, code, w.Code, route)
 	}
 }
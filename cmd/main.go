@@ -4,9 +4,11 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/getsentry/raven-go"
+	"github.com/kiwicom/go-useragent"
 
 	grpcAPI "github.com/kiwicom/iam/api/grpc"
 	pb "github.com/kiwicom/iam/api/grpc/v1"
@@ -14,6 +16,9 @@ import (
 	cfg "github.com/kiwicom/iam/configs"
 	"github.com/kiwicom/iam/internal/monitoring"
 	"github.com/kiwicom/iam/internal/security/secrets"
+	"github.com/kiwicom/iam/internal/services/directory"
+	"github.com/kiwicom/iam/internal/services/fixture"
+	"github.com/kiwicom/iam/internal/services/ldap"
 	"github.com/kiwicom/iam/internal/services/okta"
 	"github.com/kiwicom/iam/internal/storage"
 
@@ -25,11 +30,11 @@ import (
 	"google.golang.org/grpc/reflection"
 )
 
-func syncOkta(client *okta.Client) {
+func syncDirectory(dir *directory.Directory) {
 	log.Println("Start caching users")
-	client.SyncUsers()
+	dir.SyncUsers()
 	log.Println("Start caching groups")
-	client.SyncGroups()
+	dir.SyncGroups()
 
 	// Run periodic task to fill in the cache
 	ticker := time.NewTicker(time.Minute * 10)
@@ -37,9 +42,72 @@ func syncOkta(client *okta.Client) {
 
 	for tick := range ticker.C {
 		log.Println("Start caching users", tick.Round(time.Second))
-		client.SyncUsers()
+		dir.SyncUsers()
 		log.Println("Start caching groups", tick.Round(time.Second))
-		client.SyncGroups()
+		dir.SyncGroups()
+	}
+}
+
+// pollChanges applies changes from the provider between the syncs
+func pollChanges(dir *directory.Directory, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		dir.PollChanges()
+	}
+}
+
+// expireGrants removes expired temporary grants every minute, recording their
+// expiration
+func expireGrants(dir *directory.Directory) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := dir.ExpireGrants(); err != nil {
+			log.Println("[ERROR] failed to expire grants: ", err)
+			raven.CaptureError(err, nil)
+		}
+	}
+}
+
+// deliverWebhooks sends pending webhook deliveries periodically
+func deliverWebhooks(dir *directory.Directory, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := dir.DeliverWebhooks(); err != nil {
+			log.Println("[ERROR] failed to deliver webhooks: ", err)
+			raven.CaptureError(err, nil)
+		}
+	}
+}
+
+// webhookUserAgent returns the user agent of webhook deliveries
+func webhookUserAgent(iamConfig *cfg.ServiceConfig) string {
+	ua := useragent.UserAgent{
+		Name:        "kiwi-iam",
+		Environment: iamConfig.Environment,
+		Version:     iamConfig.Release,
+	}
+	uaString, err := ua.Format()
+	if err != nil {
+		log.Println("[ERROR]", err)
+		raven.CaptureError(err, nil)
+	}
+	return uaString
+}
+
+// watchFixture applies changes of the directory fixture as soon as it's saved
+func watchFixture(provider *fixture.Provider, dir *directory.Directory) {
+	// Polling starts from now, older changes are picked up by the syncs
+	dir.PollChanges()
+
+	if err := provider.Watch(nil, dir.PollChanges); err != nil {
+		log.Println("[ERROR] failed to watch directory fixture: ", err)
+		raven.CaptureError(err, nil)
 	}
 }
 
@@ -64,24 +132,32 @@ type Cacher interface {
 	Del(key string) error
 }
 
-func clearGroupsLastSync(cache Cacher) {
-	log.Println("Clearing GroupLastSync")
-	err := cache.Del("groups-sync-timestamp")
-	if err != nil {
-		log.Println("[ERROR] failed to delete GroupsLastSync from cache: ", err)
-		raven.CaptureError(err, nil)
+// lastSyncKeys are the timestamps of the last directory syncs. While they are
+// set, only changes since then are fetched from the provider.
+var lastSyncKeys = []string{"groups-sync-timestamp", "users-sync-timestamp"}
+
+func clearLastSync(cache Cacher) {
+	for _, key := range lastSyncKeys {
+		err := cache.Del(key)
+		if err != nil {
+			log.Println("[ERROR] failed to delete", key, "from cache: ", err)
+			raven.CaptureError(err, nil)
+		}
 	}
+}
+
+// clearLastSyncPeriodically makes the next syncs fetch everything, once a day
+// and on new deploys, to reconcile data which incremental syncs can miss.
+func clearLastSyncPeriodically(cache Cacher) {
+	log.Println("Clearing last sync timestamps")
+	clearLastSync(cache)
 
 	ticker := time.NewTicker(time.Hour * 24)
 	defer ticker.Stop()
 
 	for tick := range ticker.C {
-		log.Println("Clearing GroupLastSync", tick.Round(time.Second))
-		err = cache.Del("groups-sync-timestamp")
-		if err != nil {
-			log.Println("[ERROR] failed to delete GroupsLastSync from cache: ", err)
-			raven.CaptureError(err, nil)
-		}
+		log.Println("Clearing last sync timestamps", tick.Round(time.Second))
+		clearLastSync(cache)
 	}
 }
 
@@ -108,6 +184,159 @@ func createSecretManager(path string) secrets.SecretManager {
 	return localSecretManager
 }
 
+// createOktaOAuthOpts returns the OAuth options for the Okta client, or nil if
+// OAuth is not configured, in which case the OKTA_TOKEN is used.
+func createOktaOAuthOpts(oktaConfig *cfg.OktaConfig, secretManager secrets.SecretManager) *okta.OAuthOpts {
+	if oktaConfig.OAuthClientID == "" {
+		return nil
+	}
+
+	pemKey, err := secretManager.GetSetting("OKTA_OAUTH_PRIVATE_KEY")
+	if err != nil {
+		log.Println("[ERROR] Okta OAuth disabled:", err)
+		raven.CaptureError(err, nil)
+		return nil
+	}
+	privateKey, err := okta.ParsePrivateKey(pemKey)
+	if err != nil {
+		log.Println("[ERROR] Okta OAuth disabled:", err)
+		raven.CaptureError(err, nil)
+		return nil
+	}
+
+	return &okta.OAuthOpts{
+		ClientID:   oktaConfig.OAuthClientID,
+		PrivateKey: privateKey,
+		KeyID:      oktaConfig.OAuthKeyID,
+		Scopes:     strings.Fields(oktaConfig.OAuthScopes),
+		TokenURL:   oktaConfig.OAuthTokenURL,
+	}
+}
+
+// createOktaClient creates the Okta directory provider
+func createOktaClient(
+	iamConfig *cfg.ServiceConfig,
+	oktaConfig *cfg.OktaConfig,
+	secretManager secrets.SecretManager,
+	metricClient *monitoring.Metrics,
+) *okta.Client {
+	oktaToken, _ := secretManager.GetSetting("OKTA_TOKEN")
+	profileAttributes, err := okta.ParseProfileAttributes(oktaConfig.ProfileAttributes)
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		panic(err)
+	}
+
+	return okta.NewClient(&okta.ClientOpts{
+		BaseURL:    oktaConfig.URL,
+		AuthToken:  oktaToken,
+		OAuth:      createOktaOAuthOpts(oktaConfig, secretManager),
+		IAMConfig:  iamConfig,
+		OktaConfig: oktaConfig,
+		Metrics:    metricClient,
+
+		ProfileAttributes: profileAttributes,
+	})
+}
+
+// createLDAPClient creates the LDAP directory provider, invalid configuration
+// kills the app.
+func createLDAPClient(ldapConfig *cfg.LDAPConfig, secretManager secrets.SecretManager) *ldap.Client {
+	bindPassword, _ := secretManager.GetSetting("LDAP_BIND_PASSWORD")
+	attributes, err := ldap.ParseAttributeMapping(ldapConfig.Attributes)
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		panic(err)
+	}
+	groupMapping, err := ldap.ParseGroupMapping(ldapConfig.GroupMapping)
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		panic(err)
+	}
+
+	client, err := ldap.NewClient(&ldap.ClientOpts{
+		URL:          ldapConfig.URL,
+		StartTLS:     ldapConfig.StartTLS,
+		BindDN:       ldapConfig.BindDN,
+		BindPassword: bindPassword,
+		Timeout:      ldapConfig.Timeout,
+		UserBaseDN:   ldapConfig.UserBaseDN,
+		UserFilter:   ldapConfig.UserFilter,
+		GroupBaseDN:  ldapConfig.GroupBaseDN,
+		GroupFilter:  ldapConfig.GroupFilter,
+		PageSize:     ldapConfig.PageSize,
+		Membership:   ldapConfig.Membership,
+		Attributes:   attributes,
+		GroupMapping: groupMapping,
+	})
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		panic(err)
+	}
+	return client
+}
+
+// loadWebhookNetworks parses the networks webhooks may point to although they
+// aren't public, an invalid list kills the app.
+func loadWebhookNetworks(list string) []*net.IPNet {
+	networks, err := directory.ParseNetworks(list)
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		panic(err)
+	}
+	return networks
+}
+
+// loadRoles loads the roles file, an invalid file kills the app. Roles are
+// disabled without a file.
+func loadRoles(path string) *directory.Roles {
+	if path == "" {
+		return nil
+	}
+	roles, err := directory.LoadRoles(path)
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		panic(err)
+	}
+	return roles
+}
+
+// loadPolicies loads policy files of services, an invalid file kills the app.
+// Rules are disabled without a directory.
+func loadPolicies(dir string) directory.Policies {
+	if dir == "" {
+		return nil
+	}
+	policies, err := directory.LoadPolicies(dir)
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		panic(err)
+	}
+	return policies
+}
+
+// parseList splits a comma separated list, ignoring empty items
+func parseList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// createFixtureProvider creates the file directory provider, an invalid
+// fixture kills the app.
+func createFixtureProvider(path string) *fixture.Provider {
+	provider, err := fixture.NewProvider(path)
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		panic(err)
+	}
+	return provider
+}
+
 func initErrorTracking(sentry cfg.SentryConfig) {
 	if sentry.Token == "" {
 		log.Println("SENTRY_DSN is not set. Error logging disabled.")
@@ -147,6 +376,7 @@ func main() {
 	var (
 		iamConfig     cfg.ServiceConfig
 		oktaConfig    cfg.OktaConfig
+		ldapConfig    cfg.LDAPConfig
 		storageConfig cfg.StorageConfig
 		datadogConfig cfg.DatadogConfig
 		sentryConfig  cfg.SentryConfig
@@ -154,7 +384,7 @@ func main() {
 	)
 
 	// If there is an error loading the envs kill the app, as nothing will work without them.
-	if err := cfg.LoadConfigs(&iamConfig, &oktaConfig, &storageConfig, &datadogConfig, &sentryConfig, &secretsConfig); err != nil {
+	if err := cfg.LoadConfigs(&iamConfig, &oktaConfig, &ldapConfig, &storageConfig, &datadogConfig, &sentryConfig, &secretsConfig); err != nil {
 		log.Println("[ERROR]", err.Error())
 		panic(err)
 	}
@@ -191,21 +421,57 @@ func main() {
 		storageConfig.LockRetryDelay,
 		storageConfig.LockExpiration,
 	)
-	oktaToken, _ := secretManager.GetSetting("OKTA_TOKEN")
-	oktaClient := okta.NewClient(&okta.ClientOpts{
-		BaseURL:     oktaConfig.URL,
-		AuthToken:   oktaToken,
+	var provider directory.Provider
+	var fixtureProvider *fixture.Provider
+	readinessChecks := make(map[string]restAPI.ReadinessChecker)
+	switch iamConfig.DirectoryProvider {
+	case "okta":
+		oktaClient := createOktaClient(&iamConfig, &oktaConfig, secretManager, metricClient)
+		provider = oktaClient
+		readinessChecks["okta"] = oktaClient
+	case "ldap":
+		provider = createLDAPClient(&ldapConfig, secretManager)
+	case "file":
+		fixtureProvider = createFixtureProvider(iamConfig.DirectoryFile)
+		provider = fixtureProvider
+	default:
+		panic("unknown directory provider " + iamConfig.DirectoryProvider)
+	}
+	dir := directory.New(&directory.Opts{
+		Name:        iamConfig.DirectoryProvider,
+		Provider:    provider,
 		Cache:       cache,
 		LockManager: lock,
-		IAMConfig:   &iamConfig,
 		Metrics:     metricClient,
+
+		MembershipWorkers: iamConfig.MembershipWorkers,
+		SyncGenerations:   iamConfig.SyncGenerations,
+		Roles:             loadRoles(iamConfig.RolesFile),
+		Policies:          loadPolicies(iamConfig.PoliciesDir),
+		MaxGrantDuration:  iamConfig.MaxGrantDuration,
+		HistoryRetention:  iamConfig.HistoryRetention,
+
+		WebhookMaxAttempts:     iamConfig.WebhookMaxAttempts,
+		WebhookRetryDelay:      iamConfig.WebhookRetryDelay,
+		WebhookAllowedNetworks: loadWebhookNetworks(iamConfig.WebhookAllowedNetworks),
+		UserAgent:              webhookUserAgent(&iamConfig),
 	})
 
 	restServer := restAPI.NewServer("kiwi-iam.http.router")
-	restServer.OktaService = oktaClient
+	restServer.Directory = dir
+	restServer.Grants = dir
+	restServer.Denies = dir
+	restServer.Webhooks = dir
+	restServer.GroupSnapshots = dir
+	restServer.AdminServices = parseList(iamConfig.AdminServices)
 	restServer.SecretManager = secretManager
 	restServer.MetricClient = metricClient
 	restServer.Tracer = tracer
+	restServer.ReadinessChecks = readinessChecks
+	if iamConfig.DirectoryProvider == "okta" {
+		// Event Hook deliveries are applied only with the Okta provider
+		restServer.Changes = dir
+	}
 
 	// 0.0.0.0 is specified to allow listening in Docker
 	var address = "0.0.0.0"
@@ -221,9 +487,21 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	if iamConfig.Environment != "dev" {
-		go capturePanic(func() { clearGroupsLastSync(cache) })
-		go capturePanic(func() { syncOkta(oktaClient) })
+	// The file provider is offline, it's synced in development too.
+	if iamConfig.Environment != "dev" || fixtureProvider != nil {
+		go capturePanic(func() { clearLastSyncPeriodically(cache) })
+		go capturePanic(func() { syncDirectory(dir) })
+		if iamConfig.ChangesPollInterval > 0 {
+			go capturePanic(func() { pollChanges(dir, iamConfig.ChangesPollInterval) })
+		}
+	}
+
+	if fixtureProvider != nil {
+		go capturePanic(func() { watchFixture(fixtureProvider, dir) })
+	}
+	go capturePanic(func() { expireGrants(dir) })
+	if iamConfig.WebhookDeliveryInterval > 0 {
+		go capturePanic(func() { deliverWebhooks(dir, iamConfig.WebhookDeliveryInterval) })
 	}
 
 	log.Println("🚀 REST server starting on " + serveAddr)
@@ -238,7 +516,7 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s, _ := grpcAPI.CreateServer(oktaClient)
+	s, _ := grpcAPI.CreateServer(dir)
 
 	creds, err := credentials.NewServerTLSFromFile(iamConfig.GRPCCertFile, iamConfig.GRPCKeyFile)
 	if err != nil {
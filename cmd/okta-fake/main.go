@@ -0,0 +1,57 @@
+// Command okta-fake serves a fake of the Okta API, to run IAM locally without
+// an Okta organization. Point OKTA_URL to http://localhost:8070/api/v1.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kiwicom/iam/internal/services/okta/oktatest"
+)
+
+func main() {
+	addr := flag.String("addr", ":8070", "address to listen on")
+	fixture := flag.String("fixture", "", "JSON file with the users and groups to serve")
+	pageSize := flag.Int("page-size", 200, "number of items in pages of collections")
+	token := flag.String("token", "", "API token required from clients, any request is allowed if empty")
+	rateLimit := flag.Int("rate-limit", 0, "number of requests allowed to each endpoint per minute, 0 to disable")
+	latency := flag.Duration("latency", 0, "latency added to every request")
+	errorRate := flag.Float64("error-rate", 0, "share of requests failing, between 0 and 1")
+	errorStatus := flag.Int("error-status", http.StatusInternalServerError, "status of failing requests")
+	flag.Parse()
+
+	fake := oktatest.NewServer()
+	fake.PageSize = *pageSize
+	fake.Token = *token
+	fake.RateLimit = *rateLimit
+
+	if *fixture != "" {
+		data, err := ioutil.ReadFile(*fixture)
+		if err != nil {
+			log.Fatalln("[ERROR] Reading fixture:", err)
+		}
+		if err := fake.Load(data); err != nil {
+			log.Fatalln("[ERROR] Loading fixture:", err)
+		}
+	}
+	// Errors are added first so that they're not shadowed by the latency, which
+	// applies to every request.
+	if *errorRate > 0 {
+		fake.AddFault(oktatest.Fault{Status: *errorStatus, Latency: *latency, Probability: *errorRate})
+	}
+	if *latency > 0 {
+		fake.AddFault(oktatest.Fault{Latency: *latency})
+	}
+
+	server := &http.Server{
+		Addr:         *addr,
+		Handler:      fake,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: time.Minute,
+	}
+	log.Println("Serving a fake Okta API on", *addr)
+	log.Fatalln(server.ListenAndServe())
+}
@@ -0,0 +1,68 @@
+// Command okta-record runs a directory sync against Okta and records the Okta
+// requests into a sanitized cassette, to reproduce sync issues in tests with
+// okta.NewReplayer. The API token is read from the OKTA_TOKEN environment
+// variable, it's never recorded.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+	"github.com/kiwicom/iam/internal/services/okta"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+func main() {
+	url := flag.String("url", os.Getenv("OKTA_URL"), "Okta API URL, like https://kiwi.okta.com/api/v1")
+	out := flag.String("out", "cassette.json", "file the cassette is written to")
+	profileAttributes := flag.String("profile-attributes", os.Getenv("OKTA_PROFILE_ATTRIBUTES"), "profile attributes mapping")
+	piiAttributes := flag.String("pii-attributes", "", "comma-separated Okta attributes to scrub, on top of the default ones")
+	changes := flag.Duration("changes", time.Hour, "how far back changes are recorded")
+	flag.Parse()
+
+	attributes, err := okta.ParseProfileAttributes(*profileAttributes)
+	if err != nil {
+		log.Fatalln("[ERROR]", err)
+	}
+	var scrubbed []string
+	if *piiAttributes != "" {
+		scrubbed = strings.Split(*piiAttributes, ",")
+	}
+	for _, attribute := range attributes {
+		if attribute.Visibility == okta.VisibilityPrivate {
+			scrubbed = append(scrubbed, attribute.Source)
+		}
+	}
+
+	recorder := okta.NewRecorder(nil, scrubbed...)
+	client := okta.NewClient(&okta.ClientOpts{
+		BaseURL:       *url,
+		AuthToken:     os.Getenv("OKTA_TOKEN"),
+		CustomFetcher: recorder.CustomFetcher,
+
+		ProfileAttributes: attributes,
+	})
+
+	cache := storage.NewInMemoryCache()
+	dir := directory.New(&directory.Opts{
+		Name:        "okta",
+		Provider:    client,
+		Cache:       cache,
+		LockManager: storage.NewLockManager(cache, time.Second, time.Hour),
+	})
+	dir.SyncUsers()
+	dir.SyncGroups()
+	if _, _, _, err := client.ListChanges(time.Now().UTC().Add(-*changes), ""); err != nil {
+		log.Println("[ERROR] Listing changes:", err)
+	}
+
+	cassette := recorder.Cassette()
+	if err := cassette.Save(*out); err != nil {
+		log.Fatalln("[ERROR]", err)
+	}
+	log.Println("Recorded", len(cassette.Interactions), "interactions to", *out)
+}
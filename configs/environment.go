@@ -52,11 +52,75 @@ type ServiceConfig struct {
 	UseLocalhost bool   `mapstructure:"USE_LOCALHOST"`
 	Environment  string `mapstructure:"APP_ENV"`
 	Release      string `mapstructure:"SENTRY_RELEASE"`
+	// DirectoryProvider is the provider of users and groups, okta, ldap or file
+	DirectoryProvider string `mapstructure:"DIRECTORY_PROVIDER"`
+	// DirectoryFile is the fixture read by the file provider
+	DirectoryFile string `mapstructure:"DIRECTORY_FILE"`
+	// RolesFile declares roles of services, roles are disabled if it's empty
+	RolesFile string `mapstructure:"ROLES_FILE"`
+	// PoliciesDir contains policy files of services, rules are disabled if it's
+	// empty
+	PoliciesDir string `mapstructure:"POLICIES_DIR"`
+	// MembershipWorkers is the number of groups whose members are fetched
+	// concurrently by groups syncs
+	MembershipWorkers int `mapstructure:"DIRECTORY_MEMBERSHIP_WORKERS"`
+	// SyncGenerations is the number of groups snapshots, published by groups
+	// syncs and batches of changes, retained to roll back to
+	SyncGenerations int `mapstructure:"DIRECTORY_SYNC_GENERATIONS"`
+	// ChangesPollInterval is the interval of polling changes from the provider,
+	// 0 disables polling
+	ChangesPollInterval time.Duration `mapstructure:"DIRECTORY_CHANGES_POLL_INTERVAL"`
+	// AdminServices is a comma separated list of services allowed to call the
+	// admin API
+	AdminServices string `mapstructure:"ADMIN_SERVICES"`
+	// MaxGrantDuration is the longest a temporary grant can last
+	MaxGrantDuration time.Duration `mapstructure:"MAX_GRANT_DURATION"`
+	// HistoryRetention is how long changes of permissions of users are kept
+	HistoryRetention time.Duration `mapstructure:"HISTORY_RETENTION"`
+	// Webhook deliveries are sent every WebhookDeliveryInterval, 0 disables them
+	WebhookDeliveryInterval time.Duration `mapstructure:"WEBHOOK_DELIVERY_INTERVAL"`
+	WebhookMaxAttempts      int           `mapstructure:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookRetryDelay       time.Duration `mapstructure:"WEBHOOK_RETRY_DELAY"`
+	// WebhookAllowedNetworks is a comma separated list of CIDRs webhooks may
+	// point to although they aren't public
+	WebhookAllowedNetworks string `mapstructure:"WEBHOOK_ALLOWED_NETWORKS"`
 }
 
 // OktaConfig stores configuration values for Okta client
 type OktaConfig struct {
-	URL string `mapstructure:"OKTA_URL"`
+	URL            string        `mapstructure:"OKTA_URL"`
+	RateLimitShare float64       `mapstructure:"OKTA_RATE_LIMIT_SHARE"`
+	MaxRetries     int           `mapstructure:"OKTA_MAX_RETRIES"`
+	RetryBaseDelay time.Duration `mapstructure:"OKTA_RETRY_BASE_DELAY"`
+	RetryMaxDelay  time.Duration `mapstructure:"OKTA_RETRY_MAX_DELAY"`
+	MaxWait        time.Duration `mapstructure:"OKTA_MAX_WAIT"`
+
+	BreakerFailureThreshold int           `mapstructure:"OKTA_BREAKER_FAILURE_THRESHOLD"`
+	BreakerOpenTimeout      time.Duration `mapstructure:"OKTA_BREAKER_OPEN_TIMEOUT"`
+
+	OAuthClientID string `mapstructure:"OKTA_OAUTH_CLIENT_ID"`
+	OAuthKeyID    string `mapstructure:"OKTA_OAUTH_KEY_ID"`
+	OAuthScopes   string `mapstructure:"OKTA_OAUTH_SCOPES"`
+	OAuthTokenURL string `mapstructure:"OKTA_OAUTH_TOKEN_URL"`
+
+	ProfileAttributes string `mapstructure:"OKTA_PROFILE_ATTRIBUTES"`
+}
+
+// LDAPConfig stores configuration values for the LDAP directory provider
+type LDAPConfig struct {
+	URL         string        `mapstructure:"LDAP_URL"`
+	StartTLS    bool          `mapstructure:"LDAP_START_TLS"`
+	BindDN      string        `mapstructure:"LDAP_BIND_DN"`
+	Timeout     time.Duration `mapstructure:"LDAP_TIMEOUT"`
+	UserBaseDN  string        `mapstructure:"LDAP_USER_BASE_DN"`
+	UserFilter  string        `mapstructure:"LDAP_USER_FILTER"`
+	GroupBaseDN string        `mapstructure:"LDAP_GROUP_BASE_DN"`
+	GroupFilter string        `mapstructure:"LDAP_GROUP_FILTER"`
+	PageSize    int           `mapstructure:"LDAP_PAGE_SIZE"`
+
+	Membership   string `mapstructure:"LDAP_MEMBERSHIP"`
+	Attributes   string `mapstructure:"LDAP_ATTRIBUTES"`
+	GroupMapping string `mapstructure:"LDAP_GROUP_MAPPING"`
 }
 
 // StorageConfig stores configuration values for storage client.
@@ -97,10 +161,104 @@ var defaultValues = map[string]interface{}{
 	"APP_ENV": "",
 	// Uses localhost instead of 0.0.0.0, useful for OSX.
 	"USE_LOCALHOST": false,
+	// Provider of users and groups, okta, ldap or file. The file provider reads
+	// users and groups from the YAML or JSON DIRECTORY_FILE and reloads it on
+	// changes, check .directory-sample.yaml. It's synced even if APP_ENV is dev.
+	"DIRECTORY_PROVIDER": "okta",
+	"DIRECTORY_FILE":     "directory.yaml",
+	// YAML or JSON file declaring roles of services, bundles of permissions
+	// granted by iam-<service>.role.<name> groups, check .roles-sample.yaml.
+	// Roles are disabled if it's empty.
+	"ROLES_FILE": "",
+	// Directory of YAML or JSON policy files named <service>.yaml, with rules
+	// granting permissions based on attributes of users, check
+	// .policies-sample/. Rules are disabled if it's empty.
+	"POLICIES_DIR": "",
+	// Comma separated names of services, as in their user agents, allowed to
+	// manage temporary grants and deny entries through /v1/admin. The admin API
+	// is disabled if it's empty. Admin services have to send a token listed
+	// under their own app in the secrets.
+	"ADMIN_SERVICES": "",
+	// Temporary grants created through the admin API can't last longer.
+	"MAX_GRANT_DURATION": "12h",
+	// Changes of permissions of users, caused by changes of members of groups,
+	// are kept in the history served by /v1/user/history for this long.
+	"HISTORY_RETENTION": "2160h",
+	// Pending webhook deliveries are sent every interval, 0 disables deliveries.
+	// Failed attempts are retried after the retry delay, doubled after each
+	// attempt, until a delivery runs out of attempts.
+	"WEBHOOK_DELIVERY_INTERVAL": "10s",
+	"WEBHOOK_MAX_ATTEMPTS":      5,
+	"WEBHOOK_RETRY_DELAY":       "30s",
+	// Webhooks can't point to loopback, private or link-local addresses, unless
+	// they are in one of these comma separated CIDRs, e.g. "10.20.0.0/16".
+	"WEBHOOK_ALLOWED_NETWORKS": "",
+	// Groups whose members are fetched concurrently during the groups sync.
+	// With Okta, all of them share the rate limit budget given by
+	// OKTA_RATE_LIMIT_SHARE.
+	"DIRECTORY_MEMBERSHIP_WORKERS": 4,
+	// Snapshots of groups kept in cache, published by groups syncs and batches
+	// of changes, older ones can be rolled back to.
+	"DIRECTORY_SYNC_GENERATIONS": 3,
+	// Interval of polling the provider for membership and user changes, for
+	// environments which Okta Event Hooks can't reach. 0 disables polling. With
+	// Okta OAuth, the okta.logs.read scope is needed, LDAP has no changes to
+	// poll.
+	"DIRECTORY_CHANGES_POLL_INTERVAL": "0s",
 	// The OKTA token and URL are only used locally, when deployed,
 	// IAM fetches the token from Vault.
-	"OKTA_TOKEN":             "",
-	"OKTA_URL":               "",
+	"OKTA_TOKEN": "",
+	"OKTA_URL":   "",
+	// Share of the Okta rate limit budget IAM is allowed to use before it starts
+	// waiting for the rate limit window to reset (between 0 and 1).
+	"OKTA_RATE_LIMIT_SHARE": 0.8,
+	// Retries for idempotent Okta requests failing with 429, 5xx or a timeout.
+	"OKTA_MAX_RETRIES":      3,
+	"OKTA_RETRY_BASE_DELAY": "500ms",
+	"OKTA_RETRY_MAX_DELAY":  "30s",
+	// Longest time an Okta request may wait for the rate limit or between
+	// retries before failing, kept under the REST write timeout so that API
+	// requests answer in time. 0 disables the bound.
+	"OKTA_MAX_WAIT": "5s",
+	// Consecutive failed Okta requests after which requests fail fast for the
+	// open timeout, 0 disables the circuit breaker.
+	"OKTA_BREAKER_FAILURE_THRESHOLD": 5,
+	"OKTA_BREAKER_OPEN_TIMEOUT":      "30s",
+	// OAuth 2.0 service app used instead of OKTA_TOKEN when the client ID is set.
+	// The private key (PEM) is read from the OKTA_OAUTH_PRIVATE_KEY secret.
+	// Scopes are space separated, the token URL defaults to the org
+	// authorization server of OKTA_URL.
+	"OKTA_OAUTH_CLIENT_ID": "",
+	"OKTA_OAUTH_KEY_ID":    "",
+	"OKTA_OAUTH_SCOPES":    "okta.users.read okta.groups.read",
+	"OKTA_OAUTH_TOKEN_URL": "",
+	// LDAP directory used when DIRECTORY_PROVIDER is ldap. The bind password is
+	// read from the LDAP_BIND_PASSWORD secret, binds are anonymous without a DN.
+	// With LDAP_START_TLS, ldap:// connections are upgraded to TLS before the
+	// bind, ldaps:// URLs use TLS from the start.
+	"LDAP_URL":           "",
+	"LDAP_START_TLS":     false,
+	"LDAP_BIND_DN":       "",
+	"LDAP_TIMEOUT":       "10s",
+	"LDAP_USER_BASE_DN":  "",
+	"LDAP_USER_FILTER":   "(objectClass=inetOrgPerson)",
+	"LDAP_GROUP_BASE_DN": "",
+	"LDAP_GROUP_FILTER":  "(objectClass=groupOfNames)",
+	// Entries read in a page by paged searches, 0 disables paging.
+	"LDAP_PAGE_SIZE": 500,
+	// Group memberships are read from the member attribute of groups (member),
+	// or from the memberOf attribute of users (memberOf).
+	"LDAP_MEMBERSHIP": "member",
+	// JSON object overriding the LDAP attributes read into users and groups,
+	// ie. {"department": "department"}. Defaults follow inetOrgPerson, members
+	// of groups are searched by the entryDN attribute of users, use
+	// {"dn": "distinguishedName"} with Active Directory. Users disabled by the
+	// userAccountControl or nsAccountLock attributes are suspended.
+	"LDAP_ATTRIBUTES": "",
+	// JSON object naming LDAP groups by their DN, ie.
+	// {"cn=billing,ou=groups,dc=kiwi,dc=com": "iam-billing.read"}. Other groups
+	// are named by their cn, only groups named iam-* grant permissions.
+	"LDAP_GROUP_MAPPING":     "",
 	"REDIS_HOST":             "localhost",
 	"REDIS_PORT":             "6379",
 	"REDIS_LOCK_RETRY_DELAY": "1s",
@@ -111,7 +269,7 @@ var defaultValues = map[string]interface{}{
 	// generation, and for finding regressions on Sentry.
 	"SENTRY_RELEASE": "",
 	// Env is taken from APP_ENV.
-	"DATADOG_ADDR":    "",
-	"DD_AGENT_HOST":   "",
-	"SECRETS_PATH": "/etc/vault/secrets.json",
+	"DATADOG_ADDR":  "",
+	"DD_AGENT_HOST": "",
+	"SECRETS_PATH":  "/etc/vault/secrets.json",
 }
@@ -9,6 +9,11 @@ var Expirations = struct {
 	User             time.Duration
 	GroupMemberships time.Duration
 	GroupsLastSync   time.Duration
+	UsersSyncCursor  time.Duration
+	UsersLastSync    time.Duration
+	UsersIndex       time.Duration
+	ProcessedEvent   time.Duration
+	EventsPollCursor time.Duration
 }{
 	User:             time.Hour * 24,
 	GroupMemberships: time.Hour * 24,
@@ -18,4 +23,19 @@ var Expirations = struct {
 	// if there is a value for it, it's used to fetch only the changes since the
 	// last sync instead of all groups.
 	GroupsLastSync: 0,
+	// UsersSyncCursor is the next page of a failed users sync. Okta page cursors
+	// are not meant to be kept for long, so an old one is not resumed from.
+	UsersSyncCursor: time.Hour,
+	// UsersLastSync works the same way as GroupsLastSync, once it's deleted the
+	// next users sync is a full one, which also removes users deleted in Okta.
+	UsersLastSync: 0,
+	// UsersIndex lists all synced users, it's replaced on every full sync.
+	UsersIndex: 0,
+	// ProcessedEvent remembers Okta events which were already applied, so
+	// events delivered again by Okta are ignored.
+	ProcessedEvent: time.Hour * 24,
+	// EventsPollCursor is the time of the last event read from the System Log,
+	// polling is resumed from it. Old cursors are not resumed from, as the
+	// periodic syncs catch up with older changes.
+	EventsPollCursor: 0,
 }
@@ -55,3 +55,14 @@ func (c *Metrics) Incr(name string, tags ...string) {
 		log.Printf("[ERROR] Metric Incr failed: %v", err)
 	}
 }
+
+// Gauge records the current value of a metric
+func (c *Metrics) Gauge(name string, value float64, tags ...string) {
+	if c == nil || c.client == nil {
+		return
+	}
+	err := c.client.Gauge(name, value, tags, c.rate)
+	if err != nil {
+		log.Printf("[ERROR] Metric Gauge failed: %v", err)
+	}
+}
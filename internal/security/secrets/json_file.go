@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"log"	
+	"strings"
 )
 
 // Secrets represents the JSON file structure 
@@ -17,7 +18,8 @@ type Secrets struct {
 type JSONFileManager struct {
 	raw []byte
 	settings map[string]string
-	tokens map[string]bool
+	// tokens are the apps each token belongs to, by token
+	tokens map[string]map[string]bool
 }
 
 // CreateNewJSONFileManager creates a new secret manager hooked up to Viper
@@ -45,13 +47,16 @@ func (s *JSONFileManager) SyncSecrets() error {
 
 	s.settings = secrets.Settings
 
-	mappedTokens := make(map[string]bool)
+	mappedTokens := make(map[string]map[string]bool)
 	tokenCount := 0
 
-	for _, app := range secrets.TokenMap {
-		for _, token := range app {
+	for app, tokens := range secrets.TokenMap {
+		for _, token := range tokens {
 			tokenCount++
-			mappedTokens[token] = true
+			if mappedTokens[token] == nil {
+				mappedTokens[token] = make(map[string]bool)
+			}
+			mappedTokens[token][strings.ToLower(app)] = true
 		}
 	}
 	s.tokens = mappedTokens
@@ -63,7 +68,12 @@ func (s *JSONFileManager) SyncSecrets() error {
 
 // DoesTokenExist checks if a token is present in the secret manager
 func (s JSONFileManager) DoesTokenExist(reqToken string) bool {
-	return s.tokens[reqToken]
+	return len(s.tokens[reqToken]) > 0
+}
+
+// DoesTokenBelongTo checks if a token is one of the tokens of the app
+func (s JSONFileManager) DoesTokenBelongTo(reqToken, app string) bool {
+	return s.tokens[reqToken][strings.ToLower(app)]
 }
 
 // GetSetting gets a setting from the secret manager
@@ -2,6 +2,7 @@ package secrets
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -20,6 +21,13 @@ func (s LocalSecretManager) DoesTokenExist(reqToken string) bool {
 	return reqToken == token
 }
 
+// DoesTokenBelongTo checks if the token is the local token, and the app the one
+// set by TOKEN_APP. The local token doesn't belong to any app without it.
+func (s LocalSecretManager) DoesTokenBelongTo(reqToken, app string) bool {
+	tokenApp := viper.GetString("TOKEN_APP")
+	return s.DoesTokenExist(reqToken) && tokenApp != "" && strings.EqualFold(tokenApp, app)
+}
+
 // GetSetting gets a setting from Viper
 func (s LocalSecretManager) GetSetting(key string) (string, error) {
 	setting := viper.GetString(key)
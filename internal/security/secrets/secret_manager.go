@@ -3,5 +3,6 @@ package secrets
 // SecretManager is a interface that describes how we want to use secrets
 type SecretManager interface {
 	DoesTokenExist(string) bool
+	DoesTokenBelongTo(token, app string) bool
 	GetSetting(string) (string, error)
 }
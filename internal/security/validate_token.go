@@ -7,7 +7,8 @@ import (
 )
 
 var (
-	errUnathorised = errors.New("incorrect token")
+	errUnathorised         = errors.New("incorrect token")
+	errTokenOfOtherService = errors.New("token doesn't belong to the service")
 )
 
 // VerifyToken accepts a token and a service struct and verifies if this token is accepted
@@ -23,3 +24,16 @@ func VerifyToken(secretManager secrets.SecretManager, service Service, requestTo
 
 	return nil
 }
+
+// VerifyServiceToken verifies the token like VerifyToken, and that the token was
+// issued to the service itself. Service names in user agents are not
+// authenticated otherwise, any valid token can be sent with any of them.
+func VerifyServiceToken(secretManager secrets.SecretManager, service Service, requestToken string) error {
+	if err := VerifyToken(secretManager, service, requestToken); err != nil {
+		return err
+	}
+	if !secretManager.DoesTokenBelongTo(requestToken, service.Name) {
+		return errTokenOfOtherService
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package directory
+
+import (
+	"log"
+	"time"
+
+	"github.com/getsentry/raven-go"
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/kiwicom/iam/internal/monitoring"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// Types of audit events
+const (
+	AuditGrantCreated = "grant.created"
+	AuditGrantRevoked = "grant.revoked"
+	AuditGrantExpired = "grant.expired"
+	AuditDenyCreated  = "deny.created"
+	AuditDenyDeleted  = "deny.deleted"
+
+	AuditWebhookCreated   = "webhook.created"
+	AuditWebhookDeleted   = "webhook.deleted"
+	AuditDeliveryReplayed = "webhook.replayed"
+
+	AuditGroupsRolledBack = "groups.rolled_back"
+)
+
+// AuditActorIAM is the actor of changes made by IAM itself, ie. expirations
+const AuditActorIAM = "iam"
+
+// AuditEvent records a change of permissions made through IAM
+type AuditEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Actor is the service which made the change, or iam for changes made by
+	// IAM itself
+	Actor string `json:"actor"`
+	Grant *Grant `json:"grant,omitempty"`
+	Deny  *Deny  `json:"deny,omitempty"`
+	// Webhook is set without its secret
+	Webhook  *Webhook         `json:"webhook,omitempty"`
+	Delivery *WebhookDelivery `json:"delivery,omitempty"`
+	// Generation is the groups snapshot published by a rollback
+	Generation int64 `json:"generation,omitempty"`
+}
+
+// audit writes an audit event to the log, as JSON prefixed by [AUDIT]
+func (d *Directory) audit(event AuditEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		return
+	}
+	log.Println("[AUDIT]", string(data))
+	d.metrics.Incr("audit_event", monitoring.Tag("type", event.Type))
+}
@@ -0,0 +1,285 @@
+package directory
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/getsentry/raven-go"
+
+	cfg "github.com/kiwicom/iam/configs"
+	"github.com/kiwicom/iam/internal/monitoring"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// ApplyChanges updates cached users and group memberships according to changes
+// from the provider. Changes which were already applied are skipped, as
+// providers may deliver a change more than once. Group memberships are changed
+// in a copy of the published groups snapshot, which is published once all
+// changes were applied.
+func (d *Directory) ApplyChanges(changes []Change) error {
+	batch := &membershipBatch{history: make(map[string][]HistoryEntry)}
+	defer batch.discard(d)
+
+	var failed int
+	var processed []string
+	for i := range changes {
+		change := &changes[i]
+		key := "directory-change:" + change.ID
+
+		var done bool
+		if err := d.cache.Get(key, &done); err == nil && done {
+			continue
+		}
+
+		status := "ok"
+		applied, err := d.applyChange(change, batch)
+		if err != nil {
+			failed++
+			status = "error"
+			log.Println("[ERROR] Failed to apply change", change.ID, err)
+			raven.CaptureError(err, map[string]string{"changeType": change.Type})
+		} else if !applied {
+			status = "ignored"
+		}
+		d.metrics.Incr("directory_change", d.providerTag(), monitoring.Tag("type", change.Type), monitoring.Tag("status", status))
+		if err == nil {
+			processed = append(processed, key)
+		}
+	}
+
+	// Changes are marked as processed only once their group memberships are
+	// published, so that they're applied again otherwise.
+	if err := batch.publish(d); err != nil {
+		return err
+	}
+	for _, key := range processed {
+		if err := d.cache.Set(key, true, cfg.Expirations.ProcessedEvent); err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+	}
+
+	if failed > 0 {
+		return errors.New("failed to apply " + strconv.Itoa(failed) + " changes")
+	}
+	return nil
+}
+
+// membershipBatch is a copy of the published groups snapshot to which group
+// membership changes of a batch are applied, published and retained snapshots
+// are never modified. The copy is made with the first membership change, and
+// the groups snapshot lock is held until it's published or discarded.
+type membershipBatch struct {
+	snapshot *storage.Snapshot
+	changed  bool
+	// history are the changes of permissions, recorded once the batch is
+	// published
+	history map[string][]HistoryEntry
+	at      time.Time
+}
+
+// begin copies the published groups snapshot, unless it was already copied.
+func (b *membershipBatch) begin(d *Directory) error {
+	if b.snapshot != nil {
+		return nil
+	}
+
+	d.lock.Acquire(groupsSnapshotLock)
+	snapshot, err := d.groupsSnapshots.BeginCopy()
+	if err != nil {
+		d.lock.Delete(groupsSnapshotLock)
+		return err
+	}
+	b.snapshot = snapshot
+	return nil
+}
+
+// publish publishes the snapshot when memberships changed, and records the
+// changes of permissions.
+func (b *membershipBatch) publish(d *Directory) error {
+	if b.snapshot == nil || !b.changed {
+		return nil
+	}
+
+	err := b.snapshot.Publish()
+	d.lock.Delete(groupsSnapshotLock)
+	b.snapshot = nil
+	if err != nil {
+		return err
+	}
+	d.recordHistory(b.history, HistorySourceEvent, b.at)
+	return nil
+}
+
+// discard removes the snapshot if it was not published.
+func (b *membershipBatch) discard(d *Directory) {
+	if b.snapshot == nil {
+		return
+	}
+	b.snapshot.Discard()
+	d.lock.Delete(groupsSnapshotLock)
+	b.snapshot = nil
+}
+
+// applyChange applies a single change, it returns false if the change is not
+// relevant to IAM.
+func (d *Directory) applyChange(change *Change, batch *membershipBatch) (bool, error) {
+	email := change.Email
+	if email == "" {
+		return false, nil
+	}
+
+	switch change.Type {
+	case ChangeMembershipAdded, ChangeMembershipRemoved:
+		member := change.Type == ChangeMembershipAdded
+		if err := d.updateUserGroups(email, change.Group, member); err != nil {
+			return false, err
+		}
+		if err := batch.begin(d); err != nil {
+			return false, err
+		}
+		return d.updateGroupMembership(batch, change.Group.Name, email, member, change.Time)
+
+	case ChangeUserUpdated:
+		// The user is fetched again when it's requested next time.
+		return true, d.cache.Del(email)
+
+	case ChangeUserDeleted:
+		return true, d.cache.Set(email, User{}, cfg.Expirations.User)
+
+	case ChangeUserStatus:
+		return true, d.updateUserStatus(email, change.Status)
+	}
+
+	return false, nil
+}
+
+// updateGroupMembership adds or removes a user from a group-membership entry of
+// the batch. Services without memberships are left to the groups sync, as a
+// partial entry would hide permissions from other groups of the service.
+// Changes of permissions caused by actual changes of members are added to the
+// history of the batch.
+func (d *Directory) updateGroupMembership(batch *membershipBatch, groupName, email string, member bool, at time.Time) (bool, error) {
+	service, permission, ok := parseGroupName(groupName)
+	if !ok {
+		return false, nil
+	}
+
+	memberships := make(map[string]map[string]bool)
+	if err := batch.snapshot.Get(groupMembershipPrefix+service, &memberships); err != nil {
+		if err == storage.ErrNotFound {
+			return true, nil
+		}
+		return false, err
+	}
+
+	if memberships[permission][email] == member {
+		return true, nil
+	}
+	// Members before the change are kept for the permission history, members
+	// of the changed permission are copied as they're changed in place
+	previous := make(map[string]map[string]bool, len(memberships))
+	for name, users := range memberships {
+		previous[name] = users
+	}
+	previous[permission] = make(map[string]bool, len(memberships[permission]))
+	for user := range memberships[permission] {
+		previous[permission][user] = true
+	}
+	if member {
+		if memberships[permission] == nil {
+			memberships[permission] = make(map[string]bool)
+		}
+		memberships[permission][email] = true
+	} else {
+		delete(memberships[permission], email)
+	}
+
+	if err := batch.snapshot.Set(groupMembershipPrefix+service, memberships); err != nil {
+		return false, err
+	}
+	batch.changed = true
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+	if at.After(batch.at) {
+		batch.at = at
+	}
+	history := d.membershipHistory(batch, memberChange{email, groupName, member}, service, previous, memberships, at)
+	for user, entries := range history {
+		batch.history[user] = append(batch.history[user], entries...)
+	}
+	return true, nil
+}
+
+// membershipHistory returns changes of permissions caused by a change of
+// members of a group of the service, applied to the snapshot of the batch
+func (d *Directory) membershipHistory(batch *membershipBatch, change memberChange, service string, previous, current map[string]map[string]bool, at time.Time) map[string][]HistoryEntry {
+	stored := storedMemberships(batch.snapshot.Get)
+	withMembers := func(members map[string]map[string]bool) membershipsReader {
+		return func(s string) (map[string]map[string]bool, error) {
+			if s == service {
+				return members, nil
+			}
+			return stored(s)
+		}
+	}
+
+	var groups []Group
+	if err := batch.snapshot.Get("groups", &groups); err != nil && err != storage.ErrNotFound {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+	}
+	return d.permissionChanges([]memberChange{change},
+		groupsState{groups, withMembers(previous)},
+		groupsState{groups, withMembers(current)},
+		HistorySourceEvent, at)
+}
+
+// updateUserGroups updates the groups of a cached user, if they are cached.
+func (d *Directory) updateUserGroups(email string, group Group, member bool) error {
+	var user User
+	if err := d.cache.Get(email, &user); err != nil {
+		if err == storage.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if user.Email == "" || user.GroupMembership == nil {
+		return nil
+	}
+
+	groups := make([]Group, 0, len(user.GroupMembership)+1)
+	for _, g := range user.GroupMembership {
+		if g.ID != group.ID {
+			groups = append(groups, g)
+		}
+	}
+	if member {
+		groups = append(groups, group)
+	}
+	user.GroupMembership = groups
+
+	return d.cache.Set(email, user, cfg.Expirations.User)
+}
+
+// updateUserStatus sets the status of a cached user. Users which are not cached
+// are fetched with their current status when requested.
+func (d *Directory) updateUserStatus(email, status string) error {
+	var user User
+	if err := d.cache.Get(email, &user); err != nil {
+		if err == storage.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if user.Email == "" {
+		// Users which were not found might have been created since then.
+		return d.cache.Del(email)
+	}
+
+	user.Status = status
+	return d.cache.Set(email, user, cfg.Expirations.User)
+}
@@ -0,0 +1,104 @@
+package directory
+
+import (
+	"log"
+	"time"
+
+	"github.com/getsentry/raven-go"
+
+	cfg "github.com/kiwicom/iam/configs"
+	"github.com/kiwicom/iam/internal/monitoring"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// changesPollMaxLag is how far back polling goes at most. Older changes are
+// left to the periodic syncs.
+const changesPollMaxLag = time.Hour
+
+// PollChanges reads changes made since the last poll from the provider and
+// applies them to cache.
+func (d *Directory) PollChanges() {
+	lockErr := d.lock.Create("poll_events")
+	if lockErr == storage.ErrLockExists {
+		log.Println("Aborted, changes were already polled")
+		return
+	}
+	defer d.lock.Delete("poll_events")
+
+	if d.Ready() != nil {
+		log.Println(d.name, "is unavailable, skipping changes polling")
+		d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "changes"), monitoring.Tag("status", "skipped"))
+		return
+	}
+
+	since := d.getChangesPollCursor(time.Now().UTC())
+	cursor := since
+
+	var count int
+	var page string
+	for {
+		changes, until, next, err := d.provider.ListChanges(since, page)
+		if err != nil {
+			log.Println("Error polling changes", err)
+			d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "changes"), monitoring.Tag("status", "error"))
+			raven.CaptureError(err, nil)
+			return
+		}
+		if err := d.ApplyChanges(changes); err != nil {
+			// Polling is resumed from the last page applied, changes applied from
+			// this page are skipped next time.
+			log.Println("Error applying changes", err)
+			d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "changes"), monitoring.Tag("status", "error"))
+			return
+		}
+		count += len(changes)
+		// The cursor is moved past events which were not converted to changes
+		// too, so that they are not read again.
+		if !until.IsZero() {
+			cursor = until
+			d.setChangesPollCursor(cursor)
+		}
+
+		if next == "" {
+			break
+		}
+		page = next
+	}
+
+	if cursor.Equal(since) {
+		// Changes can show up with a delay, the same period is polled again
+		// next time.
+		d.setChangesPollCursor(cursor)
+	}
+	if count > 0 {
+		log.Println("Applied", count, "changes from", d.name)
+	}
+	d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "changes"), monitoring.Tag("status", "ok"))
+}
+
+// getChangesPollCursor returns the time polling continues from. Changes are
+// read again from the last one applied, as several changes can be made at the
+// same time, and applying a change twice has no effect.
+func (d *Directory) getChangesPollCursor(now time.Time) time.Time {
+	cursor := time.Time{}
+	if err := d.cache.Get("events-poll-cursor", &cursor); err != nil {
+		if err != storage.ErrNotFound {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+		// Nothing was polled yet, the syncs take care of older changes.
+		return now
+	}
+
+	if cursor.Before(now.Add(-changesPollMaxLag)) {
+		return now.Add(-changesPollMaxLag)
+	}
+	return cursor
+}
+
+func (d *Directory) setChangesPollCursor(cursor time.Time) {
+	if err := d.cache.Set("events-poll-cursor", cursor, cfg.Expirations.EventsPollCursor); err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+	}
+}
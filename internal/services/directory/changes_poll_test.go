@@ -0,0 +1,57 @@
+package directory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollChanges(t *testing.T) {
+	provider := newFailingProvider()
+	provider.SetGroup(Group{ID: "g1", Name: "iam-service.read"})
+	d, cache := newTestDirectory(provider)
+	publishMemberships(t, d, "service", map[string]map[string]bool{})
+
+	d.setChangesPollCursor(time.Now().Add(-time.Minute))
+	provider.AddMember("g1", "user@kiwi.com")
+	d.PollChanges()
+
+	user := User{Email: "user@kiwi.com"}
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Equal(t, []string{"read"}, user.Permissions, "Polled changes are applied")
+
+	cursor := time.Time{}
+	require.NoError(t, cache.Get("events-poll-cursor", &cursor))
+	changes, _, _, err := provider.ListChanges(time.Time{}, "")
+	require.NoError(t, err)
+	assert.True(t, changes[len(changes)-1].Time.Equal(cursor), "Cursor is moved to the last change")
+
+	now := time.Now().UTC()
+	d.setChangesPollCursor(now.Add(-2 * changesPollMaxLag))
+	assert.Equal(t, now.Add(-changesPollMaxLag), d.getChangesPollCursor(now), "Polling goes back at most by the maximum lag")
+
+	recent := now.Add(-time.Minute)
+	d.setChangesPollCursor(recent)
+	provider.fail("changes", true)
+	d.PollChanges()
+	require.NoError(t, cache.Get("events-poll-cursor", &cursor))
+	assert.True(t, recent.Equal(cursor), "Cursor is kept when polling fails")
+}
+
+func TestPollChangesWithoutCursor(t *testing.T) {
+	provider := NewMemoryProvider()
+	provider.SetGroup(Group{ID: "g1", Name: "iam-service.read"})
+	provider.AddMember("g1", "user@kiwi.com")
+	d, cache := newTestDirectory(provider)
+	publishMemberships(t, d, "service", map[string]map[string]bool{})
+
+	d.PollChanges()
+
+	user := User{Email: "user@kiwi.com"}
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Empty(t, user.Permissions, "Polling starts from now")
+	cursor := time.Time{}
+	require.NoError(t, cache.Get("events-poll-cursor", &cursor), "Cursor is saved even without changes")
+}
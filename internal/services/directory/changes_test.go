@@ -0,0 +1,116 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+func membershipChange(id, changeType, email, group string) Change {
+	return Change{
+		ID:    id,
+		Type:  changeType,
+		Email: email,
+		Group: Group{ID: "00g1", Name: group},
+	}
+}
+
+// publishMemberships publishes a groups snapshot with the group memberships of
+// the service
+func publishMemberships(t *testing.T, d *Directory, service string, memberships map[string]map[string]bool) {
+	snapshot, err := d.groupsSnapshots.Begin()
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Set(groupMembershipPrefix+service, memberships))
+	require.NoError(t, snapshot.Publish())
+}
+
+func TestApplyMembershipChanges(t *testing.T) {
+	d, cache := newTestDirectory(NewMemoryProvider())
+	publishMemberships(t, d, "service", map[string]map[string]bool{
+		"read": {"other@kiwi.com": true},
+	})
+	require.NoError(t, cache.Set("user@kiwi.com", User{
+		Email:           "user@kiwi.com",
+		GroupMembership: []Group{{ID: "00g2", Name: "iam-service.read"}},
+	}, 0))
+	published, err := d.groupsSnapshots.Generation()
+	require.NoError(t, err)
+
+	err = d.ApplyChanges([]Change{
+		membershipChange("1", ChangeMembershipAdded, "user@kiwi.com", "iam-service.write"),
+		membershipChange("2", ChangeMembershipAdded, "user@kiwi.com", "iam-unknown.write"),
+	})
+	require.NoError(t, err)
+
+	var retained map[string]map[string]bool
+	require.NoError(t, cache.Get(d.groupsSnapshots.Key(published, groupMembershipPrefix+"service"), &retained))
+	assert.Equal(t, map[string]map[string]bool{"read": {"other@kiwi.com": true}}, retained,
+		"Changes are published in a new snapshot, the previous one is not modified")
+
+	user := User{Email: "user@kiwi.com"}
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Equal(t, []string{"write"}, user.Permissions)
+	var memberships map[string]map[string]bool
+	assert.Equal(t, storage.ErrNotFound, d.getPublished(groupMembershipPrefix+"unknown", &memberships),
+		"Services without synced memberships are not created")
+
+	var cached User
+	require.NoError(t, cache.Get("user@kiwi.com", &cached))
+	assert.Len(t, cached.GroupMembership, 2, "Cached groups of the user are updated")
+
+	require.NoError(t, d.ApplyChanges([]Change{
+		membershipChange("3", ChangeMembershipRemoved, "user@kiwi.com", "iam-service.write"),
+	}))
+	user = User{Email: "user@kiwi.com"}
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Empty(t, user.Permissions)
+	require.NoError(t, cache.Get("user@kiwi.com", &cached))
+	assert.Equal(t, []Group{{ID: "00g2", Name: "iam-service.read"}}, cached.GroupMembership)
+}
+
+func TestApplyChangesIdempotency(t *testing.T) {
+	d, _ := newTestDirectory(NewMemoryProvider())
+	publishMemberships(t, d, "service", map[string]map[string]bool{})
+
+	add := membershipChange("1", ChangeMembershipAdded, "user@kiwi.com", "iam-service.write")
+	require.NoError(t, d.ApplyChanges([]Change{add}))
+	require.NoError(t, d.ApplyChanges([]Change{
+		membershipChange("2", ChangeMembershipRemoved, "user@kiwi.com", "iam-service.write"),
+	}))
+	// The add change is delivered again
+	require.NoError(t, d.ApplyChanges([]Change{add}))
+
+	user := User{Email: "user@kiwi.com"}
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Empty(t, user.Permissions, "Changes are applied only once")
+}
+
+func TestApplyUserChanges(t *testing.T) {
+	d, cache := newTestDirectory(NewMemoryProvider())
+	for _, email := range []string{"suspended@kiwi.com", "updated@kiwi.com", "deleted@kiwi.com"} {
+		require.NoError(t, cache.Set(email, User{Email: email, Status: StatusActive}, 0))
+	}
+	require.NoError(t, cache.Set("created@kiwi.com", User{}, 0))
+
+	err := d.ApplyChanges([]Change{
+		{ID: "1", Type: ChangeUserStatus, Email: "suspended@kiwi.com", Status: StatusSuspended},
+		{ID: "2", Type: ChangeUserUpdated, Email: "updated@kiwi.com"},
+		{ID: "3", Type: ChangeUserDeleted, Email: "deleted@kiwi.com"},
+		{ID: "4", Type: ChangeUserStatus, Email: "created@kiwi.com", Status: StatusActive},
+		{ID: "5", Type: ChangeUserStatus, Email: "uncached@kiwi.com", Status: StatusSuspended},
+	})
+	require.NoError(t, err)
+
+	var user User
+	require.NoError(t, cache.Get("suspended@kiwi.com", &user))
+	assert.Equal(t, StatusSuspended, user.Status)
+	assert.Equal(t, storage.ErrNotFound, cache.Get("updated@kiwi.com", &user), "Updated users are fetched again")
+	assert.Equal(t, storage.ErrNotFound, cache.Get("created@kiwi.com", &user), "Created users are not marked as not found")
+	assert.Equal(t, storage.ErrNotFound, cache.Get("uncached@kiwi.com", &user))
+
+	_, err = d.GetUser("deleted@kiwi.com")
+	assert.Equal(t, ErrUserNotFound, err)
+}
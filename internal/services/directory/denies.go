@@ -0,0 +1,272 @@
+package directory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// deniesKey is the cache key of deny entries, all entries are stored in a
+// single entry, as there are only a few of them at any time
+const deniesKey = "permission-denies"
+
+// ErrDenyNotFound is returned when deleting a deny entry which doesn't exist
+var ErrDenyNotFound = errors.New("deny entry not found")
+
+// InvalidDenyError is returned when creating an invalid deny entry
+type InvalidDenyError struct {
+	Reason string
+}
+
+func (e InvalidDenyError) Error() string {
+	return "invalid deny entry: " + e.Reason
+}
+
+// Deny revokes a permission of a service, and the permissions it implies, from
+// a user or members of a group, whatever grants it. Exactly one of Email and
+// Group selects whom the permission is denied.
+type Deny struct {
+	ID         string `json:"id"`
+	Service    string `json:"service"`
+	Permission string `json:"permission"`
+	Email      string `json:"email,omitempty"`
+	// Environment limits the entry to callers in the environment, the entry
+	// applies in all environments if it's empty
+	Environment string `json:"environment,omitempty"`
+	// Group is the name of a group granting IAM permissions, as only those are
+	// fetched from providers, ie. iam-orders.contractors
+	Group     string    `json:"group,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// validate checks the fields of a deny entry requested through CreateDeny
+func (e *Deny) validate() error {
+	if e.Service == "" {
+		return InvalidDenyError{"missing service"}
+	}
+	if e.Permission == "" {
+		return InvalidDenyError{"missing permission"}
+	}
+	if strings.Contains(e.Service, EnvironmentSeparator) || strings.Contains(e.Environment, EnvironmentSeparator) {
+		return InvalidDenyError{"environment has to be set by its own field"}
+	}
+	if (e.Email == "") == (e.Group == "") {
+		return InvalidDenyError{"exactly one of email and group is required"}
+	}
+	if e.Email != "" {
+		if _, err := mail.ParseAddress(e.Email); err != nil {
+			return InvalidDenyError{"invalid email"}
+		}
+	}
+	if e.Group != "" && !strings.HasPrefix(strings.ToLower(e.Group), GroupPrefix) {
+		return InvalidDenyError{"group has to start with " + GroupPrefix}
+	}
+	if e.Reason == "" {
+		return InvalidDenyError{"missing reason"}
+	}
+	return nil
+}
+
+// CreateDeny stores a deny entry, its ID and creation are set by IAM. actor is
+// the service creating the entry, recorded in the audit event.
+func (d *Directory) CreateDeny(deny Deny, actor string) (Deny, error) {
+	if err := deny.validate(); err != nil {
+		return Deny{}, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Deny{}, err
+	}
+	deny.ID = hex.EncodeToString(id)
+	deny.Email = strings.ToLower(deny.Email)
+	deny.Service = strings.ToLower(deny.Service)
+	deny.Environment = strings.ToLower(deny.Environment)
+	deny.CreatedBy = actor
+	deny.CreatedAt = time.Now()
+
+	err := d.updateDenies(func(denies map[string]Deny) error {
+		denies[deny.ID] = deny
+		return nil
+	})
+	if err != nil {
+		return Deny{}, err
+	}
+	d.audit(AuditEvent{Type: AuditDenyCreated, Time: deny.CreatedAt, Actor: actor, Deny: &deny})
+	return deny, nil
+}
+
+// DeleteDeny removes a deny entry. actor is the service deleting the entry,
+// recorded in the audit event.
+func (d *Directory) DeleteDeny(id, actor string) error {
+	var deleted Deny
+	err := d.updateDenies(func(denies map[string]Deny) error {
+		deny, ok := denies[id]
+		if !ok {
+			return ErrDenyNotFound
+		}
+		deleted = deny
+		delete(denies, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.audit(AuditEvent{Type: AuditDenyDeleted, Time: time.Now(), Actor: actor, Deny: &deleted})
+	return nil
+}
+
+// ListDenies returns deny entries sorted by creation, of a service if it's not
+// empty
+func (d *Directory) ListDenies(service string) ([]Deny, error) {
+	denies, err := d.getDenies()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Deny, 0, len(denies))
+	for _, deny := range denies {
+		if service == "" || strings.EqualFold(deny.Service, service) {
+			list = append(list, deny)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// UserDenies returns deny entries of a service applying to the user. The
+// service may be scoped to the environment of the caller by ScopedService,
+// entries of other environments are left out then. Groups of the user are
+// looked up only if there are entries selecting groups.
+func (d *Directory) UserDenies(user *User, service string) ([]Deny, error) {
+	service, environment := splitService(service)
+	return d.userDenies(user, service, environment, d.userInGroup(user))
+}
+
+// userDenies returns deny entries of a service in the environment applying to
+// the user, inGroup reports whether the user is a member of a group
+func (d *Directory) userDenies(user *User, service, environment string, inGroup func(group string) (bool, error)) ([]Deny, error) {
+	denies, err := d.ListDenies(service)
+	if err != nil || len(denies) == 0 {
+		return []Deny{}, err
+	}
+
+	applying := make([]Deny, 0)
+	for _, deny := range denies {
+		if !inEnvironment(deny.Environment, environment) {
+			continue
+		}
+		if deny.Email != "" {
+			if strings.EqualFold(deny.Email, user.Email) {
+				applying = append(applying, deny)
+			}
+			continue
+		}
+
+		member, err := inGroup(deny.Group)
+		if err != nil {
+			return nil, err
+		}
+		if member {
+			applying = append(applying, deny)
+		}
+	}
+	return applying, nil
+}
+
+// userInGroup returns a function reporting whether the user is a member of a
+// group. Members are read from the published groups snapshot, as for
+// permissions granted by groups, each service is read only once.
+func (d *Directory) userInGroup(user *User) func(group string) (bool, error) {
+	read := make(map[string]map[string]map[string]bool)
+	return func(group string) (bool, error) {
+		service, permission, ok := parseGroupName(strings.ToLower(group))
+		if !ok {
+			return false, nil
+		}
+		memberships, ok := read[service]
+		if !ok {
+			var err error
+			if memberships, err = d.groupMemberships(user, service); err != nil {
+				return false, err
+			}
+			read[service] = memberships
+		}
+		for name, members := range memberships {
+			if strings.EqualFold(name, permission) && members[user.Email] {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// applyDenies removes permissions denied to the user in the environment from
+// its permissions and records them in DeniedPermissions
+func (d *Directory) applyDenies(user *User, service, environment string, inGroup func(group string) (bool, error)) error {
+	denies, err := d.userDenies(user, service, environment, inGroup)
+	if err != nil || len(denies) == 0 {
+		return err
+	}
+
+	for _, deny := range denies {
+		user.DeniedPermissions = append(user.DeniedPermissions, deny.Permission)
+	}
+	user.Permissions = removeDenied(user.Permissions, user.DeniedPermissions)
+	return nil
+}
+
+// removeDenied returns permissions which are not implied by denied permissions
+func removeDenied(permissions, denied []string) []string {
+	if len(denied) == 0 {
+		return permissions
+	}
+
+	allowed := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		if !HasPermission(denied, permission) {
+			allowed = append(allowed, permission)
+		}
+	}
+	return allowed
+}
+
+// getDenies returns all stored deny entries by ID
+func (d *Directory) getDenies() (map[string]Deny, error) {
+	denies := make(map[string]Deny)
+	err := d.cache.Get(deniesKey, &denies)
+	if err == storage.ErrNotFound {
+		return denies, nil
+	}
+	return denies, err
+}
+
+// updateDenies applies an update to the stored deny entries, updates are
+// serialized across instances by a lock. Entries aren't written if the update
+// fails.
+func (d *Directory) updateDenies(update func(denies map[string]Deny) error) error {
+	d.lock.Acquire(deniesKey)
+	defer d.lock.Delete(deniesKey)
+
+	denies, err := d.getDenies()
+	if err != nil {
+		return err
+	}
+	if err := update(denies); err != nil {
+		return err
+	}
+	return d.cache.Set(deniesKey, denies, 0)
+}
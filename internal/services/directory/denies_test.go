@@ -0,0 +1,99 @@
+package directory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateDeny(t *testing.T) {
+	d, _ := newTestDirectory(NewMemoryProvider())
+
+	deny, err := d.CreateDeny(Deny{Service: "Service", Permission: "orders", Email: "User@kiwi.com", Reason: "INC-1"}, "admin-tool")
+	require.NoError(t, err)
+	assert.NotEmpty(t, deny.ID)
+	assert.Equal(t, "service", deny.Service)
+	assert.Equal(t, "user@kiwi.com", deny.Email)
+	assert.Equal(t, "admin-tool", deny.CreatedBy)
+
+	denies, err := d.ListDenies("SERVICE")
+	require.NoError(t, err)
+	require.Len(t, denies, 1)
+	assert.Equal(t, deny.ID, denies[0].ID)
+	denies, err = d.ListDenies("other")
+	require.NoError(t, err)
+	assert.Empty(t, denies)
+
+	invalid := map[string]Deny{
+		"missing service":    {Permission: "orders", Email: "user@kiwi.com", Reason: "INC-1"},
+		"missing permission": {Service: "service", Email: "user@kiwi.com", Reason: "INC-1"},
+		"missing selector":   {Service: "service", Permission: "orders", Reason: "INC-1"},
+		"two selectors":      {Service: "service", Permission: "orders", Email: "user@kiwi.com", Group: "g", Reason: "INC-1"},
+		"invalid email":      {Service: "service", Permission: "orders", Email: "user", Reason: "INC-1"},
+		"invalid group":      {Service: "service", Permission: "orders", Group: "contractors", Reason: "INC-1"},
+		"missing reason":     {Service: "service", Permission: "orders", Email: "user@kiwi.com"},
+	}
+	for name, deny := range invalid {
+		_, err := d.CreateDeny(deny, "admin-tool")
+		assert.IsType(t, InvalidDenyError{}, err, name)
+	}
+
+	require.NoError(t, d.DeleteDeny(deny.ID, "admin-tool"))
+	assert.Equal(t, ErrDenyNotFound, d.DeleteDeny(deny.ID, "admin-tool"))
+	denies, err = d.ListDenies("")
+	require.NoError(t, err)
+	assert.Empty(t, denies)
+}
+
+func TestAddPermissionsDenies(t *testing.T) {
+	provider := NewMemoryProvider()
+	for id, name := range map[string]string{
+		"g1": "iam-service.orders",
+		"g2": "iam-service.users.read",
+		"g3": "iam-other.orders",
+		"g4": "iam-service.contractors",
+	} {
+		provider.SetGroup(Group{ID: id, Name: name})
+		provider.AddMember(id, "user@kiwi.com")
+	}
+	provider.SetUser(User{Email: "user@kiwi.com", Status: StatusActive})
+	d, _ := newTestDirectory(provider)
+	d.SyncGroups()
+	// Members of groups of deny entries are read from the synced memberships,
+	// the provider is not asked for groups of the user
+	provider.RemoveMember("g4", "user@kiwi.com")
+
+	for _, deny := range []Deny{
+		{Service: "service", Permission: "orders.refund", Email: "user@kiwi.com", Reason: "INC-1"},
+		{Service: "service", Permission: "users", Group: "IAM-service.contractors", Reason: "Policy"},
+		{Service: "service", Permission: "orders", Email: "other@kiwi.com", Reason: "INC-2"},
+	} {
+		_, err := d.CreateDeny(deny, "admin-tool")
+		require.NoError(t, err)
+	}
+
+	user := User{Email: "user@kiwi.com", Status: StatusActive}
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.ElementsMatch(t, []string{"contractors", "orders"}, user.Permissions, "Denied permissions and their descendants are removed")
+	assert.ElementsMatch(t, []string{"orders.refund", "users"}, user.DeniedPermissions)
+	assert.True(t, user.HasPermission("orders.read"))
+	assert.False(t, user.HasPermission("orders.refund.approve"), "Denials take precedence over ancestor grants")
+
+	grant := testGrant(time.Hour)
+	grant.Email = "user@kiwi.com"
+	grant.Permission = "orders.refund"
+	_, err := d.CreateGrant(grant, "admin-tool")
+	require.NoError(t, err)
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.ElementsMatch(t, []string{"contractors", "orders"}, user.Permissions, "Denials take precedence over temporary grants")
+
+	require.NoError(t, d.AddPermissions(&user, "other"))
+	assert.Equal(t, []string{"orders"}, user.Permissions, "Denials are scoped to services")
+	assert.Empty(t, user.DeniedPermissions)
+
+	denies, err := d.UserDenies(&user, "service")
+	require.NoError(t, err)
+	assert.Len(t, denies, 2)
+}
@@ -0,0 +1,394 @@
+package directory
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/getsentry/raven-go"
+	"golang.org/x/sync/singleflight"
+
+	cfg "github.com/kiwicom/iam/configs"
+	"github.com/kiwicom/iam/internal/monitoring"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// Cacher contains methods needed from a cache
+type Cacher interface {
+	Get(key string, value interface{}) error
+	Set(key string, value interface{}, ttl time.Duration) error
+	Del(key string) error
+	MSet(pairs map[string]interface{}, ttl time.Duration) error
+}
+
+// Opts contains options to create a Directory
+type Opts struct {
+	// Name of the provider, used in logs and metrics
+	Name        string
+	Provider    Provider
+	Cache       Cacher
+	LockManager *storage.LockManager
+	Metrics     *monitoring.Metrics
+	// MembershipWorkers is the number of groups whose members are fetched
+	// concurrently
+	MembershipWorkers int
+	// SyncGenerations is the number of groups syncs retained to roll back to
+	SyncGenerations int
+	// Roles granted by iam-<service>.role.<name> groups, optional
+	Roles *Roles
+	// Policies grant permissions based on attributes of users, optional
+	Policies Policies
+	// MaxGrantDuration is the longest a temporary grant can last,
+	// DefaultMaxGrantDuration is used if it's not set
+	MaxGrantDuration time.Duration
+	// HistoryRetention is how long changes of permissions of users are kept,
+	// DefaultHistoryRetention is used if it's not set
+	HistoryRetention time.Duration
+	// WebhookMaxAttempts is the number of attempts of a webhook delivery,
+	// DefaultWebhookMaxAttempts is used if it's not set
+	WebhookMaxAttempts int
+	// WebhookRetryDelay is the delay after the first failed attempt of a
+	// webhook delivery, DefaultWebhookRetryDelay is used if it's not set
+	WebhookRetryDelay time.Duration
+	// WebhookAllowedNetworks are networks webhooks can be delivered to even
+	// though they are loopback, private or link-local
+	WebhookAllowedNetworks []*net.IPNet
+	// UserAgent of webhook deliveries
+	UserAgent string
+}
+
+// Directory serves users and their permissions from cache. The cache is filled
+// by periodic syncs and changes from a provider, users missing in cache are
+// fetched from the provider.
+type Directory struct {
+	group    singleflight.Group
+	name     string
+	provider Provider
+	cache    Cacher
+	lock     *storage.LockManager
+	metrics  *monitoring.Metrics
+	// membershipWorkers is the number of groups whose members are fetched
+	// concurrently
+	membershipWorkers int
+	// groupsSnapshots contains groups and their members written by groups syncs
+	groupsSnapshots *storage.SnapshotManager
+	// roles expand role permissions into the permissions of the roles
+	roles *Roles
+	// policies grant permissions of services based on attributes of users
+	policies Policies
+	// maxGrantDuration is the longest a temporary grant can last
+	maxGrantDuration time.Duration
+	// historyRetention is how long changes of permissions of users are kept
+	historyRetention time.Duration
+	// webhookClient sends webhook deliveries
+	webhookClient *http.Client
+	// webhookAllowedNetworks are networks webhooks can be delivered to even
+	// though they are loopback, private or link-local
+	webhookAllowedNetworks []*net.IPNet
+	// webhookMaxAttempts is the number of attempts of a webhook delivery
+	webhookMaxAttempts int
+	// webhookRetryDelay is the delay after the first failed attempt of a
+	// webhook delivery, it doubles after each attempt
+	webhookRetryDelay time.Duration
+	// userAgent of webhook deliveries
+	userAgent string
+}
+
+// New creates a Directory based on the given options
+func New(opts *Opts) *Directory {
+	membershipWorkers := 1
+	if opts.MembershipWorkers > 1 {
+		membershipWorkers = opts.MembershipWorkers
+	}
+	maxGrantDuration := DefaultMaxGrantDuration
+	if opts.MaxGrantDuration > 0 {
+		maxGrantDuration = opts.MaxGrantDuration
+	}
+	historyRetention := DefaultHistoryRetention
+	if opts.HistoryRetention > 0 {
+		historyRetention = opts.HistoryRetention
+	}
+	webhookMaxAttempts := DefaultWebhookMaxAttempts
+	if opts.WebhookMaxAttempts > 0 {
+		webhookMaxAttempts = opts.WebhookMaxAttempts
+	}
+	webhookRetryDelay := DefaultWebhookRetryDelay
+	if opts.WebhookRetryDelay > 0 {
+		webhookRetryDelay = opts.WebhookRetryDelay
+	}
+
+	return &Directory{
+		name:     opts.Name,
+		provider: opts.Provider,
+		cache:    opts.Cache,
+		lock:     opts.LockManager,
+		metrics:  opts.Metrics,
+
+		membershipWorkers: membershipWorkers,
+		groupsSnapshots:   storage.NewSnapshotManager(opts.Cache, "groups-snapshot", opts.SyncGenerations),
+		roles:             opts.Roles,
+		policies:          opts.Policies,
+		maxGrantDuration:  maxGrantDuration,
+		historyRetention:  historyRetention,
+
+		webhookClient: &http.Client{
+			Timeout: webhookTimeout,
+			// Deliveries are not sent through proxies, so that addresses they
+			// connect to can be checked
+			Transport: &http.Transport{DialContext: webhookDialer(opts.WebhookAllowedNetworks).DialContext},
+		},
+		webhookAllowedNetworks: opts.WebhookAllowedNetworks,
+		webhookMaxAttempts:     webhookMaxAttempts,
+		webhookRetryDelay:      webhookRetryDelay,
+		userAgent:              opts.UserAgent,
+	}
+}
+
+// Ready returns ErrUpstreamUnavailable while the provider is known to be
+// unavailable.
+func (d *Directory) Ready() error {
+	if checker, ok := d.provider.(readinessChecker); ok {
+		return checker.Ready()
+	}
+	return nil
+}
+
+const groupMembershipPrefix = "group-membership:"
+
+// GetUser returns a user by email. It first tries to get it from cache, and if
+// not present there, it will fetch it from the provider.
+func (d *Directory) GetUser(email string) (User, error) {
+	var user User
+	err := d.cache.Get(email, &user)
+	if err == nil {
+		// User email is not specified only in case the user was not found.
+		if user.Email == "" {
+			return User{}, ErrUserNotFound
+		}
+		// Cache hit
+		return user, nil
+	}
+
+	if err != storage.ErrNotFound {
+		// Not a cache hit, not a cache miss, something went wrong
+		return User{}, err
+	}
+
+	// Cache miss
+	// Deduplicate network calls and cache writes if this controller is called
+	// multiple times concurrently.
+	val, err, _ := d.group.Do(email, func() (interface{}, error) {
+		lockErr := d.lock.Create(email)
+		if lockErr == storage.ErrLockExists {
+			// If there was a lock for this user, it means another instance was
+			// fetching its data recently, in that case we should be able to just get
+			// the data from cache.
+			return d.GetUser(email)
+		}
+		defer d.lock.Delete(email)
+
+		user, fetchErr := d.provider.GetUser(email)
+		if fetchErr != nil {
+			if errors.Is(fetchErr, ErrUserNotFound) {
+				cacheErr := d.cache.Set(email, User{}, cfg.Expirations.User)
+				raven.CaptureError(cacheErr, nil)
+			}
+			return User{}, fetchErr
+		}
+
+		cacheErr := d.cache.Set(user.Email, user, cfg.Expirations.User)
+		if cacheErr != nil {
+			raven.CaptureError(cacheErr, nil)
+		}
+		return user, nil
+	})
+
+	if err != nil {
+		return User{}, err
+	}
+	return val.(User), nil
+}
+
+// AddPermissions adds permissions for the given service to the user object.
+// The service may be scoped to the environment of the caller by
+// ScopedService, in which case groups scoped to the environment take
+// precedence over unscoped groups of the same permissions.
+// Permissions granted by rules of the service's policy and by temporary grants
+// are added to those granted by groups, and roles are expanded into the
+// permissions of the roles. Permissions denied to the user by deny entries are
+// removed last, whatever granted them. Grants and deny entries limited to an
+// environment apply only in that environment.
+func (d *Directory) AddPermissions(user *User, service string) error {
+	service, environment := splitService(service)
+	memberships := func(service string) (map[string]map[string]bool, error) {
+		return d.groupMemberships(user, service)
+	}
+	return d.addPermissions(user, service, environment, memberships, d.userInGroup(user))
+}
+
+// membershipsReader returns members of groups of a service by permission
+type membershipsReader func(service string) (map[string]map[string]bool, error)
+
+// addPermissions adds permissions of the service in the environment to the
+// user as AddPermissions, with members of groups read by memberships and
+// groups of deny entries resolved by inGroup
+func (d *Directory) addPermissions(user *User, service, environment string, memberships membershipsReader, inGroup func(group string) (bool, error)) error {
+	user.DeniedPermissions = nil
+	if err := d.addGroupPermissions(user, service, environment, memberships); err != nil {
+		return err
+	}
+	if !user.IsActive() {
+		return nil
+	}
+	if policy, ok := d.policies[strings.ToLower(service)]; ok {
+		user.Permissions = policy.grant(user, user.Permissions)
+	}
+	permissions, err := d.addGrantedPermissions(user, service, environment, user.Permissions)
+	if err != nil {
+		return err
+	}
+	user.Permissions = permissions
+	if d.roles != nil {
+		user.Permissions = d.roles.expand(service, user.Permissions)
+	}
+	return d.applyDenies(user, service, environment, inGroup)
+}
+
+// addGroupPermissions adds permissions granted by groups of the user
+func (d *Directory) addGroupPermissions(user *User, service, environment string, memberships membershipsReader) error {
+	user.Permissions = make([]string, 0)
+
+	if !user.IsActive() {
+		// Deactivated, suspended or not yet activated users have no permissions.
+		return nil
+	}
+
+	members, err := memberships(service)
+	if err != nil {
+		return err
+	}
+	if environment != "" {
+		scoped, err := memberships(ScopedService(service, environment))
+		if err != nil {
+			return err
+		}
+		// Groups scoped to the environment take precedence over unscoped groups,
+		// the members are copied, as readers may return shared maps
+		merged := make(map[string]map[string]bool, len(members)+len(scoped))
+		for permission, users := range members {
+			merged[permission] = users
+		}
+		for permission, users := range scoped {
+			merged[permission] = users
+		}
+		members = merged
+	}
+
+	for permission, users := range members {
+		if users[user.Email] {
+			user.Permissions = append(user.Permissions, permission)
+		}
+	}
+
+	return nil
+}
+
+// groupMemberships returns members of groups of the service by permission.
+// Without cached group memberships, only the groups of the user are known.
+func (d *Directory) groupMemberships(user *User, service string) (map[string]map[string]bool, error) {
+	cachedGroupMemberships := make(map[string]map[string]bool)
+
+	key, err := d.groupsKey(groupMembershipPrefix + service)
+	if err != nil {
+		return nil, err
+	}
+	err = d.cache.Get(key, &cachedGroupMemberships)
+	if err == nil {
+		return cachedGroupMemberships, nil
+	}
+	if err != storage.ErrNotFound {
+		return nil, err
+	}
+
+	timestamp := time.Time{}
+	_ = d.cache.Get("groups-sync-timestamp", &timestamp)
+	if time.Now().Before(timestamp.Add(10 * time.Minute)) {
+		// If there are no groups cached for the service and it's less than 10
+		// minutes from the last sync, we assume that there are no groups for that
+		// service.
+		return cachedGroupMemberships, nil
+	}
+
+	// Get cached groups or ask the provider in case of cache miss.
+	groups, err := d.getUserGroups(user)
+	if err != nil {
+		return nil, err
+	}
+
+	groupPrefix := GroupPrefix + strings.ToLower(service) + "."
+
+	for _, group := range groups {
+		if strings.HasPrefix(group.Name, groupPrefix) {
+			permission := strings.Replace(group.Name, groupPrefix, "", 1)
+			cachedGroupMemberships[permission] = map[string]bool{user.Email: true}
+		}
+	}
+
+	return cachedGroupMemberships, nil
+}
+
+// GetGroups retrieves the groups cached by the last groups sync
+func (d *Directory) GetGroups() ([]Group, error) {
+	key, err := d.groupsKey("groups")
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []Group
+	err = d.cache.Get(key, &groups)
+	return groups, err
+}
+
+func (d *Directory) getUserGroups(user *User) ([]Group, error) {
+	if user.GroupMembership != nil {
+		// Group membership is cached, don't fetch it.
+		return user.GroupMembership, nil
+	}
+
+	lockName := user.Email + ":groupMembership"
+	// Deduplicate network calls and cache writes if this function is called
+	// multiple times within the same instance.
+	val, err, _ := d.group.Do(lockName, func() (interface{}, error) {
+		lockErr := d.lock.Create(lockName)
+		if lockErr == storage.ErrLockExists {
+			// If there was a lock, it means another instance was fetching this data
+			// recently, in that case, we should be able to just get the data from
+			// cache.
+			var u User
+			if err := d.cache.Get(user.Email, &u); err != nil {
+				return nil, err
+			}
+			return u.GroupMembership, nil
+		}
+		defer d.lock.Delete(lockName)
+
+		groups, fetchErr := d.provider.ListUserGroups(*user)
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		user.GroupMembership = groups
+
+		cacheErr := d.cache.Set(user.Email, user, cfg.Expirations.User)
+		if cacheErr != nil {
+			raven.CaptureError(cacheErr, nil)
+		}
+		return groups, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return val.([]Group), nil
+}
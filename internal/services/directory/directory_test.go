@@ -0,0 +1,153 @@
+package directory
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+func newTestDirectory(provider Provider) (*Directory, storage.InMemoryCache) {
+	cache := storage.NewInMemoryCache()
+	d := New(&Opts{
+		Name:            "test",
+		Provider:        provider,
+		Cache:           cache,
+		LockManager:     storage.NewLockManager(cache, time.Millisecond, time.Second),
+		SyncGenerations: 2,
+		// Webhooks are delivered to test servers on the loopback
+		WebhookAllowedNetworks: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+	})
+	return d, cache
+}
+
+var errProviderFailure = errors.New("provider failure")
+
+// failingProvider records requests made to a MemoryProvider, and fails those
+// listed in failing: pages of users by cursor, members of groups by ID, and
+// changes as "changes".
+type failingProvider struct {
+	*MemoryProvider
+	mu             sync.Mutex
+	failing        map[string]bool
+	usersRequests  []string
+	usersSince     []time.Time
+	memberRequests []string
+}
+
+func newFailingProvider() *failingProvider {
+	return &failingProvider{
+		MemoryProvider: NewMemoryProvider(),
+		failing:        make(map[string]bool),
+	}
+}
+
+func (p *failingProvider) fail(key string, failing bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failing[key] = failing
+}
+
+func (p *failingProvider) ListUsers(since time.Time, cursor string) ([]User, string, error) {
+	p.mu.Lock()
+	p.usersRequests = append(p.usersRequests, cursor)
+	p.usersSince = append(p.usersSince, since)
+	failing := p.failing[cursor]
+	p.mu.Unlock()
+
+	if failing {
+		return nil, "", errProviderFailure
+	}
+	return p.MemoryProvider.ListUsers(since, cursor)
+}
+
+func (p *failingProvider) ListGroupMembers(group Group) ([]string, error) {
+	p.mu.Lock()
+	p.memberRequests = append(p.memberRequests, group.ID)
+	failing := p.failing[group.ID]
+	p.mu.Unlock()
+
+	if failing {
+		return nil, errProviderFailure
+	}
+	return p.MemoryProvider.ListGroupMembers(group)
+}
+
+func (p *failingProvider) ListChanges(since time.Time, cursor string) ([]Change, time.Time, string, error) {
+	p.mu.Lock()
+	failing := p.failing["changes"]
+	p.mu.Unlock()
+
+	if failing {
+		return nil, time.Time{}, "", errProviderFailure
+	}
+	return p.MemoryProvider.ListChanges(since, cursor)
+}
+
+func TestGetUser(t *testing.T) {
+	provider := NewMemoryProvider()
+	provider.SetUser(User{ID: "1", Email: "user@kiwi.com", FirstName: "User"})
+	d, cache := newTestDirectory(provider)
+
+	user, err := d.GetUser("user@kiwi.com")
+	require.NoError(t, err)
+	assert.Equal(t, "User", user.FirstName)
+
+	provider.DeleteUser("user@kiwi.com")
+	user, err = d.GetUser("user@kiwi.com")
+	require.NoError(t, err, "Users are served from cache")
+	assert.Equal(t, "User", user.FirstName)
+
+	_, err = d.GetUser("unknown@kiwi.com")
+	assert.Equal(t, ErrUserNotFound, err)
+	var cached User
+	require.NoError(t, cache.Get("unknown@kiwi.com", &cached))
+	assert.Equal(t, User{}, cached, "Users which were not found are cached too")
+}
+
+func TestAddPermissionsInactiveUsers(t *testing.T) {
+	d, cache := newTestDirectory(NewMemoryProvider())
+	_ = cache.Set("group-membership:service", map[string]map[string]bool{
+		"permission": {"active@kiwi.com": true, "suspended@kiwi.com": true, "legacy@kiwi.com": true},
+	}, 0)
+
+	tests := []struct {
+		user     User
+		expected []string
+	}{
+		{User{Email: "active@kiwi.com", Status: StatusActive}, []string{"permission"}},
+		{User{Email: "suspended@kiwi.com", Status: StatusSuspended}, []string{}},
+		{User{Email: "legacy@kiwi.com"}, []string{"permission"}},
+	}
+
+	for _, test := range tests {
+		user := test.user
+		assert.NoError(t, d.AddPermissions(&user, "service"))
+		assert.Equal(t, test.expected, user.Permissions, user.Email)
+	}
+}
+
+func TestAddPermissionsWithoutSync(t *testing.T) {
+	provider := NewMemoryProvider()
+	provider.SetUser(User{ID: "1", Email: "user@kiwi.com"})
+	provider.SetGroup(Group{ID: "g1", Name: "iam-service.read"})
+	provider.SetGroup(Group{ID: "g2", Name: "iam-other.read"})
+	provider.AddMember("g1", "user@kiwi.com")
+	provider.AddMember("g2", "user@kiwi.com")
+	d, cache := newTestDirectory(provider)
+
+	user, err := d.GetUser("user@kiwi.com")
+	require.NoError(t, err)
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Equal(t, []string{"read"}, user.Permissions, "Groups of the user are read from the provider")
+
+	var cached User
+	require.NoError(t, cache.Get("user@kiwi.com", &cached))
+	assert.Len(t, cached.GroupMembership, 2, "Groups of the user are cached")
+}
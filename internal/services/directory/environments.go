@@ -0,0 +1,101 @@
+package directory
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// EnvironmentSeparator separates the service and the environment in names of
+// groups scoped to an environment, ie. iam-orders@sandbox.refund
+const EnvironmentSeparator = "@"
+
+// ScopedService returns the service scoped to the environment of the caller,
+// as accepted by AddPermissions, ie. orders@sandbox. Services aren't scoped to
+// an empty environment.
+func ScopedService(service, environment string) string {
+	if environment == "" {
+		return service
+	}
+	return service + EnvironmentSeparator + environment
+}
+
+// splitService splits a service scoped by ScopedService into the service and
+// the environment
+func splitService(scoped string) (service, environment string) {
+	parts := strings.SplitN(scoped, EnvironmentSeparator, 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// inEnvironment reports whether a grant or a deny entry scoped to
+// entryEnvironment applies in the environment. Entries without an environment
+// apply in all environments.
+func inEnvironment(entryEnvironment, environment string) bool {
+	return entryEnvironment == "" || strings.EqualFold(entryEnvironment, environment)
+}
+
+// CatalogEntry is a permission of a service in an environment and the group
+// granting it
+type CatalogEntry struct {
+	Permission string `json:"permission"`
+	Group      string `json:"group"`
+	// Environment is empty if the permission is granted by an unscoped group
+	Environment string `json:"environment,omitempty"`
+	// Overrides is true if the group is scoped to the environment and takes
+	// precedence over the unscoped group of the same permission
+	Overrides bool `json:"overrides,omitempty"`
+}
+
+// PermissionCatalog returns the permissions of a service in an environment
+// sorted by name, with the groups granting them. A group scoped to the
+// environment takes precedence over the unscoped group of the same permission,
+// unscoped groups grant the other permissions. Only unscoped groups are listed
+// if the environment is empty.
+//
+// Temporary grants and deny entries aren't listed. They don't override each
+// other by environment: those without an environment apply in all
+// environments, those with one only in theirs, and denies remove permissions
+// whatever granted them.
+func (d *Directory) PermissionCatalog(service, environment string) ([]CatalogEntry, error) {
+	groups, err := d.GetGroups()
+	if err == storage.ErrNotFound {
+		// Groups were not synced yet, no permissions are known
+		return []CatalogEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	scoped := strings.ToLower(ScopedService(service, environment))
+	entries := make(map[string]CatalogEntry)
+	for _, group := range groups {
+		groupService, permission, ok := parseGroupName(group.Name)
+		if !ok {
+			continue
+		}
+		groupService = strings.ToLower(groupService)
+		switch {
+		case groupService == scoped && environment != "":
+			_, overrides := entries[permission]
+			entries[permission] = CatalogEntry{Permission: permission, Group: group.Name, Environment: environment, Overrides: overrides}
+		case groupService == strings.ToLower(service):
+			if entry, ok := entries[permission]; ok {
+				entry.Overrides = true
+				entries[permission] = entry
+				continue
+			}
+			entries[permission] = CatalogEntry{Permission: permission, Group: group.Name}
+		}
+	}
+
+	catalog := make([]CatalogEntry, 0, len(entries))
+	for _, entry := range entries {
+		catalog = append(catalog, entry)
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].Permission < catalog[j].Permission })
+	return catalog, nil
+}
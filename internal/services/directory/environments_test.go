@@ -0,0 +1,122 @@
+package directory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScopedGroupName(t *testing.T) {
+	service, permission, ok := parseGroupName("iam-orders@sandbox.refund.approve")
+	assert.True(t, ok)
+	assert.Equal(t, "orders@sandbox", service)
+	assert.Equal(t, "refund.approve", permission)
+
+	_, _, ok = parseGroupName("iam-orders@.refund")
+	assert.False(t, ok)
+	_, _, ok = parseGroupName("iam-orders@sandbox@production.refund")
+	assert.False(t, ok)
+
+	assert.Equal(t, "orders@sandbox", ScopedService("orders", "sandbox"))
+	assert.Equal(t, "orders", ScopedService("orders", ""))
+}
+
+func newEnvironmentsDirectory() *Directory {
+	provider := NewMemoryProvider()
+	for id, name := range map[string]string{
+		"g1": "iam-service.orders.read",
+		"g2": "iam-service.orders.refund",
+		"g3": "iam-service@sandbox.orders.refund",
+		"g4": "iam-service@sandbox.orders.cancel",
+		"g5": "iam-service@production.orders.admin",
+	} {
+		provider.SetGroup(Group{ID: id, Name: name})
+	}
+	provider.AddMember("g1", "user@kiwi.com")
+	provider.AddMember("g2", "user@kiwi.com")
+	provider.AddMember("g4", "user@kiwi.com")
+	provider.AddMember("g5", "user@kiwi.com")
+	provider.SetUser(User{Email: "user@kiwi.com", Status: StatusActive})
+	d, _ := newTestDirectory(provider)
+	d.SyncGroups()
+	return d
+}
+
+func TestAddPermissionsEnvironment(t *testing.T) {
+	d := newEnvironmentsDirectory()
+	user := User{Email: "user@kiwi.com", Status: StatusActive}
+
+	require.NoError(t, d.AddPermissions(&user, "service@sandbox"))
+	assert.ElementsMatch(t, []string{"orders.read", "orders.cancel"}, user.Permissions,
+		"Scoped groups take precedence over unscoped groups of the same permission")
+
+	require.NoError(t, d.AddPermissions(&user, "service@production"))
+	assert.ElementsMatch(t, []string{"orders.read", "orders.refund", "orders.admin"}, user.Permissions)
+
+	require.NoError(t, d.AddPermissions(&user, "service@test"))
+	assert.ElementsMatch(t, []string{"orders.read", "orders.refund"}, user.Permissions,
+		"Unscoped groups are used in environments without scoped groups")
+
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.ElementsMatch(t, []string{"orders.read", "orders.refund"}, user.Permissions)
+}
+
+func TestPermissionCatalog(t *testing.T) {
+	d := newEnvironmentsDirectory()
+
+	catalog, err := d.PermissionCatalog("service", "sandbox")
+	require.NoError(t, err)
+	assert.Equal(t, []CatalogEntry{
+		{Permission: "orders.cancel", Group: "iam-service@sandbox.orders.cancel", Environment: "sandbox"},
+		{Permission: "orders.read", Group: "iam-service.orders.read"},
+		{Permission: "orders.refund", Group: "iam-service@sandbox.orders.refund", Environment: "sandbox", Overrides: true},
+	}, catalog)
+
+	catalog, err = d.PermissionCatalog("service", "")
+	require.NoError(t, err)
+	assert.Equal(t, []CatalogEntry{
+		{Permission: "orders.read", Group: "iam-service.orders.read"},
+		{Permission: "orders.refund", Group: "iam-service.orders.refund"},
+	}, catalog)
+
+	d, _ = newTestDirectory(NewMemoryProvider())
+	catalog, err = d.PermissionCatalog("service", "sandbox")
+	require.NoError(t, err)
+	assert.Empty(t, catalog, "No permissions are known before the groups sync")
+}
+
+func TestAddPermissionsEnvironmentGrantsAndDenies(t *testing.T) {
+	d := newEnvironmentsDirectory()
+	user := User{Email: "user@kiwi.com", Status: StatusActive}
+
+	grant := testGrant(time.Hour)
+	grant.Email = "user@kiwi.com"
+	grant.Permission = "orders.approve"
+	grant.Environment = "Sandbox"
+	_, err := d.CreateGrant(grant, "admin-tool")
+	require.NoError(t, err)
+	_, err = d.CreateDeny(Deny{Service: "service", Environment: "production", Permission: "orders.read", Email: "user@kiwi.com", Reason: "INC-1"}, "admin-tool")
+	require.NoError(t, err)
+
+	require.NoError(t, d.AddPermissions(&user, "service@sandbox"))
+	assert.ElementsMatch(t, []string{"orders.read", "orders.cancel", "orders.approve"}, user.Permissions,
+		"Grants and denies of an environment apply only in it")
+	assert.Empty(t, user.DeniedPermissions)
+
+	require.NoError(t, d.AddPermissions(&user, "service@production"))
+	assert.ElementsMatch(t, []string{"orders.refund", "orders.admin"}, user.Permissions)
+	assert.Equal(t, []string{"orders.read"}, user.DeniedPermissions)
+
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.ElementsMatch(t, []string{"orders.read", "orders.refund"}, user.Permissions,
+		"Grants and denies of an environment don't apply to unscoped services")
+
+	_, err = d.CreateDeny(Deny{Service: "service", Permission: "orders.refund", Email: "user@kiwi.com", Reason: "INC-2"}, "admin-tool")
+	require.NoError(t, err)
+	for _, service := range []string{"service", "service@sandbox", "service@production"} {
+		require.NoError(t, d.AddPermissions(&user, service))
+		assert.Contains(t, user.DeniedPermissions, "orders.refund", "Denies without an environment apply in all of them, %s", service)
+	}
+}
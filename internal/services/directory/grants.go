@@ -0,0 +1,241 @@
+package directory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// grantsKey is the cache key of temporary grants, all grants are stored in a
+// single entry, as there are only a few of them at any time
+const grantsKey = "temporary-grants"
+
+// DefaultMaxGrantDuration is the longest a temporary grant can last if the
+// maximum is not configured
+const DefaultMaxGrantDuration = 12 * time.Hour
+
+// ErrGrantNotFound is returned when revoking a grant which doesn't exist or
+// already expired
+var ErrGrantNotFound = errors.New("grant not found")
+
+// InvalidGrantError is returned when creating an invalid grant
+type InvalidGrantError struct {
+	Reason string
+}
+
+func (e InvalidGrantError) Error() string {
+	return "invalid grant: " + e.Reason
+}
+
+// Grant is a permission of a service granted to a user by IAM until it
+// expires, ie. elevated access of on-call engineers
+type Grant struct {
+	ID         string `json:"id"`
+	Email      string `json:"email"`
+	Service    string `json:"service"`
+	Permission string `json:"permission"`
+	// Environment limits the grant to callers in the environment, the grant
+	// applies in all environments if it's empty
+	Environment string `json:"environment,omitempty"`
+	// Reason of the grant, ie. an incident
+	Reason string `json:"reason"`
+	// Approver is the email of the person who approved the grant
+	Approver string `json:"approver"`
+	// CreatedBy is the service which created the grant
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// active reports whether the grant didn't expire at the time
+func (g *Grant) active(now time.Time) bool {
+	return now.Before(g.ExpiresAt)
+}
+
+// validate checks the fields of a grant requested through CreateGrant
+func (g *Grant) validate(now time.Time, maxDuration time.Duration) error {
+	if _, err := mail.ParseAddress(g.Email); err != nil {
+		return InvalidGrantError{"invalid email"}
+	}
+	if g.Service == "" {
+		return InvalidGrantError{"missing service"}
+	}
+	if g.Permission == "" {
+		return InvalidGrantError{"missing permission"}
+	}
+	if strings.Contains(g.Service, EnvironmentSeparator) || strings.Contains(g.Environment, EnvironmentSeparator) {
+		return InvalidGrantError{"environment has to be set by its own field"}
+	}
+	if g.Reason == "" {
+		return InvalidGrantError{"missing reason"}
+	}
+	if _, err := mail.ParseAddress(g.Approver); err != nil {
+		return InvalidGrantError{"invalid approver"}
+	}
+	if strings.EqualFold(g.Approver, g.Email) {
+		return InvalidGrantError{"grants can't be approved by their grantee"}
+	}
+	if !g.ExpiresAt.After(now) {
+		return InvalidGrantError{"expiration has to be in the future"}
+	}
+	if g.ExpiresAt.Sub(now) > maxDuration {
+		return InvalidGrantError{"grants can last at most " + maxDuration.String()}
+	}
+	return nil
+}
+
+// CreateGrant stores a temporary grant, its ID and creation are set by IAM.
+// actor is the service creating the grant, recorded in the audit event.
+func (d *Directory) CreateGrant(grant Grant, actor string) (Grant, error) {
+	now := time.Now()
+	if err := grant.validate(now, d.maxGrantDuration); err != nil {
+		return Grant{}, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Grant{}, err
+	}
+	grant.ID = hex.EncodeToString(id)
+	grant.Email = strings.ToLower(grant.Email)
+	grant.Service = strings.ToLower(grant.Service)
+	grant.Environment = strings.ToLower(grant.Environment)
+	grant.CreatedBy = actor
+	grant.CreatedAt = now
+
+	err := d.updateGrants(func(grants map[string]Grant) error {
+		grants[grant.ID] = grant
+		return nil
+	})
+	if err != nil {
+		return Grant{}, err
+	}
+	d.audit(AuditEvent{Type: AuditGrantCreated, Time: now, Actor: actor, Grant: &grant})
+	return grant, nil
+}
+
+// RevokeGrant removes a temporary grant before it expires. actor is the
+// service revoking the grant, recorded in the audit event.
+func (d *Directory) RevokeGrant(id, actor string) error {
+	now := time.Now()
+	var revoked Grant
+	err := d.updateGrants(func(grants map[string]Grant) error {
+		grant, ok := grants[id]
+		if !ok || !grant.active(now) {
+			return ErrGrantNotFound
+		}
+		revoked = grant
+		delete(grants, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.audit(AuditEvent{Type: AuditGrantRevoked, Time: now, Actor: actor, Grant: &revoked})
+	return nil
+}
+
+// ListGrants returns active temporary grants sorted by expiration, filtered by
+// email and service if they're not empty
+func (d *Directory) ListGrants(email, service string) ([]Grant, error) {
+	grants, err := d.getGrants()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	list := make([]Grant, 0, len(grants))
+	for _, grant := range grants {
+		if !grant.active(now) ||
+			(email != "" && !strings.EqualFold(grant.Email, email)) ||
+			(service != "" && !strings.EqualFold(grant.Service, service)) {
+			continue
+		}
+		list = append(list, grant)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].ExpiresAt.Equal(list[j].ExpiresAt) {
+			return list[i].ExpiresAt.Before(list[j].ExpiresAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// ExpireGrants removes expired temporary grants and records their expiration.
+// Expired grants don't grant permissions even before they're removed.
+func (d *Directory) ExpireGrants() error {
+	now := time.Now()
+	var expired []Grant
+	err := d.updateGrants(func(grants map[string]Grant) error {
+		for id, grant := range grants {
+			if !grant.active(now) {
+				expired = append(expired, grant)
+				delete(grants, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range expired {
+		d.audit(AuditEvent{Type: AuditGrantExpired, Time: now, Actor: AuditActorIAM, Grant: &expired[i]})
+	}
+	return nil
+}
+
+// addGrantedPermissions adds permissions of active temporary grants of the
+// user applying in the environment to permissions, without duplicates
+func (d *Directory) addGrantedPermissions(user *User, service, environment string, permissions []string) ([]string, error) {
+	grants, err := d.ListGrants(user.Email, service)
+	if err != nil || len(grants) == 0 {
+		return permissions, err
+	}
+
+	granted := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		granted[permission] = true
+	}
+	for _, grant := range grants {
+		if inEnvironment(grant.Environment, environment) && !granted[grant.Permission] {
+			granted[grant.Permission] = true
+			permissions = append(permissions, grant.Permission)
+		}
+	}
+	return permissions, nil
+}
+
+// getGrants returns all stored temporary grants by ID
+func (d *Directory) getGrants() (map[string]Grant, error) {
+	grants := make(map[string]Grant)
+	err := d.cache.Get(grantsKey, &grants)
+	if err == storage.ErrNotFound {
+		return grants, nil
+	}
+	return grants, err
+}
+
+// updateGrants applies an update to the stored temporary grants, updates are
+// serialized across instances by a lock. Grants aren't written if the update
+// fails.
+func (d *Directory) updateGrants(update func(grants map[string]Grant) error) error {
+	d.lock.Acquire(grantsKey)
+	defer d.lock.Delete(grantsKey)
+
+	grants, err := d.getGrants()
+	if err != nil {
+		return err
+	}
+	if err := update(grants); err != nil {
+		return err
+	}
+	return d.cache.Set(grantsKey, grants, 0)
+}
@@ -0,0 +1,120 @@
+package directory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testGrant(expiresIn time.Duration) Grant {
+	return Grant{
+		Email:      "Oncall@kiwi.com",
+		Service:    "Service",
+		Permission: "orders.refund",
+		Reason:     "INC-123",
+		Approver:   "lead@kiwi.com",
+		ExpiresAt:  time.Now().Add(expiresIn),
+	}
+}
+
+func TestCreateGrant(t *testing.T) {
+	d, _ := newTestDirectory(NewMemoryProvider())
+
+	grant, err := d.CreateGrant(testGrant(time.Hour), "admin-tool")
+	require.NoError(t, err)
+	assert.NotEmpty(t, grant.ID)
+	assert.Equal(t, "oncall@kiwi.com", grant.Email)
+	assert.Equal(t, "service", grant.Service)
+	assert.Equal(t, "admin-tool", grant.CreatedBy)
+	assert.False(t, grant.CreatedAt.IsZero())
+
+	grants, err := d.ListGrants("oncall@kiwi.com", "SERVICE")
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	assert.Equal(t, grant.ID, grants[0].ID)
+
+	grants, err = d.ListGrants("", "other")
+	require.NoError(t, err)
+	assert.Empty(t, grants)
+
+	invalid := map[string]func(g *Grant){
+		"invalid email":      func(g *Grant) { g.Email = "oncall" },
+		"missing service":    func(g *Grant) { g.Service = "" },
+		"missing permission": func(g *Grant) { g.Permission = "" },
+		"scoped service":     func(g *Grant) { g.Service = "service@sandbox" },
+		"missing reason":     func(g *Grant) { g.Reason = "" },
+		"missing approver":   func(g *Grant) { g.Approver = "" },
+		"self approval":      func(g *Grant) { g.Approver = "oncall@KIWI.com" },
+		"expired":            func(g *Grant) { g.ExpiresAt = time.Now().Add(-time.Minute) },
+		"too long":           func(g *Grant) { g.ExpiresAt = time.Now().Add(DefaultMaxGrantDuration + time.Hour) },
+	}
+	for name, modify := range invalid {
+		g := testGrant(time.Hour)
+		modify(&g)
+		_, err := d.CreateGrant(g, "admin-tool")
+		assert.Error(t, err, name)
+	}
+}
+
+func TestRevokeAndExpireGrants(t *testing.T) {
+	d, cache := newTestDirectory(NewMemoryProvider())
+
+	active, err := d.CreateGrant(testGrant(time.Hour), "admin-tool")
+	require.NoError(t, err)
+	revoked, err := d.CreateGrant(testGrant(time.Hour), "admin-tool")
+	require.NoError(t, err)
+	expired, err := d.CreateGrant(testGrant(time.Hour), "admin-tool")
+	require.NoError(t, err)
+
+	require.NoError(t, d.RevokeGrant(revoked.ID, "admin-tool"))
+	assert.Equal(t, ErrGrantNotFound, d.RevokeGrant(revoked.ID, "admin-tool"))
+	assert.Equal(t, ErrGrantNotFound, d.RevokeGrant("unknown", "admin-tool"))
+
+	// Expire a grant
+	grants := make(map[string]Grant)
+	require.NoError(t, cache.Get(grantsKey, &grants))
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	grants[expired.ID] = expired
+	require.NoError(t, cache.Set(grantsKey, grants, 0))
+
+	list, err := d.ListGrants("", "")
+	require.NoError(t, err)
+	require.Len(t, list, 1, "Expired grants are not listed before they're removed")
+	assert.Equal(t, active.ID, list[0].ID)
+	assert.Equal(t, ErrGrantNotFound, d.RevokeGrant(expired.ID, "admin-tool"))
+
+	require.NoError(t, d.ExpireGrants())
+	grants = make(map[string]Grant)
+	require.NoError(t, cache.Get(grantsKey, &grants))
+	assert.Len(t, grants, 1)
+	assert.Contains(t, grants, active.ID)
+}
+
+func TestAddPermissionsGrants(t *testing.T) {
+	provider := NewMemoryProvider()
+	provider.SetGroup(Group{ID: "g1", Name: "iam-service.orders.read"})
+	provider.AddMember("g1", "oncall@kiwi.com")
+	provider.SetUser(User{Email: "oncall@kiwi.com", Status: StatusActive})
+	d, _ := newTestDirectory(provider)
+	d.SyncGroups()
+
+	grant := testGrant(time.Hour)
+	grant.Permission = "orders.read"
+	_, err := d.CreateGrant(grant, "admin-tool")
+	require.NoError(t, err)
+	_, err = d.CreateGrant(testGrant(time.Hour), "admin-tool")
+	require.NoError(t, err)
+
+	user := User{Email: "oncall@kiwi.com", Status: StatusActive}
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.ElementsMatch(t, []string{"orders.read", "orders.refund"}, user.Permissions)
+
+	require.NoError(t, d.AddPermissions(&user, "other"))
+	assert.Empty(t, user.Permissions)
+
+	user.Status = StatusSuspended
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Empty(t, user.Permissions, "Grants don't apply to inactive users")
+}
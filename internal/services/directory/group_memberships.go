@@ -0,0 +1,164 @@
+package directory
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/getsentry/raven-go"
+
+	cfg "github.com/kiwicom/iam/configs"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// GroupMembership holds the emails of users who are part of a given group
+type GroupMembership struct {
+	GroupID   string
+	GroupName string
+	Users     []string
+}
+
+// groupMembershipFailure is a group whose members couldn't be fetched
+type groupMembershipFailure struct {
+	Group Group
+	Err   error
+}
+
+// fetchGroupMemberships fetches members of the given groups, several groups at
+// a time. Groups which failed are returned separately, so members of the other
+// groups can still be used.
+func (d *Directory) fetchGroupMemberships(groups []Group) ([]GroupMembership, []groupMembershipFailure) {
+	type result struct {
+		group Group
+		users []string
+		err   error
+	}
+
+	jobs := make(chan Group)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < d.membershipWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range jobs {
+				users, err := d.provider.ListGroupMembers(group)
+				results <- result{group, users, err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, group := range groups {
+			jobs <- group
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	groupMemberships := make([]GroupMembership, 0, len(groups))
+	var failures []groupMembershipFailure
+	for res := range results {
+		if res.err != nil {
+			failures = append(failures, groupMembershipFailure{res.group, res.err})
+			continue
+		}
+		groupMemberships = append(groupMemberships, GroupMembership{
+			res.group.ID,
+			res.group.Name,
+			res.users,
+		})
+	}
+
+	return groupMemberships, failures
+}
+
+// getFailedGroups returns the groups whose members couldn't be fetched by the
+// last groups sync.
+func (d *Directory) getFailedGroups() []Group {
+	var groups []Group
+	if err := d.cache.Get("groups-sync-failed", &groups); err != nil && err != storage.ErrNotFound {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+	}
+	return groups
+}
+
+// setFailedGroups caches the groups whose members couldn't be fetched, so that
+// the next sync fetches them again, even if their members didn't change since.
+func (d *Directory) setFailedGroups(failures []groupMembershipFailure) {
+	var err error
+	if len(failures) == 0 {
+		err = d.cache.Del("groups-sync-failed")
+	} else {
+		groups := make([]Group, len(failures))
+		for i := range failures {
+			groups[i] = failures[i].Group
+		}
+		err = d.cache.Set("groups-sync-failed", groups, cfg.Expirations.GroupMemberships)
+	}
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+	}
+}
+
+// groupPattern matches names of IAM groups, segments of permissions may be
+// wildcards, ie. iam-serviceName.orders.*, and groups may be scoped to an
+// environment, ie. iam-serviceName@sandbox.orders
+var groupPattern = regexp.MustCompile(`^iam-[\w-]+(@[\w-]+)?\.([\w-]+|\*)(\.([\w-]+|\*))*\.?$`)
+
+// parseGroupName splits the name of an IAM group into the service and the
+// permission, ie. iam-serviceName.rule -> serviceName, rule. The service of
+// groups scoped to an environment includes it, ie. serviceName@sandbox.
+func parseGroupName(name string) (service, permission string, ok bool) {
+	if !groupPattern.MatchString(name) {
+		return "", "", false
+	}
+
+	groupParts := strings.SplitAfterN(name, ".", 2)
+	service = strings.Replace(strings.TrimRight(groupParts[0], "."), GroupPrefix, "", 1)
+	return service, groupParts[1], true
+}
+
+// updateGroupMemberships replaces members of groups, it returns the users added
+// to and removed from the groups.
+func (d *Directory) updateGroupMemberships(store keyValueStore, memberships []GroupMembership) ([]memberChange, error) {
+	var changes []memberChange
+	for _, membership := range memberships {
+		service, permission, ok := parseGroupName(membership.GroupName)
+		if !ok {
+			formatErr := errors.New("group name has incorrect format: " + membership.GroupName)
+			raven.CaptureError(formatErr, nil)
+			continue
+		}
+		serviceName := groupMembershipPrefix + service
+
+		cachedGroupMemberships := make(map[string]map[string]bool)
+
+		err := store.Get(serviceName, &cachedGroupMemberships)
+		if err != nil {
+			if err != storage.ErrNotFound {
+				return nil, err
+			}
+		}
+
+		previous := cachedGroupMemberships[permission]
+		cachedGroupMemberships[permission] = make(map[string]bool)
+
+		for _, userid := range membership.Users {
+			cachedGroupMemberships[permission][userid] = true
+		}
+		changes = append(changes, diffMembers(membership.GroupName, previous, cachedGroupMemberships[permission])...)
+
+		if err := store.Set(serviceName, cachedGroupMemberships); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
@@ -1,17 +1,16 @@
-package okta
+package directory
 
 import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-
-	"github.com/kiwicom/iam/internal/storage"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGroupMemberships(t *testing.T) {
-	client := NewClient(&ClientOpts{
-		Cache: storage.NewInMemoryCache(),
-	})
+	d, _ := newTestDirectory(NewMemoryProvider())
+	snapshot, err := d.groupsSnapshots.Begin()
+	require.NoError(t, err)
 
 	tests := []struct {
 		groupName   string
@@ -24,7 +23,7 @@ func TestGroupMemberships(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		err := client.updateGroupMemberships([]GroupMembership{
+		_, err := d.updateGroupMemberships(snapshot, []GroupMembership{
 			{"group-id", test.groupName, []string{"user1", "user2"}},
 		})
 
@@ -37,16 +36,17 @@ func TestGroupMemberships(t *testing.T) {
 }
 
 func TestGroupMembershipsInvalidation(t *testing.T) {
-	cache := storage.NewInMemoryCache()
-	client := NewClient(&ClientOpts{Cache: cache})
+	d, _ := newTestDirectory(NewMemoryProvider())
+	snapshot, err := d.groupsSnapshots.Begin()
+	require.NoError(t, err)
 
-	err := client.updateGroupMemberships([]GroupMembership{
+	_, err = d.updateGroupMemberships(snapshot, []GroupMembership{
 		{"group-id", "iam-service.permission1", []string{"user1", "user2"}},
 		{"group-id", "iam-service.permission2", []string{"user1", "user2"}},
 	})
 
 	membershipsBefore := make(map[string]map[string]bool)
-	_ = cache.Get("group-membership:service", &membershipsBefore)
+	_ = snapshot.Get("group-membership:service", &membershipsBefore)
 
 	assert.NoError(t, err)
 	assert.Equal(t, map[string]map[string]bool{
@@ -60,13 +60,13 @@ func TestGroupMembershipsInvalidation(t *testing.T) {
 		},
 	}, membershipsBefore, "Group memberships are added correctly")
 
-	err = client.updateGroupMemberships([]GroupMembership{
+	_, err = d.updateGroupMemberships(snapshot, []GroupMembership{
 		{"group-id", "iam-service.permission1", []string{"user2"}},
 		{"group-id", "iam-service.permission2", []string{"user1", "user2"}},
 	})
 
 	membershipsAfter := make(map[string]map[string]bool)
-	_ = cache.Get("group-membership:service", &membershipsAfter)
+	_ = snapshot.Get("group-membership:service", &membershipsAfter)
 
 	assert.NoError(t, err)
 	assert.Equal(t, map[string]map[string]bool{
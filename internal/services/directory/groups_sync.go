@@ -0,0 +1,329 @@
+package directory
+
+import (
+	"log"
+	"time"
+
+	"github.com/getsentry/raven-go"
+
+	cfg "github.com/kiwicom/iam/configs"
+	"github.com/kiwicom/iam/internal/monitoring"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// SyncGroups gets all groups from the provider and saves them into cache.
+func (d *Directory) SyncGroups() {
+	lockErr := d.lock.Create("sync_groups")
+	if lockErr == storage.ErrLockExists {
+		log.Println("Aborted, groups were already fetched")
+		return
+	}
+	defer d.lock.Delete("sync_groups")
+
+	if d.Ready() != nil {
+		log.Println(d.name, "is unavailable, skipping groups sync")
+		d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "groups"), monitoring.Tag("status", "skipped"))
+		return
+	}
+	syncStart := time.Now().UTC()
+
+	// All groups are fetched to find out which were removed, members are fetched
+	// only for groups which changed.
+	groups, err := d.provider.ListGroups()
+	if err != nil {
+		log.Println("Error fetching groups", err)
+		d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "groups"), monitoring.Tag("status", "error"))
+		raven.CaptureError(err, nil)
+		return
+	}
+	previous := d.getCachedGroups()
+	changed := changedGroups(groups, previous, d.getFailedGroups(), d.getGroupsLastSync())
+
+	// We need to keep track of users assigned to various groups
+	groupMemberships, failures := d.fetchGroupMemberships(changed)
+	for _, failure := range failures {
+		log.Println("Error fetching members of group", failure.Group.Name, failure.Err)
+		raven.CaptureError(failure.Err, map[string]string{"group": failure.Group.Name})
+	}
+
+	history, err := d.publishGroups(groups, previous, groupMemberships, syncStart)
+	if err != nil {
+		log.Println("Error updating group memeberships ", err)
+		d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "groups"), monitoring.Tag("status", "error"))
+		raven.CaptureError(err, nil)
+		return
+	}
+	d.setFailedGroups(failures)
+	d.recordHistory(history, HistorySourceSync, syncStart)
+
+	if err = d.cache.Set("groups-sync-timestamp", syncStart, cfg.Expirations.GroupsLastSync); err != nil {
+		log.Println("Error while caching last synchronization time ", err)
+		d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "groups"), monitoring.Tag("status", "error"))
+		raven.CaptureError(err, nil)
+		return
+	}
+	if len(failures) > 0 {
+		log.Println("Cached", len(groupMemberships), "group memberships,", len(failures), "groups will be retried")
+		d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "groups"), monitoring.Tag("status", "partial"))
+		return
+	}
+	log.Println("Cached", len(groupMemberships), "group memberships")
+	d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "groups"), monitoring.Tag("status", "ok"))
+}
+
+// getGroupsLastSync returns the start of the last groups sync, or zero time if
+// the next sync should fetch members of all groups.
+func (d *Directory) getGroupsLastSync() time.Time {
+	timestamp := time.Time{}
+	if err := d.cache.Get("groups-sync-timestamp", &timestamp); err != nil {
+		if err != storage.ErrNotFound {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+	}
+	return timestamp
+}
+
+// getCachedGroups returns the groups cached by the last groups sync.
+func (d *Directory) getCachedGroups() []Group {
+	groups, err := d.GetGroups()
+	if err != nil && err != storage.ErrNotFound {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+	}
+	return groups
+}
+
+// changedGroups returns the groups whose members have to be fetched: all of
+// them when there was no sync yet, otherwise groups with members updated since
+// the last sync or without a membership update time, new or renamed groups, and
+// groups which failed last time.
+func changedGroups(groups, previous, failed []Group, lastSync time.Time) []Group {
+	if lastSync.IsZero() {
+		return groups
+	}
+
+	previousNames := make(map[string]string, len(previous))
+	for _, group := range previous {
+		previousNames[group.ID] = group.Name
+	}
+	failedIDs := make(map[string]bool, len(failed))
+	for _, group := range failed {
+		failedIDs[group.ID] = true
+	}
+
+	var changed []Group
+	for _, group := range groups {
+		updated := group.LastMembershipUpdated.IsZero() || group.LastMembershipUpdated.After(lastSync)
+		if updated || previousNames[group.ID] != group.Name || failedIDs[group.ID] {
+			changed = append(changed, group)
+		}
+	}
+	return changed
+}
+
+// servicePermissions returns the permissions of each service given by the
+// names of groups.
+func servicePermissions(groups []Group) map[string]map[string]bool {
+	permissions := make(map[string]map[string]bool)
+	for _, group := range groups {
+		service, permission, ok := parseGroupName(group.Name)
+		if !ok {
+			continue
+		}
+		if permissions[service] == nil {
+			permissions[service] = make(map[string]bool)
+		}
+		permissions[service][permission] = true
+	}
+	return permissions
+}
+
+// groupsSnapshotLock is the name of the lock serializing writes of groups
+// snapshots by groups syncs, batches of changes and rollbacks
+const groupsSnapshotLock = "groups-snapshot"
+
+// keyValueStore is a snapshot of groups being written, its items are kept for
+// as long as the snapshot is retained.
+type keyValueStore interface {
+	Get(key string, value interface{}) error
+	Set(key string, value interface{}) error
+	Del(key string) error
+}
+
+// groupsKey returns the key of an item of the published groups snapshot.
+func (d *Directory) groupsKey(key string) (string, error) {
+	generation, err := d.groupsSnapshots.Generation()
+	if err != nil {
+		return "", err
+	}
+	return d.groupsSnapshots.Key(generation, key), nil
+}
+
+// getPublished reads an item of the published groups snapshot.
+func (d *Directory) getPublished(key string, value interface{}) error {
+	key, err := d.groupsKey(key)
+	if err != nil {
+		return err
+	}
+	return d.cache.Get(key, value)
+}
+
+// publishGroups writes a new snapshot of groups and their members, and
+// publishes it once it's complete, so readers never see a partially written
+// sync. Published snapshots are never modified, changes applied from events
+// between syncs are published in snapshots of their own, see ApplyChanges.
+// It returns the changes of permissions of users since the published
+// snapshot, previous are its groups.
+func (d *Directory) publishGroups(groups, previous []Group, memberships []GroupMembership, at time.Time) (map[string][]HistoryEntry, error) {
+	// Members of services without groups left are read before they're gone
+	var removed []memberChange
+	if previous != nil {
+		removed = d.removedServiceMembers(groups, previous)
+	}
+
+	d.lock.Acquire(groupsSnapshotLock)
+	defer d.lock.Delete(groupsSnapshotLock)
+
+	snapshot, err := d.groupsSnapshots.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := d.writeGroups(snapshot, groups, memberships)
+	if err != nil {
+		snapshot.Discard()
+		return nil, err
+	}
+
+	var history map[string][]HistoryEntry
+	if previous != nil {
+		// Without cached groups, all members are new and there's nothing to
+		// diff. Permissions are compared before publishing, which may prune the
+		// published snapshot.
+		history = d.permissionChanges(append(changes, removed...),
+			groupsState{previous, storedMemberships(d.getPublished)},
+			groupsState{groups, storedMemberships(snapshot.Get)},
+			HistorySourceSync, at)
+	}
+	return history, snapshot.Publish()
+}
+
+func (d *Directory) writeGroups(snapshot *storage.Snapshot, groups []Group, memberships []GroupMembership) ([]memberChange, error) {
+	// Members of groups which didn't change are taken from the published
+	// snapshot. Services which have no groups left are not copied.
+	for service := range servicePermissions(groups) {
+		key, err := d.groupsKey(groupMembershipPrefix + service)
+		if err != nil {
+			return nil, err
+		}
+
+		serviceMemberships := make(map[string]map[string]bool)
+		if err := d.cache.Get(key, &serviceMemberships); err != nil {
+			if err == storage.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		if err := snapshot.Set(groupMembershipPrefix+service, serviceMemberships); err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := d.updateGroupMemberships(snapshot, memberships)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := d.removeStaleGroupMemberships(snapshot, groups)
+	if err != nil {
+		return nil, err
+	}
+	return append(changes, removed...), snapshot.Set("groups", groups)
+}
+
+// removeStaleGroupMemberships removes permissions of groups which are not in
+// the provider anymore from group memberships, it returns their members.
+func (d *Directory) removeStaleGroupMemberships(store keyValueStore, groups []Group) ([]memberChange, error) {
+	var changes []memberChange
+	for service, permissions := range servicePermissions(groups) {
+		key := groupMembershipPrefix + service
+		memberships := make(map[string]map[string]bool)
+		if err := store.Get(key, &memberships); err != nil {
+			if err == storage.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+
+		var removed int
+		for permission, members := range memberships {
+			if !permissions[permission] {
+				changes = append(changes, diffMembers(GroupPrefix+service+"."+permission, members, nil)...)
+				delete(memberships, permission)
+				removed++
+			}
+		}
+		if removed == 0 {
+			continue
+		}
+
+		log.Println("Removing", removed, "permissions of service", service)
+		if err := store.Set(key, memberships); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+
+// removedServiceMembers returns members of the published groups of services
+// which have no groups left, their memberships are not copied to the next
+// snapshot. Errors are only reported, as the members are used only by the
+// permission history.
+func (d *Directory) removedServiceMembers(groups, previous []Group) []memberChange {
+	current := servicePermissions(groups)
+	var changes []memberChange
+	for service := range servicePermissions(previous) {
+		if current[service] != nil {
+			continue
+		}
+		key, err := d.groupsKey(groupMembershipPrefix + service)
+		if err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+			return changes
+		}
+		memberships := make(map[string]map[string]bool)
+		if err := d.cache.Get(key, &memberships); err != nil {
+			if err != storage.ErrNotFound {
+				log.Println("[ERROR]", err.Error())
+				raven.CaptureError(err, nil)
+			}
+			continue
+		}
+		for permission, members := range memberships {
+			changes = append(changes, diffMembers(GroupPrefix+service+"."+permission, members, nil)...)
+		}
+	}
+	return changes
+}
+
+// RollbackGroups publishes the groups snapshot published before the current
+// one, by a groups sync or a batch of changes, and returns its generation.
+// Changes of the rolled back snapshot are lost until the next sync. It waits
+// for a running groups sync, so that the sync doesn't publish over the
+// rollback.
+func (d *Directory) RollbackGroups(actor string) (int64, error) {
+	d.lock.Acquire("sync_groups")
+	defer d.lock.Delete("sync_groups")
+	d.lock.Acquire(groupsSnapshotLock)
+	defer d.lock.Delete(groupsSnapshotLock)
+
+	generation, err := d.groupsSnapshots.Rollback()
+	if err != nil {
+		return 0, err
+	}
+	log.Println("Rolled back groups to generation", generation)
+	d.audit(AuditEvent{Type: AuditGroupsRolledBack, Time: time.Now().UTC(), Actor: actor, Generation: generation})
+	return generation, nil
+}
@@ -0,0 +1,89 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+func TestSyncGroupsPartialFailure(t *testing.T) {
+	provider := newFailingProvider()
+	for id, name := range map[string]string{"g1": "iam-service.read", "g2": "iam-service.write", "g3": "iam-other.read"} {
+		provider.SetGroup(Group{ID: id, Name: name})
+		provider.AddMember(id, "user@kiwi.com")
+	}
+	provider.fail("g2", true)
+	d, _ := newTestDirectory(provider)
+	d.membershipWorkers = 2
+
+	d.SyncGroups()
+
+	assert.ElementsMatch(t, []string{"g1", "g2", "g3"}, provider.memberRequests)
+	user := User{Email: "user@kiwi.com"}
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Equal(t, []string{"read"}, user.Permissions, "Groups which didn't fail are cached")
+	require.NoError(t, d.AddPermissions(&user, "other"))
+	assert.Equal(t, []string{"read"}, user.Permissions)
+	failed := d.getFailedGroups()
+	require.Len(t, failed, 1)
+	assert.Equal(t, "iam-service.write", failed[0].Name)
+
+	provider.fail("g2", false)
+	provider.memberRequests = nil
+	d.SyncGroups()
+
+	assert.Equal(t, []string{"g2"}, provider.memberRequests, "Failed groups are fetched again")
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.ElementsMatch(t, []string{"read", "write"}, user.Permissions)
+	assert.Empty(t, d.getFailedGroups())
+}
+
+func TestSyncGroupsReconcile(t *testing.T) {
+	provider := newFailingProvider()
+	for id, name := range map[string]string{"g1": "iam-service.read", "g2": "iam-service.write", "g3": "iam-other.read"} {
+		provider.SetGroup(Group{ID: id, Name: name})
+		provider.AddMember(id, "user@kiwi.com")
+	}
+	d, cache := newTestDirectory(provider)
+
+	d.SyncGroups()
+
+	cachedGroups, err := d.GetGroups()
+	require.NoError(t, err, "Groups are cached")
+	assert.Len(t, cachedGroups, 3)
+
+	// The write group is removed, the other group renamed and read is unchanged
+	provider.DeleteGroup("g2")
+	provider.SetGroup(Group{ID: "g3", Name: "iam-renamed.read"})
+	provider.memberRequests = nil
+	d.SyncGroups()
+
+	assert.Equal(t, []string{"g3"}, provider.memberRequests, "Only members of changed groups are fetched")
+	getMemberships := func(service string, memberships interface{}) error {
+		key, err := d.groupsKey(groupMembershipPrefix + service)
+		require.NoError(t, err)
+		return cache.Get(key, memberships)
+	}
+	memberships := make(map[string]map[string]bool)
+	require.NoError(t, getMemberships("service", &memberships))
+	assert.Equal(t, map[string]map[string]bool{"read": {"user@kiwi.com": true}}, memberships, "Removed groups are not cached")
+	assert.Equal(t, storage.ErrNotFound, getMemberships("other", &memberships), "Services without groups are removed")
+	require.NoError(t, getMemberships("renamed", &memberships))
+
+	cachedGroups, err = d.GetGroups()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iam-renamed.read", "iam-service.read"}, []string{cachedGroups[0].Name, cachedGroups[1].Name})
+
+	generation, err := d.groupsSnapshots.Generation()
+	require.NoError(t, err)
+	rolledBack, err := d.RollbackGroups("admin")
+	require.NoError(t, err)
+	assert.Equal(t, generation-1, rolledBack)
+	require.NoError(t, getMemberships("other", &memberships), "Groups of the previous sync are restored")
+	cachedGroups, err = d.GetGroups()
+	require.NoError(t, err)
+	assert.Len(t, cachedGroups, 3)
+}
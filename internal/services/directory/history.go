@@ -0,0 +1,422 @@
+package directory
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/monitoring"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// historyPrefix is the prefix of cache keys of permission histories of users
+const historyPrefix = "permission-history:"
+
+// historyLock is the name of the lock serializing updates of permission
+// histories
+const historyLock = "permission-history"
+
+// DefaultHistoryRetention is how long changes of permissions are kept if the
+// retention is not configured
+const DefaultHistoryRetention = 90 * 24 * time.Hour
+
+// maxHistoryEntries is the number of the latest changes kept for each user,
+// older changes are dropped even before the retention period passes
+const maxHistoryEntries = 1000
+
+// Sources of changes of permissions
+const (
+	HistorySourceSync  = "sync"
+	HistorySourceEvent = "event"
+)
+
+// Kinds of changes of permissions
+const (
+	HistoryGranted = "granted"
+	HistoryRevoked = "revoked"
+)
+
+// HistoryEntry is a change of a permission served to a user, caused by a
+// change of members of groups
+type HistoryEntry struct {
+	Time        time.Time `json:"time"`
+	Service     string    `json:"service"`
+	Environment string    `json:"environment,omitempty"`
+	Permission  string    `json:"permission"`
+	// Change is granted or revoked
+	Change string `json:"change"`
+	// Group whose members changed, empty if several groups changed the
+	// permission together
+	Group string `json:"group,omitempty"`
+	// Source is sync for changes found by groups syncs, or event for changes
+	// delivered by the provider
+	Source string `json:"source"`
+}
+
+// memberChange is a user added to or removed from a group
+type memberChange struct {
+	email string
+	group string
+	added bool
+}
+
+// diffMembers returns users added to and removed from a group
+func diffMembers(group string, previous, current map[string]bool) []memberChange {
+	var changes []memberChange
+	for email := range current {
+		if !previous[email] {
+			changes = append(changes, memberChange{email, group, true})
+		}
+	}
+	for email := range previous {
+		if !current[email] {
+			changes = append(changes, memberChange{email, group, false})
+		}
+	}
+	return changes
+}
+
+// groupsState is the groups and their members before or after changes of
+// members, permissions of users are compared between two states
+type groupsState struct {
+	groups      []Group
+	memberships membershipsReader
+}
+
+// inGroup returns a function reporting whether the user of the email is a
+// member of a group of the state
+func (s groupsState) inGroup(email string) func(group string) (bool, error) {
+	return func(group string) (bool, error) {
+		for _, g := range s.groups {
+			if !strings.EqualFold(g.Name, group) {
+				continue
+			}
+			service, permission, ok := parseGroupName(g.Name)
+			if !ok {
+				return false, nil
+			}
+			members, err := s.memberships(service)
+			if err != nil {
+				return false, err
+			}
+			return members[permission][email], nil
+		}
+		return false, nil
+	}
+}
+
+// storedMemberships returns a reader of group memberships stored by get, each
+// service is read only once
+func storedMemberships(get func(key string, value interface{}) error) membershipsReader {
+	read := make(map[string]map[string]map[string]bool)
+	return func(service string) (map[string]map[string]bool, error) {
+		if memberships, ok := read[service]; ok {
+			return memberships, nil
+		}
+		memberships := make(map[string]map[string]bool)
+		if err := get(groupMembershipPrefix+service, &memberships); err != nil && err != storage.ErrNotFound {
+			return nil, err
+		}
+		read[service] = memberships
+		return memberships, nil
+	}
+}
+
+// affectedService is a service in which permissions of a user may have changed
+type affectedService struct {
+	// environments are evaluated, an empty one stands for environments without
+	// groups scoped to them
+	environments map[string]bool
+	// groups are the changed groups of the service
+	groups []string
+}
+
+// affectedServices returns the services in which permissions of users may
+// have changed, by email and service. Changes of unscoped groups affect all
+// environments of the service, and groups scoped to an environment added or
+// removed affect the members of the unscoped group they override.
+func affectedServices(changes []memberChange, before, after groupsState) (map[string]map[string]*affectedService, error) {
+	environments := serviceEnvironments(before.groups, after.groups)
+	affected := make(map[string]map[string]*affectedService)
+	add := func(email, group, service, environment string) {
+		if affected[email] == nil {
+			affected[email] = make(map[string]*affectedService)
+		}
+		entry := affected[email][service]
+		if entry == nil {
+			entry = &affectedService{environments: make(map[string]bool)}
+			affected[email][service] = entry
+		}
+		entry.environments[environment] = true
+		entry.groups = append(entry.groups, group)
+		if environment == "" {
+			for scoped := range environments[service] {
+				entry.environments[scoped] = true
+			}
+		}
+	}
+
+	for _, change := range changes {
+		if service, _, ok := parseGroupName(change.group); ok {
+			service, environment := splitService(service)
+			add(change.email, change.group, service, environment)
+		}
+	}
+	for _, group := range scopedGroupsChanged(before.groups, after.groups) {
+		service, permission, _ := parseGroupName(group)
+		service, environment := splitService(service)
+		for _, state := range []groupsState{before, after} {
+			members, err := state.memberships(service)
+			if err != nil {
+				return nil, err
+			}
+			for email := range members[permission] {
+				add(email, group, service, environment)
+			}
+		}
+	}
+	return affected, nil
+}
+
+// serviceEnvironments returns the environments with groups scoped to them by
+// service
+func serviceEnvironments(groupLists ...[]Group) map[string]map[string]bool {
+	environments := make(map[string]map[string]bool)
+	for _, groups := range groupLists {
+		for service := range servicePermissions(groups) {
+			service, environment := splitService(service)
+			if environment == "" {
+				continue
+			}
+			if environments[service] == nil {
+				environments[service] = make(map[string]bool)
+			}
+			environments[service][environment] = true
+		}
+	}
+	return environments
+}
+
+// scopedGroupsChanged returns the names of groups scoped to an environment
+// which are only in one of previous and current
+func scopedGroupsChanged(previous, current []Group) []string {
+	names := make(map[string]int)
+	for _, group := range previous {
+		names[group.Name]++
+	}
+	for _, group := range current {
+		names[group.Name]--
+	}
+
+	var changed []string
+	for name, count := range names {
+		service, _, ok := parseGroupName(name)
+		if _, environment := splitService(service); ok && environment != "" && count != 0 {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// permissionChanges returns changes of the permissions users are served, by
+// email, caused by changes of members of groups between the states. Grants,
+// rules, roles and deny entries are applied, only permissions which were
+// actually granted or revoked are returned. Rules are matched against cached
+// users, users aren't fetched from the provider. Errors are only reported, as
+// the history is not needed to serve permissions.
+func (d *Directory) permissionChanges(changes []memberChange, before, after groupsState, source string, at time.Time) map[string][]HistoryEntry {
+	entries := make(map[string][]HistoryEntry)
+	affected, err := affectedServices(changes, before, after)
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		return entries
+	}
+
+	for email, services := range affected {
+		user := d.historyUser(email)
+		for service, scope := range services {
+			for environment := range scope.environments {
+				previous, err := d.effectivePermissions(user, service, environment, before)
+				if err != nil {
+					log.Println("[ERROR]", err.Error())
+					raven.CaptureError(err, nil)
+					continue
+				}
+				current, err := d.effectivePermissions(user, service, environment, after)
+				if err != nil {
+					log.Println("[ERROR]", err.Error())
+					raven.CaptureError(err, nil)
+					continue
+				}
+				key := strings.ToLower(email)
+				for _, entry := range diffPermissions(previous, current) {
+					entry.Time = at
+					entry.Service = strings.ToLower(service)
+					entry.Environment = strings.ToLower(environment)
+					entry.Group = changedGroup(scope.groups, service, environment, entry.Permission)
+					entry.Source = source
+					entries[key] = append(entries[key], entry)
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// historyUser returns the cached user of the email, or a user with just the
+// email if it's not cached
+func (d *Directory) historyUser(email string) User {
+	var user User
+	if err := d.cache.Get(email, &user); err != nil || user.Email == "" {
+		if err != nil && err != storage.ErrNotFound {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+		return User{Email: email}
+	}
+	// Members of groups are keyed by the email of the membership
+	user.Email = email
+	return user
+}
+
+// effectivePermissions returns the permissions the user is served for the
+// service in the environment with the groups of the state
+func (d *Directory) effectivePermissions(user User, service, environment string, state groupsState) (map[string]bool, error) {
+	if err := d.addPermissions(&user, service, environment, state.memberships, state.inGroup(user.Email)); err != nil {
+		return nil, err
+	}
+	permissions := make(map[string]bool, len(user.Permissions))
+	for _, permission := range user.Permissions {
+		permissions[permission] = true
+	}
+	return permissions, nil
+}
+
+// diffPermissions returns entries of permissions granted and revoked between
+// the sets, sorted by permission
+func diffPermissions(previous, current map[string]bool) []HistoryEntry {
+	var entries []HistoryEntry
+	for permission := range current {
+		if !previous[permission] {
+			entries = append(entries, HistoryEntry{Permission: permission, Change: HistoryGranted})
+		}
+	}
+	for permission := range previous {
+		if !current[permission] {
+			entries = append(entries, HistoryEntry{Permission: permission, Change: HistoryRevoked})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Permission < entries[j].Permission })
+	return entries
+}
+
+// changedGroup returns the changed group named after the permission, or the
+// only changed group if none is. It's empty if several groups changed the
+// permission together, ie. through roles.
+func changedGroup(groups []string, service, environment, permission string) string {
+	names := []string{
+		GroupPrefix + ScopedService(service, environment) + "." + permission,
+		GroupPrefix + service + "." + permission,
+	}
+	for _, name := range names {
+		for _, group := range groups {
+			if strings.EqualFold(group, name) {
+				return group
+			}
+		}
+	}
+
+	for _, group := range groups[1:] {
+		if group != groups[0] {
+			return ""
+		}
+	}
+	return groups[0]
+}
+
+// recordHistory adds changes of permissions to the histories of the users and
+// notifies webhooks of the changed services. Histories are updated under a
+// lock, so that changes recorded by events and syncs at the same time aren't
+// lost. Errors are only reported, as the history is not needed to serve
+// permissions.
+func (d *Directory) recordHistory(entries map[string][]HistoryEntry, source string, at time.Time) {
+	if len(entries) == 0 {
+		return
+	}
+
+	d.notifyPermissionChanges(entries, at)
+
+	d.lock.Acquire(historyLock)
+	defer d.lock.Delete(historyLock)
+
+	pairs := make(map[string]interface{}, len(entries))
+	for email, userEntries := range entries {
+		history, err := d.getHistory(email)
+		if err != nil {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+			continue
+		}
+		pairs[historyPrefix+email] = d.pruneHistory(append(history, userEntries...), at)
+	}
+
+	if err := d.cache.MSet(pairs, d.historyRetention); err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+		return
+	}
+	d.metrics.Incr("permission_history", d.providerTag(), monitoring.Tag("source", source))
+}
+
+// pruneHistory drops entries older than the retention period and the oldest
+// entries above maxHistoryEntries
+func (d *Directory) pruneHistory(history []HistoryEntry, now time.Time) []HistoryEntry {
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Time.Before(history[j].Time) })
+
+	start := 0
+	for start < len(history) && now.Sub(history[start].Time) > d.historyRetention {
+		start++
+	}
+	if len(history)-start > maxHistoryEntries {
+		start = len(history) - maxHistoryEntries
+	}
+	return history[start:]
+}
+
+// getHistory returns all entries of the permission history of a user
+func (d *Directory) getHistory(email string) ([]HistoryEntry, error) {
+	var history []HistoryEntry
+	err := d.cache.Get(historyPrefix+strings.ToLower(email), &history)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	}
+	return history, err
+}
+
+// History returns changes of permissions of a user within the retention
+// period, oldest first. Changes are filtered by service and by the permission
+// they imply if they're not empty, ie. changes of orders.refund.* are returned
+// for orders.refund.approve.
+func (d *Directory) History(email, service, permission string) ([]HistoryEntry, error) {
+	history, err := d.getHistory(email)
+	if err != nil {
+		return nil, err
+	}
+
+	history = d.pruneHistory(history, time.Now())
+	filtered := make([]HistoryEntry, 0, len(history))
+	for _, entry := range history {
+		if service != "" && !strings.EqualFold(entry.Service, service) {
+			continue
+		}
+		if permission != "" && !MatchPermission(entry.Permission, permission) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered, nil
+}
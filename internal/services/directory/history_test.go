@@ -0,0 +1,220 @@
+package directory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncGroupsHistory(t *testing.T) {
+	provider := newFailingProvider()
+	provider.SetGroup(Group{ID: "g1", Name: "iam-service.read"})
+	provider.SetGroup(Group{ID: "g2", Name: "iam-service@sandbox.write"})
+	provider.AddMember("g1", "user@kiwi.com")
+	d, _ := newTestDirectory(provider)
+
+	d.SyncGroups()
+
+	history, err := d.History("user@kiwi.com", "", "")
+	require.NoError(t, err)
+	assert.Empty(t, history, "The first sync has no previous members to diff")
+
+	provider.AddMember("g2", "user@kiwi.com")
+	provider.DeleteGroup("g1")
+	d.SyncGroups()
+
+	history, err = d.History("User@kiwi.com", "", "")
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	for _, entry := range history {
+		assert.Equal(t, HistorySourceSync, entry.Source)
+		assert.False(t, entry.Time.IsZero())
+		entry.Time = time.Time{}
+		switch entry.Group {
+		case "iam-service.read":
+			assert.Equal(t, HistoryEntry{
+				Service:     "service",
+				Environment: entry.Environment,
+				Permission:  "read",
+				Change:      HistoryRevoked,
+				Group:       "iam-service.read",
+				Source:      HistorySourceSync,
+			}, entry, "Members of removed groups lose their permissions in all environments")
+			assert.Contains(t, []string{"", "sandbox"}, entry.Environment)
+		default:
+			assert.Equal(t, HistoryEntry{
+				Service:     "service",
+				Environment: "sandbox",
+				Permission:  "write",
+				Change:      HistoryGranted,
+				Group:       "iam-service@sandbox.write",
+				Source:      HistorySourceSync,
+			}, entry)
+		}
+	}
+}
+
+func TestApplyChangesHistory(t *testing.T) {
+	d, _ := newTestDirectory(NewMemoryProvider())
+	publishMemberships(t, d, "service", map[string]map[string]bool{})
+
+	added := membershipChange("1", ChangeMembershipAdded, "user@kiwi.com", "iam-service.write")
+	added.Time = time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	require.NoError(t, d.ApplyChanges([]Change{
+		added,
+		membershipChange("2", ChangeMembershipAdded, "user@kiwi.com", "iam-service.write"),
+		membershipChange("3", ChangeMembershipRemoved, "user@kiwi.com", "iam-service.write"),
+	}))
+
+	_, err := d.CreateDeny(Deny{Service: "service", Permission: "admin", Email: "user@kiwi.com", Reason: "INC-1"}, "admin-tool")
+	require.NoError(t, err)
+	require.NoError(t, d.ApplyChanges([]Change{
+		membershipChange("4", ChangeMembershipAdded, "user@kiwi.com", "iam-service.admin"),
+	}))
+
+	history, err := d.getHistory("user@kiwi.com")
+	require.NoError(t, err)
+	require.Len(t, history, 2, "Changes which don't change members or permissions are not recorded")
+	assert.Equal(t, added.Time, history[0].Time.UTC())
+	assert.Equal(t, HistoryGranted, history[0].Change)
+	assert.Equal(t, HistoryRevoked, history[1].Change)
+	assert.Equal(t, HistorySourceEvent, history[1].Source)
+}
+
+func TestSyncGroupsHistoryEffectivePermissions(t *testing.T) {
+	provider := newFailingProvider()
+	for id, name := range map[string]string{
+		"g1": "iam-service.orders.read",
+		"g2": "iam-service.role.viewer",
+		"g3": "iam-service.orders.write",
+		"g4": "iam-service.orders.admin",
+		"g5": "iam-service@sandbox.orders.cancel",
+		"g6": "iam-service.orders.cancel",
+	} {
+		provider.SetGroup(Group{ID: id, Name: name})
+	}
+	provider.AddMember("g1", "user@kiwi.com")
+	provider.SetUser(User{Email: "user@kiwi.com", Status: StatusActive})
+	d, _ := newTestDirectory(provider)
+	roles, err := ParseRoles([]byte(testRoles))
+	require.NoError(t, err)
+	d.roles = roles
+	d.SyncGroups()
+
+	grant := testGrant(time.Hour)
+	grant.Email = "user@kiwi.com"
+	grant.Permission = "orders.write"
+	_, err = d.CreateGrant(grant, "admin-tool")
+	require.NoError(t, err)
+	_, err = d.CreateDeny(Deny{Service: "service", Permission: "orders.admin", Email: "user@kiwi.com", Reason: "INC-1"}, "admin-tool")
+	require.NoError(t, err)
+
+	for _, id := range []string{"g2", "g3", "g4", "g6"} {
+		provider.AddMember(id, "user@kiwi.com")
+	}
+	d.SyncGroups()
+
+	history, err := d.History("user@kiwi.com", "", "")
+	require.NoError(t, err)
+	var changes []string
+	for _, entry := range history {
+		assert.Equal(t, HistoryGranted, entry.Change)
+		changes = append(changes, ScopedService(entry.Permission, entry.Environment))
+	}
+	assert.ElementsMatch(t, []string{"orders.cancel", "role.viewer", "role.viewer@sandbox"}, changes,
+		"Permissions granted by roles, grants or other groups and denied permissions don't change, "+
+			"permissions aren't granted in environments with a group scoped to them")
+
+	provider.DeleteGroup("g5")
+	d.SyncGroups()
+
+	history, err = d.History("user@kiwi.com", "", "")
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	history[3].Time = time.Time{}
+	assert.Equal(t, HistoryEntry{
+		Service:     "service",
+		Environment: "sandbox",
+		Permission:  "orders.cancel",
+		Change:      HistoryGranted,
+		Group:       "iam-service@sandbox.orders.cancel",
+		Source:      HistorySourceSync,
+	}, history[3], "Members of unscoped groups get permissions of removed scoped groups")
+}
+
+func TestHistoryLock(t *testing.T) {
+	d, _ := newTestDirectory(NewMemoryProvider())
+	now := time.Now()
+	require.NoError(t, d.lock.Create(historyLock))
+
+	recorded := make(chan bool)
+	go func() {
+		d.recordHistory(historyEntries("user@kiwi.com", HistoryEntry{Time: now, Service: "service", Permission: "read"}), HistorySourceEvent, now)
+		close(recorded)
+	}()
+
+	select {
+	case <-recorded:
+		t.Fatal("History is recorded while another instance holds the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	d.lock.Delete(historyLock)
+	<-recorded
+
+	history, err := d.History("user@kiwi.com", "", "")
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+}
+
+// historyEntries returns entries of a user as recorded by recordHistory
+func historyEntries(email string, entries ...HistoryEntry) map[string][]HistoryEntry {
+	return map[string][]HistoryEntry{email: entries}
+}
+
+func TestHistoryFilters(t *testing.T) {
+	d, _ := newTestDirectory(NewMemoryProvider())
+	now := time.Now()
+	d.recordHistory(historyEntries("user@kiwi.com",
+		HistoryEntry{Time: now, Service: "service", Permission: "orders.refund.*", Group: "iam-service.orders.refund.*"},
+		HistoryEntry{Time: now, Service: "service", Permission: "orders.read", Group: "iam-service.orders.read"},
+		HistoryEntry{Time: now, Service: "other", Permission: "orders.refund.approve", Group: "iam-other.orders.refund.approve"},
+	), HistorySourceSync, now)
+
+	history, err := d.History("user@kiwi.com", "Service", "orders.refund.approve")
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, "iam-service.orders.refund.*", history[0].Group)
+
+	history, err = d.History("nobody@kiwi.com", "", "")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestHistoryRetention(t *testing.T) {
+	d, _ := newTestDirectory(NewMemoryProvider())
+	d.historyRetention = time.Hour
+	now := time.Now()
+
+	entry := func(at time.Time, change string) map[string][]HistoryEntry {
+		return historyEntries("user@kiwi.com", HistoryEntry{Time: at, Service: "service", Permission: "read", Change: change})
+	}
+	d.recordHistory(entry(now.Add(-2*time.Hour), HistoryGranted), HistorySourceSync, now.Add(-2*time.Hour))
+	d.recordHistory(entry(now, HistoryRevoked), HistorySourceSync, now)
+
+	history, err := d.History("user@kiwi.com", "", "")
+	require.NoError(t, err)
+	require.Len(t, history, 1, "Changes older than the retention are dropped")
+	assert.Equal(t, HistoryRevoked, history[0].Change)
+
+	entries := make([]HistoryEntry, maxHistoryEntries+1)
+	for i := range entries {
+		entries[i] = HistoryEntry{Time: now, Service: "service", Permission: "read", Change: HistoryGranted}
+	}
+	d.recordHistory(historyEntries("user@kiwi.com", entries...), HistorySourceSync, now)
+
+	history, err = d.History("user@kiwi.com", "", "")
+	require.NoError(t, err)
+	assert.Len(t, history, maxHistoryEntries, "Only the latest changes are kept")
+}
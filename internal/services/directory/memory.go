@@ -0,0 +1,263 @@
+package directory
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMemoryPageSize is the number of items in pages listed by
+// MemoryProvider by default
+const defaultMemoryPageSize = 100
+
+// MemoryProvider is a Provider keeping users and groups in memory, meant for
+// tests and local development. Changes made to it are listed by ListChanges.
+type MemoryProvider struct {
+	// PageSize is the number of users and changes listed in a page
+	PageSize int
+
+	// instance prefixes IDs of changes, so that they don't collide with changes
+	// of other instances remembered in cache as applied
+	instance string
+
+	mu      sync.Mutex
+	users   map[string]User
+	updated map[string]time.Time
+	groups  map[string]Group
+	members map[string]map[string]bool
+	changes []Change
+}
+
+// NewMemoryProvider creates an empty MemoryProvider
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{
+		instance: strconv.FormatInt(time.Now().UnixNano(), 36),
+		users:    make(map[string]User),
+		updated:  make(map[string]time.Time),
+		groups:   make(map[string]Group),
+		members:  make(map[string]map[string]bool),
+	}
+}
+
+// SetUser creates or updates a user.
+func (p *MemoryProvider) SetUser(user User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	email := strings.ToLower(user.Email)
+	previous, exists := p.users[email]
+	p.users[email] = user
+	now := time.Now().UTC()
+	p.updated[email] = now
+
+	if exists && previous.Status != user.Status {
+		p.addChange(Change{Type: ChangeUserStatus, Email: user.Email, Status: user.Status}, now)
+	} else if exists {
+		p.addChange(Change{Type: ChangeUserUpdated, Email: user.Email}, now)
+	}
+}
+
+// DeleteUser removes a user and its group memberships.
+func (p *MemoryProvider) DeleteUser(email string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	email = strings.ToLower(email)
+	delete(p.users, email)
+	delete(p.updated, email)
+	for _, members := range p.members {
+		delete(members, email)
+	}
+	p.addChange(Change{Type: ChangeUserDeleted, Email: email}, time.Now().UTC())
+}
+
+// SetGroup creates or renames a group.
+func (p *MemoryProvider) SetGroup(group Group) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if previous, ok := p.groups[group.ID]; ok && group.LastMembershipUpdated.IsZero() {
+		group.LastMembershipUpdated = previous.LastMembershipUpdated
+	}
+	p.groups[group.ID] = group
+	if p.members[group.ID] == nil {
+		p.members[group.ID] = make(map[string]bool)
+	}
+}
+
+// DeleteGroup removes a group.
+func (p *MemoryProvider) DeleteGroup(groupID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.groups, groupID)
+	delete(p.members, groupID)
+}
+
+// AddMember adds a user to a group, the group has to exist.
+func (p *MemoryProvider) AddMember(groupID, email string) {
+	p.setMember(groupID, email, true)
+}
+
+// RemoveMember removes a user from a group.
+func (p *MemoryProvider) RemoveMember(groupID, email string) {
+	p.setMember(groupID, email, false)
+}
+
+func (p *MemoryProvider) setMember(groupID, email string, member bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	group, ok := p.groups[groupID]
+	if !ok {
+		return
+	}
+	email = strings.ToLower(email)
+	changeType := ChangeMembershipRemoved
+	if member {
+		p.members[groupID][email] = true
+		changeType = ChangeMembershipAdded
+	} else {
+		delete(p.members[groupID], email)
+	}
+
+	now := time.Now().UTC()
+	group.LastMembershipUpdated = now
+	p.groups[groupID] = group
+	p.addChange(Change{Type: changeType, Email: email, Group: group}, now)
+}
+
+func (p *MemoryProvider) addChange(change Change, now time.Time) {
+	change.ID = p.instance + "-" + strconv.Itoa(len(p.changes)+1)
+	change.Time = now
+	p.changes = append(p.changes, change)
+}
+
+// GetUser implements Provider
+func (p *MemoryProvider) GetUser(email string) (User, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	user, ok := p.users[strings.ToLower(email)]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// ListUsers implements Provider, users are listed by email.
+func (p *MemoryProvider) ListUsers(since time.Time, cursor string) ([]User, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	emails := make([]string, 0, len(p.users))
+	for email := range p.users {
+		if since.IsZero() || p.updated[email].After(since) {
+			emails = append(emails, email)
+		}
+	}
+	sort.Strings(emails)
+
+	start, end, next, err := p.page(len(emails), cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	users := make([]User, 0, end-start)
+	for _, email := range emails[start:end] {
+		users = append(users, p.users[email])
+	}
+	return users, next, nil
+}
+
+// ListGroups implements Provider, groups are listed by name.
+func (p *MemoryProvider) ListGroups() ([]Group, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	groups := make([]Group, 0, len(p.groups))
+	for _, group := range p.groups {
+		if strings.HasPrefix(group.Name, GroupPrefix) {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// ListGroupMembers implements Provider
+func (p *MemoryProvider) ListGroupMembers(group Group) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	emails := make([]string, 0, len(p.members[group.ID]))
+	for email := range p.members[group.ID] {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	return emails, nil
+}
+
+// ListUserGroups implements Provider
+func (p *MemoryProvider) ListUserGroups(user User) ([]Group, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	groups := make([]Group, 0)
+	email := strings.ToLower(user.Email)
+	for id, members := range p.members {
+		if members[email] && strings.HasPrefix(p.groups[id].Name, GroupPrefix) {
+			groups = append(groups, p.groups[id])
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// ListChanges implements Provider, it lists changes made to the provider.
+func (p *MemoryProvider) ListChanges(since time.Time, cursor string) ([]Change, time.Time, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var changes []Change
+	for _, change := range p.changes {
+		if !change.Time.Before(since) {
+			changes = append(changes, change)
+		}
+	}
+
+	start, end, next, err := p.page(len(changes), cursor)
+	if err != nil {
+		return nil, time.Time{}, "", err
+	}
+
+	var until time.Time
+	if end > start {
+		until = changes[end-1].Time
+	}
+	return changes[start:end], until, next, nil
+}
+
+// page returns the bounds of the page at cursor, which is the offset of the
+// page, and the cursor of the next page.
+func (p *MemoryProvider) page(length int, cursor string) (start, end int, next string, err error) {
+	if cursor != "" {
+		if start, err = strconv.Atoi(cursor); err != nil {
+			return 0, 0, "", err
+		}
+	}
+	if start > length {
+		start = length
+	}
+
+	pageSize := p.PageSize
+	if pageSize < 1 {
+		pageSize = defaultMemoryPageSize
+	}
+	end = start + pageSize
+	if end >= length {
+		return start, length, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
@@ -0,0 +1,116 @@
+package directory
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// PermissionWildcard is a segment of granted permissions matching any segment
+const PermissionWildcard = "*"
+
+// MatchPermission reports whether a grant implies a permission. Permissions are
+// hierarchical, dotted names: a grant implies itself and its descendants, ie.
+// orders implies orders.refund.approve. A * segment matches any segment, a
+// trailing * matches any descendants, ie. orders.*.approve implies
+// orders.refund.approve, and orders.* implies orders.refund but not orders.
+func MatchPermission(grant, permission string) bool {
+	if grant == "" || permission == "" {
+		return false
+	}
+
+	grantSegments := strings.Split(grant, ".")
+	permissionSegments := strings.Split(permission, ".")
+	for i, segment := range grantSegments {
+		if i >= len(permissionSegments) {
+			return false
+		}
+		if segment == PermissionWildcard && i == len(grantSegments)-1 {
+			return true
+		}
+		if segment != PermissionWildcard && segment != permissionSegments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// HasPermission reports whether any of the grants implies the permission
+func HasPermission(grants []string, permission string) bool {
+	for _, grant := range grants {
+		if MatchPermission(grant, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandPermissions returns the effective permission set of grants: the grants
+// and the known permissions they imply, sorted and without duplicates.
+func ExpandPermissions(grants, known []string) []string {
+	expanded := make(map[string]bool, len(grants))
+	for _, grant := range grants {
+		expanded[grant] = true
+	}
+	for _, permission := range known {
+		if !expanded[permission] && HasPermission(grants, permission) {
+			expanded[permission] = true
+		}
+	}
+
+	permissions := make([]string, 0, len(expanded))
+	for permission := range expanded {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// HasPermission reports whether the permissions of the user, added by
+// AddPermissions, imply the permission and it's not denied to the user.
+func (u *User) HasPermission(permission string) bool {
+	return HasPermission(u.Permissions, permission) && !HasPermission(u.DeniedPermissions, permission)
+}
+
+// ServicePermissions returns the permissions of a service granted by the groups
+// cached by the last groups sync. Wildcards and roles are left out. Groups
+// scoped to the environment of a service scoped by ScopedService are included.
+func (d *Directory) ServicePermissions(service string) ([]string, error) {
+	groups, err := d.GetGroups()
+	if err == storage.ErrNotFound {
+		// Groups were not synced yet, no permissions are known
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	unscoped, _ := splitService(service)
+	var permissions []string
+	for _, group := range groups {
+		groupService, permission, ok := parseGroupName(group.Name)
+		if ok && (strings.EqualFold(groupService, service) || strings.EqualFold(groupService, unscoped)) &&
+			!strings.Contains(permission, PermissionWildcard) && !strings.HasPrefix(permission, RolePrefix) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, nil
+}
+
+// ExpandPermissions replaces the permissions of the user, added by
+// AddPermissions, with their effective permission set, so that callers don't
+// need to match hierarchical and wildcard grants themselves. Denied permissions
+// are left out of the set.
+func (d *Directory) ExpandPermissions(user *User, service string) error {
+	if len(user.Permissions) == 0 {
+		return nil
+	}
+
+	known, err := d.ServicePermissions(service)
+	if err != nil {
+		return err
+	}
+	user.Permissions = removeDenied(ExpandPermissions(user.Permissions, known), user.DeniedPermissions)
+	return nil
+}
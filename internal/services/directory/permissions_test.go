@@ -0,0 +1,69 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchPermission(t *testing.T) {
+	matches := map[[2]string]bool{
+		{"orders", "orders"}:                          true,
+		{"orders", "orders.refund.approve"}:           true,
+		{"orders", "ordersv2"}:                        false,
+		{"orders.refund", "orders"}:                   false,
+		{"orders.*", "orders.refund"}:                 true,
+		{"orders.*", "orders.refund.approve"}:         true,
+		{"orders.*", "orders"}:                        false,
+		{"orders.*.approve", "orders.refund.approve"}: true,
+		{"orders.*.approve", "orders.refund.reject"}:  false,
+		{"*", "orders.refund"}:                        true,
+		{"", "orders"}:                                false,
+		{"orders", ""}:                                false,
+	}
+	for grantAndPermission, expected := range matches {
+		assert.Equal(t, expected, MatchPermission(grantAndPermission[0], grantAndPermission[1]), grantAndPermission)
+	}
+
+	user := User{Permissions: []string{"read", "orders.*"}}
+	assert.True(t, user.HasPermission("orders.refund"))
+	assert.False(t, user.HasPermission("write"))
+}
+
+func TestExpandPermissions(t *testing.T) {
+	known := []string{"orders", "orders.refund", "orders.refund.approve", "users.read", "users.write"}
+	assert.Equal(t, []string{"orders.*", "orders.refund", "orders.refund.approve", "users.read"},
+		ExpandPermissions([]string{"orders.*", "users.read"}, known))
+	assert.Equal(t, []string{"orders.refund", "orders.refund.approve", "unknown"},
+		ExpandPermissions([]string{"unknown", "orders.refund"}, known), "Grants which are not known are kept")
+	assert.Empty(t, ExpandPermissions(nil, known))
+}
+
+func TestDirectoryExpandPermissions(t *testing.T) {
+	provider := NewMemoryProvider()
+	for id, name := range map[string]string{
+		"g1": "iam-service.orders.*",
+		"g2": "iam-service.orders.refund",
+		"g3": "iam-service.orders.refund.approve",
+		"g4": "iam-other.orders.cancel",
+	} {
+		provider.SetGroup(Group{ID: id, Name: name})
+	}
+	provider.SetUser(User{Email: "user@kiwi.com", Status: StatusActive})
+	provider.AddMember("g1", "user@kiwi.com")
+	d, _ := newTestDirectory(provider)
+
+	user := User{Email: "user@kiwi.com", Status: StatusActive}
+	require.NoError(t, d.ExpandPermissions(&user, "service"), "Nothing is expanded before groups are synced")
+
+	d.SyncGroups()
+	permissions, err := d.ServicePermissions("service")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"orders.refund", "orders.refund.approve"}, permissions, "Wildcards are not permissions")
+
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Equal(t, []string{"orders.*"}, user.Permissions, "Wildcard groups are granted")
+	require.NoError(t, d.ExpandPermissions(&user, "service"))
+	assert.Equal(t, []string{"orders.*", "orders.refund", "orders.refund.approve"}, user.Permissions)
+}
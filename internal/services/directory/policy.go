@@ -0,0 +1,303 @@
+package directory
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// PolicyVersion is the version of the format of policy files
+const PolicyVersion = 1
+
+// attributesPrefix is the prefix of rule attributes read from the mapped
+// profile attributes of a user, ie. attributes.costCenter
+const attributesPrefix = "attributes."
+
+// userAttributes returns the values of attributes of a user matched by rules,
+// named as in the JSON representation of the user. Attributes with multiple
+// values match a condition if any of their values matches.
+var userAttributes = map[string]func(u *User) []string{
+	"email":               func(u *User) []string { return []string{u.Email} },
+	"employeeNumber":      func(u *User) []string { return []string{u.EmployeeNumber} },
+	"position":            func(u *User) []string { return []string{u.Position} },
+	"department":          func(u *User) []string { return []string{u.Department} },
+	"location":            func(u *User) []string { return []string{u.Location} },
+	"isVendor":            func(u *User) []string { return []string{strconv.FormatBool(u.IsVendor)} },
+	"teamMembership":      func(u *User) []string { return u.TeamMembership },
+	"orgStructure":        func(u *User) []string { return []string{u.OrganizationStructure} },
+	"manager":             func(u *User) []string { return []string{u.Manager} },
+	"boocsek.site":        func(u *User) []string { return []string{u.BoocsekAttributes.Site} },
+	"boocsek.position":    func(u *User) []string { return []string{u.BoocsekAttributes.Position} },
+	"boocsek.channel":     func(u *User) []string { return []string{u.BoocsekAttributes.Channel} },
+	"boocsek.tier":        func(u *User) []string { return []string{u.BoocsekAttributes.Tier} },
+	"boocsek.team":        func(u *User) []string { return []string{u.BoocsekAttributes.Team} },
+	"boocsek.teamManager": func(u *User) []string { return []string{u.BoocsekAttributes.TeamManager} },
+	"boocsek.staff":       func(u *User) []string { return []string{u.BoocsekAttributes.Staff} },
+	"boocsek.state":       func(u *User) []string { return []string{u.BoocsekAttributes.State} },
+	"boocsek.substate":    func(u *User) []string { return []string{u.BoocsekAttributes.Substate} },
+	"boocsek.skills":      func(u *User) []string { return u.BoocsekAttributes.Skills },
+	"boocsek.kiwibaseId": func(u *User) []string {
+		return []string{strconv.Itoa(int(u.BoocsekAttributes.KiwibaseID))}
+	},
+}
+
+// attributeValues returns the values of an attribute of a user
+func attributeValues(u *User, attribute string) []string {
+	if strings.HasPrefix(attribute, attributesPrefix) {
+		value, ok := u.Attributes[strings.TrimPrefix(attribute, attributesPrefix)]
+		if !ok || value == nil {
+			return nil
+		}
+		if values, ok := value.([]interface{}); ok {
+			formatted := make([]string, 0, len(values))
+			for _, v := range values {
+				formatted = append(formatted, fmt.Sprint(v))
+			}
+			return formatted
+		}
+		return []string{fmt.Sprint(value)}
+	}
+	return userAttributes[attribute](u)
+}
+
+// Condition is a predicate on an attribute of a user. Exactly one of Equals,
+// In and Matches is set.
+type Condition struct {
+	Attribute string   `yaml:"attribute" json:"attribute"`
+	Equals    *string  `yaml:"equals" json:"equals,omitempty"`
+	In        []string `yaml:"in" json:"in,omitempty"`
+	// Matches is a regular expression, it has to match the whole value
+	Matches string `yaml:"matches" json:"matches,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// match reports whether any value of the attribute satisfies the condition
+func (c *Condition) match(u *User) bool {
+	for _, value := range attributeValues(u, c.Attribute) {
+		switch {
+		case c.Equals != nil:
+			if value == *c.Equals {
+				return true
+			}
+		case c.pattern != nil:
+			if c.pattern.MatchString(value) {
+				return true
+			}
+		default:
+			for _, v := range c.In {
+				if value == v {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Rule grants permissions to users matching all of its conditions
+type Rule struct {
+	Name        string      `yaml:"name" json:"name"`
+	Description string      `yaml:"description" json:"description,omitempty"`
+	Permissions []string    `yaml:"permissions" json:"permissions"`
+	When        []Condition `yaml:"when" json:"when"`
+}
+
+// match reports whether the user satisfies all conditions of the rule
+func (r *Rule) match(u *User) bool {
+	for i := range r.When {
+		if !r.When[i].match(u) {
+			return false
+		}
+	}
+	return true
+}
+
+// RuleResult is the result of evaluating a rule for a user
+type RuleResult struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+	Fired       bool     `json:"fired"`
+}
+
+// Policy contains rules granting permissions of a service based on attributes
+// of users. A policy file looks like:
+//
+//	version: 1
+//	rules:
+//	  - name: refunds
+//	    permissions: [orders.refund]
+//	    when:
+//	      - attribute: boocsek.team
+//	        equals: refunds
+//	      - attribute: boocsek.tier
+//	        in: ["2", "3"]
+//	      - attribute: boocsek.site
+//	        matches: "prague-.*"
+type Policy struct {
+	Version int    `yaml:"version"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// ParsePolicy parses the content of a YAML or JSON policy file
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, errors.New("invalid policy: " + err.Error())
+	}
+	if policy.Version != PolicyVersion {
+		return nil, errors.New("unsupported policy version " + strconv.Itoa(policy.Version))
+	}
+
+	names := make(map[string]bool, len(policy.Rules))
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.Name == "" {
+			return nil, errors.New("rule " + strconv.Itoa(i+1) + " has no name")
+		}
+		if names[rule.Name] {
+			return nil, errors.New("duplicate rule " + rule.Name)
+		}
+		names[rule.Name] = true
+
+		if len(rule.Permissions) == 0 {
+			return nil, errors.New("rule " + rule.Name + " has no permissions")
+		}
+		for _, permission := range rule.Permissions {
+			if permission == "" {
+				return nil, errors.New("rule " + rule.Name + " has an empty permission")
+			}
+		}
+		if len(rule.When) == 0 {
+			// Rules without conditions would grant permissions to everyone
+			return nil, errors.New("rule " + rule.Name + " has no conditions")
+		}
+		for j := range rule.When {
+			if err := rule.When[j].compile(); err != nil {
+				return nil, errors.New("rule " + rule.Name + ": " + err.Error())
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// compile validates the condition and compiles its regular expression
+func (c *Condition) compile() error {
+	_, known := userAttributes[c.Attribute]
+	if !known && (!strings.HasPrefix(c.Attribute, attributesPrefix) || c.Attribute == attributesPrefix) {
+		return errors.New("unknown attribute " + c.Attribute)
+	}
+
+	predicates := 0
+	if c.Equals != nil {
+		predicates++
+	}
+	if c.In != nil {
+		if len(c.In) == 0 {
+			return errors.New("condition on " + c.Attribute + " has no values")
+		}
+		predicates++
+	}
+	if c.Matches != "" {
+		predicates++
+		pattern, err := regexp.Compile("^(?:" + c.Matches + ")$")
+		if err != nil {
+			return errors.New("invalid pattern of " + c.Attribute + ": " + err.Error())
+		}
+		c.pattern = pattern
+	}
+	if predicates != 1 {
+		return errors.New("condition on " + c.Attribute + " needs exactly one of equals, in and matches")
+	}
+	return nil
+}
+
+// Evaluate evaluates all rules of the policy for the user
+func (p *Policy) Evaluate(u *User) []RuleResult {
+	results := make([]RuleResult, 0, len(p.Rules))
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		results = append(results, RuleResult{
+			Name:        rule.Name,
+			Description: rule.Description,
+			Permissions: rule.Permissions,
+			Fired:       rule.match(u),
+		})
+	}
+	return results
+}
+
+// grant adds the permissions granted to the user by the policy to permissions,
+// without duplicates
+func (p *Policy) grant(u *User, permissions []string) []string {
+	granted := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		granted[permission] = true
+	}
+	for i := range p.Rules {
+		if !p.Rules[i].match(u) {
+			continue
+		}
+		for _, permission := range p.Rules[i].Permissions {
+			if !granted[permission] {
+				granted[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions
+}
+
+// Policies holds the policies of services, by lowercase service name
+type Policies map[string]*Policy
+
+// LoadPolicies reads policy files from a directory, a file per service named
+// <service>.yaml, <service>.yml or <service>.json
+func LoadPolicies(dir string) (Policies, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make(Policies)
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		service := strings.ToLower(strings.TrimSuffix(file.Name(), ext))
+		if _, ok := policies[service]; ok {
+			return nil, errors.New("duplicate policy of service " + service)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		policy, err := ParsePolicy(data)
+		if err != nil {
+			return nil, errors.New(file.Name() + ": " + err.Error())
+		}
+		policies[service] = policy
+	}
+	return policies, nil
+}
+
+// EvaluateRules evaluates the rules of the policy of a service for the user
+// without granting any permissions, the result is empty if the service has no
+// policy.
+func (d *Directory) EvaluateRules(user *User, service string) []RuleResult {
+	service, _ = splitService(service)
+	policy, ok := d.policies[strings.ToLower(service)]
+	if !ok {
+		return []RuleResult{}
+	}
+	return policy.Evaluate(user)
+}
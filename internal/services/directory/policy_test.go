@@ -0,0 +1,149 @@
+package directory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `
+version: 1
+rules:
+  - name: refunds
+    description: Senior agents of refund teams
+    permissions: [orders.refund, role.support]
+    when:
+      - attribute: boocsek.team
+        matches: "refunds-.*"
+      - attribute: boocsek.tier
+        in: ["3", "4"]
+  - name: support
+    permissions: [orders.read]
+    when:
+      - attribute: department
+        equals: Customer Support
+  - name: skills
+    permissions: [orders.read, payments.read]
+    when:
+      - attribute: boocsek.skills
+        equals: payments
+  - name: cost-center
+    permissions: [reports]
+    when:
+      - attribute: attributes.costCenter
+        in: ["1234"]
+`
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+
+	user := User{
+		Department: "Customer Support",
+		BoocsekAttributes: BoocsekAttributes{
+			Team:   "refunds-prague",
+			Tier:   "3",
+			Skills: []string{"english", "payments"},
+		},
+		Attributes: map[string]interface{}{"costCenter": 1234},
+	}
+	fired := func() map[string]bool {
+		fired := make(map[string]bool)
+		for _, result := range policy.Evaluate(&user) {
+			fired[result.Name] = result.Fired
+		}
+		return fired
+	}
+	assert.Equal(t, map[string]bool{"refunds": true, "support": true, "skills": true, "cost-center": true}, fired())
+
+	user.BoocsekAttributes.Team = "refunds"
+	user.BoocsekAttributes.Skills = nil
+	user.Department = "customer support"
+	user.Attributes = nil
+	assert.Equal(t, map[string]bool{"refunds": false, "support": false, "skills": false, "cost-center": false}, fired(),
+		"Patterns match whole values and comparisons are case sensitive")
+
+	user.BoocsekAttributes.Team = "refunds-brno"
+	user.BoocsekAttributes.Tier = "1"
+	assert.False(t, fired()["refunds"], "All conditions have to match")
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	invalid := map[string]string{
+		"no version":        `rules: []`,
+		"unknown field":     `{version: 1, grants: []}`,
+		"no name":           `{version: 1, rules: [{permissions: [read], when: [{attribute: email, equals: a}]}]}`,
+		"duplicate name":    `{version: 1, rules: [{name: a, permissions: [read], when: [{attribute: email, equals: a}]}, {name: a, permissions: [read], when: [{attribute: email, equals: a}]}]}`,
+		"no permissions":    `{version: 1, rules: [{name: a, when: [{attribute: email, equals: a}]}]}`,
+		"no conditions":     `{version: 1, rules: [{name: a, permissions: [read]}]}`,
+		"unknown attribute": `{version: 1, rules: [{name: a, permissions: [read], when: [{attribute: team, equals: a}]}]}`,
+		"no predicate":      `{version: 1, rules: [{name: a, permissions: [read], when: [{attribute: email}]}]}`,
+		"two predicates":    `{version: 1, rules: [{name: a, permissions: [read], when: [{attribute: email, equals: a, matches: a}]}]}`,
+		"empty set":         `{version: 1, rules: [{name: a, permissions: [read], when: [{attribute: email, in: []}]}]}`,
+		"invalid pattern":   `{version: 1, rules: [{name: a, permissions: [read], when: [{attribute: email, matches: "("}]}]}`,
+	}
+	for name, content := range invalid {
+		_, err := ParsePolicy([]byte(content))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadPolicies(t *testing.T) {
+	policies, err := LoadPolicies("../../../.policies-sample")
+	require.NoError(t, err, "The sample policies are valid")
+	assert.Contains(t, policies, "kiwi-iam")
+
+	dir, err := ioutil.TempDir("", "policies")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Service.yaml"), []byte(testPolicy), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# Policies"), 0600))
+
+	policies, err = LoadPolicies(dir)
+	require.NoError(t, err)
+	assert.Len(t, policies, 1)
+	assert.Contains(t, policies, "service", "Services are named by lowercase file names")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte(`{"version": 2}`), 0600))
+	_, err = LoadPolicies(dir)
+	assert.Error(t, err)
+}
+
+func TestAddPermissionsPolicy(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+	roles, err := ParseRoles([]byte(testRoles))
+	require.NoError(t, err)
+
+	provider := NewMemoryProvider()
+	provider.SetGroup(Group{ID: "g1", Name: "iam-service.orders.read"})
+	provider.AddMember("g1", "user@kiwi.com")
+	provider.SetUser(User{Email: "user@kiwi.com", Status: StatusActive})
+	d, _ := newTestDirectory(provider)
+	d.roles = roles
+	d.policies = Policies{"service": policy}
+	d.SyncGroups()
+
+	user := User{
+		Email:             "user@kiwi.com",
+		Status:            StatusActive,
+		Department:        "Customer Support",
+		BoocsekAttributes: BoocsekAttributes{Team: "refunds-prague", Tier: "4"},
+	}
+	require.NoError(t, d.AddPermissions(&user, "Service"))
+	assert.ElementsMatch(t, []string{"orders.read", "orders.refund", "role.support", "orders.refund.*"}, user.Permissions,
+		"Rules add permissions and roles to those granted by groups")
+
+	require.NoError(t, d.AddPermissions(&user, "other"))
+	assert.Empty(t, user.Permissions)
+
+	user.Status = StatusSuspended
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Empty(t, user.Permissions, "Rules don't grant permissions to inactive users")
+	assert.Len(t, d.EvaluateRules(&user, "service"), 4)
+	assert.Empty(t, d.EvaluateRules(&user, "other"))
+}
@@ -0,0 +1,60 @@
+package directory
+
+import (
+	"time"
+)
+
+// Provider is a source of users and groups, ie. Okta. Directory caches what is
+// read from a provider and computes permissions of users from their groups.
+type Provider interface {
+	// GetUser returns a user by email, or ErrUserNotFound.
+	GetUser(email string) (User, error)
+	// ListUsers returns a page of users starting at cursor, or the first page
+	// for an empty cursor. Only users updated since the given time are listed,
+	// all of them for zero time. The returned cursor points to the next page,
+	// it's empty after the last page.
+	ListUsers(since time.Time, cursor string) ([]User, string, error)
+	// ListGroups returns all IAM groups.
+	ListGroups() ([]Group, error)
+	// ListGroupMembers returns emails of the members of a group.
+	ListGroupMembers(group Group) ([]string, error)
+	// ListUserGroups returns the IAM groups of a user.
+	ListUserGroups(user User) ([]Group, error)
+	// ListChanges returns a page of changes made since the given time, oldest
+	// first, and the time of the last event read for the page, including events
+	// which are not converted to changes. The time is zero if the page is
+	// empty. Pages are read like with ListUsers.
+	ListChanges(since time.Time, cursor string) ([]Change, time.Time, string, error)
+}
+
+// readinessChecker is implemented by providers which know when they are
+// unavailable.
+type readinessChecker interface {
+	Ready() error
+}
+
+// Types of changes
+const (
+	ChangeMembershipAdded   = "membership.added"
+	ChangeMembershipRemoved = "membership.removed"
+	// ChangeUserUpdated invalidates the cached user, it's fetched again when
+	// it's requested next time.
+	ChangeUserUpdated = "user.updated"
+	// ChangeUserStatus sets the status of the cached user
+	ChangeUserStatus  = "user.status"
+	ChangeUserDeleted = "user.deleted"
+)
+
+// Change is a change of a user or of a group membership. Changes are applied
+// to cache between syncs, so they show up sooner.
+type Change struct {
+	// ID is unique, changes delivered more than once are applied only once
+	ID    string    `json:"id"`
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Email string    `json:"email"`
+	// Group is set for membership changes
+	Group Group `json:"group"`
+	// Status is set for status changes
+	Status string `json:"status"`
+}
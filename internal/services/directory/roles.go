@@ -0,0 +1,197 @@
+package directory
+
+import (
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RolePrefix is the prefix of permissions granting roles, granted by groups
+// named iam-<service>.role.<name>
+const RolePrefix = "role."
+
+// RolesVersion is the version of the format of roles files
+const RolesVersion = 1
+
+var roleNamePattern = regexp.MustCompile(`^[\w-]+$`)
+
+// Role is a bundle of permissions of a service
+type Role struct {
+	Service     string   `json:"service"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+	// EffectivePermissions are the permissions implied by the role, with
+	// hierarchical and wildcard permissions expanded
+	EffectivePermissions []string `json:"effectivePermissions,omitempty"`
+}
+
+// Roles holds the roles of services declared in a roles file
+type Roles struct {
+	// Version of the roles file
+	Version  int
+	services map[string]map[string]*Role
+}
+
+// rolesFile is the content of a roles file:
+//
+//	version: 1
+//	services:
+//	  orders:
+//	    support:
+//	      description: Customer support
+//	      permissions: [orders.read, orders.refund.*]
+type rolesFile struct {
+	Version  int                                 `yaml:"version"`
+	Services map[string]map[string]rolesFileRole `yaml:"services"`
+}
+
+type rolesFileRole struct {
+	Description string   `yaml:"description"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// LoadRoles reads roles from a YAML or JSON roles file
+func LoadRoles(path string) (*Roles, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRoles(data)
+}
+
+// ParseRoles parses the content of a YAML or JSON roles file
+func ParseRoles(data []byte) (*Roles, error) {
+	var file rolesFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, errors.New("invalid roles: " + err.Error())
+	}
+	if file.Version != RolesVersion {
+		return nil, errors.New("unsupported roles version " + strconv.Itoa(file.Version))
+	}
+
+	roles := &Roles{Version: file.Version, services: make(map[string]map[string]*Role)}
+	for service, serviceRoles := range file.Services {
+		service = strings.ToLower(service)
+		if _, ok := roles.services[service]; ok {
+			return nil, errors.New("duplicate roles of service " + service)
+		}
+		roles.services[service] = make(map[string]*Role, len(serviceRoles))
+
+		for name, role := range serviceRoles {
+			if !roleNamePattern.MatchString(name) {
+				return nil, errors.New("invalid role name " + service + "." + name)
+			}
+			if len(role.Permissions) == 0 {
+				return nil, errors.New("role " + service + "." + name + " has no permissions")
+			}
+			for _, permission := range role.Permissions {
+				if permission == "" || strings.HasPrefix(permission, RolePrefix) {
+					return nil, errors.New("role " + service + "." + name + " has an invalid permission " + permission)
+				}
+			}
+			roles.services[service][name] = &Role{
+				Service:     service,
+				Name:        name,
+				Description: role.Description,
+				Permissions: role.Permissions,
+			}
+		}
+	}
+	return roles, nil
+}
+
+// List returns the roles of a service sorted by name, or of all services if
+// service is empty
+func (r *Roles) List(service string) []Role {
+	var roles []Role
+	for roleService, serviceRoles := range r.services {
+		if service != "" && roleService != strings.ToLower(service) {
+			continue
+		}
+		for _, role := range serviceRoles {
+			roles = append(roles, *role)
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		if roles[i].Service != roles[j].Service {
+			return roles[i].Service < roles[j].Service
+		}
+		return roles[i].Name < roles[j].Name
+	})
+	return roles
+}
+
+// permissions returns all permissions declared by roles of a service
+func (r *Roles) permissions(service string) []string {
+	var permissions []string
+	for _, role := range r.services[strings.ToLower(service)] {
+		permissions = append(permissions, role.Permissions...)
+	}
+	return permissions
+}
+
+// expand adds the permissions of roles granted by permissions of a service.
+// Granted roles are kept, roles which are not declared are left unexpanded.
+func (r *Roles) expand(service string, permissions []string) []string {
+	serviceRoles := r.services[strings.ToLower(service)]
+	if len(serviceRoles) == 0 {
+		return permissions
+	}
+
+	granted := make(map[string]bool, len(permissions))
+	expanded := make([]string, 0, len(permissions))
+	add := func(permission string) {
+		if !granted[permission] {
+			granted[permission] = true
+			expanded = append(expanded, permission)
+		}
+	}
+	for _, permission := range permissions {
+		add(permission)
+		if role, ok := serviceRoles[strings.TrimPrefix(permission, RolePrefix)]; ok && strings.HasPrefix(permission, RolePrefix) {
+			for _, rolePermission := range role.Permissions {
+				add(rolePermission)
+			}
+		}
+	}
+	return expanded
+}
+
+// ListRoles returns the roles of a service, or of all services if service is
+// empty, with their effective permissions. Wildcard and hierarchical
+// permissions are expanded to the permissions of the service granted by groups
+// or declared by roles.
+func (d *Directory) ListRoles(service string) ([]Role, error) {
+	if d.roles == nil {
+		return []Role{}, nil
+	}
+
+	roles := d.roles.List(service)
+	known := make(map[string][]string)
+	for i := range roles {
+		role := &roles[i]
+		if _, ok := known[role.Service]; !ok {
+			permissions, err := d.ServicePermissions(role.Service)
+			if err != nil {
+				return nil, err
+			}
+			for _, permission := range d.roles.permissions(role.Service) {
+				if !strings.Contains(permission, PermissionWildcard) {
+					permissions = append(permissions, permission)
+				}
+			}
+			known[role.Service] = permissions
+		}
+		role.EffectivePermissions = ExpandPermissions(role.Permissions, known[role.Service])
+	}
+	if roles == nil {
+		roles = []Role{}
+	}
+	return roles, nil
+}
@@ -0,0 +1,90 @@
+package directory
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRoles = `
+version: 1
+services:
+  Service:
+    support:
+      description: Customer support
+      permissions: [orders.read, orders.refund.*]
+    viewer:
+      permissions: [orders.read]
+`
+
+func TestParseRoles(t *testing.T) {
+	roles, err := ParseRoles([]byte(testRoles))
+	require.NoError(t, err)
+	assert.Equal(t, []Role{
+		{Service: "service", Name: "support", Description: "Customer support", Permissions: []string{"orders.read", "orders.refund.*"}},
+		{Service: "service", Name: "viewer", Permissions: []string{"orders.read"}},
+	}, roles.List("SERVICE"))
+	assert.Empty(t, roles.List("other"))
+
+	invalid := map[string]string{
+		"no version":         `services: {}`,
+		"unknown version":    `{version: 2, services: {}}`,
+		"unknown field":      `{version: 1, roles: {}}`,
+		"invalid name":       `{version: 1, services: {service: {"a.b": {permissions: [read]}}}}`,
+		"no permissions":     `{version: 1, services: {service: {support: {permissions: []}}}}`,
+		"nested role":        `{version: 1, services: {service: {support: {permissions: [role.viewer]}}}}`,
+		"invalid permission": `{version: 1, services: {service: {support: {permissions: [""]}}}}`,
+	}
+	for name, content := range invalid {
+		_, err := ParseRoles([]byte(content))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseRolesSample(t *testing.T) {
+	content, err := ioutil.ReadFile("../../../.roles-sample.yaml")
+	require.NoError(t, err)
+	roles, err := ParseRoles(content)
+	require.NoError(t, err, "The sample roles are valid")
+	assert.Len(t, roles.List(""), 2)
+}
+
+func TestAddPermissionsRoles(t *testing.T) {
+	roles, err := ParseRoles([]byte(testRoles))
+	require.NoError(t, err)
+	provider := NewMemoryProvider()
+	for id, name := range map[string]string{
+		"g1": "iam-service.role.support",
+		"g2": "iam-service.orders.read",
+		"g3": "iam-service.role.unknown",
+		"g4": "iam-service.orders.refund.approve",
+	} {
+		provider.SetGroup(Group{ID: id, Name: name})
+		provider.AddMember(id, "user@kiwi.com")
+	}
+	provider.SetUser(User{Email: "user@kiwi.com", Status: StatusActive})
+	d, _ := newTestDirectory(provider)
+	d.roles = roles
+	d.SyncGroups()
+
+	user := User{Email: "user@kiwi.com", Status: StatusActive}
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.ElementsMatch(t, []string{
+		"role.support", "orders.read", "orders.refund.*", "role.unknown", "orders.refund.approve",
+	}, user.Permissions, "Roles are expanded into their permissions without duplicates")
+	assert.True(t, user.HasPermission("orders.refund.approve"))
+
+	listed, err := d.ListRoles("service")
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, []string{"orders.read", "orders.refund.*", "orders.refund.approve"}, listed[0].EffectivePermissions,
+		"Effective permissions are expanded with permissions granted by groups")
+	assert.Equal(t, []string{"orders.read"}, listed[1].EffectivePermissions)
+
+	d.roles = nil
+	listed, err = d.ListRoles("")
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+}
@@ -0,0 +1,85 @@
+package directory
+
+import (
+	"errors"
+	"time"
+)
+
+// BoocsekAttributes contains formatted Boocsek attributes of a user
+type BoocsekAttributes struct {
+	Site        string   `json:"site"`
+	Position    string   `json:"position"`
+	Channel     string   `json:"channel"`
+	Tier        string   `json:"tier"`
+	Team        string   `json:"team"`
+	TeamManager string   `json:"teamManager"`
+	Staff       string   `json:"staff"`
+	State       string   `json:"state"`
+	KiwibaseID  int32    `json:"kiwibaseId"`
+	Substate    string   `json:"substate"`
+	Skills      []string `json:"skills"`
+}
+
+// User contains formatted user data provided by a directory provider
+type User struct {
+	// ID of the user in the provider. The JSON name is kept from when Okta was
+	// the only provider, so that cached users stay readable.
+	ID                    string            `json:"oktaId,omitempty"`
+	EmployeeNumber        string            `json:"employeeNumber"`
+	FirstName             string            `json:"firstName"`
+	LastName              string            `json:"lastName"`
+	Position              string            `json:"position"`
+	Department            string            `json:"department"`
+	Email                 string            `json:"email"`
+	Location              string            `json:"location"`
+	IsVendor              bool              `json:"isVendor"`
+	TeamMembership        []string          `json:"teamMembership"` // Deprecated
+	OrganizationStructure string            `json:"orgStructure"`
+	GroupMembership       []Group           `json:"groupMembership,omitempty"`
+	Manager               string            `json:"manager"`
+	Permissions           []string          `json:"permissions"`
+	BoocsekAttributes     BoocsekAttributes `json:"boocsek"`
+	Status                string            `json:"status"`
+	// Attributes are mapped from the provider's profile as configured
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	// PrivateAttributes are mapped like Attributes, but not exposed by the APIs
+	PrivateAttributes map[string]interface{} `json:"privateAttributes,omitempty"`
+	// DeniedPermissions are denied to the user by deny entries, added by
+	// AddPermissions. They take precedence over Permissions.
+	DeniedPermissions []string `json:"deniedPermissions,omitempty"`
+}
+
+// User statuses, as defined by Okta
+// https://developer.okta.com/docs/reference/api/users/#user-status
+const (
+	StatusActive        = "ACTIVE"
+	StatusProvisioned   = "PROVISIONED"
+	StatusSuspended     = "SUSPENDED"
+	StatusDeprovisioned = "DEPROVISIONED"
+)
+
+// IsActive returns whether the user is active. Users cached before the status
+// was synced have no status and are considered active.
+func (u *User) IsActive() bool {
+	return u.Status == "" || u.Status == StatusActive
+}
+
+// GroupPrefix is the prefix of names of groups granting IAM permissions
+const GroupPrefix = "iam-"
+
+// Group represents a group of users, named iam-<service>.<permission>
+type Group struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// LastMembershipUpdated is zero if the provider doesn't track it, in which
+	// case members of the group are fetched by each groups sync.
+	LastMembershipUpdated time.Time `json:"lastMembershipUpdated"`
+}
+
+// ErrUserNotFound is returned when a user is not present in the directory
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUpstreamUnavailable is returned when the provider is known to be
+// unavailable, and requests to it fail fast.
+var ErrUpstreamUnavailable = errors.New("identity provider is unavailable")
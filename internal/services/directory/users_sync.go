@@ -0,0 +1,306 @@
+package directory
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getsentry/raven-go"
+
+	cfg "github.com/kiwicom/iam/configs"
+	"github.com/kiwicom/iam/internal/monitoring"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// usersSyncProgress is the state of a users sync. It's cached when a sync fails,
+// so that the next one resumes where the failed one stopped.
+type usersSyncProgress struct {
+	// Cursor is the page of users to fetch next, empty for the first page
+	Cursor string `json:"cursor"`
+	// Since is the time of the last sync, only users updated since then are
+	// fetched
+	Since time.Time `json:"since"`
+	// Started is used as the last sync time once the sync finishes
+	Started time.Time `json:"started"`
+	// Full is true when all users are fetched instead of only the updated ones
+	Full bool `json:"full"`
+	// Seen contains the emails of users fetched so far
+	Seen map[string]bool `json:"seen"`
+}
+
+// SyncUsers gets users from the provider and saves them into cache.
+func (d *Directory) SyncUsers() {
+	lockErr := d.lock.Create("sync_users")
+	if lockErr == storage.ErrLockExists {
+		log.Println("Aborted, users were already fetched")
+		return
+	}
+	defer d.lock.Delete("sync_users")
+
+	if d.Ready() != nil {
+		log.Println(d.name, "is unavailable, skipping users sync")
+		d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "users"), monitoring.Tag("status", "skipped"))
+		return
+	}
+
+	progress, resumed := d.getUsersSyncProgress(), true
+	if progress == nil {
+		progress = d.newUsersSyncProgress()
+		resumed = false
+	}
+
+	count, err := d.cacheUsers(progress)
+	if err != nil {
+		log.Println("Error fetching users", err)
+		d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "users"), monitoring.Tag("status", "error"))
+		raven.CaptureError(err, nil)
+
+		if resumed && count == 0 {
+			// Resuming didn't work at all, the cursor might be expired. Next sync
+			// starts from the beginning.
+			progress = nil
+		}
+		d.setUsersSyncProgress(progress)
+		return
+	}
+	d.setUsersSyncProgress(nil)
+
+	if err := d.reconcileUsers(progress); err != nil {
+		log.Println("Error reconciling users", err)
+		d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "users"), monitoring.Tag("status", "error"))
+		raven.CaptureError(err, nil)
+		return
+	}
+
+	if err := d.cache.Set("users-sync-timestamp", progress.Started, cfg.Expirations.UsersLastSync); err != nil {
+		log.Println("Error while caching last synchronization time ", err)
+		d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "users"), monitoring.Tag("status", "error"))
+		raven.CaptureError(err, nil)
+		return
+	}
+
+	syncType := "incremental"
+	if progress.Full {
+		syncType = "full"
+	}
+	log.Println("Cached", count, "users", "("+syncType+" sync)")
+	d.metrics.Incr("directory_sync", d.providerTag(), monitoring.Tag("type", "users"), monitoring.Tag("status", "ok"), monitoring.Tag("mode", syncType))
+}
+
+// providerTag tags metrics with the name of the provider
+func (d *Directory) providerTag() string {
+	return monitoring.Tag("provider", d.name)
+}
+
+// usersSyncBatchSize is the maximum number of users written to cache at once.
+const usersSyncBatchSize = 200
+
+// cacheUsers streams the users listed by the provider into cache, in batches of
+// up to usersSyncBatchSize, and adds their emails to seen. It returns the
+// number of cached users. On failure, the cursor of progress points to the
+// first page which wasn't cached. Incremental syncs notify webhooks of changed
+// profiles of cached users.
+func (d *Directory) cacheUsers(progress *usersSyncProgress) (int, error) {
+	var cached int
+	batch := make(map[string]interface{}, usersSyncBatchSize)
+	// profiles are the changed fields of users in batch
+	profiles := make(map[string][]string)
+	// batchCursor is the page the users in batch were read from
+	batchCursor := progress.Cursor
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := d.cache.MSet(batch, cfg.Expirations.User); err != nil {
+			progress.Cursor = batchCursor
+			return err
+		}
+		cached += len(batch)
+		d.notifyProfileChanges(profiles, progress.Started)
+		batch = make(map[string]interface{}, usersSyncBatchSize)
+		profiles = make(map[string][]string)
+		batchCursor = progress.Cursor
+		return nil
+	}
+
+	for {
+		users, next, err := d.provider.ListUsers(progress.Since, progress.Cursor)
+		if err != nil {
+			// Users from pages read before a failure are cached too, so the sync
+			// can be resumed from the page which failed.
+			if flushErr := flush(); flushErr != nil {
+				return cached, flushErr
+			}
+			return cached, err
+		}
+
+		for i := range users {
+			if !progress.Full {
+				if fields := d.changedProfile(&users[i]); len(fields) > 0 {
+					profiles[strings.ToLower(users[i].Email)] = fields
+				}
+			}
+			batch[users[i].Email] = users[i]
+			progress.Seen[strings.ToLower(users[i].Email)] = true
+		}
+		if next == "" {
+			break
+		}
+		progress.Cursor = next
+
+		if len(batch) >= usersSyncBatchSize {
+			if err := flush(); err != nil {
+				return cached, err
+			}
+		}
+	}
+
+	return cached, flush()
+}
+
+// unprofiledFields are fields of users which are not part of their profiles,
+// changes of them are not delivered to webhooks
+var unprofiledFields = map[string]bool{
+	"oktaId":            true,
+	"groupMembership":   true,
+	"permissions":       true,
+	"privateAttributes": true,
+	"deniedPermissions": true,
+}
+
+// changedProfile returns the fields of the profile of a user which differ from
+// the cached user, named as in the JSON representation of users. Users which
+// are not cached have no changed fields.
+func (d *Directory) changedProfile(user *User) []string {
+	var cached User
+	if err := d.cache.Get(user.Email, &cached); err != nil || cached.Email == "" {
+		if err != nil && err != storage.ErrNotFound {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+		return nil
+	}
+
+	previous, err := profileFields(&cached)
+	if err != nil {
+		return nil
+	}
+	current, err := profileFields(user)
+	if err != nil {
+		return nil
+	}
+
+	var fields []string
+	for field, value := range current {
+		if !reflect.DeepEqual(previous[field], value) {
+			fields = append(fields, field)
+		}
+	}
+	for field := range previous {
+		if _, ok := current[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// profileFields returns the fields of the profile of a user by their JSON name
+func profileFields(user *User) (map[string]interface{}, error) {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for field := range unprofiledFields {
+		delete(fields, field)
+	}
+	return fields, nil
+}
+
+// newUsersSyncProgress starts a new users sync. If the last sync time is
+// known, only users updated since then are fetched, otherwise all users are.
+func (d *Directory) newUsersSyncProgress() *usersSyncProgress {
+	lastSync := time.Time{}
+	if err := d.cache.Get("users-sync-timestamp", &lastSync); err != nil && err != storage.ErrNotFound {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+	}
+
+	return &usersSyncProgress{
+		Since:   lastSync,
+		Started: time.Now().UTC(),
+		Full:    lastSync.IsZero(),
+		Seen:    make(map[string]bool),
+	}
+}
+
+// getUsersSyncProgress returns the progress of a failed users sync which should
+// be resumed, or nil if the last sync finished.
+func (d *Directory) getUsersSyncProgress() *usersSyncProgress {
+	var progress usersSyncProgress
+	if err := d.cache.Get("users-sync-progress", &progress); err != nil {
+		if err != storage.ErrNotFound {
+			log.Println("[ERROR]", err.Error())
+			raven.CaptureError(err, nil)
+		}
+		return nil
+	}
+	if progress.Seen == nil {
+		progress.Seen = make(map[string]bool)
+	}
+	return &progress
+}
+
+// setUsersSyncProgress caches the progress of a failed users sync, or clears it
+// when progress is nil.
+func (d *Directory) setUsersSyncProgress(progress *usersSyncProgress) {
+	var err error
+	if progress == nil {
+		err = d.cache.Del("users-sync-progress")
+	} else {
+		log.Println("Users sync will be resumed from", progress.Cursor)
+		err = d.cache.Set("users-sync-progress", progress, cfg.Expirations.UsersSyncCursor)
+	}
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+	}
+}
+
+// reconcileUsers updates the index of synced users. After a full sync, users
+// which are in the index but were not listed by the provider anymore are
+// marked as not found, so they stop being served from cache.
+func (d *Directory) reconcileUsers(progress *usersSyncProgress) error {
+	index := make(map[string]bool)
+	if err := d.cache.Get("users-index", &index); err != nil && err != storage.ErrNotFound {
+		return err
+	}
+
+	if !progress.Full {
+		for email := range progress.Seen {
+			index[email] = true
+		}
+		return d.cache.Set("users-index", index, cfg.Expirations.UsersIndex)
+	}
+
+	removed := make(map[string]interface{})
+	for email := range index {
+		if !progress.Seen[email] {
+			removed[email] = User{}
+		}
+	}
+	if len(removed) > 0 {
+		if err := d.cache.MSet(removed, cfg.Expirations.User); err != nil {
+			return err
+		}
+		log.Println("Removed", len(removed), "users not present in", d.name, "anymore")
+	}
+
+	return d.cache.Set("users-index", progress.Seen, cfg.Expirations.UsersIndex)
+}
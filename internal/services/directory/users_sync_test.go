@@ -0,0 +1,88 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+func TestSyncUsersResume(t *testing.T) {
+	provider := newFailingProvider()
+	provider.PageSize = 1
+	for _, email := range []string{"user1@kiwi.com", "user2@kiwi.com", "user3@kiwi.com"} {
+		provider.SetUser(User{Email: email})
+	}
+	provider.fail("1", true)
+	d, cache := newTestDirectory(provider)
+
+	d.SyncUsers()
+
+	var user User
+	assert.NoError(t, cache.Get("user1@kiwi.com", &user), "Users read before the failure are cached")
+	assert.Equal(t, storage.ErrNotFound, cache.Get("user2@kiwi.com", &user))
+	require.NotNil(t, d.getUsersSyncProgress())
+	assert.Equal(t, "1", d.getUsersSyncProgress().Cursor)
+
+	provider.fail("1", false)
+	provider.usersRequests = nil
+	d.SyncUsers()
+
+	assert.Equal(t, []string{"1", "2"}, provider.usersRequests, "Sync is resumed from the page which failed")
+	assert.NoError(t, cache.Get("user2@kiwi.com", &user))
+	assert.NoError(t, cache.Get("user3@kiwi.com", &user))
+	assert.Nil(t, d.getUsersSyncProgress(), "Progress is cleared after a finished sync")
+
+	index := make(map[string]bool)
+	assert.NoError(t, cache.Get("users-index", &index))
+	assert.Len(t, index, 3, "Users from before the failure are in the index")
+}
+
+func TestSyncUsersIncremental(t *testing.T) {
+	provider := newFailingProvider()
+	provider.SetUser(User{Email: "user1@kiwi.com", FirstName: "One"})
+	provider.SetUser(User{Email: "user2@kiwi.com"})
+	d, cache := newTestDirectory(provider)
+
+	d.SyncUsers()
+	provider.SetUser(User{Email: "user1@kiwi.com", FirstName: "Updated"})
+	d.SyncUsers()
+
+	require.Len(t, provider.usersSince, 2)
+	assert.True(t, provider.usersSince[0].IsZero(), "First sync fetches all users")
+	assert.False(t, provider.usersSince[1].IsZero(), "Next syncs fetch only updated users")
+
+	var user User
+	assert.NoError(t, cache.Get("user1@kiwi.com", &user))
+	assert.Equal(t, "Updated", user.FirstName)
+	assert.NoError(t, cache.Get("user2@kiwi.com", &user))
+	assert.Equal(t, "user2@kiwi.com", user.Email, "Users not updated are kept")
+
+	// user2 is deleted, it's noticed by the next full sync
+	provider.DeleteUser("user2@kiwi.com")
+	_ = cache.Del("users-sync-timestamp")
+	d.SyncUsers()
+
+	assert.True(t, provider.usersSince[2].IsZero())
+	assert.NoError(t, cache.Get("user2@kiwi.com", &user))
+	assert.Equal(t, "", user.Email, "Removed user is marked as not found")
+
+	index := make(map[string]bool)
+	assert.NoError(t, cache.Get("users-index", &index))
+	assert.Equal(t, map[string]bool{"user1@kiwi.com": true}, index)
+}
+
+func TestSyncUsersDeprovisioned(t *testing.T) {
+	provider := NewMemoryProvider()
+	provider.SetUser(User{Email: "gone@kiwi.com", Status: StatusDeprovisioned})
+	d, cache := newTestDirectory(provider)
+
+	d.SyncUsers()
+
+	var user User
+	require.NoError(t, cache.Get("gone@kiwi.com", &user))
+	assert.Equal(t, StatusDeprovisioned, user.Status)
+	assert.False(t, user.IsActive())
+}
@@ -0,0 +1,440 @@
+package directory
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/monitoring"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// deliveriesKey is the cache key of the webhook delivery log, all deliveries
+// are stored in a single entry, pruned by updateDeliveries
+const deliveriesKey = "webhook-deliveries"
+
+// deliveriesWorkerLock is held by the instance delivering pending deliveries
+const deliveriesWorkerLock = "webhook-deliveries-worker"
+
+// DefaultWebhookMaxAttempts is the number of attempts of a delivery before it
+// fails, if it's not configured
+const DefaultWebhookMaxAttempts = 5
+
+// DefaultWebhookRetryDelay is the delay after the first failed attempt of a
+// delivery if it's not configured, it doubles after each attempt
+const DefaultWebhookRetryDelay = 30 * time.Second
+
+// webhookTimeout limits requests delivering webhooks
+const webhookTimeout = 10 * time.Second
+
+// deliveryLockTTL is how long the delivery worker lock is kept for an attempt,
+// longer than the attempt can last
+const deliveryLockTTL = 2 * webhookTimeout
+
+// deliveryRetention is how long delivered and failed deliveries are kept
+const deliveryRetention = 7 * 24 * time.Hour
+
+// maxFinishedDeliveries is the number of the latest delivered and failed
+// deliveries kept, older ones are dropped even before the retention passes
+const maxFinishedDeliveries = 1000
+
+// Statuses of webhook deliveries
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Headers of webhook deliveries
+const (
+	WebhookEventHeader     = "X-IAM-Event"
+	WebhookDeliveryHeader  = "X-IAM-Delivery"
+	WebhookTimestampHeader = "X-IAM-Timestamp"
+	WebhookSignatureHeader = "X-IAM-Signature"
+)
+
+// ErrDeliveryNotFound is returned when replaying a delivery which isn't in the
+// delivery log
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// WebhookPayload is the JSON body of a webhook delivery
+type WebhookPayload struct {
+	// ID of the event, retries and replays keep it, so that receivers can skip
+	// events they already handled
+	ID    string    `json:"id"`
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Email string    `json:"email"`
+	// Service is set for changes of permissions
+	Service string `json:"service,omitempty"`
+	// Changes of permissions of the user in the service
+	Changes []HistoryEntry `json:"changes,omitempty"`
+	// Fields of the profile of the user which changed, as in the user endpoint
+	Fields []string `json:"fields,omitempty"`
+}
+
+// WebhookDelivery is a payload delivered to a webhook, with the result of the
+// last attempt
+type WebhookDelivery struct {
+	ID        string         `json:"id"`
+	WebhookID string         `json:"webhookId"`
+	Payload   WebhookPayload `json:"payload"`
+	// Status is pending until the delivery succeeds or runs out of attempts
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// StatusCode of the response to the last attempt
+	StatusCode int `json:"statusCode,omitempty"`
+	// Error of the last attempt
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// NextAttempt is when a pending delivery is attempted
+	NextAttempt time.Time `json:"nextAttempt"`
+	// ReplayOf is the ID of the delivery replayed by this one
+	ReplayOf string `json:"replayOf,omitempty"`
+}
+
+// SignWebhook returns the signature of a delivery sent in the X-IAM-Signature
+// header. It's the HMAC-SHA256 of the timestamp from the X-IAM-Timestamp header
+// and the body joined by a dot, ie. sha256=<hex>.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomID returns a random hex encoded ID
+func randomID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// notifyPermissionChanges queues deliveries of changes of permissions of users
+// to webhooks of the changed services
+func (d *Directory) notifyPermissionChanges(entries map[string][]HistoryEntry, at time.Time) {
+	var payloads []WebhookPayload
+	for email, userEntries := range entries {
+		services := make(map[string][]HistoryEntry)
+		for _, entry := range userEntries {
+			services[entry.Service] = append(services[entry.Service], entry)
+		}
+		for service, changes := range services {
+			payloads = append(payloads, WebhookPayload{
+				Type:    WebhookPermissionsChanged,
+				Time:    at,
+				Email:   email,
+				Service: service,
+				Changes: changes,
+			})
+		}
+	}
+	d.notify(payloads)
+}
+
+// notifyProfileChanges queues deliveries of changed fields of profiles of
+// users, by email, to webhooks of all services
+func (d *Directory) notifyProfileChanges(fields map[string][]string, at time.Time) {
+	payloads := make([]WebhookPayload, 0, len(fields))
+	for email, changed := range fields {
+		payloads = append(payloads, WebhookPayload{
+			Type:   WebhookProfileChanged,
+			Time:   at,
+			Email:  email,
+			Fields: changed,
+		})
+	}
+	d.notify(payloads)
+}
+
+// notify queues deliveries of payloads to the webhooks subscribed to them,
+// they're sent by DeliverWebhooks. Errors are only reported, as webhooks are
+// not needed to serve permissions.
+func (d *Directory) notify(payloads []WebhookPayload) {
+	if len(payloads) == 0 {
+		return
+	}
+	webhooks, err := d.getWebhooks()
+	if err != nil || len(webhooks) == 0 {
+		reportWebhookError(err)
+		return
+	}
+
+	now := time.Now()
+	var deliveries []WebhookDelivery
+	for _, payload := range payloads {
+		if payload.ID, err = randomID(); err != nil {
+			reportWebhookError(err)
+			return
+		}
+		for _, webhook := range webhooks {
+			if !webhook.subscribed(payload.Type) ||
+				(payload.Service != "" && !strings.EqualFold(webhook.Service, payload.Service)) {
+				continue
+			}
+			id, err := randomID()
+			if err != nil {
+				reportWebhookError(err)
+				return
+			}
+			deliveries = append(deliveries, WebhookDelivery{
+				ID:          id,
+				WebhookID:   webhook.ID,
+				Payload:     payload,
+				Status:      DeliveryPending,
+				CreatedAt:   now,
+				NextAttempt: now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+
+	err = d.updateDeliveries(func(queued map[string]WebhookDelivery) error {
+		for _, delivery := range deliveries {
+			queued[delivery.ID] = delivery
+		}
+		return nil
+	})
+	reportWebhookError(err)
+}
+
+// reportWebhookError logs and reports an error, if it's not nil
+func reportWebhookError(err error) {
+	if err != nil {
+		log.Println("[ERROR]", err.Error())
+		raven.CaptureError(err, nil)
+	}
+}
+
+// DeliverWebhooks sends pending deliveries whose next attempt is due. Failed
+// attempts are retried with an exponential backoff until the deliveries run out
+// of attempts. Only one instance delivers at a time, its lock is refreshed
+// before each attempt so that it outlasts the attempt. The result of each
+// attempt is written before the next one, deliveries are sent at least once.
+func (d *Directory) DeliverWebhooks() error {
+	if d.lock.Create(deliveriesWorkerLock) == storage.ErrLockExists {
+		return nil
+	}
+	defer d.lock.Delete(deliveriesWorkerLock)
+
+	deliveries, err := d.getDeliveries()
+	if err != nil {
+		return err
+	}
+	webhooks, err := d.getWebhooks()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var due []WebhookDelivery
+	for _, delivery := range deliveries {
+		if delivery.Status == DeliveryPending && !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+
+	for i := range due {
+		delivery := due[i]
+		if webhook, ok := webhooks[delivery.WebhookID]; ok {
+			d.lock.Refresh(deliveriesWorkerLock, deliveryLockTTL)
+			d.attemptDelivery(&webhook, &delivery, time.Now())
+		} else {
+			delivery.Status = DeliveryFailed
+			delivery.Error = "webhook was deleted"
+		}
+		d.metrics.Incr("webhook_delivery", monitoring.Tag("type", delivery.Payload.Type), monitoring.Tag("status", delivery.Status))
+
+		err := d.updateDeliveries(func(deliveries map[string]WebhookDelivery) error {
+			if current, ok := deliveries[delivery.ID]; ok && current.Status == DeliveryPending {
+				deliveries[delivery.ID] = delivery
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attemptDelivery sends a delivery to the webhook and records the result
+func (d *Directory) attemptDelivery(webhook *Webhook, delivery *WebhookDelivery, now time.Time) {
+	delivery.Attempts++
+	statusCode, err := d.sendDelivery(webhook, delivery)
+	delivery.StatusCode = statusCode
+	if err == nil {
+		delivery.Status = DeliveryDelivered
+		delivery.Error = ""
+		return
+	}
+
+	delivery.Error = err.Error()
+	if delivery.Attempts >= d.webhookMaxAttempts {
+		delivery.Status = DeliveryFailed
+		return
+	}
+	delivery.NextAttempt = now.Add(d.webhookRetryDelay << uint(delivery.Attempts-1))
+}
+
+// sendDelivery posts the signed payload of a delivery to the webhook, it
+// returns the status code of the response
+func (d *Directory) sendDelivery(webhook *Webhook, delivery *WebhookDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", d.userAgent)
+	request.Header.Set(WebhookEventHeader, delivery.Payload.Type)
+	request.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, body))
+
+	response, err := d.webhookClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// The body is read so that the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(response.Body, 1<<16))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, errors.New("unexpected status " + response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// ListDeliveries returns the delivery log, newest first, filtered by the
+// webhook and the status if they're not empty
+func (d *Directory) ListDeliveries(webhookID, status string) ([]WebhookDelivery, error) {
+	deliveries, err := d.getDeliveries()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		if (webhookID == "" || delivery.WebhookID == webhookID) && (status == "" || delivery.Status == status) {
+			list = append(list, delivery)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// ReplayDelivery queues a new delivery of the payload of a logged delivery,
+// the original delivery is kept in the log. actor is the service replaying the
+// delivery, recorded in the audit event.
+func (d *Directory) ReplayDelivery(id, actor string) (WebhookDelivery, error) {
+	replayID, err := randomID()
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	now := time.Now()
+	var replay WebhookDelivery
+	err = d.updateDeliveries(func(deliveries map[string]WebhookDelivery) error {
+		original, ok := deliveries[id]
+		if !ok {
+			return ErrDeliveryNotFound
+		}
+		replay = WebhookDelivery{
+			ID:          replayID,
+			WebhookID:   original.WebhookID,
+			Payload:     original.Payload,
+			Status:      DeliveryPending,
+			CreatedAt:   now,
+			NextAttempt: now,
+			ReplayOf:    id,
+		}
+		deliveries[replay.ID] = replay
+		return nil
+	})
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	d.audit(AuditEvent{Type: AuditDeliveryReplayed, Time: now, Actor: actor, Delivery: &replay})
+	return replay, nil
+}
+
+// getDeliveries returns the delivery log by ID
+func (d *Directory) getDeliveries() (map[string]WebhookDelivery, error) {
+	deliveries := make(map[string]WebhookDelivery)
+	err := d.cache.Get(deliveriesKey, &deliveries)
+	if err == storage.ErrNotFound {
+		return deliveries, nil
+	}
+	return deliveries, err
+}
+
+// updateDeliveries applies an update to the delivery log and prunes finished
+// deliveries, updates are serialized across instances by a lock. The log isn't
+// written if the update fails.
+func (d *Directory) updateDeliveries(update func(deliveries map[string]WebhookDelivery) error) error {
+	d.lock.Acquire(deliveriesKey)
+	defer d.lock.Delete(deliveriesKey)
+
+	deliveries, err := d.getDeliveries()
+	if err != nil {
+		return err
+	}
+	if err := update(deliveries); err != nil {
+		return err
+	}
+	pruneDeliveries(deliveries, time.Now())
+	return d.cache.Set(deliveriesKey, deliveries, 0)
+}
+
+// pruneDeliveries drops delivered and failed deliveries older than the
+// retention period and the oldest ones above maxFinishedDeliveries. Pending
+// deliveries are kept.
+func pruneDeliveries(deliveries map[string]WebhookDelivery, now time.Time) {
+	var finished []WebhookDelivery
+	for id, delivery := range deliveries {
+		if delivery.Status == DeliveryPending {
+			continue
+		}
+		if now.Sub(delivery.CreatedAt) > deliveryRetention {
+			delete(deliveries, id)
+			continue
+		}
+		finished = append(finished, delivery)
+	}
+
+	if len(finished) <= maxFinishedDeliveries {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].CreatedAt.Before(finished[j].CreatedAt) })
+	for _, delivery := range finished[:len(finished)-maxFinishedDeliveries] {
+		delete(deliveries, delivery.ID)
+	}
+}
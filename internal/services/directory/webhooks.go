@@ -0,0 +1,274 @@
+package directory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+// webhooksKey is the cache key of webhook subscriptions, all subscriptions are
+// stored in a single entry, as there are only a few of them
+const webhooksKey = "webhook-subscriptions"
+
+// minWebhookSecretLength is the shortest secret signing deliveries
+const minWebhookSecretLength = 16
+
+// Types of events delivered to webhooks
+const (
+	// WebhookPermissionsChanged is delivered when permissions of a user in the
+	// service of the webhook change, because members of groups changed
+	WebhookPermissionsChanged = "permissions.changed"
+	// WebhookProfileChanged is delivered when the profile of a user changes,
+	// to webhooks of all services
+	WebhookProfileChanged = "profile.changed"
+)
+
+// webhookEvents are the types of events webhooks can subscribe to
+var webhookEvents = map[string]bool{
+	WebhookPermissionsChanged: true,
+	WebhookProfileChanged:     true,
+}
+
+// ErrWebhookNotFound is returned when deleting a webhook which doesn't exist
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// InvalidWebhookError is returned when creating an invalid webhook
+type InvalidWebhookError struct {
+	Reason string
+}
+
+func (e InvalidWebhookError) Error() string {
+	return "invalid webhook: " + e.Reason
+}
+
+// Webhook subscribes a URL of a service to events, ie. to invalidate cached
+// permissions. Deliveries are signed by the secret, which is never listed.
+type Webhook struct {
+	ID      string `json:"id"`
+	Service string `json:"service"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret,omitempty"`
+	// Events are the types of events delivered to the webhook
+	Events []string `json:"events"`
+	// CreatedBy is the service which created the webhook
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// subscribed reports whether the webhook subscribes to the event type
+func (w *Webhook) subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the fields of a webhook requested through CreateWebhook
+func (w *Webhook) validate() error {
+	if w.Service == "" {
+		return InvalidWebhookError{"missing service"}
+	}
+	parsed, err := url.Parse(w.URL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return InvalidWebhookError{"url has to be an absolute http or https url"}
+	}
+	if len(w.Secret) < minWebhookSecretLength {
+		return InvalidWebhookError{"secret has to be at least 16 characters long"}
+	}
+	if len(w.Events) == 0 {
+		return InvalidWebhookError{"missing events"}
+	}
+	for _, event := range w.Events {
+		if !webhookEvents[event] {
+			return InvalidWebhookError{"unknown event " + event}
+		}
+	}
+	return nil
+}
+
+// ParseNetworks parses a comma separated list of networks in CIDR notation, ie.
+// 10.20.0.0/16,fd00::/8
+func ParseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// webhookIPAllowed reports whether webhooks can be delivered to the IP. Loopback,
+// private, link-local (cloud metadata endpoints included), multicast and
+// unspecified addresses are refused, so that webhooks can't make IAM call
+// internal endpoints, unless they're in allowed networks.
+func webhookIPAllowed(ip net.IP, allowed []*net.IPNet) bool {
+	for _, network := range allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// webhookHostAllowed refuses webhook URLs whose host is localhost or an IP
+// address webhooks can't be delivered to. Hosts resolved to such addresses are
+// refused when deliveries connect, see webhookDialer.
+func (d *Directory) webhookHostAllowed(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return webhookIPAllowed(net.IPv4(127, 0, 0, 1), d.webhookAllowedNetworks)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return webhookIPAllowed(ip, d.webhookAllowedNetworks)
+	}
+	return true
+}
+
+// errWebhookAddress is returned when a delivery would connect to an address
+// webhooks can't be delivered to
+var errWebhookAddress = errors.New("webhook address is not allowed")
+
+// webhookDialer returns a dialer refusing connections to addresses webhooks
+// can't be delivered to. Addresses are checked once resolved, so hosts can't
+// be pointed to internal addresses after the webhook was created.
+func webhookDialer(allowed []*net.IPNet) *net.Dialer {
+	return &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !webhookIPAllowed(ip, allowed) {
+				return errWebhookAddress
+			}
+			return nil
+		},
+	}
+}
+
+// CreateWebhook stores a webhook subscription, its ID and creation are set by
+// IAM. actor is the service creating the webhook, recorded in the audit event.
+// The returned webhook doesn't contain the secret.
+func (d *Directory) CreateWebhook(webhook Webhook, actor string) (Webhook, error) {
+	if err := webhook.validate(); err != nil {
+		return Webhook{}, err
+	}
+	if !d.webhookHostAllowed(webhook.URL) {
+		return Webhook{}, InvalidWebhookError{"url has to point to a public address"}
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Webhook{}, err
+	}
+	webhook.ID = hex.EncodeToString(id)
+	webhook.Service = strings.ToLower(webhook.Service)
+	webhook.CreatedBy = actor
+	webhook.CreatedAt = time.Now()
+
+	err := d.updateWebhooks(func(webhooks map[string]Webhook) error {
+		webhooks[webhook.ID] = webhook
+		return nil
+	})
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook.Secret = ""
+	d.audit(AuditEvent{Type: AuditWebhookCreated, Time: webhook.CreatedAt, Actor: actor, Webhook: &webhook})
+	return webhook, nil
+}
+
+// DeleteWebhook removes a webhook subscription, its pending deliveries fail.
+// actor is the service deleting the webhook, recorded in the audit event.
+func (d *Directory) DeleteWebhook(id, actor string) error {
+	var deleted Webhook
+	err := d.updateWebhooks(func(webhooks map[string]Webhook) error {
+		webhook, ok := webhooks[id]
+		if !ok {
+			return ErrWebhookNotFound
+		}
+		deleted = webhook
+		delete(webhooks, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	deleted.Secret = ""
+	d.audit(AuditEvent{Type: AuditWebhookDeleted, Time: time.Now(), Actor: actor, Webhook: &deleted})
+	return nil
+}
+
+// ListWebhooks returns webhook subscriptions without their secrets sorted by
+// creation, of a service if it's not empty
+func (d *Directory) ListWebhooks(service string) ([]Webhook, error) {
+	webhooks, err := d.getWebhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		if service == "" || strings.EqualFold(webhook.Service, service) {
+			webhook.Secret = ""
+			list = append(list, webhook)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// getWebhooks returns all stored webhook subscriptions by ID
+func (d *Directory) getWebhooks() (map[string]Webhook, error) {
+	webhooks := make(map[string]Webhook)
+	err := d.cache.Get(webhooksKey, &webhooks)
+	if err == storage.ErrNotFound {
+		return webhooks, nil
+	}
+	return webhooks, err
+}
+
+// updateWebhooks applies an update to the stored webhook subscriptions,
+// updates are serialized across instances by a lock. Subscriptions aren't
+// written if the update fails.
+func (d *Directory) updateWebhooks(update func(webhooks map[string]Webhook) error) error {
+	d.lock.Acquire(webhooksKey)
+	defer d.lock.Delete(webhooksKey)
+
+	webhooks, err := d.getWebhooks()
+	if err != nil {
+		return err
+	}
+	if err := update(webhooks); err != nil {
+		return err
+	}
+	return d.cache.Set(webhooksKey, webhooks, 0)
+}
@@ -0,0 +1,299 @@
+package directory
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver records payloads delivered to it, responding with status
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	payloads []WebhookPayload
+}
+
+func (r *webhookReceiver) handler(t *testing.T, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, SignWebhook(secret, req.Header.Get(WebhookTimestampHeader), body), req.Header.Get(WebhookSignatureHeader))
+		assert.NotEmpty(t, req.Header.Get(WebhookDeliveryHeader))
+
+		var payload WebhookPayload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		assert.Equal(t, payload.Type, req.Header.Get(WebhookEventHeader))
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.payloads = append(r.payloads, payload)
+		w.WriteHeader(r.status)
+	}
+}
+
+func TestCreateWebhook(t *testing.T) {
+	d, _ := newTestDirectory(NewMemoryProvider())
+	valid := Webhook{
+		Service: "Service",
+		URL:     "https://service.kiwi.com/iam",
+		Secret:  "0123456789abcdef",
+		Events:  []string{WebhookPermissionsChanged},
+	}
+
+	invalid := map[string]func(w *Webhook){
+		"missing service": func(w *Webhook) { w.Service = "" },
+		"relative url":    func(w *Webhook) { w.URL = "/iam" },
+		"ftp url":         func(w *Webhook) { w.URL = "ftp://service.kiwi.com" },
+		"short secret":    func(w *Webhook) { w.Secret = "secret" },
+		"missing events":  func(w *Webhook) { w.Events = nil },
+		"unknown event":   func(w *Webhook) { w.Events = []string{"user.deleted"} },
+		"metadata url":    func(w *Webhook) { w.URL = "http://169.254.169.254/latest/meta-data" },
+		"private url":     func(w *Webhook) { w.URL = "https://10.0.0.1/iam" },
+		"loopback url":    func(w *Webhook) { w.URL = "http://[::1]:8080/iam" },
+	}
+	for name, modify := range invalid {
+		webhook := valid
+		modify(&webhook)
+		_, err := d.CreateWebhook(webhook, "admin")
+		assert.IsType(t, InvalidWebhookError{}, err, name)
+	}
+
+	created, err := d.CreateWebhook(valid, "admin")
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, "service", created.Service)
+	assert.Equal(t, "admin", created.CreatedBy)
+	assert.Empty(t, created.Secret, "Secrets are not returned")
+
+	webhooks, err := d.ListWebhooks("SERVICE")
+	require.NoError(t, err)
+	require.Len(t, webhooks, 1)
+	assert.Equal(t, created.ID, webhooks[0].ID)
+	assert.Empty(t, webhooks[0].Secret)
+	stored, err := d.getWebhooks()
+	require.NoError(t, err)
+	assert.Equal(t, valid.Secret, stored[created.ID].Secret)
+
+	require.NoError(t, d.DeleteWebhook(created.ID, "admin"))
+	assert.Equal(t, ErrWebhookNotFound, d.DeleteWebhook(created.ID, "admin"))
+	webhooks, err = d.ListWebhooks("")
+	require.NoError(t, err)
+	assert.Empty(t, webhooks)
+}
+
+func TestDeliverPermissionWebhooks(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusOK}
+	server := httptest.NewServer(receiver.handler(t, "0123456789abcdef"))
+	defer server.Close()
+
+	d, _ := newTestDirectory(NewMemoryProvider())
+	publishMemberships(t, d, "service", map[string]map[string]bool{})
+	webhook, err := d.CreateWebhook(Webhook{
+		Service: "service",
+		URL:     server.URL,
+		Secret:  "0123456789abcdef",
+		Events:  []string{WebhookPermissionsChanged},
+	}, "admin")
+	require.NoError(t, err)
+	_, err = d.CreateWebhook(Webhook{
+		Service: "other",
+		URL:     server.URL,
+		Secret:  "0123456789abcdef",
+		Events:  []string{WebhookPermissionsChanged, WebhookProfileChanged},
+	}, "admin")
+	require.NoError(t, err)
+
+	require.NoError(t, d.ApplyChanges([]Change{
+		membershipChange("1", ChangeMembershipAdded, "user@kiwi.com", "iam-service.write"),
+	}))
+	deliveries, err := d.ListDeliveries("", DeliveryPending)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1, "Only webhooks of the changed service are notified")
+	assert.Equal(t, webhook.ID, deliveries[0].WebhookID)
+
+	require.NoError(t, d.DeliverWebhooks())
+
+	require.Len(t, receiver.payloads, 1)
+	payload := receiver.payloads[0]
+	assert.Equal(t, WebhookPermissionsChanged, payload.Type)
+	assert.Equal(t, "user@kiwi.com", payload.Email)
+	assert.Equal(t, "service", payload.Service)
+	require.Len(t, payload.Changes, 1)
+	assert.Equal(t, "write", payload.Changes[0].Permission)
+	assert.Equal(t, HistoryGranted, payload.Changes[0].Change)
+
+	deliveries, err = d.ListDeliveries(webhook.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, DeliveryDelivered, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+}
+
+func TestDeliverWebhooksRetry(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(receiver.handler(t, "0123456789abcdef"))
+	defer server.Close()
+
+	d, _ := newTestDirectory(NewMemoryProvider())
+	d.webhookMaxAttempts = 2
+	webhook, err := d.CreateWebhook(Webhook{
+		Service: "service",
+		URL:     server.URL,
+		Secret:  "0123456789abcdef",
+		Events:  []string{WebhookProfileChanged},
+	}, "admin")
+	require.NoError(t, err)
+	d.notifyProfileChanges(map[string][]string{"user@kiwi.com": {"department"}}, time.Now())
+
+	require.NoError(t, d.DeliverWebhooks())
+	deliveries, err := d.ListDeliveries(webhook.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	failed := deliveries[0]
+	assert.Equal(t, DeliveryPending, failed.Status)
+	assert.Equal(t, http.StatusServiceUnavailable, failed.StatusCode)
+	assert.NotEmpty(t, failed.Error)
+	assert.True(t, failed.NextAttempt.After(time.Now()), "Failed attempts are retried later")
+
+	require.NoError(t, d.DeliverWebhooks())
+	assert.Len(t, receiver.payloads, 1, "Retries wait for the next attempt")
+
+	d.webhookRetryDelay = 0
+	require.NoError(t, d.updateDeliveries(func(deliveries map[string]WebhookDelivery) error {
+		delivery := deliveries[failed.ID]
+		delivery.NextAttempt = time.Now()
+		deliveries[failed.ID] = delivery
+		return nil
+	}))
+	require.NoError(t, d.DeliverWebhooks())
+	deliveries, err = d.ListDeliveries(webhook.ID, DeliveryFailed)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1, "Deliveries fail after the last attempt")
+	assert.Equal(t, 2, deliveries[0].Attempts)
+
+	receiver.status = http.StatusNoContent
+	replay, err := d.ReplayDelivery(failed.ID, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, failed.ID, replay.ReplayOf)
+	require.NoError(t, d.DeliverWebhooks())
+
+	require.Len(t, receiver.payloads, 3)
+	assert.Equal(t, receiver.payloads[0].ID, receiver.payloads[2].ID, "Replays keep the ID of the event")
+	assert.Equal(t, []string{"department"}, receiver.payloads[2].Fields)
+	deliveries, err = d.ListDeliveries(webhook.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2, "Replayed deliveries are kept in the log")
+	assert.Equal(t, DeliveryDelivered, deliveries[0].Status)
+
+	_, err = d.ReplayDelivery("unknown", "admin")
+	assert.Equal(t, ErrDeliveryNotFound, err)
+}
+
+func TestWebhookDialer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	address := server.Listener.Addr().String()
+
+	_, err := webhookDialer(nil).Dial("tcp", address)
+	assert.True(t, errors.Is(err, errWebhookAddress), "Deliveries don't connect to the loopback")
+
+	allowed, err := ParseNetworks("10.0.0.0/8, 127.0.0.0/8")
+	require.NoError(t, err)
+	conn, err := webhookDialer(allowed).Dial("tcp", address)
+	require.NoError(t, err, "Allowed networks are reachable")
+	conn.Close()
+
+	_, err = ParseNetworks("10.0.0.1")
+	assert.Error(t, err)
+}
+
+// roundTripFunc sends requests of an http.Client in the goroutine of the caller
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDeliverWebhooksRecordsEachAttempt(t *testing.T) {
+	d, cache := newTestDirectory(NewMemoryProvider())
+	webhook, err := d.CreateWebhook(Webhook{
+		Service: "service",
+		URL:     "https://service.kiwi.com/iam",
+		Secret:  "0123456789abcdef",
+		Events:  []string{WebhookProfileChanged},
+	}, "admin")
+	require.NoError(t, err)
+	d.notifyProfileChanges(map[string][]string{"first@kiwi.com": {"department"}}, time.Now())
+	d.notifyProfileChanges(map[string][]string{"second@kiwi.com": {"department"}}, time.Now().Add(time.Millisecond))
+
+	var delivered []int
+	d.webhookClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		deliveries, err := d.ListDeliveries(webhook.ID, DeliveryDelivered)
+		require.NoError(t, err)
+		delivered = append(delivered, len(deliveries))
+
+		var lockTime time.Time
+		assert.NoError(t, cache.Get("lock:"+deliveriesWorkerLock, &lockTime), "Worker lock is held")
+		return &http.Response{StatusCode: http.StatusNoContent, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})}
+
+	require.NoError(t, d.DeliverWebhooks())
+	assert.Equal(t, []int{0, 1}, delivered, "Each attempt is recorded before the next one")
+}
+
+func TestSyncUsersProfileWebhooks(t *testing.T) {
+	provider := newFailingProvider()
+	provider.SetUser(User{Email: "user1@kiwi.com", Department: "Engineering"})
+	provider.SetUser(User{Email: "user2@kiwi.com"})
+	d, _ := newTestDirectory(provider)
+	_, err := d.CreateWebhook(Webhook{
+		Service: "service",
+		URL:     "https://service.kiwi.com/iam",
+		Secret:  "0123456789abcdef",
+		Events:  []string{WebhookProfileChanged},
+	}, "admin")
+	require.NoError(t, err)
+
+	d.SyncUsers()
+	deliveries, err := d.ListDeliveries("", "")
+	require.NoError(t, err)
+	assert.Empty(t, deliveries, "Users are not compared by full syncs")
+
+	provider.SetUser(User{Email: "user1@kiwi.com", Department: "Finance", Permissions: []string{"read"}})
+	d.SyncUsers()
+
+	deliveries, err = d.ListDeliveries("", "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "user1@kiwi.com", deliveries[0].Payload.Email)
+	assert.Equal(t, []string{"department"}, deliveries[0].Payload.Fields)
+}
+
+func TestPruneDeliveries(t *testing.T) {
+	now := time.Now()
+	deliveries := map[string]WebhookDelivery{
+		"old":     {ID: "old", Status: DeliveryDelivered, CreatedAt: now.Add(-deliveryRetention - time.Hour)},
+		"pending": {ID: "pending", Status: DeliveryPending, CreatedAt: now.Add(-deliveryRetention - time.Hour)},
+	}
+	for i := 0; i <= maxFinishedDeliveries; i++ {
+		id := "d" + strconv.Itoa(i)
+		deliveries[id] = WebhookDelivery{ID: id, Status: DeliveryFailed, CreatedAt: now.Add(time.Duration(i) * time.Second)}
+	}
+
+	pruneDeliveries(deliveries, now)
+
+	assert.Len(t, deliveries, maxFinishedDeliveries+1)
+	assert.Contains(t, deliveries, "pending", "Pending deliveries are kept")
+	assert.NotContains(t, deliveries, "old")
+	assert.NotContains(t, deliveries, "d0", "The oldest finished deliveries are dropped")
+}
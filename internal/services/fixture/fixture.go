@@ -0,0 +1,183 @@
+package fixture
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// fixture is the content of a fixture file. Memberships can be listed both by
+// users and by groups.
+type fixture struct {
+	Users  []fixtureUser  `yaml:"users"`
+	Groups []fixtureGroup `yaml:"groups"`
+}
+
+type fixtureUser struct {
+	Email             string                 `yaml:"email"`
+	EmployeeNumber    string                 `yaml:"employeeNumber"`
+	FirstName         string                 `yaml:"firstName"`
+	LastName          string                 `yaml:"lastName"`
+	Position          string                 `yaml:"position"`
+	Department        string                 `yaml:"department"`
+	Location          string                 `yaml:"location"`
+	IsVendor          bool                   `yaml:"isVendor"`
+	Manager           string                 `yaml:"manager"`
+	Status            string                 `yaml:"status"`
+	Attributes        map[string]interface{} `yaml:"attributes"`
+	PrivateAttributes map[string]interface{} `yaml:"privateAttributes"`
+	// Groups are names of groups the user is a member of
+	Groups []string `yaml:"groups"`
+}
+
+type fixtureGroup struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Members are emails of members of the group
+	Members []string `yaml:"members"`
+}
+
+// directoryData contains users by email, groups by name, and emails of members
+// by group name.
+type directoryData struct {
+	users   map[string]directory.User
+	groups  map[string]directory.Group
+	members map[string]map[string]bool
+}
+
+// parse reads a YAML or JSON fixture. Groups are identified by their name,
+// groups which are only listed by users are created.
+func parse(data []byte) (*directoryData, error) {
+	var f fixture
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, errors.New("invalid directory fixture: " + err.Error())
+	}
+
+	d := &directoryData{
+		users:   make(map[string]directory.User),
+		groups:  make(map[string]directory.Group),
+		members: make(map[string]map[string]bool),
+	}
+
+	for i := range f.Groups {
+		group := &f.Groups[i]
+		if err := d.addGroup(group.Name, group.Description); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range f.Users {
+		user, err := formatUser(&f.Users[i])
+		if err != nil {
+			return nil, err
+		}
+		email := strings.ToLower(user.Email)
+		if _, ok := d.users[email]; ok {
+			return nil, errors.New("duplicate user " + user.Email)
+		}
+		d.users[email] = user
+
+		for _, name := range f.Users[i].Groups {
+			if _, ok := d.groups[name]; !ok {
+				if err := d.addGroup(name, ""); err != nil {
+					return nil, err
+				}
+			}
+			d.members[name][email] = true
+		}
+	}
+
+	for i := range f.Groups {
+		for _, email := range f.Groups[i].Members {
+			email = strings.ToLower(email)
+			if _, ok := d.users[email]; !ok {
+				return nil, errors.New("member " + email + " of group " + f.Groups[i].Name + " is not a user")
+			}
+			d.members[f.Groups[i].Name][email] = true
+		}
+	}
+
+	return d, nil
+}
+
+func (d *directoryData) addGroup(name, description string) error {
+	if !strings.HasPrefix(name, directory.GroupPrefix) || !strings.Contains(name, ".") {
+		return errors.New("group " + name + " is not named " + directory.GroupPrefix + "<service>.<permission>")
+	}
+	if _, ok := d.groups[name]; ok {
+		return errors.New("duplicate group " + name)
+	}
+
+	d.groups[name] = directory.Group{ID: name, Name: name, Description: description}
+	d.members[name] = make(map[string]bool)
+	return nil
+}
+
+func formatUser(user *fixtureUser) (directory.User, error) {
+	if user.Email == "" {
+		return directory.User{}, errors.New("user without email")
+	}
+
+	status := user.Status
+	switch status {
+	case "":
+		status = directory.StatusActive
+	case directory.StatusActive, directory.StatusProvisioned, directory.StatusSuspended, directory.StatusDeprovisioned:
+	default:
+		return directory.User{}, errors.New("user " + user.Email + " has an unknown status " + status)
+	}
+
+	return directory.User{
+		ID:                user.Email,
+		EmployeeNumber:    user.EmployeeNumber,
+		FirstName:         user.FirstName,
+		LastName:          user.LastName,
+		Position:          user.Position,
+		Department:        user.Department,
+		Email:             user.Email,
+		Location:          user.Location,
+		IsVendor:          user.IsVendor,
+		TeamMembership:    make([]string, 0),
+		Manager:           user.Manager,
+		BoocsekAttributes: directory.BoocsekAttributes{Skills: make([]string, 0)},
+		Status:            status,
+		Attributes:        stringKeys(user.Attributes),
+		PrivateAttributes: stringKeys(user.PrivateAttributes),
+	}, nil
+}
+
+// stringKeys converts maps decoded from YAML, which can have keys of any type,
+// to maps with string keys, so that they can be encoded to JSON.
+func stringKeys(attributes map[string]interface{}) map[string]interface{} {
+	if attributes == nil {
+		return nil
+	}
+
+	converted := make(map[string]interface{}, len(attributes))
+	for key, value := range attributes {
+		converted[key] = stringKeysValue(value)
+	}
+	return converted
+}
+
+func stringKeysValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = stringKeysValue(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = stringKeysValue(item)
+		}
+		return converted
+	}
+	return value
+}
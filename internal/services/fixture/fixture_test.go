@@ -0,0 +1,80 @@
+package fixture
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+func TestParse(t *testing.T) {
+	data, err := parse([]byte(`
+users:
+  - email: Alice@kiwi.com
+    firstName: Alice
+    groups: [iam-service.read]
+    attributes:
+      costCenter: 42
+      address: {city: Brno}
+  - email: bob@kiwi.com
+    status: SUSPENDED
+groups:
+  - name: iam-service.write
+    description: Write access
+    members: [alice@kiwi.com, BOB@kiwi.com]
+`))
+	require.NoError(t, err)
+
+	alice := data.users["alice@kiwi.com"]
+	assert.Equal(t, "Alice@kiwi.com", alice.Email)
+	assert.Equal(t, "Alice", alice.FirstName)
+	assert.Equal(t, directory.StatusActive, alice.Status, "Users are active by default")
+	assert.Equal(t, map[string]interface{}{
+		"costCenter": 42,
+		"address":    map[string]interface{}{"city": "Brno"},
+	}, alice.Attributes)
+	assert.Equal(t, directory.StatusSuspended, data.users["bob@kiwi.com"].Status)
+
+	assert.Equal(t, directory.Group{ID: "iam-service.write", Name: "iam-service.write", Description: "Write access"},
+		data.groups["iam-service.write"])
+	assert.Contains(t, data.groups, "iam-service.read", "Groups listed by users are created")
+	assert.Equal(t, map[string]map[string]bool{
+		"iam-service.read":  {"alice@kiwi.com": true},
+		"iam-service.write": {"alice@kiwi.com": true, "bob@kiwi.com": true},
+	}, data.members)
+}
+
+func TestParseJSON(t *testing.T) {
+	data, err := parse([]byte(`{"users": [{"email": "alice@kiwi.com", "groups": ["iam-service.read"]}]}`))
+	require.NoError(t, err)
+	assert.Len(t, data.users, 1)
+	assert.Equal(t, map[string]bool{"alice@kiwi.com": true}, data.members["iam-service.read"])
+}
+
+func TestParseInvalid(t *testing.T) {
+	fixtures := map[string]string{
+		"unknown field":   `users: [{email: alice@kiwi.com, mail: alice@kiwi.com}]`,
+		"no email":        `users: [{firstName: Alice}]`,
+		"duplicate user":  `users: [{email: alice@kiwi.com}, {email: ALICE@kiwi.com}]`,
+		"unknown status":  `users: [{email: alice@kiwi.com, status: ENABLED}]`,
+		"not IAM group":   `groups: [{name: vpn-users}]`,
+		"duplicate group": `groups: [{name: iam-service.read}, {name: iam-service.read}]`,
+		"unknown member":  `groups: [{name: iam-service.read, members: [alice@kiwi.com]}]`,
+	}
+
+	for name, fixture := range fixtures {
+		_, err := parse([]byte(fixture))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	content, err := ioutil.ReadFile("../../../.directory-sample.yaml")
+	require.NoError(t, err)
+	data, err := parse(content)
+	require.NoError(t, err, "The sample fixture is valid")
+	assert.Len(t, data.users, 2)
+}
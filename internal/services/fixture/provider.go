@@ -0,0 +1,156 @@
+package fixture
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/getsentry/raven-go"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// Provider is a directory.Provider serving users, groups and memberships from
+// a YAML or JSON fixture file, meant for local development and tests. Changes
+// of the file are applied by Reload, and listed as changes of the provider.
+type Provider struct {
+	*directory.MemoryProvider
+	path string
+
+	// mu serializes reloads
+	mu   sync.Mutex
+	data *directoryData
+}
+
+var _ directory.Provider = (*Provider)(nil)
+
+// NewProvider creates a Provider and loads the fixture at path
+func NewProvider(path string) (*Provider, error) {
+	p := &Provider{
+		MemoryProvider: directory.NewMemoryProvider(),
+		path:           path,
+		data: &directoryData{
+			users:   make(map[string]directory.User),
+			groups:  make(map[string]directory.Group),
+			members: make(map[string]map[string]bool),
+		},
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload reads the fixture again. If it's invalid, the provider is left as it
+// was.
+func (p *Provider) Reload() error {
+	content, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	data, err := parse(content)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.apply(data)
+	p.data = data
+	return nil
+}
+
+// apply makes the differences between the loaded data and data, so that only
+// them are listed as changes.
+func (p *Provider) apply(data *directoryData) {
+	old := p.data
+
+	for email, user := range data.users {
+		if previous, ok := old.users[email]; !ok || !reflect.DeepEqual(previous, user) {
+			p.SetUser(user)
+		}
+	}
+
+	for name, group := range data.groups {
+		if previous, ok := old.groups[name]; !ok || previous.Description != group.Description {
+			p.SetGroup(group)
+		}
+		for email := range data.members[name] {
+			if !old.members[name][email] {
+				p.AddMember(group.ID, email)
+			}
+		}
+		for email := range old.members[name] {
+			if !data.members[name][email] {
+				p.RemoveMember(group.ID, email)
+			}
+		}
+	}
+
+	for name, group := range old.groups {
+		if _, ok := data.groups[name]; ok {
+			continue
+		}
+		for email := range old.members[name] {
+			p.RemoveMember(group.ID, email)
+		}
+		p.DeleteGroup(group.ID)
+	}
+
+	for email := range old.users {
+		if _, ok := data.users[email]; !ok {
+			p.DeleteUser(email)
+		}
+	}
+}
+
+// Watch reloads the fixture whenever the file changes, and calls onReload
+// after each successful reload, until done is closed.
+func (p *Provider) Watch(done <-chan struct{}, onReload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// The directory is watched, as editors often replace files instead of
+	// writing to them.
+	path := filepath.Clean(p.path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-done:
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != path || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if err := p.Reload(); err != nil {
+				log.Println("[ERROR] Failed to reload directory fixture:", err)
+				raven.CaptureError(err, nil)
+				continue
+			}
+			log.Println("Reloaded directory fixture", p.path)
+			if onReload != nil {
+				onReload()
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Println("[ERROR] Failed to watch directory fixture:", err)
+			raven.CaptureError(err, nil)
+		}
+	}
+}
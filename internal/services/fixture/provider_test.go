@@ -0,0 +1,152 @@
+package fixture
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+const testFixture = `
+users:
+  - email: alice@kiwi.com
+    firstName: Alice
+    groups: [iam-service.read, iam-service.write]
+  - email: bob@kiwi.com
+    groups: [iam-service.read]
+`
+
+func writeFixture(t *testing.T, path, content string) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+}
+
+func newTestProvider(t *testing.T) (*Provider, string, func()) {
+	dir, err := ioutil.TempDir("", "fixture")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "directory.yaml")
+	writeFixture(t, path, testFixture)
+
+	provider, err := NewProvider(path)
+	require.NoError(t, err)
+	return provider, path, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestProvider(t *testing.T) {
+	provider, _, cleanup := newTestProvider(t)
+	defer cleanup()
+
+	user, err := provider.GetUser("alice@kiwi.com")
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", user.FirstName)
+
+	groups, err := provider.ListUserGroups(user)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, "iam-service.read", groups[0].Name)
+
+	members, err := provider.ListGroupMembers(groups[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice@kiwi.com", "bob@kiwi.com"}, members)
+}
+
+func TestProviderReload(t *testing.T) {
+	provider, path, cleanup := newTestProvider(t)
+	defer cleanup()
+	loaded := time.Now().UTC()
+
+	writeFixture(t, path, `
+users:
+  - email: alice@kiwi.com
+    firstName: Alice
+    groups: [iam-service.read, iam-other.read]
+  - email: carol@kiwi.com
+    status: SUSPENDED
+`)
+	require.NoError(t, provider.Reload())
+
+	changes, _, _, err := provider.ListChanges(loaded, "")
+	require.NoError(t, err)
+	var types []string
+	for _, change := range changes {
+		types = append(types, change.Type+" "+change.Email+" "+change.Group.Name)
+	}
+	assert.ElementsMatch(t, []string{
+		directory.ChangeMembershipAdded + " alice@kiwi.com iam-other.read",
+		directory.ChangeMembershipRemoved + " alice@kiwi.com iam-service.write",
+		directory.ChangeMembershipRemoved + " bob@kiwi.com iam-service.read",
+		directory.ChangeUserDeleted + " bob@kiwi.com ",
+	}, types, "Only differences are listed as changes")
+
+	groups, err := provider.ListGroups()
+	require.NoError(t, err)
+	assert.Len(t, groups, 2, "Groups which are not listed anymore are removed")
+	_, err = provider.GetUser("bob@kiwi.com")
+	assert.Equal(t, directory.ErrUserNotFound, err)
+	user, err := provider.GetUser("carol@kiwi.com")
+	require.NoError(t, err)
+	assert.False(t, user.IsActive())
+
+	writeFixture(t, path, `users: [{email: ""}]`)
+	assert.Error(t, provider.Reload())
+	_, err = provider.GetUser("carol@kiwi.com")
+	assert.NoError(t, err, "Invalid fixtures are not loaded")
+}
+
+func TestProviderWatch(t *testing.T) {
+	provider, path, cleanup := newTestProvider(t)
+	defer cleanup()
+	cache := storage.NewInMemoryCache()
+	d := directory.New(&directory.Opts{
+		Name:        "file",
+		Provider:    provider,
+		Cache:       cache,
+		LockManager: storage.NewLockManager(cache, time.Millisecond, time.Second),
+	})
+	d.SyncUsers()
+	d.SyncGroups()
+	d.PollChanges()
+
+	reloaded := make(chan struct{}, 10)
+	done := make(chan struct{})
+	defer close(done)
+	watching := make(chan error, 1)
+	go func() {
+		watching <- provider.Watch(done, func() {
+			d.PollChanges()
+			reloaded <- struct{}{}
+		})
+	}()
+
+	// The watcher is started asynchronously, the fixture is written until it's
+	// noticed.
+	fixture := `users: [{email: alice@kiwi.com, groups: [iam-service.write]}, {email: bob@kiwi.com}]`
+	timeout := time.After(5 * time.Second)
+	for noticed := false; !noticed; {
+		writeFixture(t, path, fixture)
+		select {
+		case <-reloaded:
+			noticed = true
+		case err := <-watching:
+			require.NoError(t, err)
+		case <-time.After(50 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("Fixture changes were not noticed")
+		}
+	}
+
+	user, err := d.GetUser("alice@kiwi.com")
+	require.NoError(t, err)
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Equal(t, []string{"write"}, user.Permissions, "Changes are applied to the directory")
+	user, err = d.GetUser("bob@kiwi.com")
+	require.NoError(t, err)
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Empty(t, user.Permissions)
+}
@@ -0,0 +1,509 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	gourl "net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getsentry/raven-go"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// Ways of resolving group memberships
+const (
+	// MembershipMember reads members from the member attribute of groups
+	MembershipMember = "member"
+	// MembershipMemberOf reads groups from the memberOf attribute of users
+	MembershipMemberOf = "memberOf"
+)
+
+// ldapTimeFormat is the generalized time format of operational attributes
+const ldapTimeFormat = "20060102150405Z"
+
+// memberBatchSize is the number of members of a group resolved by a search,
+// it bounds the length of the search filter.
+const memberBatchSize = 100
+
+// ClientOpts contains options to create an LDAP client
+type ClientOpts struct {
+	// URL of the server, ldap:// or ldaps://
+	URL string
+	// StartTLS upgrades ldap:// connections to TLS before binding
+	StartTLS bool
+	// BindDN and BindPassword are used to bind, anonymously if BindDN is empty
+	BindDN       string
+	BindPassword string
+	Timeout      time.Duration
+
+	UserBaseDN  string
+	UserFilter  string
+	GroupBaseDN string
+	GroupFilter string
+	// PageSize is the number of entries read in a page, 0 disables paging
+	PageSize int
+
+	// Membership is MembershipMember or MembershipMemberOf
+	Membership string
+	Attributes AttributeMapping
+	// GroupMapping maps DNs of groups to names of IAM groups. Groups which
+	// aren't mapped are named by their group name attribute.
+	GroupMapping map[string]string
+}
+
+// Client is an LDAP directory, it's the LDAP implementation of
+// directory.Provider. Calls share a bound connection, which is dialed again
+// once it's closed.
+type Client struct {
+	url          string
+	tlsConfig    *tls.Config
+	bindDN       string
+	bindPassword string
+	timeout      time.Duration
+
+	userBaseDN  string
+	userFilter  string
+	groupBaseDN string
+	groupFilter string
+	pageSize    int
+
+	membership   string
+	attributes   AttributeMapping
+	groupMapping map[string]string
+
+	mu   sync.Mutex
+	conn *ldap.Conn
+}
+
+var _ directory.Provider = (*Client)(nil)
+
+// NewClient creates an LDAP client based on the given options
+func NewClient(opts *ClientOpts) (*Client, error) {
+	for _, filter := range []string{opts.UserFilter, opts.GroupFilter} {
+		if _, err := ldap.CompileFilter(filter); err != nil {
+			return nil, errors.New("invalid LDAP filter " + filter)
+		}
+	}
+	if opts.Membership != MembershipMember && opts.Membership != MembershipMemberOf {
+		return nil, errors.New("unknown LDAP membership " + opts.Membership)
+	}
+
+	var tlsConfig *tls.Config
+	if opts.StartTLS {
+		url, err := gourl.Parse(opts.URL)
+		if err != nil {
+			return nil, errors.New("invalid LDAP URL " + opts.URL)
+		}
+		if url.Scheme != "ldap" {
+			return nil, errors.New("StartTLS needs an ldap:// URL")
+		}
+		tlsConfig = &tls.Config{ServerName: url.Hostname()}
+	}
+
+	groupMapping := make(map[string]string, len(opts.GroupMapping))
+	for dn, name := range opts.GroupMapping {
+		groupMapping[normalizeDN(dn)] = name
+	}
+
+	return &Client{
+		url:          opts.URL,
+		tlsConfig:    tlsConfig,
+		bindDN:       opts.BindDN,
+		bindPassword: opts.BindPassword,
+		timeout:      opts.Timeout,
+
+		userBaseDN:  opts.UserBaseDN,
+		userFilter:  opts.UserFilter,
+		groupBaseDN: opts.GroupBaseDN,
+		groupFilter: opts.GroupFilter,
+		pageSize:    opts.PageSize,
+
+		membership:   opts.Membership,
+		attributes:   opts.Attributes,
+		groupMapping: groupMapping,
+	}, nil
+}
+
+// normalizeDN returns a DN used to compare DNs. Only the case is normalized,
+// DNs are expected to be spelled the same by the server otherwise.
+func normalizeDN(dn string) string {
+	return strings.ToLower(dn)
+}
+
+// withConn calls f with the shared bound connection. A connection closed
+// meanwhile, ie. by an idle timeout of the server, is dialed again once.
+// Failures to connect are reported and ErrUpstreamUnavailable is returned
+// instead.
+func (c *Client) withConn(f func(conn *ldap.Conn) error) error {
+	for attempt := 0; ; attempt++ {
+		conn, err := c.connection()
+		if err != nil {
+			return err
+		}
+
+		err = f(conn)
+		if err == nil || (!conn.IsClosing() && !ldap.IsErrorWithCode(err, ldap.ErrorNetwork)) {
+			return err
+		}
+		c.drop(conn)
+		if attempt > 0 {
+			return unavailable(err)
+		}
+	}
+}
+
+// connection returns the shared connection, dialing and binding a new one if
+// there's none or it was closed.
+func (c *Client) connection() (*ldap.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil && !c.conn.IsClosing() {
+		return c.conn, nil
+	}
+
+	conn, err := ldap.DialURL(c.url, ldap.DialWithDialer(&net.Dialer{Timeout: c.timeout}))
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	conn.SetTimeout(c.timeout)
+
+	if c.tlsConfig != nil {
+		if err := conn.StartTLS(c.tlsConfig); err != nil {
+			conn.Close()
+			return nil, unavailable(err)
+		}
+	}
+	if c.bindDN != "" {
+		if err := conn.Bind(c.bindDN, c.bindPassword); err != nil {
+			conn.Close()
+			if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+				return nil, unavailable(err)
+			}
+			return nil, err
+		}
+	}
+
+	c.conn = conn
+	return conn, nil
+}
+
+// drop closes the shared connection, unless it was replaced already
+func (c *Client) drop(conn *ldap.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn.Close()
+	if c.conn == conn {
+		c.conn = nil
+	}
+}
+
+// unavailable reports a failure to reach the server
+func unavailable(err error) error {
+	log.Println("[ERROR] Failed to connect to LDAP:", err)
+	raven.CaptureError(err, nil)
+	return directory.ErrUpstreamUnavailable
+}
+
+// search returns entries matching the request, read in pages if paging is
+// enabled. Search result references are not followed.
+func (c *Client) search(conn *ldap.Conn, baseDN string, scope int, filter string, attributes []string) ([]*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(
+		baseDN, scope, ldap.NeverDerefAliases, 0, int(c.timeout.Seconds()), false,
+		filter, attributes, nil,
+	)
+
+	var result *ldap.SearchResult
+	var err error
+	if c.pageSize > 0 {
+		result, err = conn.SearchWithPaging(request, uint32(c.pageSize))
+	} else {
+		result, err = conn.Search(request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+// userAttributes are the attributes read into users
+func (c *Client) userAttributes() []string {
+	mapped := []string{
+		c.attributes.Email,
+		c.attributes.FirstName,
+		c.attributes.LastName,
+		c.attributes.EmployeeNumber,
+		c.attributes.Department,
+		c.attributes.Position,
+		c.attributes.Location,
+		c.attributes.Manager,
+		c.attributes.UserAccountControl,
+		c.attributes.AccountLock,
+	}
+
+	attributes := make([]string, 0, len(mapped))
+	for _, attribute := range mapped {
+		if attribute != "" {
+			attributes = append(attributes, attribute)
+		}
+	}
+	return attributes
+}
+
+func (c *Client) formatUser(e *ldap.Entry) directory.User {
+	value := func(attribute string) string {
+		if attribute == "" {
+			return ""
+		}
+		return e.GetEqualFoldAttributeValue(attribute)
+	}
+
+	return directory.User{
+		ID:             e.DN,
+		Status:         accountStatus(value(c.attributes.UserAccountControl), value(c.attributes.AccountLock)),
+		EmployeeNumber: value(c.attributes.EmployeeNumber),
+		FirstName:      value(c.attributes.FirstName),
+		LastName:       value(c.attributes.LastName),
+		Position:       value(c.attributes.Position),
+		Department:     value(c.attributes.Department),
+		Email:          value(c.attributes.Email),
+		Location:       value(c.attributes.Location),
+		Manager:        value(c.attributes.Manager),
+		TeamMembership: make([]string, 0),
+		BoocsekAttributes: directory.BoocsekAttributes{
+			Skills: make([]string, 0),
+		},
+	}
+}
+
+// searchUsers returns users matching filter within the user filter
+func (c *Client) searchUsers(conn *ldap.Conn, filter string) ([]directory.User, error) {
+	if filter != "" {
+		filter = "(&" + c.userFilter + filter + ")"
+	} else {
+		filter = c.userFilter
+	}
+
+	entries, err := c.search(conn, c.userBaseDN, ldap.ScopeWholeSubtree, filter, c.userAttributes())
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]directory.User, 0, len(entries))
+	for _, e := range entries {
+		if user := c.formatUser(e); user.Email != "" {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// GetUser returns a user by email.
+func (c *Client) GetUser(email string) (directory.User, error) {
+	var users []directory.User
+	err := c.withConn(func(conn *ldap.Conn) error {
+		var err error
+		users, err = c.searchUsers(conn, "("+c.attributes.Email+"="+ldap.EscapeFilter(email)+")")
+		return err
+	})
+	if err != nil {
+		return directory.User{}, err
+	}
+	if len(users) == 0 {
+		return directory.User{}, directory.ErrUserNotFound
+	}
+	return users[0], nil
+}
+
+// ListUsers returns users, or users modified since the given time. Paging
+// cookies are bound to the connection in most servers, so all pages are read
+// by a single call and the cursor is never set.
+func (c *Client) ListUsers(since time.Time, cursor string) ([]directory.User, string, error) {
+	var filter string
+	if !since.IsZero() {
+		filter = "(modifyTimestamp>=" + since.UTC().Format(ldapTimeFormat) + ")"
+	}
+
+	var users []directory.User
+	err := c.withConn(func(conn *ldap.Conn) error {
+		var err error
+		users, err = c.searchUsers(conn, filter)
+		return err
+	})
+	return users, "", err
+}
+
+// searchGroups returns IAM groups matching filter within the group filter
+func (c *Client) searchGroups(conn *ldap.Conn, filter string) ([]directory.Group, error) {
+	if filter != "" {
+		filter = "(&" + c.groupFilter + filter + ")"
+	} else {
+		filter = c.groupFilter
+	}
+
+	entries, err := c.search(conn, c.groupBaseDN, ldap.ScopeWholeSubtree, filter, []string{c.attributes.GroupName})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]directory.Group, 0, len(entries))
+	for _, e := range entries {
+		name, ok := c.groupMapping[normalizeDN(e.DN)]
+		if !ok {
+			name = e.GetEqualFoldAttributeValue(c.attributes.GroupName)
+		}
+		if strings.HasPrefix(name, directory.GroupPrefix) {
+			groups = append(groups, directory.Group{ID: e.DN, Name: name})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// ListGroups returns all IAM groups. LDAP doesn't tell when memberships
+// change, so members of all groups are fetched by each groups sync.
+func (c *Client) ListGroups() ([]directory.Group, error) {
+	var groups []directory.Group
+	err := c.withConn(func(conn *ldap.Conn) error {
+		var err error
+		groups, err = c.searchGroups(conn, "")
+		return err
+	})
+	return groups, err
+}
+
+// ListGroupMembers returns emails of members of a group. Members which aren't
+// users matched by the user filter, like nested groups, are skipped.
+func (c *Client) ListGroupMembers(group directory.Group) ([]string, error) {
+	var emails []string
+	err := c.withConn(func(conn *ldap.Conn) error {
+		var err error
+		if c.membership == MembershipMemberOf {
+			emails, err = c.listMembersOf(conn, group)
+		} else {
+			emails, err = c.listMembers(conn, group)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(emails)
+	return emails, nil
+}
+
+// listMembersOf searches users having the group in their memberOf attribute
+func (c *Client) listMembersOf(conn *ldap.Conn, group directory.Group) ([]string, error) {
+	users, err := c.searchUsers(conn, "("+c.attributes.MemberOf+"="+ldap.EscapeFilter(group.ID)+")")
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]string, 0, len(users))
+	for i := range users {
+		emails = append(emails, users[i].Email)
+	}
+	return emails, nil
+}
+
+// listMembers reads the member attribute of the group, and the emails of the
+// members by searches of users matching batches of the member DNs.
+func (c *Client) listMembers(conn *ldap.Conn, group directory.Group) ([]string, error) {
+	groupEntries, err := c.search(conn, group.ID, ldap.ScopeBaseObject, "(objectClass=*)", []string{c.attributes.Member})
+	if err != nil {
+		return nil, err
+	}
+	if len(groupEntries) == 0 {
+		return nil, nil
+	}
+
+	members := groupEntries[0].GetEqualFoldAttributeValues(c.attributes.Member)
+	emails := make([]string, 0, len(members))
+	for start := 0; start < len(members); start += memberBatchSize {
+		end := start + memberBatchSize
+		if end > len(members) {
+			end = len(members)
+		}
+
+		var filter strings.Builder
+		filter.WriteString("(|")
+		for _, dn := range members[start:end] {
+			filter.WriteString("(" + c.attributes.DN + "=" + ldap.EscapeFilter(dn) + ")")
+		}
+		filter.WriteString(")")
+
+		users, err := c.searchUsers(conn, filter.String())
+		if err != nil {
+			return nil, err
+		}
+		for i := range users {
+			emails = append(emails, users[i].Email)
+		}
+	}
+	return emails, nil
+}
+
+// ListUserGroups returns IAM groups of a user.
+func (c *Client) ListUserGroups(user directory.User) ([]directory.Group, error) {
+	if user.ID == "" {
+		var err error
+		if user, err = c.GetUser(user.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	var groups []directory.Group
+	err := c.withConn(func(conn *ldap.Conn) error {
+		var err error
+		if c.membership == MembershipMemberOf {
+			groups, err = c.listGroupsOf(conn, user)
+		} else {
+			groups, err = c.searchGroups(conn, "("+c.attributes.Member+"="+ldap.EscapeFilter(user.ID)+")")
+		}
+		return err
+	})
+	return groups, err
+}
+
+// listGroupsOf returns IAM groups listed in the memberOf attribute of the user
+func (c *Client) listGroupsOf(conn *ldap.Conn, user directory.User) ([]directory.Group, error) {
+	entries, err := c.search(conn, user.ID, ldap.ScopeBaseObject, "(objectClass=*)", []string{c.attributes.MemberOf})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	memberOf := make(map[string]bool)
+	for _, dn := range entries[0].GetEqualFoldAttributeValues(c.attributes.MemberOf) {
+		memberOf[normalizeDN(dn)] = true
+	}
+
+	allGroups, err := c.searchGroups(conn, "")
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]directory.Group, 0, len(memberOf))
+	for _, group := range allGroups {
+		if memberOf[normalizeDN(group.ID)] {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// ListChanges returns no changes, LDAP has no standard change log. Changes are
+// picked up by the syncs.
+func (c *Client) ListChanges(since time.Time, cursor string) ([]directory.Change, time.Time, string, error) {
+	return nil, time.Time{}, "", nil
+}
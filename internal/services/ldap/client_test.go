@@ -0,0 +1,245 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+	"github.com/kiwicom/iam/internal/storage"
+)
+
+const (
+	aliceDN   = "uid=alice,ou=people,dc=kiwi,dc=com"
+	bobDN     = "uid=bob,ou=people,dc=kiwi,dc=com"
+	readDN    = "cn=iam-service.read,ou=groups,dc=kiwi,dc=com"
+	writeDN   = "cn=service-writers,ou=groups,dc=kiwi,dc=com"
+	otherDN   = "cn=vpn-users,ou=groups,dc=kiwi,dc=com"
+	contactDN = "cn=contact,ou=contacts,dc=kiwi,dc=com"
+)
+
+// newTestDirectory creates a server with two users in three groups, one group
+// is mapped to an IAM group by its DN and one isn't an IAM group.
+func newTestDirectory(t *testing.T) *testServer {
+	s := newTestServer(t)
+	for _, dn := range []string{"dc=kiwi,dc=com", "ou=people,dc=kiwi,dc=com", "ou=groups,dc=kiwi,dc=com"} {
+		s.add(dn, map[string][]string{"objectClass": {"organizationalUnit"}})
+	}
+	s.add(aliceDN, map[string][]string{
+		"objectClass":     {"inetOrgPerson"},
+		"mail":            {"alice@kiwi.com"},
+		"givenName":       {"Alice"},
+		"sn":              {"Smith"},
+		"title":           {"Contractor"},
+		"memberOf":        {readDN, writeDN, otherDN},
+		"modifyTimestamp": {"20200101000000Z"},
+	})
+	s.add(bobDN, map[string][]string{
+		"objectClass":     {"inetOrgPerson"},
+		"mail":            {"bob@kiwi.com"},
+		"memberOf":        {readDN},
+		"modifyTimestamp": {"20200301000000Z"},
+	})
+	s.add(readDN, map[string][]string{
+		"objectClass": {"groupOfNames"},
+		"cn":          {"iam-service.read"},
+		"member":      {aliceDN, bobDN, contactDN},
+	})
+	s.add(writeDN, map[string][]string{
+		"objectClass": {"groupOfNames"},
+		"cn":          {"service-writers"},
+		"member":      {aliceDN},
+	})
+	s.add(otherDN, map[string][]string{
+		"objectClass": {"groupOfNames"},
+		"cn":          {"vpn-users"},
+		"member":      {aliceDN},
+	})
+	return s
+}
+
+func newTestClient(t *testing.T, s *testServer, membership string) *Client {
+	client, err := NewClient(&ClientOpts{
+		URL:          s.URL(),
+		BindDN:       s.bindDN,
+		BindPassword: s.password,
+		Timeout:      time.Second,
+		UserBaseDN:   "ou=people,dc=kiwi,dc=com",
+		UserFilter:   "(objectClass=inetOrgPerson)",
+		GroupBaseDN:  "ou=groups,dc=kiwi,dc=com",
+		GroupFilter:  "(objectClass=groupOfNames)",
+		PageSize:     1,
+		Membership:   membership,
+		Attributes:   DefaultAttributeMapping,
+		GroupMapping: map[string]string{"CN=service-writers,ou=groups,dc=kiwi,dc=com": "iam-service.write"},
+	})
+	require.NoError(t, err)
+	return client
+}
+
+func TestGetUser(t *testing.T) {
+	s := newTestDirectory(t)
+	defer s.Close()
+	client := newTestClient(t, s, MembershipMember)
+
+	user, err := client.GetUser("Alice@kiwi.com")
+	require.NoError(t, err)
+	assert.Equal(t, aliceDN, user.ID)
+	assert.Equal(t, "alice@kiwi.com", user.Email)
+	assert.Equal(t, "Alice", user.FirstName)
+	assert.Equal(t, "Smith", user.LastName)
+	assert.Equal(t, "Contractor", user.Position)
+	assert.Equal(t, directory.StatusActive, user.Status)
+
+	s.add("cn=carol,ou=people,dc=kiwi,dc=com", map[string][]string{
+		"objectClass":        {"inetOrgPerson"},
+		"mail":               {"carol@kiwi.com"},
+		"userAccountControl": {"514"},
+	})
+	user, err = client.GetUser("carol@kiwi.com")
+	require.NoError(t, err)
+	assert.Equal(t, directory.StatusSuspended, user.Status, "Disabled accounts are not active")
+
+	_, err = client.GetUser("unknown@kiwi.com")
+	assert.Equal(t, directory.ErrUserNotFound, err)
+
+	_, err = client.GetUser("*")
+	assert.Equal(t, directory.ErrUserNotFound, err, "Values are escaped in filters")
+
+	client = newTestClient(t, s, MembershipMember)
+	client.bindPassword = "wrong"
+	_, err = client.GetUser("alice@kiwi.com")
+	assert.True(t, ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials), "Bind failures are returned")
+}
+
+func TestConnectionReuse(t *testing.T) {
+	s := newTestDirectory(t)
+	defer s.Close()
+	client := newTestClient(t, s, MembershipMember)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.GetUser("alice@kiwi.com")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, s.binds, "The bound connection is shared by calls")
+
+	s.disconnect()
+	_, err := client.GetUser("alice@kiwi.com")
+	require.NoError(t, err, "Closed connections are dialed again")
+	assert.Equal(t, 2, s.binds)
+}
+
+func TestStartTLS(t *testing.T) {
+	s := newTestDirectory(t)
+	defer s.Close()
+	client, err := NewClient(&ClientOpts{
+		URL:         s.URL(),
+		StartTLS:    true,
+		UserFilter:  "(objectClass=inetOrgPerson)",
+		GroupFilter: "(objectClass=groupOfNames)",
+		Membership:  MembershipMember,
+		Attributes:  DefaultAttributeMapping,
+	})
+	require.NoError(t, err)
+
+	_, err = client.GetUser("alice@kiwi.com")
+	assert.Equal(t, directory.ErrUpstreamUnavailable, err, "Connections aren't used when StartTLS fails")
+	assert.Equal(t, 0, s.searches)
+}
+
+func TestGetUserUnavailable(t *testing.T) {
+	s := newTestDirectory(t)
+	client := newTestClient(t, s, MembershipMember)
+	s.Close()
+
+	_, err := client.GetUser("alice@kiwi.com")
+	assert.Equal(t, directory.ErrUpstreamUnavailable, err)
+}
+
+func TestListUsers(t *testing.T) {
+	s := newTestDirectory(t)
+	defer s.Close()
+	client := newTestClient(t, s, MembershipMember)
+
+	users, cursor, err := client.ListUsers(time.Time{}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "", cursor)
+	require.Len(t, users, 2)
+	assert.Equal(t, "alice@kiwi.com", users[0].Email)
+	assert.Equal(t, "bob@kiwi.com", users[1].Email)
+	assert.Equal(t, 1, s.pages, "Users are read in pages")
+
+	users, _, err = client.ListUsers(time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), "")
+	require.NoError(t, err)
+	require.Len(t, users, 1, "Only users modified since are listed")
+	assert.Equal(t, "bob@kiwi.com", users[0].Email)
+}
+
+func TestGroups(t *testing.T) {
+	for _, membership := range []string{MembershipMember, MembershipMemberOf} {
+		s := newTestDirectory(t)
+		client := newTestClient(t, s, membership)
+
+		groups, err := client.ListGroups()
+		require.NoError(t, err, membership)
+		assert.Equal(t, []directory.Group{
+			{ID: readDN, Name: "iam-service.read"},
+			{ID: writeDN, Name: "iam-service.write"},
+		}, groups, "Groups are named by mapping or their name, %s", membership)
+
+		searches := s.searches
+		members, err := client.ListGroupMembers(groups[0])
+		require.NoError(t, err, membership)
+		assert.Equal(t, []string{"alice@kiwi.com", "bob@kiwi.com"}, members, membership)
+		assert.LessOrEqual(t, s.searches-searches, 2, "Members are not searched one by one, %s", membership)
+
+		userGroups, err := client.ListUserGroups(directory.User{Email: "alice@kiwi.com"})
+		require.NoError(t, err, membership)
+		assert.Equal(t, groups, userGroups, membership)
+
+		userGroups, err = client.ListUserGroups(directory.User{ID: bobDN, Email: "bob@kiwi.com"})
+		require.NoError(t, err, membership)
+		assert.Equal(t, groups[:1], userGroups, membership)
+
+		s.Close()
+	}
+}
+
+func TestDirectoryPermissions(t *testing.T) {
+	s := newTestDirectory(t)
+	defer s.Close()
+	cache := storage.NewInMemoryCache()
+	d := directory.New(&directory.Opts{
+		Name:        "ldap",
+		Provider:    newTestClient(t, s, MembershipMemberOf),
+		Cache:       cache,
+		LockManager: storage.NewLockManager(cache, time.Millisecond, time.Second),
+	})
+
+	d.SyncUsers()
+	d.SyncGroups()
+
+	user, err := d.GetUser("alice@kiwi.com")
+	require.NoError(t, err)
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.ElementsMatch(t, []string{"read", "write"}, user.Permissions)
+
+	user, err = d.GetUser("bob@kiwi.com")
+	require.NoError(t, err)
+	require.NoError(t, d.AddPermissions(&user, "service"))
+	assert.Equal(t, []string{"read"}, user.Permissions)
+}
+
+func TestNewClientValidation(t *testing.T) {
+	_, err := NewClient(&ClientOpts{UserFilter: "(objectClass=*)", GroupFilter: "objectClass=*", Membership: MembershipMember})
+	assert.Error(t, err)
+
+	_, err = NewClient(&ClientOpts{UserFilter: "(objectClass=*)", GroupFilter: "(objectClass=*)", Membership: "nested"})
+	assert.Error(t, err)
+
+	_, err = NewClient(&ClientOpts{URL: "ldaps://ldap.kiwi.com", StartTLS: true, UserFilter: "(objectClass=*)", GroupFilter: "(objectClass=*)", Membership: MembershipMember})
+	assert.Error(t, err, "StartTLS needs a plain connection")
+}
@@ -0,0 +1,115 @@
+package ldap
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// AttributeMapping contains names of LDAP attributes read into users and
+// groups. Fields of users mapped to an empty name are left empty.
+type AttributeMapping struct {
+	Email          string `json:"email"`
+	FirstName      string `json:"firstName"`
+	LastName       string `json:"lastName"`
+	EmployeeNumber string `json:"employeeNumber"`
+	Department     string `json:"department"`
+	Position       string `json:"position"`
+	Location       string `json:"location"`
+	Manager        string `json:"manager"`
+	// UserAccountControl is the Active Directory attribute of users whose
+	// ACCOUNTDISABLE flag marks disabled accounts
+	UserAccountControl string `json:"userAccountControl"`
+	// AccountLock is the attribute of users set to true for disabled accounts,
+	// nsAccountLock in 389 Directory Server and OpenDJ
+	AccountLock string `json:"accountLock"`
+	// GroupName is the attribute of groups containing their name
+	GroupName string `json:"groupName"`
+	// Member is the attribute of groups listing DNs of their members
+	Member string `json:"member"`
+	// MemberOf is the attribute of users listing DNs of their groups
+	MemberOf string `json:"memberOf"`
+	// DN is the attribute of users containing their DN, members of groups are
+	// searched by it. It's entryDN in most servers, distinguishedName in
+	// Active Directory.
+	DN string `json:"dn"`
+}
+
+// DefaultAttributeMapping maps attributes of the inetOrgPerson and
+// groupOfNames object classes.
+var DefaultAttributeMapping = AttributeMapping{
+	Email:          "mail",
+	FirstName:      "givenName",
+	LastName:       "sn",
+	EmployeeNumber: "employeeNumber",
+	Department:     "departmentNumber",
+	Position:       "title",
+	Location:       "l",
+	Manager:        "manager",
+	GroupName:      "cn",
+	Member:         "member",
+	MemberOf:       "memberOf",
+	DN:             "entryDN",
+
+	UserAccountControl: "userAccountControl",
+	AccountLock:        "nsAccountLock",
+}
+
+// accountDisable is the ACCOUNTDISABLE flag of userAccountControl
+// https://docs.microsoft.com/en-us/troubleshoot/windows-server/identity/useraccountcontrol-manipulate-account-properties
+const accountDisable = 0x2
+
+// accountStatus returns the status of a user with the given values of the
+// userAccountControl and account lock attributes. Disabled accounts are
+// suspended, users without these attributes are active.
+func accountStatus(userAccountControl, accountLock string) string {
+	if flags, err := strconv.ParseInt(userAccountControl, 10, 64); err == nil && flags&accountDisable != 0 {
+		return directory.StatusSuspended
+	}
+	if strings.EqualFold(accountLock, "true") {
+		return directory.StatusSuspended
+	}
+	return directory.StatusActive
+}
+
+// ParseAttributeMapping parses a JSON object overriding attributes of the
+// default mapping, ie. {"department": "department", "location": ""}
+func ParseAttributeMapping(mapping string) (AttributeMapping, error) {
+	attributes := DefaultAttributeMapping
+	if strings.TrimSpace(mapping) == "" {
+		return attributes, nil
+	}
+
+	if err := json.Unmarshal([]byte(mapping), &attributes); err != nil {
+		return AttributeMapping{}, errors.New("invalid LDAP attribute mapping: " + err.Error())
+	}
+	if attributes.Email == "" || attributes.GroupName == "" {
+		return AttributeMapping{}, errors.New("LDAP attribute mapping needs email and group name attributes")
+	}
+	return attributes, nil
+}
+
+// ParseGroupMapping parses a JSON object mapping DNs of groups to names of IAM
+// groups, ie. {"cn=billing,ou=groups,dc=kiwi,dc=com": "iam-billing.read"}
+func ParseGroupMapping(mapping string) (map[string]string, error) {
+	if strings.TrimSpace(mapping) == "" {
+		return nil, nil
+	}
+
+	var groups map[string]string
+	if err := json.Unmarshal([]byte(mapping), &groups); err != nil {
+		return nil, errors.New("invalid LDAP group mapping: " + err.Error())
+	}
+	for dn, name := range groups {
+		if !strings.HasPrefix(name, directory.GroupPrefix) {
+			return nil, errors.New("LDAP group " + dn + " is not mapped to an IAM group")
+		}
+	}
+	return groups, nil
+}
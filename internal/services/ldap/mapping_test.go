@@ -0,0 +1,43 @@
+package ldap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+func TestParseAttributeMapping(t *testing.T) {
+	attributes, err := ParseAttributeMapping("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultAttributeMapping, attributes)
+
+	attributes, err = ParseAttributeMapping(`{"department": "department", "location": ""}`)
+	require.NoError(t, err)
+	assert.Equal(t, "department", attributes.Department)
+	assert.Equal(t, "", attributes.Location)
+	assert.Equal(t, "mail", attributes.Email, "Attributes which are not set are kept")
+
+	_, err = ParseAttributeMapping(`{"email": ""}`)
+	assert.Error(t, err)
+	_, err = ParseAttributeMapping(`["mail"]`)
+	assert.Error(t, err)
+}
+
+func TestAccountStatus(t *testing.T) {
+	assert.Equal(t, directory.StatusActive, accountStatus("", ""))
+	assert.Equal(t, directory.StatusActive, accountStatus("512", "false"), "Normal account")
+	assert.Equal(t, directory.StatusSuspended, accountStatus("514", ""), "Disabled Active Directory account")
+	assert.Equal(t, directory.StatusSuspended, accountStatus("", "TRUE"), "Locked account")
+}
+
+func TestParseGroupMapping(t *testing.T) {
+	groups, err := ParseGroupMapping(`{"cn=billing,ou=groups,dc=kiwi,dc=com": "iam-billing.read"}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"cn=billing,ou=groups,dc=kiwi,dc=com": "iam-billing.read"}, groups)
+
+	_, err = ParseGroupMapping(`{"cn=billing,ou=groups,dc=kiwi,dc=com": "billing"}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,344 @@
+package ldap
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+// entry is an entry served by the test server
+type entry struct {
+	DN string
+	// attributes by lower-cased names
+	attributes map[string][]string
+}
+
+func (e *entry) values(name string) []string {
+	return e.attributes[strings.ToLower(name)]
+}
+
+// testServer is an in-process LDAP server serving entries from memory. It
+// supports simple binds and paged searches, searches require a bind. Entries
+// have their DN in the entryDN attribute.
+type testServer struct {
+	listener net.Listener
+	bindDN   string
+	password string
+
+	mu      sync.Mutex
+	entries []*entry
+	conns   []net.Conn
+	// binds counts the successful binds
+	binds int
+	// searches counts the searches, requests of further pages are not
+	// counted, pages counts the searches which were answered with a paging
+	// cookie
+	searches int
+	pages    int
+}
+
+func newTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testServer{
+		listener: listener,
+		bindDN:   "cn=iam,dc=kiwi,dc=com",
+		password: "secret",
+	}
+	go s.serve()
+	return s
+}
+
+func (s *testServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testServer) Close() {
+	_ = s.listener.Close()
+}
+
+// add adds an entry, attribute names are case insensitive
+func (s *testServer) add(dn string, attributes map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := &entry{DN: dn, attributes: map[string][]string{"entrydn": {dn}}}
+	for name, values := range attributes {
+		e.attributes[strings.ToLower(name)] = values
+	}
+	s.entries = append(s.entries, e)
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// disconnect closes the open connections, like servers closing idle ones
+func (s *testServer) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	bound := false
+	for {
+		message, err := ber.ReadPacket(conn)
+		if err != nil || len(message.Children) < 2 {
+			return
+		}
+		id := message.Children[0].Value.(int64)
+		request := message.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, _ := request.Children[1].Value.(string)
+			password := request.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			if dn == s.bindDN && password == s.password {
+				code, bound = ldap.LDAPResultSuccess, true
+				s.mu.Lock()
+				s.binds++
+				s.mu.Unlock()
+			}
+			writeMessage(conn, id, newResult(ldap.ApplicationBindResponse, code), nil)
+
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				writeMessage(conn, id, newResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights), nil)
+				continue
+			}
+			s.search(conn, id, message)
+
+		default:
+			return
+		}
+	}
+}
+
+func (s *testServer) search(conn net.Conn, id int64, message *ber.Packet) {
+	request := message.Children[1]
+	baseDN := normalizeDN(request.Children[0].Value.(string))
+	scope := request.Children[1].Value.(int64)
+	filter := request.Children[6]
+	var attributes []string
+	for _, attribute := range request.Children[7].Children {
+		attributes = append(attributes, strings.ToLower(attribute.Value.(string)))
+	}
+
+	size, offset := 0, 0
+	if len(message.Children) > 2 {
+		size, offset = pagingRequest(message.Children[2])
+	}
+
+	s.mu.Lock()
+	if offset == 0 {
+		s.searches++
+	}
+	var matched []*entry
+	baseFound := false
+	for _, e := range s.entries {
+		dn := normalizeDN(e.DN)
+		if dn == baseDN {
+			baseFound = true
+		}
+		inScope := dn == baseDN || (scope == ldap.ScopeWholeSubtree && strings.HasSuffix(dn, ","+baseDN))
+		if inScope && matchFilter(filter, e) {
+			matched = append(matched, e)
+		}
+	}
+	s.mu.Unlock()
+
+	if !baseFound {
+		writeMessage(conn, id, newResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject), nil)
+		return
+	}
+
+	var controls *ber.Packet
+	if size > 0 {
+		end, cookie := offset+size, ""
+		if end < len(matched) {
+			cookie = strconv.Itoa(end)
+			s.mu.Lock()
+			s.pages++
+			s.mu.Unlock()
+		} else {
+			end = len(matched)
+		}
+		matched = matched[offset:end]
+		paging := ldap.NewControlPaging(uint32(size))
+		paging.SetCookie([]byte(cookie))
+		controls = ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		controls.AppendChild(paging.Encode())
+	}
+
+	for _, e := range matched {
+		writeMessage(conn, id, newEntry(e, attributes), nil)
+	}
+	writeMessage(conn, id, newResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess), controls)
+}
+
+// pagingRequest returns the page size and offset requested by the paging
+// control, the cookie is the offset.
+func pagingRequest(controls *ber.Packet) (size, offset int) {
+	for _, control := range controls.Children {
+		if control.Children[0].Value != ldap.ControlTypePaging {
+			continue
+		}
+		value := ber.DecodePacket(control.Children[len(control.Children)-1].ByteValue)
+		offset, _ = strconv.Atoi(string(value.Children[1].ByteValue))
+		return int(value.Children[0].Value.(int64)), offset
+	}
+	return 0, 0
+}
+
+func newResult(tag ber.Tag, code int64) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return result
+}
+
+func newEntry(e *entry, attributes []string) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+
+	partialAttributes := ber.NewSequence("Attributes")
+	for name, values := range e.attributes {
+		if len(attributes) > 0 && !contains(attributes, name) {
+			continue
+		}
+		attribute := ber.NewSequence("Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		partialAttributes.AppendChild(attribute)
+	}
+	packet.AppendChild(partialAttributes)
+	return packet
+}
+
+func writeMessage(conn net.Conn, id int64, operation, controls *ber.Packet) {
+	message := ber.NewSequence("LDAP Message")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	message.AppendChild(operation)
+	if controls != nil {
+		message.AppendChild(controls)
+	}
+	_, _ = conn.Write(message.Bytes())
+}
+
+// matchFilter evaluates a compiled filter against an entry. Values are
+// compared case insensitively.
+func matchFilter(filter *ber.Packet, e *entry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchFilter(child, e) {
+				return false
+			}
+		}
+		return true
+
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchFilter(child, e) {
+				return true
+			}
+		}
+		return false
+
+	case ldap.FilterNot:
+		return !matchFilter(filter.Children[0], e)
+
+	case ldap.FilterPresent:
+		return len(e.values(filter.Data.String())) > 0
+
+	case ldap.FilterSubstrings:
+		for _, value := range e.values(filter.Children[0].Value.(string)) {
+			if matchSubstrings(filter.Children[1].Children, strings.ToLower(value)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	asserted := strings.ToLower(filter.Children[1].Value.(string))
+	for _, value := range e.values(filter.Children[0].Value.(string)) {
+		value = strings.ToLower(value)
+		switch filter.Tag {
+		case ldap.FilterEqualityMatch, ldap.FilterApproxMatch:
+			if value == asserted {
+				return true
+			}
+		case ldap.FilterGreaterOrEqual:
+			if value >= asserted {
+				return true
+			}
+		case ldap.FilterLessOrEqual:
+			if value <= asserted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchSubstrings(substrings []*ber.Packet, value string) bool {
+	for _, substring := range substrings {
+		part := strings.ToLower(substring.Data.String())
+		switch substring.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, part) {
+				return false
+			}
+			value = value[len(part):]
+		case ldap.FilterSubstringsAny:
+			i := strings.Index(value, part)
+			if i < 0 {
+				return false
+			}
+			value = value[i+len(part):]
+		case ldap.FilterSubstringsFinal:
+			if !strings.HasSuffix(value, part) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package okta
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	gourl "net/url"
+	"strconv"
+	"sync"
+
+	"github.com/kiwicom/iam/internal/monitoring"
+)
+
+// Interaction is a request sent to Okta and its response
+type Interaction struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	// Error is set instead of the response if the request failed
+	Error string `json:"error,omitempty"`
+}
+
+// Cassette holds interactions with Okta recorded by a Recorder, to be served
+// by a Replayer.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads a cassette from a file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, err
+	}
+	return &cassette, nil
+}
+
+// Save writes the cassette to a file
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Recorder records interactions with Okta into a sanitized Cassette. Tokens are
+// never recorded, emails and PII attributes are replaced by pseudonyms, and the
+// host of the Okta organization by CassetteHost.
+type Recorder struct {
+	fetch     Fetcher
+	sanitizer *sanitizer
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder creates a Recorder sending requests with fetch. PII attributes
+// are scrubbed on top of DefaultPIIAttributes, like private profile attributes.
+func NewRecorder(fetch Fetcher, piiAttributes ...string) *Recorder {
+	return &Recorder{
+		fetch:     fetch,
+		sanitizer: newSanitizer(piiAttributes),
+	}
+}
+
+// CustomFetcher records requests sent by the default fetcher, to be used as
+// ClientOpts.CustomFetcher.
+func (r *Recorder) CustomFetcher(userAgent string, metrics *monitoring.Metrics) Fetcher {
+	if r.fetch == nil {
+		r.fetch = defaultFetcher(userAgent, "okta", metrics)
+	}
+	return r.Fetch
+}
+
+// Fetch sends a request and records it with its response
+func (r *Recorder) Fetch(req Request) (*Response, error) {
+	res, err := r.fetch(req)
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	interaction := Interaction{
+		Method: method,
+		URL:    r.sanitizer.URL(req.URL),
+	}
+	if err != nil {
+		interaction.Error = r.sanitizer.text(err.Error())
+	}
+	if err == nil && res != nil && res.Response != nil {
+		body, readErr := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if readErr != nil {
+			return nil, readErr
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		interaction.Status = res.StatusCode
+		interaction.Header = r.sanitizer.Header(res.Header)
+		interaction.Body = r.sanitizer.Body(body)
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mu.Unlock()
+	return res, err
+}
+
+// Cassette returns the interactions recorded so far
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return &Cassette{Interactions: append([]Interaction(nil), r.cassette.Interactions...)}
+}
+
+// ErrInteractionNotFound is returned by a Replayer for requests which were not
+// recorded, or which were already replayed as many times as they were recorded.
+var ErrInteractionNotFound = errors.New("interaction not found in cassette")
+
+// Replayer serves recorded interactions without network access. Each
+// interaction is served once, in the recorded order, so retries and changing
+// data are replayed as they happened.
+type Replayer struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// NewReplayer creates a Replayer of the interactions of a cassette
+func NewReplayer(cassette *Cassette) *Replayer {
+	return &Replayer{
+		cassette: cassette,
+		used:     make([]bool, len(cassette.Interactions)),
+	}
+}
+
+// CustomFetcher returns Fetch, to be used as ClientOpts.CustomFetcher
+func (r *Replayer) CustomFetcher(string, *monitoring.Metrics) Fetcher {
+	return r.Fetch
+}
+
+// Fetch serves the recorded response of a request. Requests are matched by
+// method, path and query, ignoring the host. Requests whose query changes
+// between runs, like times in filters, fall back to the interactions of the
+// same path when no interaction matches the query.
+func (r *Replayer) Fetch(req Request) (*Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path, query := replayKey(req.URL)
+
+	match := -1
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || interaction.Method != method {
+			continue
+		}
+		interactionPath, interactionQuery := replayKey(interaction.URL)
+		if interactionPath != path {
+			continue
+		}
+		if interactionQuery == query {
+			match = i
+			break
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+	if match < 0 {
+		log.Println("[ERROR]", method, req.URL, "was not recorded")
+		return nil, ErrInteractionNotFound
+	}
+	r.used[match] = true
+
+	interaction := r.cassette.Interactions[match]
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+	header := http.Header{}
+	for name, values := range interaction.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	return &Response{&http.Response{
+		Status:        strconv.Itoa(interaction.Status) + " " + http.StatusText(interaction.Status),
+		StatusCode:    interaction.Status,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(interaction.Body))),
+		ContentLength: int64(len(interaction.Body)),
+	}}, nil
+}
+
+// Unused returns the interactions which were not replayed
+func (r *Replayer) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Interaction
+	for i, used := range r.used {
+		if !used {
+			unused = append(unused, r.cassette.Interactions[i])
+		}
+	}
+	return unused
+}
+
+// replayKey returns the path and the sorted query of a URL
+func replayKey(rawURL string) (string, string) {
+	u, err := gourl.Parse(rawURL)
+	if err != nil {
+		return rawURL, ""
+	}
+	return u.Path, u.Query().Encode()
+}
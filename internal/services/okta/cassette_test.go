@@ -0,0 +1,136 @@
+package okta
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/kiwicom/iam/configs"
+	"github.com/kiwicom/iam/internal/services/directory"
+	"github.com/kiwicom/iam/internal/services/okta/oktatest"
+)
+
+func TestSanitizer(t *testing.T) {
+	s := newSanitizer([]string{"costCenter"})
+
+	url := s.URL("https://kiwi.okta.com/api/v1/users/Alice@kiwi.com?limit=2&filter=" +
+		"profile.email+eq+%22bob%40kiwi.com%22")
+	assert.Equal(t, "https://okta.example.com/api/v1/users/user-1@kiwi.com?filter=profile.email+eq+%22user-2%40kiwi.com%22&limit=2", url)
+
+	body := s.Body([]byte(`{
+		"id": "00u1",
+		"profile": {"login": "alice@kiwi.com", "firstName": "Alice", "manager": "Bob Smith", "costCenter": 42,
+			"boocsek_kiwibase_id": 42, "employeeNumber": ""},
+		"_links": {"self": {"href": "https://kiwi.okta.com/api/v1/users/00u1"}},
+		"access_token": "secret"
+	}`))
+	assert.JSONEq(t, `{
+		"id": "00u1",
+		"profile": {"login": "user-1@kiwi.com", "firstName": "person-4", "manager": "person-5", "costCenter": 3,
+			"boocsek_kiwibase_id": 3, "employeeNumber": ""},
+		"_links": {"self": {"href": "https://okta.example.com/api/v1/users/00u1"}},
+		"access_token": "REDACTED"
+	}`, body, "The same values get the same pseudonyms")
+
+	assert.Equal(t, "Invalid user@kiwi", s.Body([]byte("Invalid user@kiwi")))
+	header := s.Header(http.Header{
+		"Link":          {`<https://kiwi.okta.com/api/v1/users?after=00u1>; rel="next"`},
+		"Set-Cookie":    {"sid=secret"},
+		"Authorization": {"SSWS token"},
+	})
+	assert.Equal(t, http.Header{"Link": {`<https://okta.example.com/api/v1/users?after=00u1>; rel="next"`}}, header)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	fake := oktatest.NewServer()
+	fake.PageSize = 2
+	require.NoError(t, fake.Load([]byte(fakeFixture)))
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	retries := &cfg.OktaConfig{MaxRetries: 1, RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Millisecond}
+	recorder := NewRecorder(nil)
+	client := NewClient(&ClientOpts{
+		BaseURL:       ts.URL + "/api/v1",
+		AuthToken:     "SSWS secret-token",
+		OktaConfig:    retries,
+		CustomFetcher: recorder.CustomFetcher,
+	})
+	fake.AddFault(oktatest.Fault{Path: "/groups", Status: http.StatusServiceUnavailable, Times: 1})
+	_, err := client.ListGroups()
+	require.NoError(t, err)
+	recorded := listDirectory(t, client)
+	assert.Equal(t, "alice@kiwi.com", recorded.user.Email)
+
+	dir, err := ioutil.TempDir("", "cassette")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+	require.NoError(t, recorder.Cassette().Save(path))
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	for _, secret := range []string{"alice", "bob", "carol", "Alice", "secret-token", strings.TrimPrefix(ts.URL, "http://")} {
+		assert.NotContains(t, string(content), secret)
+	}
+
+	cassette, err := LoadCassette(path)
+	require.NoError(t, err)
+	replayer := NewReplayer(cassette)
+	client = NewClient(&ClientOpts{
+		BaseURL:       "https://" + CassetteHost + "/api/v1",
+		CustomFetcher: replayer.CustomFetcher,
+		OktaConfig:    retries,
+	})
+	groups, err := client.ListGroups()
+	require.NoError(t, err, "The recorded failure is replayed and retried")
+	assert.Len(t, groups, 2)
+	assert.Equal(t, http.StatusServiceUnavailable, cassette.Interactions[0].Status)
+
+	replayed := listDirectory(t, client)
+	assert.Len(t, replayed.users, len(recorded.users))
+	assert.Equal(t, recorded.groups, replayed.groups, "Replayed interactions return the recorded data")
+	assert.True(t, strings.HasPrefix(replayed.user.FirstName, "person-"), "Names are replaced")
+	assert.Equal(t, replayed.users[0], replayed.user, "References between resources are kept")
+	assert.Contains(t, replayed.members, replayed.user.Email)
+	assert.Empty(t, replayer.Unused())
+
+	_, err = client.GetUser(replayed.user.Email)
+	assert.Equal(t, ErrInteractionNotFound, err, "Interactions are replayed once")
+}
+
+type directoryListing struct {
+	users   []directory.User
+	user    directory.User
+	groups  []directory.Group
+	members []string
+}
+
+// listDirectory lists users, the first user, groups and members of the first
+// group like syncs do
+func listDirectory(t *testing.T, client *Client) directoryListing {
+	var listing directoryListing
+	for cursor, pages := "", 0; pages == 0 || cursor != ""; pages++ {
+		users, next, err := client.ListUsers(time.Time{}, cursor)
+		require.NoError(t, err)
+		listing.users = append(listing.users, users...)
+		cursor = next
+	}
+
+	var err error
+	listing.user, err = client.GetUser(listing.users[0].Email)
+	require.NoError(t, err)
+	listing.groups, err = client.ListGroups()
+	require.NoError(t, err)
+	listing.members, err = client.ListGroupMembers(listing.groups[0])
+	require.NoError(t, err)
+	return listing
+}
@@ -0,0 +1,186 @@
+package okta
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	cfg "github.com/kiwicom/iam/configs"
+	"github.com/kiwicom/iam/internal/monitoring"
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// ErrUpstreamUnavailable is returned when a request is not sent to Okta because
+// the circuit breaker is open after too many consecutive failures.
+var ErrUpstreamUnavailable = directory.ErrUpstreamUnavailable
+
+// BreakerState is the state of the circuit breaker around Okta requests
+type BreakerState int
+
+// Circuit breaker states
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops sending requests to Okta after a number of consecutive
+// failures. Once the open timeout is over, a single probe request is allowed
+// (half-open state), and depending on its result the breaker closes or opens
+// again.
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       BreakerState
+	failures    int
+	probing     bool
+	openedAt    time.Time
+	threshold   int
+	openTimeout time.Duration
+	metrics     *monitoring.Metrics
+	now         func() time.Time
+}
+
+func newCircuitBreaker(oktaConfig *cfg.OktaConfig, metrics *monitoring.Metrics) *circuitBreaker {
+	b := &circuitBreaker{
+		metrics: metrics,
+		now:     time.Now,
+	}
+	if oktaConfig != nil {
+		b.threshold = oktaConfig.BreakerFailureThreshold
+		b.openTimeout = oktaConfig.BreakerOpenTimeout
+	}
+	return b
+}
+
+// allow returns whether a request can be sent to Okta.
+func (b *circuitBreaker) allow() bool {
+	if b.threshold <= 0 {
+		// Circuit breaker is disabled
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.transition(BreakerHalfOpen)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			// Only one probe request is allowed at a time
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the result of a request sent to Okta.
+func (b *circuitBreaker) record(success bool) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		b.probing = false
+		if success {
+			b.failures = 0
+			b.transition(BreakerClosed)
+		} else {
+			b.openedAt = b.now()
+			b.transition(BreakerOpen)
+		}
+	case BreakerClosed:
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openedAt = b.now()
+			b.transition(BreakerOpen)
+		}
+	}
+}
+
+// transition changes the breaker state, the caller must hold the lock.
+func (b *circuitBreaker) transition(to BreakerState) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+
+	log.Println("Okta circuit breaker changed from", from, "to", to)
+	b.metrics.Incr(
+		"okta.circuit_breaker.transition",
+		monitoring.Tag("from", from.String()),
+		monitoring.Tag("to", to.String()),
+	)
+	var open float64
+	if to == BreakerOpen {
+		open = 1
+	}
+	b.metrics.Gauge("okta.circuit_breaker.open", open)
+}
+
+// State returns the current state of the breaker.
+func (b *circuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.openTimeout {
+		// The next request will be let through as a probe
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// isUpstreamFailure returns whether a response means that Okta is not working
+// properly. Client errors (ie. 404) are a sign of a healthy upstream.
+func isUpstreamFailure(res *Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	if res == nil || res.Response == nil {
+		return false
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError
+}
+
+// circuitBreakerFetcher wraps a Fetcher to fail fast with ErrUpstreamUnavailable
+// while the circuit breaker is open.
+func circuitBreakerFetcher(fetch Fetcher, breaker *circuitBreaker) Fetcher {
+	return func(req Request) (*Response, error) {
+		if !breaker.allow() {
+			return nil, ErrUpstreamUnavailable
+		}
+
+		res, err := fetch(req)
+		breaker.record(!isUpstreamFailure(res, err))
+		return res, err
+	}
+}
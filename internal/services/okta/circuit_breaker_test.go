@@ -0,0 +1,81 @@
+package okta
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	cfg "github.com/kiwicom/iam/configs"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Unix(1000, 0)
+	breaker := newCircuitBreaker(&cfg.OktaConfig{
+		BreakerFailureThreshold: 2,
+		BreakerOpenTimeout:      time.Minute,
+	}, nil)
+	breaker.now = func() time.Time { return now }
+
+	assert.True(t, breaker.allow())
+	breaker.record(false)
+	assert.Equal(t, BreakerClosed, breaker.State(), "Stays closed under the threshold")
+
+	assert.True(t, breaker.allow())
+	breaker.record(false)
+	assert.Equal(t, BreakerOpen, breaker.State(), "Opens after reaching the threshold")
+	assert.False(t, breaker.allow(), "Requests fail fast while open")
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	assert.True(t, breaker.allow(), "A probe is let through after the timeout")
+	assert.False(t, breaker.allow(), "Only one probe at a time")
+
+	breaker.record(false)
+	assert.Equal(t, BreakerOpen, breaker.State(), "Failed probe opens the breaker again")
+
+	now = now.Add(time.Minute)
+	assert.True(t, breaker.allow())
+	breaker.record(true)
+	assert.Equal(t, BreakerClosed, breaker.State(), "Successful probe closes the breaker")
+	assert.True(t, breaker.allow())
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breaker := newCircuitBreaker(nil, nil)
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, breaker.allow())
+		breaker.record(false)
+	}
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestCircuitBreakerFetcher(t *testing.T) {
+	breaker := newCircuitBreaker(&cfg.OktaConfig{
+		BreakerFailureThreshold: 1,
+		BreakerOpenTimeout:      time.Minute,
+	}, nil)
+
+	var calls int
+	fetch := circuitBreakerFetcher(func(req Request) (*Response, error) {
+		calls++
+		if req.URL == "not-found" {
+			return &Response{&http.Response{StatusCode: http.StatusNotFound}}, nil
+		}
+		return nil, errors.New("connection refused")
+	}, breaker)
+
+	_, err := fetch(Request{URL: "not-found"})
+	assert.NoError(t, err)
+	assert.Equal(t, BreakerClosed, breaker.State(), "Client errors don't open the breaker")
+
+	_, err = fetch(Request{URL: "failing"})
+	assert.EqualError(t, err, "connection refused")
+
+	_, err = fetch(Request{URL: "failing"})
+	assert.Equal(t, ErrUpstreamUnavailable, err)
+	assert.Equal(t, 2, calls, "Request is not sent while the breaker is open")
+}
@@ -1,53 +1,51 @@
 package okta
 
 import (
+	"errors"
+	"fmt"
 	"log"
-	"time"
 
 	"github.com/getsentry/raven-go"
 	"github.com/kiwicom/go-useragent"
 
 	cfg "github.com/kiwicom/iam/configs"
 	"github.com/kiwicom/iam/internal/monitoring"
-	"github.com/kiwicom/iam/internal/storage"
-
-	"golang.org/x/sync/singleflight"
+	"github.com/kiwicom/iam/internal/services/directory"
 )
 
-// Cacher contains methods needed from a cache
-type Cacher interface {
-	Get(key string, value interface{}) error
-	Set(key string, value interface{}, ttl time.Duration) error
-	Del(key string) error
-	MSet(pairs map[string]interface{}, ttl time.Duration) error
-}
-
 // Fetcher is a function used to send HTTP requests.
 type Fetcher func(req Request) (*Response, error)
 
 // ClientOpts contains options to create an Okta client
 type ClientOpts struct {
-	Cache         Cacher
-	LockManager   *storage.LockManager
 	BaseURL       string
 	AuthToken     string
+	OAuth         *OAuthOpts
 	IAMConfig     *cfg.ServiceConfig
+	OktaConfig    *cfg.OktaConfig
 	Metrics       *monitoring.Metrics
 	CustomFetcher func(userAgent string, metrics *monitoring.Metrics) Fetcher
+
+	// ProfileAttributes are the Okta profile attributes mapped to user attributes
+	ProfileAttributes []ProfileAttribute
 }
 
-// Client represent an Okta client
+// Client represent an Okta client, it's the Okta implementation of
+// directory.Provider.
 type Client struct {
-	group     singleflight.Group
-	cache     Cacher
-	lock      *storage.LockManager
 	baseURL   string
 	authToken string
+	oauth     *oauthTokenSource
 	iamConfig *cfg.ServiceConfig
 	metrics   *monitoring.Metrics
 	fetch     Fetcher
+	breaker   *circuitBreaker
+	// profileAttributes are the Okta profile attributes mapped to user attributes
+	profileAttributes []ProfileAttribute
 }
 
+var _ directory.Provider = (*Client)(nil)
+
 func getUserAgent(iamConfig *cfg.ServiceConfig) (string, error) {
 	if iamConfig == nil {
 		// We can get to this point only when running tests. If IAM config is not
@@ -78,13 +76,76 @@ func NewClient(opts *ClientOpts) *Client {
 		fetch = opts.CustomFetcher(uaString, opts.Metrics)
 	}
 
+	var rateLimitShare float64
+	if opts.OktaConfig != nil {
+		rateLimitShare = opts.OktaConfig.RateLimitShare
+	}
+	limiter := newRateLimiter(rateLimitShare, opts.Metrics)
+	fetch = rateLimitedFetcher(fetch, limiter, newRetryPolicy(opts.OktaConfig), opts.Metrics)
+
+	breaker := newCircuitBreaker(opts.OktaConfig, opts.Metrics)
+	fetch = circuitBreakerFetcher(fetch, breaker)
+
+	var oauth *oauthTokenSource
+	if opts.OAuth != nil {
+		var oauthErr error
+		oauth, oauthErr = newOAuthTokenSource(opts.OAuth, opts.BaseURL, fetch)
+		if oauthErr != nil {
+			log.Println("[ERR] OAuth disabled:", oauthErr)
+			raven.CaptureError(oauthErr, nil)
+		}
+	}
+
 	return &Client{
-		cache:     opts.Cache,
-		lock:      opts.LockManager,
 		baseURL:   opts.BaseURL,
 		authToken: opts.AuthToken,
+		oauth:     oauth,
 		iamConfig: opts.IAMConfig,
 		metrics:   opts.Metrics,
 		fetch:     fetch,
+		breaker:   breaker,
+
+		profileAttributes: opts.ProfileAttributes,
+	}
+}
+
+// ErrNoCredentials is returned when there is no way to authenticate to Okta.
+var ErrNoCredentials = errors.New("no Okta credentials available")
+
+// authorization returns the Authorization header for Okta requests. An OAuth
+// access token is preferred, the API token is used as a fallback when OAuth is
+// not configured or an access token can't be obtained.
+func (c *Client) authorization() (string, error) {
+	if c.oauth == nil {
+		return c.authToken, nil
+	}
+
+	token, err := c.oauth.Token()
+	if err == nil {
+		return "Bearer " + token, nil
+	}
+
+	if c.authToken == "" {
+		log.Println("[ERROR] Failed to get Okta access token:", err)
+		raven.CaptureError(err, nil)
+		return "", ErrNoCredentials
+	}
+
+	log.Println("[ERROR] Failed to get Okta access token, using API token:", err)
+	raven.CaptureError(err, nil)
+	return c.authToken, nil
+}
+
+// BreakerState returns the state of the circuit breaker around Okta requests.
+func (c *Client) BreakerState() BreakerState {
+	return c.breaker.State()
+}
+
+// Ready returns ErrUpstreamUnavailable, wrapped with the state of the circuit
+// breaker, while requests to Okta fail fast.
+func (c *Client) Ready() error {
+	if state := c.BreakerState(); state == BreakerOpen {
+		return fmt.Errorf("circuit breaker %s: %w", state, ErrUpstreamUnavailable)
 	}
+	return nil
 }
@@ -0,0 +1,123 @@
+package okta
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/kiwicom/iam/configs"
+	"github.com/kiwicom/iam/internal/services/directory"
+	"github.com/kiwicom/iam/internal/services/okta/oktatest"
+)
+
+const fakeFixture = `{
+	"users": [
+		{"profile": {"login": "alice@kiwi.com", "email": "alice@kiwi.com", "firstName": "Alice"}},
+		{"profile": {"login": "bob@kiwi.com", "email": "bob@kiwi.com"}, "status": "SUSPENDED"},
+		{"profile": {"login": "carol@kiwi.com", "email": "carol@kiwi.com"}}
+	],
+	"groups": [
+		{"profile": {"name": "iam-service.read"}, "members": ["alice@kiwi.com", "bob@kiwi.com"]},
+		{"profile": {"name": "iam-service.write"}, "members": ["alice@kiwi.com"]},
+		{"profile": {"name": "vpn-users"}, "members": ["alice@kiwi.com", "carol@kiwi.com"]}
+	]
+}`
+
+func newFakeClient(t *testing.T, oktaConfig *cfg.OktaConfig) (*Client, *oktatest.Server, func()) {
+	fake := oktatest.NewServer()
+	fake.PageSize = 2
+	fake.Token = "token"
+	// Fixtures are loaded in the past, so tests can tell them apart from changes
+	loaded := time.Now().Add(-time.Hour)
+	fake.Now = func() time.Time { return loaded }
+	require.NoError(t, fake.Load([]byte(fakeFixture)))
+	ts := httptest.NewServer(fake)
+
+	client := NewClient(&ClientOpts{BaseURL: ts.URL + "/api/v1", AuthToken: "SSWS token", OktaConfig: oktaConfig})
+	return client, fake, ts.Close
+}
+
+func TestClientWithFake(t *testing.T) {
+	client, _, cleanup := newFakeClient(t, nil)
+	defer cleanup()
+
+	var emails []string
+	for cursor, pages := "", 0; pages == 0 || cursor != ""; pages++ {
+		users, next, err := client.ListUsers(time.Time{}, cursor)
+		require.NoError(t, err)
+		for _, user := range users {
+			emails = append(emails, user.Email)
+		}
+		cursor = next
+	}
+	assert.Equal(t, []string{"alice@kiwi.com", "bob@kiwi.com", "carol@kiwi.com"}, emails)
+
+	user, err := client.GetUser("alice@kiwi.com")
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", user.FirstName)
+	_, err = client.GetUser("dave@kiwi.com")
+	assert.Equal(t, directory.ErrUserNotFound, err)
+
+	groups, err := client.ListGroups()
+	require.NoError(t, err)
+	require.Len(t, groups, 2, "Only IAM groups are listed")
+	members, err := client.ListGroupMembers(groups[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice@kiwi.com", "bob@kiwi.com"}, members)
+
+	groups, err = client.ListUserGroups(user)
+	require.NoError(t, err)
+	assert.Len(t, groups, 2)
+}
+
+func TestClientWithFakeChanges(t *testing.T) {
+	client, fake, cleanup := newFakeClient(t, nil)
+	defer cleanup()
+	since := time.Now().UTC().Add(-time.Minute)
+	fake.Now = func() time.Time { return since.Add(30 * time.Second) }
+
+	user, err := client.GetUser("carol@kiwi.com")
+	require.NoError(t, err)
+	users, _, err := client.ListUsers(since, "")
+	require.NoError(t, err)
+	assert.Empty(t, users)
+
+	groups, err := client.ListGroups()
+	require.NoError(t, err)
+	require.NoError(t, fake.AddMember(groups[0].ID, user.ID))
+	require.NoError(t, fake.SetUserStatus(user.ID, "SUSPENDED"))
+
+	users, _, err = client.ListUsers(since, "")
+	require.NoError(t, err)
+	require.Len(t, users, 1, "Users updated since the given time are listed")
+	assert.Equal(t, directory.StatusSuspended, users[0].Status)
+
+	changes, _, _, err := client.ListChanges(since, "")
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, directory.ChangeMembershipAdded, changes[0].Type)
+	assert.Equal(t, "iam-service.read", changes[0].Group.Name)
+	assert.Equal(t, directory.ChangeUserStatus, changes[1].Type)
+}
+
+func TestClientWithFakeRetries(t *testing.T) {
+	client, fake, cleanup := newFakeClient(t, &cfg.OktaConfig{
+		MaxRetries:     2,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  time.Millisecond,
+	})
+	defer cleanup()
+
+	fake.AddFault(oktatest.Fault{Path: "/groups", Status: http.StatusServiceUnavailable, Times: 2})
+	groups, err := client.ListGroups()
+	require.NoError(t, err, "Server errors are retried")
+	assert.Len(t, groups, 2)
+
+	fake.AddFault(oktatest.Fault{Path: "/groups", Status: http.StatusServiceUnavailable, Times: 3})
+	_, err = client.ListGroups()
+	assert.Error(t, err)
+}
@@ -0,0 +1,125 @@
+package okta
+
+import (
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// Event is an Okta System Log event, as delivered by Event Hooks
+// https://developer.okta.com/docs/reference/api/system-log/#logevent-object
+type Event struct {
+	UUID      string        `json:"uuid"`
+	Published time.Time     `json:"published"`
+	EventType string        `json:"eventType"`
+	Target    []EventTarget `json:"target"`
+}
+
+// EventTarget is an entity affected by an Okta event
+type EventTarget struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	AlternateID string `json:"alternateId"`
+	DisplayName string `json:"displayName"`
+}
+
+// Okta event types converted to directory changes
+const (
+	EventMembershipAdd       = "group.user_membership.add"
+	EventMembershipRemove    = "group.user_membership.remove"
+	EventUserProfileUpdate   = "user.account.update_profile"
+	eventUserLifecyclePrefix = "user.lifecycle."
+)
+
+// lifecycleStatuses are the statuses users end up in after lifecycle events.
+// Other lifecycle events invalidate the cached user instead.
+var lifecycleStatuses = map[string]string{
+	"user.lifecycle.activate":         directory.StatusActive,
+	"user.lifecycle.unsuspend":        directory.StatusActive,
+	"user.lifecycle.suspend":          directory.StatusSuspended,
+	"user.lifecycle.deactivate":       directory.StatusDeprovisioned,
+	"user.lifecycle.reactivate":       directory.StatusProvisioned,
+	"user.lifecycle.delete.initiated": directory.StatusDeprovisioned,
+}
+
+// eventTarget returns the first target of the given type.
+func (e *Event) eventTarget(targetType string) (EventTarget, bool) {
+	for _, target := range e.Target {
+		if target.Type == targetType {
+			return target, true
+		}
+	}
+	return EventTarget{}, false
+}
+
+// EventChanges converts Okta events to directory changes. Events which are not
+// relevant to IAM are left out.
+func EventChanges(events []Event) []directory.Change {
+	changes := make([]directory.Change, 0, len(events))
+	for i := range events {
+		if change, ok := events[i].change(); ok {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// change converts an event to a directory change, it returns false if the
+// event is not relevant to IAM.
+func (e *Event) change() (directory.Change, bool) {
+	user, ok := e.eventTarget("User")
+	if !ok || !isEmail(user.AlternateID) {
+		// Users are cached by email, the login of the user is used as the email
+		// and events of users whose login is not an email can't be applied.
+		return directory.Change{}, false
+	}
+	change := directory.Change{
+		ID:    e.UUID,
+		Time:  e.Published,
+		Email: user.AlternateID,
+	}
+
+	switch {
+	case e.EventType == EventMembershipAdd || e.EventType == EventMembershipRemove:
+		group, ok := e.eventTarget("UserGroup")
+		if !ok || !strings.HasPrefix(group.DisplayName, directory.GroupPrefix) {
+			return directory.Change{}, false
+		}
+		change.Type = directory.ChangeMembershipRemoved
+		if e.EventType == EventMembershipAdd {
+			change.Type = directory.ChangeMembershipAdded
+		}
+		change.Group = directory.Group{ID: group.ID, Name: group.DisplayName}
+
+	case e.EventType == EventUserProfileUpdate:
+		// The event doesn't contain the profile, the user is fetched again when
+		// it's requested next time.
+		change.Type = directory.ChangeUserUpdated
+
+	case strings.HasPrefix(e.EventType, eventUserLifecyclePrefix):
+		if e.EventType == "user.lifecycle.delete.completed" {
+			change.Type = directory.ChangeUserDeleted
+			break
+		}
+		status, ok := lifecycleStatuses[e.EventType]
+		if !ok {
+			change.Type = directory.ChangeUserUpdated
+			break
+		}
+		change.Type = directory.ChangeUserStatus
+		change.Status = status
+
+	default:
+		return directory.Change{}, false
+	}
+
+	return change, true
+}
+
+// isEmail checks that the login of a user is a plain email address
+func isEmail(login string) bool {
+	address, err := mail.ParseAddress(login)
+	return err == nil && address.Address == login
+}
@@ -0,0 +1,44 @@
+package okta
+
+import (
+	gourl "net/url"
+	"time"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+// eventsPollFilter selects the System Log events which are converted to changes
+const eventsPollFilter = `eventType eq "` + EventMembershipAdd + `"` +
+	` or eventType eq "` + EventMembershipRemove + `"` +
+	` or eventType eq "` + EventUserProfileUpdate + `"` +
+	` or eventType sw "` + eventUserLifecyclePrefix + `"`
+
+// ListChanges returns a page of changes from events published to the Okta
+// System Log since the given time, and the time the last event of the page
+// was published. The cursor is the URL of the page.
+func (c *Client) ListChanges(since time.Time, cursor string) ([]directory.Change, time.Time, string, error) {
+	if cursor == "" {
+		url, err := joinURL(c.baseURL, "/logs")
+		if err != nil {
+			return nil, time.Time{}, "", err
+		}
+		query := gourl.Values{}
+		query.Set("since", oktaTimeFormat(since))
+		query.Set("until", oktaTimeFormat(time.Now().UTC()))
+		query.Set("sortOrder", "ASCENDING")
+		query.Set("filter", eventsPollFilter)
+		cursor = url + "?" + query.Encode()
+	}
+
+	var events []Event
+	next, err := c.fetchPage(cursor, &events)
+	if err != nil {
+		return nil, time.Time{}, "", err
+	}
+
+	var until time.Time
+	if len(events) > 0 {
+		until = events[len(events)-1].Published
+	}
+	return EventChanges(events), until, next, nil
+}
@@ -0,0 +1,100 @@
+package okta
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+func membershipEvent(uuid, eventType, email, group string) Event {
+	return Event{
+		UUID:      uuid,
+		EventType: eventType,
+		Target: []EventTarget{
+			{ID: "00u1", Type: "User", AlternateID: email},
+			{ID: "00g1", Type: "UserGroup", DisplayName: group},
+		},
+	}
+}
+
+func userEvent(uuid, eventType, email string) Event {
+	return Event{
+		UUID:      uuid,
+		EventType: eventType,
+		Target:    []EventTarget{{ID: "00u1", Type: "User", AlternateID: email}},
+	}
+}
+
+func TestEventChanges(t *testing.T) {
+	changes := EventChanges([]Event{
+		membershipEvent("1", EventMembershipAdd, "user@kiwi.com", "iam-service.write"),
+		membershipEvent("2", EventMembershipRemove, "user@kiwi.com", "iam-service.write"),
+		membershipEvent("3", EventMembershipAdd, "user@kiwi.com", "Everyone"),
+		userEvent("4", "user.lifecycle.suspend", "user@kiwi.com"),
+		userEvent("5", EventUserProfileUpdate, "user@kiwi.com"),
+		userEvent("6", "user.lifecycle.delete.completed", "user@kiwi.com"),
+		userEvent("7", "user.lifecycle.create", "user@kiwi.com"),
+		userEvent("8", "user.session.start", "user@kiwi.com"),
+		{UUID: "9", EventType: EventUserProfileUpdate},
+		userEvent("10", EventUserProfileUpdate, "user"),
+		userEvent("11", EventUserProfileUpdate, "User <user@kiwi.com>"),
+	})
+
+	group := directory.Group{ID: "00g1", Name: "iam-service.write"}
+	assert.Equal(t, []directory.Change{
+		{ID: "1", Type: directory.ChangeMembershipAdded, Email: "user@kiwi.com", Group: group},
+		{ID: "2", Type: directory.ChangeMembershipRemoved, Email: "user@kiwi.com", Group: group},
+		{ID: "4", Type: directory.ChangeUserStatus, Email: "user@kiwi.com", Status: directory.StatusSuspended},
+		{ID: "5", Type: directory.ChangeUserUpdated, Email: "user@kiwi.com"},
+		{ID: "6", Type: directory.ChangeUserDeleted, Email: "user@kiwi.com"},
+		{ID: "7", Type: directory.ChangeUserUpdated, Email: "user@kiwi.com"},
+	}, changes, "Events not relevant to IAM are left out")
+}
+
+func TestListChanges(t *testing.T) {
+	var queries []map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/logs", r.URL.Path)
+		query := r.URL.Query()
+		queries = append(queries, map[string]string{"since": query.Get("since"), "filter": query.Get("filter")})
+		_, _ = w.Write([]byte(`[{
+			"uuid": "1",
+			"published": "2020-07-15T10:36:33.497Z",
+			"eventType": "group.user_membership.add",
+			"target": [
+				{"id": "00u1", "type": "User", "alternateId": "user@kiwi.com"},
+				{"id": "00g1", "type": "UserGroup", "displayName": "iam-service.read"}
+			]
+		}, {
+			"uuid": "2",
+			"published": "2020-07-15T10:40:00.000Z",
+			"eventType": "group.user_membership.add",
+			"target": [
+				{"id": "00u1", "type": "User", "alternateId": "user@kiwi.com"},
+				{"id": "00g2", "type": "UserGroup", "displayName": "Everyone"}
+			]
+		}]`))
+	}))
+	defer ts.Close()
+	client := NewClient(&ClientOpts{BaseURL: ts.URL})
+
+	since := time.Date(2020, 7, 15, 10, 0, 0, 0, time.UTC)
+	changes, until, cursor, err := client.ListChanges(since, "")
+	require.NoError(t, err)
+
+	require.Len(t, queries, 1)
+	assert.Equal(t, oktaTimeFormat(since), queries[0]["since"])
+	assert.Contains(t, queries[0]["filter"], `eventType eq "group.user_membership.add"`)
+	assert.Contains(t, queries[0]["filter"], `eventType sw "user.lifecycle."`)
+	assert.Equal(t, "", cursor)
+	require.Len(t, changes, 1)
+	assert.Equal(t, directory.ChangeMembershipAdded, changes[0].Type)
+	assert.True(t, time.Date(2020, 7, 15, 10, 36, 33, 497000000, time.UTC).Equal(changes[0].Time), "Changes keep the time of events")
+	assert.True(t, time.Date(2020, 7, 15, 10, 40, 0, 0, time.UTC).Equal(until), "Events not converted to changes are read too")
+}
@@ -1,37 +1,19 @@
 package okta
 
 import (
-	gourl "net/url"
 	"strings"
 	"time"
 
-	"github.com/getsentry/raven-go"
-
-	cfg "github.com/kiwicom/iam/configs"
-	"github.com/kiwicom/iam/internal/storage"
+	"github.com/kiwicom/iam/internal/services/directory"
 )
 
-const iamGroupPrefix = "iam-"
-
-// Group represents an Okta group
-type Group struct {
-	ID                    string    `json:"id"`
-	Name                  string    `json:"name"`
-	Description           string    `json:"description"`
-	LastMembershipUpdated time.Time `json:"lastMembershipUpdated"`
-}
-
 type oktaGroupProfile struct {
 	Name        string
 	Description string
 }
 
-func (c *Client) fetchGroups(userID, since string) ([]Group, error) {
-	var filter string
-	if since != "" {
-		filter = "?filter=" + gourl.QueryEscape("lastMembershipUpdated gt \""+since+"\"")
-	}
-
+// fetchGroups fetches IAM groups, either all of them or the groups of a user.
+func (c *Client) fetchGroups(userID string) ([]directory.Group, error) {
 	var url string
 	var err error
 	if userID != "" {
@@ -43,31 +25,21 @@ func (c *Client) fetchGroups(userID, since string) ([]Group, error) {
 		return nil, err
 	}
 
-	var allGroups []Group
-
-	responses, err := c.fetchPagedResource(url + filter)
-	if err != nil {
-		return nil, err
+	var allGroups []directory.Group
+	var resources []struct {
+		ID                    string
+		Profile               oktaGroupProfile
+		LastMembershipUpdated time.Time
 	}
 
-	for _, response := range responses {
-		var resources []struct {
-			ID                    string
-			Profile               oktaGroupProfile
-			LastMembershipUpdated time.Time
-			LastFetched           time.Time
-		}
-
-		jsonErr := json.UnmarshalFromString(response, &resources)
-		if jsonErr != nil {
-			return nil, jsonErr
-		}
-
-		var groups []Group
+	pages := c.iteratePages(url)
+	// Resources are reset before each page, so that values of a page don't leak
+	// into fields missing in the next one.
+	for resources = nil; pages.Next(&resources); resources = nil {
 		for i := range resources {
 			group := &resources[i]
-			if strings.HasPrefix(group.Profile.Name, iamGroupPrefix) {
-				groups = append(groups, Group{
+			if strings.HasPrefix(group.Profile.Name, directory.GroupPrefix) {
+				allGroups = append(allGroups, directory.Group{
 					ID:                    group.ID,
 					Name:                  group.Profile.Name,
 					Description:           group.Profile.Description,
@@ -75,50 +47,20 @@ func (c *Client) fetchGroups(userID, since string) ([]Group, error) {
 				})
 			}
 		}
-		allGroups = append(allGroups, groups...)
+	}
+	if err := pages.Err(); err != nil {
+		return nil, err
 	}
 
 	return allGroups, nil
 }
 
-func (c *Client) getUserGroups(user *User) ([]Group, error) {
-	if user.GroupMembership != nil {
-		// Group membership is cached, don't fetch it.
-		return user.GroupMembership, nil
-	}
-
-	lockName := user.Email + ":groupMembership"
-	// Deduplicate network calls and cache writes if this function is called
-	// multiple times within the same instance.
-	val, err, _ := c.group.Do(lockName, func() (interface{}, error) {
-		lockErr := c.lock.Create(lockName)
-		if lockErr == storage.ErrLockExists {
-			// If there was a lock, it means another instance was fetching this data
-			// recently, in that case, we should be able to just get the data from
-			// cache.
-			var u User
-			if err := c.cache.Get(user.Email, &u); err != nil {
-				return nil, err
-			}
-			return u.GroupMembership, nil
-		}
-		defer c.lock.Delete(lockName)
-
-		groups, fetchErr := c.fetchGroups(user.OktaID, "")
-		if fetchErr != nil {
-			return nil, fetchErr
-		}
-		user.GroupMembership = groups
-
-		cacheErr := c.cache.Set(user.Email, user, cfg.Expirations.User)
-		if cacheErr != nil {
-			raven.CaptureError(cacheErr, nil)
-		}
-		return groups, nil
-	})
+// ListGroups returns all IAM groups from Okta.
+func (c *Client) ListGroups() ([]directory.Group, error) {
+	return c.fetchGroups("")
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return val.([]Group), nil
+// ListUserGroups returns the IAM groups of a user from Okta.
+func (c *Client) ListUserGroups(user directory.User) ([]directory.Group, error) {
+	return c.fetchGroups(user.ID)
 }
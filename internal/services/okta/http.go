@@ -23,10 +23,11 @@ var httpClient = &http.Client{
 
 // Request contains options for an HTTP request created using shared.Fetch
 type Request struct {
-	Method string
-	URL    string
-	Token  string
-	Body   io.Reader
+	Method      string
+	URL         string
+	Token       string
+	ContentType string
+	Body        io.Reader
 }
 
 // Response for an HTTP request, exposes a JSON method to get the
@@ -67,7 +68,12 @@ func defaultFetcher(userAgent string, service string, metrics *monitoring.Metric
 		}
 
 		httpReq.Header.Set("User-Agent", userAgent)
-		httpReq.Header.Set("Authorization", req.Token)
+		if req.Token != "" {
+			httpReq.Header.Set("Authorization", req.Token)
+		}
+		if req.ContentType != "" {
+			httpReq.Header.Set("Content-Type", req.ContentType)
+		}
 
 		httpRes, err := httpClient.Do(httpReq) //nolint:bodyclose // body is closed on reading either to string or JSON
 		if err != nil {
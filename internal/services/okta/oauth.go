@@ -0,0 +1,254 @@
+package okta
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	gourl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultOAuthScopes are the scopes needed by IAM to read users and groups.
+var DefaultOAuthScopes = []string{"okta.users.read", "okta.groups.read"}
+
+// tokenExpiryMargin is how long before its expiration an access token is
+// refreshed, to avoid sending requests with a token that expires in flight.
+const tokenExpiryMargin = time.Minute
+
+// clientAssertionLifetime is the lifetime of the signed JWT used to request an
+// access token. Okta rejects assertions valid for more than an hour.
+const clientAssertionLifetime = 5 * time.Minute
+
+// OAuthOpts contains options to authenticate to Okta with an OAuth 2.0 service
+// app, using the client credentials flow with a private_key_jwt assertion.
+type OAuthOpts struct {
+	ClientID   string
+	PrivateKey *rsa.PrivateKey
+	// KeyID is the kid of the public key registered in Okta, optional
+	KeyID string
+	// Scopes defaults to DefaultOAuthScopes
+	Scopes []string
+	// TokenURL defaults to the org authorization server of the Okta BaseURL
+	TokenURL string
+}
+
+// ErrInvalidPrivateKey is returned when an OAuth private key can't be parsed.
+var ErrInvalidPrivateKey = errors.New("invalid OAuth private key, expected an RSA key in PEM format")
+
+// ParsePrivateKey parses a PEM encoded RSA private key (PKCS #1 or PKCS #8).
+func ParsePrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidPrivateKey
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidPrivateKey
+	}
+	return rsaKey, nil
+}
+
+// oauthTokenURL returns the token endpoint of the org authorization server,
+// ie. https://example.okta.com/api/v1 -> https://example.okta.com/oauth2/v1/token
+func oauthTokenURL(baseURL string) (string, error) {
+	u, err := gourl.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = "/oauth2/v1/token"
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+type oauthToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// oauthTokenSource requests access tokens from Okta and caches them until they
+// are about to expire.
+type oauthTokenSource struct {
+	opts  OAuthOpts
+	fetch Fetcher
+	now   func() time.Time
+
+	group singleflight.Group
+	mu    sync.Mutex
+	token oauthToken
+}
+
+func newOAuthTokenSource(opts *OAuthOpts, baseURL string, fetch Fetcher) (*oauthTokenSource, error) {
+	if opts.PrivateKey == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	source := &oauthTokenSource{
+		opts:  *opts,
+		fetch: fetch,
+		now:   time.Now,
+	}
+	if len(source.opts.Scopes) == 0 {
+		source.opts.Scopes = DefaultOAuthScopes
+	}
+	if source.opts.TokenURL == "" {
+		tokenURL, err := oauthTokenURL(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		source.opts.TokenURL = tokenURL
+	}
+	return source, nil
+}
+
+// Token returns a cached access token, or requests a new one if there is no
+// valid token cached.
+func (s *oauthTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+
+	if token.value != "" && s.now().Before(token.expiresAt.Add(-tokenExpiryMargin)) {
+		return token.value, nil
+	}
+
+	// Deduplicate token requests if the token is needed concurrently.
+	val, err, _ := s.group.Do("token", func() (interface{}, error) {
+		newToken, err := s.requestToken()
+		if err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		s.token = newToken
+		s.mu.Unlock()
+		return newToken.value, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return val.(string), nil
+}
+
+// invalidate drops the cached token, so a new one is requested next time.
+func (s *oauthTokenSource) invalidate() {
+	s.mu.Lock()
+	s.token = oauthToken{}
+	s.mu.Unlock()
+}
+
+func (s *oauthTokenSource) requestToken() (oauthToken, error) {
+	assertion, err := s.clientAssertion()
+	if err != nil {
+		return oauthToken{}, err
+	}
+
+	form := gourl.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("scope", strings.Join(s.opts.Scopes, " "))
+	form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	form.Set("client_assertion", assertion)
+
+	requestedAt := s.now()
+	res, err := s.fetch(Request{
+		Method:      http.MethodPost,
+		URL:         s.opts.TokenURL,
+		ContentType: "application/x-www-form-urlencoded",
+		Body:        strings.NewReader(form.Encode()),
+	})
+	if err != nil {
+		return oauthToken{}, err
+	}
+
+	var body struct {
+		TokenType        string `json:"token_type"`
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = res.JSON(&body)
+		return oauthToken{}, errors.New("POST " + s.opts.TokenURL + " returned error: " + res.Status + " " + body.ErrorDescription)
+	}
+	if err := res.JSON(&body); err != nil {
+		return oauthToken{}, err
+	}
+	if body.AccessToken == "" {
+		return oauthToken{}, errors.New("POST " + s.opts.TokenURL + " returned no access token")
+	}
+
+	return oauthToken{
+		value:     body.AccessToken,
+		expiresAt: requestedAt.Add(time.Duration(body.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// clientAssertion creates the JWT used to authenticate the client, signed with
+// its private key (RS256).
+func (s *oauthTokenSource) clientAssertion() (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if s.opts.KeyID != "" {
+		header["kid"] = s.opts.KeyID
+	}
+
+	now := s.now()
+	claims := map[string]interface{}{
+		"iss": s.opts.ClientID,
+		"sub": s.opts.ClientID,
+		"aud": s.opts.TokenURL,
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+		"jti": hex.EncodeToString(jti),
+	}
+
+	encodedHeader, err := encodeJWTSegment(header)
+	if err != nil {
+		return "", err
+	}
+	encodedClaims, err := encodeJWTSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encodedHeader + "." + encodedClaims
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.opts.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func encodeJWTSegment(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
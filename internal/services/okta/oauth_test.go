@@ -0,0 +1,167 @@
+package okta
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOktaAuth is a local fake of the Okta token endpoint and users API.
+type fakeOktaAuth struct {
+	t             *testing.T
+	publicKey     *rsa.PublicKey
+	tokenRequests int
+	failTokens    bool
+}
+
+func (f *fakeOktaAuth) handler(serverURL *string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/token", func(w http.ResponseWriter, r *http.Request) {
+		f.tokenRequests++
+		if f.failTokens {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"Invalid client"}`))
+			return
+		}
+
+		assert.Equal(f.t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+		require.NoError(f.t, r.ParseForm())
+		assert.Equal(f.t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(f.t, "okta.users.read okta.groups.read", r.PostForm.Get("scope"))
+		assert.Equal(f.t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", r.PostForm.Get("client_assertion_type"))
+
+		claims := f.verifyAssertion(r.PostForm.Get("client_assertion"))
+		assert.Equal(f.t, "client-id", claims["iss"])
+		assert.Equal(f.t, "client-id", claims["sub"])
+		assert.Equal(f.t, *serverURL+"/oauth2/v1/token", claims["aud"])
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"token_type":"Bearer","expires_in":3600,"access_token":"token-` +
+			strconv.Itoa(f.tokenRequests) + `","scope":"okta.users.read okta.groups.read"}`))
+	})
+	mux.HandleFunc("/api/v1/users/test@kiwi.com", func(w http.ResponseWriter, r *http.Request) {
+		if f.failTokens {
+			assert.Equal(f.t, "SSWS api-token", r.Header.Get("Authorization"))
+		} else {
+			assert.Equal(f.t, "Bearer token-"+strconv.Itoa(f.tokenRequests), r.Header.Get("Authorization"))
+		}
+		_, _ = w.Write([]byte(`{"id":"00u1","profile":{"email":"test@kiwi.com"}}`))
+	})
+	return mux
+}
+
+func (f *fakeOktaAuth) verifyAssertion(assertion string) map[string]interface{} {
+	parts := strings.Split(assertion, ".")
+	require.Len(f.t, parts, 3)
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(f.t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(f.t, rsa.VerifyPKCS1v15(f.publicKey, crypto.SHA256, digest[:], signature), "Assertion is signed")
+
+	var header map[string]string
+	headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	require.NoError(f.t, json.Unmarshal(headerJSON, &header))
+	assert.Equal(f.t, "RS256", header["alg"])
+	assert.Equal(f.t, "key-id", header["kid"])
+
+	var claims map[string]interface{}
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(f.t, json.Unmarshal(claimsJSON, &claims))
+	return claims
+}
+
+func newOAuthTestClient(t *testing.T) (*Client, *fakeOktaAuth, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	fake := &fakeOktaAuth{t: t, publicKey: &key.PublicKey}
+	var serverURL string
+	ts := httptest.NewServer(fake.handler(&serverURL))
+	serverURL = ts.URL
+
+	c := NewClient(&ClientOpts{
+		BaseURL:   ts.URL + "/api/v1",
+		AuthToken: "SSWS api-token",
+		OAuth: &OAuthOpts{
+			ClientID:   "client-id",
+			PrivateKey: key,
+			KeyID:      "key-id",
+		},
+	})
+	return c, fake, ts.Close
+}
+
+func TestOAuthAccessToken(t *testing.T) {
+	c, fake, closeServer := newOAuthTestClient(t)
+	defer closeServer()
+
+	user, err := c.GetUser("test@kiwi.com")
+	require.NoError(t, err)
+	assert.Equal(t, "00u1", user.ID)
+
+	_, err = c.GetUser("test@kiwi.com")
+	require.NoError(t, err)
+	assert.Equal(t, 1, fake.tokenRequests, "Access token is cached")
+}
+
+func TestOAuthAccessTokenRefresh(t *testing.T) {
+	c, fake, closeServer := newOAuthTestClient(t)
+	defer closeServer()
+
+	_, err := c.GetUser("test@kiwi.com")
+	require.NoError(t, err)
+
+	// Token expires in an hour, it's refreshed shortly before that
+	c.oauth.now = func() time.Time { return time.Now().Add(time.Hour - tokenExpiryMargin/2) }
+
+	_, err = c.GetUser("test@kiwi.com")
+	require.NoError(t, err)
+	assert.Equal(t, 2, fake.tokenRequests, "Access token is refreshed before it expires")
+}
+
+func TestOAuthFallbackToAPIToken(t *testing.T) {
+	c, fake, closeServer := newOAuthTestClient(t)
+	defer closeServer()
+	fake.failTokens = true
+
+	_, err := c.GetUser("test@kiwi.com")
+	require.NoError(t, err, "API token is used when an access token can't be obtained")
+
+	c.authToken = ""
+	_, err = c.GetUser("test@kiwi.com")
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	parsed, err := ParsePrivateKey(string(pkcs1))
+	require.NoError(t, err)
+	assert.Equal(t, key.D, parsed.D)
+
+	pkcs8Bytes, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	pkcs8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Bytes})
+	parsed, err = ParsePrivateKey(string(pkcs8))
+	require.NoError(t, err)
+	assert.Equal(t, key.D, parsed.D)
+
+	_, err = ParsePrivateKey("not a key")
+	assert.Equal(t, ErrInvalidPrivateKey, err)
+}
@@ -0,0 +1,128 @@
+package oktatest
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fault makes requests to the Server fail, or slow them down
+type Fault struct {
+	// Path is the prefix of paths, without /api/v1, the fault applies to. It
+	// applies to all paths if it's empty.
+	Path string
+	// Status of the failed responses, requests are only delayed if it's 0
+	Status int
+	// Latency is added to the requests
+	Latency time.Duration
+	// Times is the number of requests the fault applies to, 0 for all of them
+	Times int
+	// Probability of the fault applying to a request, between 0 and 1. It
+	// applies to all requests if it's 0.
+	Probability float64
+}
+
+// AddFault makes requests fail or slow down as described by fault. Faults
+// apply in the order they were added, at most one to each request.
+func (s *Server) AddFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes all faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+}
+
+// fault returns the fault applying to a request to path, and counts it
+func (s *Server) fault(path string) *Fault {
+	for i, fault := range s.faults {
+		if !strings.HasPrefix(path, fault.Path) {
+			continue
+		}
+		if fault.Probability > 0 && rand.Float64() >= fault.Probability { //nolint:gosec // faults don't need crypto/rand
+			continue
+		}
+
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+func writeFault(w http.ResponseWriter, status int) {
+	switch {
+	case status == http.StatusTooManyRequests:
+		setRateLimitHeaders(w, 0, 0, time.Now().Add(time.Second))
+		writeError(w, status, "E0000047", "API call exceeded rate limit due to too many requests.")
+	case status >= http.StatusInternalServerError:
+		writeError(w, status, "E0000009", "Internal Server Error")
+	default:
+		writeError(w, status, "E0000001", http.StatusText(status))
+	}
+}
+
+// rateLimitWindow is the window of Okta rate limits
+const rateLimitWindow = time.Minute
+
+type rateLimitBucket struct {
+	remaining int
+	reset     time.Time
+}
+
+// rateLimitEndpoint returns the endpoint of a path the rate limit applies to,
+// IDs in the path don't make a new endpoint.
+func rateLimitEndpoint(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		if i%2 == 1 {
+			segments[i] = "*"
+		} else {
+			segments[i] = segment
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// rateLimit counts a request against the rate limit of its endpoint and sets
+// the rate limit headers. It returns false after responding with 429 if the
+// limit was reached.
+func (s *Server) rateLimit(w http.ResponseWriter, path string) bool {
+	if s.RateLimit <= 0 {
+		return true
+	}
+
+	endpoint := rateLimitEndpoint(path)
+	bucket, ok := s.buckets[endpoint]
+	if !ok || !time.Now().Before(bucket.reset) {
+		bucket = &rateLimitBucket{remaining: s.RateLimit, reset: time.Now().Add(rateLimitWindow)}
+		s.buckets[endpoint] = bucket
+	}
+
+	if bucket.remaining == 0 {
+		setRateLimitHeaders(w, s.RateLimit, 0, bucket.reset)
+		writeError(w, http.StatusTooManyRequests, "E0000047", "API call exceeded rate limit due to too many requests.")
+		return false
+	}
+	bucket.remaining--
+	setRateLimitHeaders(w, s.RateLimit, bucket.remaining, bucket.reset)
+	return true
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, limit, remaining int, reset time.Time) {
+	w.Header().Set("X-Rate-Limit-Limit", strconv.Itoa(limit))
+	w.Header().Set("X-Rate-Limit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(reset.Unix(), 10))
+}
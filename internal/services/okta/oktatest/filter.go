@@ -0,0 +1,198 @@
+package oktatest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// errInvalidFilter is returned for filters which can't be parsed
+var errInvalidFilter = errors.New("invalid filter")
+
+// attributeFunc returns the value of an attribute of a resource, false if the
+// resource doesn't have it.
+type attributeFunc func(name string) (string, bool)
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func userAttribute(user *User) attributeFunc {
+	return func(name string) (string, bool) {
+		switch name {
+		case "id":
+			return user.ID, true
+		case "status":
+			return user.Status, true
+		case "created":
+			return formatTime(user.Created), true
+		case "lastUpdated":
+			return formatTime(user.LastUpdated), true
+		}
+		if strings.HasPrefix(name, "profile.") {
+			value, ok := user.Profile[strings.TrimPrefix(name, "profile.")]
+			if !ok || value == nil {
+				return "", false
+			}
+			return fmt.Sprint(value), true
+		}
+		return "", false
+	}
+}
+
+func groupAttribute(group *Group) attributeFunc {
+	return func(name string) (string, bool) {
+		switch name {
+		case "id":
+			return group.ID, true
+		case "type":
+			return group.Type, true
+		case "created":
+			return formatTime(group.Created), true
+		case "lastUpdated":
+			return formatTime(group.LastUpdated), true
+		case "lastMembershipUpdated":
+			return formatTime(group.LastMembershipUpdated), true
+		case "profile.name":
+			return group.Profile.Name, true
+		case "profile.description":
+			return group.Profile.Description, true
+		}
+		return "", false
+	}
+}
+
+func eventAttribute(event *Event) attributeFunc {
+	return func(name string) (string, bool) {
+		switch name {
+		case "uuid":
+			return event.UUID, true
+		case "eventType":
+			return event.EventType, true
+		case "published":
+			return formatTime(event.Published), true
+		}
+		return "", false
+	}
+}
+
+// matchFilter evaluates an Okta filter expression, like
+// `lastUpdated gt "2020-01-01T00:00:00.000Z" and status eq "ACTIVE"`. The
+// operators eq, ne, sw, gt, ge, lt and le are supported, joined by and and or
+// without parentheses. An empty filter matches everything.
+func matchFilter(filter string, attribute attributeFunc) (bool, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return false, err
+	}
+	if len(tokens) == 0 {
+		return true, nil
+	}
+
+	// Expressions are evaluated as a disjunction of conjunctions, as and takes
+	// precedence over or.
+	matched, conjunction := false, true
+	for i := 0; ; i += 4 {
+		if i+3 > len(tokens) {
+			return false, errInvalidFilter
+		}
+		ok, err := compare(tokens[i], tokens[i+1], tokens[i+2], attribute)
+		if err != nil {
+			return false, err
+		}
+		conjunction = conjunction && ok
+
+		if i+3 == len(tokens) {
+			return matched || conjunction, nil
+		}
+		switch tokens[i+3].value {
+		case "and":
+		case "or":
+			matched = matched || conjunction
+			conjunction = true
+		default:
+			return false, errInvalidFilter
+		}
+	}
+}
+
+// token is a word of a filter, values of quoted tokens are unquoted
+type token struct {
+	value  string
+	quoted bool
+}
+
+func tokenize(filter string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(filter); {
+		switch {
+		case filter[i] == ' ':
+			i++
+		case filter[i] == '"':
+			end := strings.IndexByte(filter[i+1:], '"')
+			if end < 0 {
+				return nil, errInvalidFilter
+			}
+			tokens = append(tokens, token{value: filter[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		default:
+			end := strings.IndexByte(filter[i:], ' ')
+			if end < 0 {
+				end = len(filter) - i
+			}
+			tokens = append(tokens, token{value: filter[i : i+end]})
+			i += end
+		}
+	}
+	return tokens, nil
+}
+
+func compare(name, operator, operand token, attribute attributeFunc) (bool, error) {
+	if name.quoted || operator.quoted {
+		return false, errInvalidFilter
+	}
+
+	value, ok := attribute(name.value)
+	expected := operand.value
+	switch operator.value {
+	case "eq":
+		return ok && value == expected, nil
+	case "ne":
+		return !ok || value != expected, nil
+	case "sw":
+		return ok && strings.HasPrefix(value, expected), nil
+	case "gt", "ge", "lt", "le":
+	default:
+		return false, errInvalidFilter
+	}
+	if !ok {
+		return false, nil
+	}
+
+	// Times are compared as times, other values as strings
+	order := strings.Compare(value, expected)
+	valueTime, valueErr := time.Parse(time.RFC3339Nano, value)
+	expectedTime, expectedErr := time.Parse(time.RFC3339Nano, expected)
+	if valueErr == nil && expectedErr == nil {
+		switch {
+		case valueTime.Before(expectedTime):
+			order = -1
+		case valueTime.After(expectedTime):
+			order = 1
+		default:
+			order = 0
+		}
+	}
+
+	switch operator.value {
+	case "gt":
+		return order > 0, nil
+	case "ge":
+		return order >= 0, nil
+	case "lt":
+		return order < 0, nil
+	default:
+		return order <= 0, nil
+	}
+}
@@ -0,0 +1,49 @@
+package oktatest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchFilter(t *testing.T) {
+	attributes := map[string]string{
+		"status":      "ACTIVE",
+		"lastUpdated": "2020-07-15T10:36:33.5Z",
+		"eventType":   "user.lifecycle.suspend",
+	}
+	attribute := func(name string) (string, bool) {
+		value, ok := attributes[name]
+		return value, ok
+	}
+
+	filters := map[string]bool{
+		``:                               true,
+		`status eq "ACTIVE"`:             true,
+		`status eq "SUSPENDED"`:          false,
+		`status ne "SUSPENDED"`:          true,
+		`profile.name eq "x"`:            false,
+		`profile.name ne "x"`:            true,
+		`eventType sw "user.lifecycle."`: true,
+		`lastUpdated gt "2020-07-15T10:36:33.0Z"`:                          true,
+		`lastUpdated gt "2020-07-15T12:36:33+02:00"`:                       true,
+		`lastUpdated lt "2020-07-15T10:36:33.5Z"`:                          false,
+		`lastUpdated le "2020-07-15T10:36:33.5Z"`:                          true,
+		`status eq "SUSPENDED" or status eq "ACTIVE"`:                      true,
+		`status eq "ACTIVE" and eventType eq "x"`:                          false,
+		`status eq "SUSPENDED" and eventType eq "x" or status eq "ACTIVE"`: true,
+		`status eq "ACTIVE" or status eq "SUSPENDED" and eventType eq "x"`: true,
+		`status eq "SUSPENDED" or status eq "ACTIVE" and eventType eq "x"`: false,
+		`status eq "with spaces" or eventType eq "user.lifecycle.suspend"`: true,
+	}
+	for filter, expected := range filters {
+		matched, err := matchFilter(filter, attribute)
+		assert.NoError(t, err, filter)
+		assert.Equal(t, expected, matched, filter)
+	}
+
+	for _, filter := range []string{`status`, `status eq`, `status is "ACTIVE"`, `status eq "ACTIVE" nor x eq "y"`, `status eq "ACTIVE`} {
+		_, err := matchFilter(filter, attribute)
+		assert.Equal(t, errInvalidFilter, err, filter)
+	}
+}
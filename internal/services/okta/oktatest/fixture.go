@@ -0,0 +1,42 @@
+package oktatest
+
+import "errors"
+
+// Fixture describes the users and groups of a Server, in JSON:
+//
+//	{
+//	  "users": [{"profile": {"login": "alice@kiwi.com", "email": "alice@kiwi.com"}}],
+//	  "groups": [{"profile": {"name": "iam-service.read"}, "members": ["alice@kiwi.com"]}]
+//	}
+type Fixture struct {
+	Users  []User         `json:"users"`
+	Groups []FixtureGroup `json:"groups"`
+}
+
+// FixtureGroup is a group of a Fixture with its members, referenced by ID,
+// login or email.
+type FixtureGroup struct {
+	Group
+	Members []string `json:"members"`
+}
+
+// Load adds the users and groups of a JSON Fixture to the Server
+func (s *Server) Load(data []byte) error {
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return err
+	}
+
+	for _, user := range fixture.Users {
+		s.AddUser(user)
+	}
+	for _, fixtureGroup := range fixture.Groups {
+		group := s.AddGroup(fixtureGroup.Group)
+		for _, member := range fixtureGroup.Members {
+			if err := s.AddMember(group.ID, member); err != nil {
+				return errors.New("member " + member + " of " + group.Profile.Name + ": " + err.Error())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,609 @@
+// Package oktatest provides a stateful fake of the Okta API endpoints used by
+// the Okta client, for tests and for running IAM locally. It's an http.Handler,
+// usually served by httptest:
+//
+//	fake := oktatest.NewServer()
+//	ts := httptest.NewServer(fake)
+//	client := okta.NewClient(&okta.ClientOpts{BaseURL: ts.URL + "/api/v1"})
+package oktatest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	gourl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// defaultPageSize is the page size of Okta collections when no limit is given
+const defaultPageSize = 200
+
+// basePath is the prefix of Okta API paths, requests are served without it
+// as well.
+const basePath = "/api/v1"
+
+// User is an Okta user
+type User struct {
+	ID          string                 `json:"id"`
+	Status      string                 `json:"status"`
+	Created     time.Time              `json:"created"`
+	LastUpdated time.Time              `json:"lastUpdated"`
+	Profile     map[string]interface{} `json:"profile"`
+}
+
+// GroupProfile is the profile of an Okta group
+type GroupProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Group is an Okta group
+type Group struct {
+	ID                    string       `json:"id"`
+	Type                  string       `json:"type"`
+	Created               time.Time    `json:"created"`
+	LastUpdated           time.Time    `json:"lastUpdated"`
+	LastMembershipUpdated time.Time    `json:"lastMembershipUpdated"`
+	Profile               GroupProfile `json:"profile"`
+}
+
+// Event is an Okta System Log event
+type Event struct {
+	UUID      string        `json:"uuid"`
+	Published time.Time     `json:"published"`
+	EventType string        `json:"eventType"`
+	Target    []EventTarget `json:"target"`
+}
+
+// EventTarget is an entity affected by an event
+type EventTarget struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	AlternateID string `json:"alternateId"`
+	DisplayName string `json:"displayName"`
+}
+
+// Errors returned by the mutators of Server
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrGroupNotFound = errors.New("group not found")
+)
+
+// Server is a fake of the Okta API. Users and groups are listed in the order
+// they were created, and changes made to them are published as System Log
+// events.
+type Server struct {
+	// PageSize is used for pages of requests which don't set a limit
+	PageSize int
+	// Token, if set, has to be sent as an SSWS API token or a Bearer token
+	Token string
+	// RateLimit is the number of requests allowed to each endpoint per minute,
+	// 0 disables rate limiting.
+	RateLimit int
+	// Now returns the time of changes made to the Server, it defaults to
+	// time.Now.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	lastID   int
+	users    []*User
+	groups   []*Group
+	members  map[string][]string
+	events   []*Event
+	faults   []*Fault
+	buckets  map[string]*rateLimitBucket
+	requests []string
+}
+
+// NewServer creates an empty Server
+func NewServer() *Server {
+	return &Server{
+		members: make(map[string][]string),
+		buckets: make(map[string]*rateLimitBucket),
+	}
+}
+
+func (s *Server) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// newID returns an ID with the given prefix, like Okta IDs start with 00u for
+// users and 00g for groups.
+func (s *Server) newID(prefix string) string {
+	s.lastID++
+	return fmt.Sprintf("%s%017d", prefix, s.lastID)
+}
+
+func (s *Server) publish(eventType string, targets ...EventTarget) {
+	s.events = append(s.events, &Event{
+		UUID:      s.newID("evt"),
+		Published: s.now(),
+		EventType: eventType,
+		Target:    targets,
+	})
+}
+
+func userTarget(user *User) EventTarget {
+	login, _ := user.Profile["login"].(string)
+	if login == "" {
+		login, _ = user.Profile["email"].(string)
+	}
+	return EventTarget{ID: user.ID, Type: "User", AlternateID: login, DisplayName: login}
+}
+
+func groupTarget(group *Group) EventTarget {
+	return EventTarget{ID: group.ID, Type: "UserGroup", AlternateID: group.Profile.Name, DisplayName: group.Profile.Name}
+}
+
+// AddUser adds a user and returns it. The ID is generated if it's not set, the
+// status is ACTIVE by default.
+func (s *Server) AddUser(user User) User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user.ID == "" {
+		user.ID = s.newID("00u")
+	}
+	if user.Status == "" {
+		user.Status = "ACTIVE"
+	}
+	if user.Created.IsZero() {
+		user.Created = s.now()
+	}
+	if user.LastUpdated.IsZero() {
+		user.LastUpdated = user.Created
+	}
+	if user.Profile == nil {
+		user.Profile = make(map[string]interface{})
+	}
+	s.users = append(s.users, &user)
+	s.publish("user.lifecycle.create", userTarget(&user))
+	return user
+}
+
+// UpdateUser sets attributes of the profile of a user
+func (s *Server) UpdateUser(id string, profile map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.findUser(id)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	for name, value := range profile {
+		user.Profile[name] = value
+	}
+	user.LastUpdated = s.now()
+	s.publish("user.account.update_profile", userTarget(user))
+	return nil
+}
+
+// lifecycleEvents are the events published when users reach a status
+var lifecycleEvents = map[string]string{
+	"ACTIVE":        "user.lifecycle.activate",
+	"SUSPENDED":     "user.lifecycle.suspend",
+	"DEPROVISIONED": "user.lifecycle.deactivate",
+	"PROVISIONED":   "user.lifecycle.reactivate",
+}
+
+// SetUserStatus changes the status of a user
+func (s *Server) SetUserStatus(id, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.findUser(id)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	eventType, ok := lifecycleEvents[status]
+	if !ok {
+		return errors.New("unknown user status " + status)
+	}
+	if user.Status == "SUSPENDED" && status == "ACTIVE" {
+		eventType = "user.lifecycle.unsuspend"
+	}
+	user.Status = status
+	user.LastUpdated = s.now()
+	s.publish(eventType, userTarget(user))
+	return nil
+}
+
+// DeleteUser removes a user and its group memberships
+func (s *Server) DeleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.findUser(id)
+	if user == nil {
+		return ErrUserNotFound
+	}
+	for i := range s.users {
+		if s.users[i] == user {
+			s.users = append(s.users[:i], s.users[i+1:]...)
+			break
+		}
+	}
+	for groupID := range s.members {
+		s.members[groupID] = without(s.members[groupID], user.ID)
+	}
+	s.publish("user.lifecycle.delete.completed", userTarget(user))
+	return nil
+}
+
+// AddGroup adds a group and returns it. The ID is generated if it's not set.
+func (s *Server) AddGroup(group Group) Group {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if group.ID == "" {
+		group.ID = s.newID("00g")
+	}
+	if group.Type == "" {
+		group.Type = "OKTA_GROUP"
+	}
+	if group.Created.IsZero() {
+		group.Created = s.now()
+	}
+	if group.LastUpdated.IsZero() {
+		group.LastUpdated = group.Created
+	}
+	if group.LastMembershipUpdated.IsZero() {
+		group.LastMembershipUpdated = group.Created
+	}
+	s.groups = append(s.groups, &group)
+	return group
+}
+
+// DeleteGroup removes a group
+func (s *Server) DeleteGroup(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, group := range s.groups {
+		if group.ID == id {
+			s.groups = append(s.groups[:i], s.groups[i+1:]...)
+			delete(s.members, id)
+			return nil
+		}
+	}
+	return ErrGroupNotFound
+}
+
+// AddMember adds a user, by ID, login or email, to a group
+func (s *Server) AddMember(groupID, userID string) error {
+	return s.setMember(groupID, userID, true)
+}
+
+// RemoveMember removes a user, by ID, login or email, from a group
+func (s *Server) RemoveMember(groupID, userID string) error {
+	return s.setMember(groupID, userID, false)
+}
+
+func (s *Server) setMember(groupID, userID string, member bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group := s.findGroup(groupID)
+	if group == nil {
+		return ErrGroupNotFound
+	}
+	user := s.findUser(userID)
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	members := without(s.members[group.ID], user.ID)
+	eventType := "group.user_membership.remove"
+	if member {
+		members = append(members, user.ID)
+		eventType = "group.user_membership.add"
+	}
+	s.members[group.ID] = members
+	group.LastMembershipUpdated = s.now()
+	s.publish(eventType, userTarget(user), groupTarget(group))
+	return nil
+}
+
+func without(ids []string, id string) []string {
+	filtered := ids[:0:0]
+	for _, other := range ids {
+		if other != id {
+			filtered = append(filtered, other)
+		}
+	}
+	return filtered
+}
+
+// findUser returns a user by ID, login or email
+func (s *Server) findUser(id string) *User {
+	for _, user := range s.users {
+		if user.ID == id {
+			return user
+		}
+		for _, attribute := range []string{"login", "email"} {
+			if value, ok := user.Profile[attribute].(string); ok && strings.EqualFold(value, id) {
+				return user
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) findGroup(id string) *Group {
+	for _, group := range s.groups {
+		if group.ID == id {
+			return group
+		}
+	}
+	return nil
+}
+
+// Requests returns the requests served so far, as the method, the path without
+// the API prefix, and the query.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// ServeHTTP serves the Okta API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, basePath)
+	request := r.Method + " " + path
+	if r.URL.RawQuery != "" {
+		request += "?" + r.URL.RawQuery
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	fault := s.fault(path)
+	s.mu.Unlock()
+
+	if fault != nil && fault.Latency > 0 {
+		select {
+		case <-time.After(fault.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.rateLimit(w, path) {
+		return
+	}
+	if fault != nil && fault.Status != 0 {
+		writeFault(w, fault.Status)
+		return
+	}
+	if s.Token != "" {
+		authorization := r.Header.Get("Authorization")
+		if authorization != "SSWS "+s.Token && authorization != "Bearer "+s.Token {
+			writeError(w, http.StatusUnauthorized, "E0000011", "Invalid token provided")
+			return
+		}
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "E0000022", "The endpoint does not support the provided HTTP method")
+		return
+	}
+
+	s.route(w, r, strings.Split(strings.Trim(path, "/"), "/"))
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request, segments []string) {
+	switch {
+	case len(segments) == 1 && segments[0] == "users":
+		s.listUsers(w, r)
+	case len(segments) == 2 && segments[0] == "users":
+		user := s.findUser(segments[1])
+		if user == nil {
+			writeError(w, http.StatusNotFound, "E0000007", "Not found: Resource not found: "+segments[1]+" (User)")
+			return
+		}
+		writeJSON(w, user)
+	case len(segments) == 3 && segments[0] == "users" && segments[2] == "groups":
+		s.listUserGroups(w, r, segments[1])
+	case len(segments) == 1 && segments[0] == "groups":
+		s.listGroups(w, r)
+	case len(segments) == 2 && segments[0] == "groups":
+		group := s.findGroup(segments[1])
+		if group == nil {
+			writeError(w, http.StatusNotFound, "E0000007", "Not found: Resource not found: "+segments[1]+" (UserGroup)")
+			return
+		}
+		writeJSON(w, group)
+	case len(segments) == 3 && segments[0] == "groups" && segments[2] == "users":
+		s.listGroupMembers(w, r, segments[1])
+	case len(segments) == 1 && segments[0] == "logs":
+		s.listEvents(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "E0000022", "The endpoint does not exist")
+	}
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	matched := make([]interface{}, 0, len(s.users))
+	for _, user := range s.users {
+		ok, err := matchFilter(r.URL.Query().Get("filter"), userAttribute(user))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "E0000031", "Invalid search criteria.")
+			return
+		}
+		if ok {
+			matched = append(matched, user)
+		}
+	}
+	s.writePage(w, r, matched, func(i int) string { return matched[i].(*User).ID })
+}
+
+func (s *Server) listUserGroups(w http.ResponseWriter, r *http.Request, id string) {
+	user := s.findUser(id)
+	if user == nil {
+		writeError(w, http.StatusNotFound, "E0000007", "Not found: Resource not found: "+id+" (User)")
+		return
+	}
+
+	groups := make([]interface{}, 0)
+	for _, group := range s.groups {
+		for _, member := range s.members[group.ID] {
+			if member == user.ID {
+				groups = append(groups, group)
+			}
+		}
+	}
+	s.writePage(w, r, groups, func(i int) string { return groups[i].(*Group).ID })
+}
+
+func (s *Server) listGroups(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	matched := make([]interface{}, 0, len(s.groups))
+	for _, group := range s.groups {
+		if q := query.Get("q"); q != "" && !strings.HasPrefix(strings.ToLower(group.Profile.Name), strings.ToLower(q)) {
+			continue
+		}
+		ok, err := matchFilter(query.Get("filter"), groupAttribute(group))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "E0000031", "Invalid search criteria.")
+			return
+		}
+		if ok {
+			matched = append(matched, group)
+		}
+	}
+	s.writePage(w, r, matched, func(i int) string { return matched[i].(*Group).ID })
+}
+
+func (s *Server) listGroupMembers(w http.ResponseWriter, r *http.Request, id string) {
+	group := s.findGroup(id)
+	if group == nil {
+		writeError(w, http.StatusNotFound, "E0000007", "Not found: Resource not found: "+id+" (UserGroup)")
+		return
+	}
+
+	members := make([]interface{}, 0, len(s.members[group.ID]))
+	for _, userID := range s.members[group.ID] {
+		members = append(members, s.findUser(userID))
+	}
+	s.writePage(w, r, members, func(i int) string { return members[i].(*User).ID })
+}
+
+// listEvents lists events published since and before until, which default to
+// 15 minutes ago and now.
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since, until := s.now().Add(-15*time.Minute), s.now().Add(time.Second)
+	for name, bound := range map[string]*time.Time{"since": &since, "until": &until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: "+name)
+				return
+			}
+			*bound = parsed
+		}
+	}
+
+	events := make([]interface{}, 0)
+	for _, event := range s.events {
+		if event.Published.Before(since) || !event.Published.Before(until) {
+			continue
+		}
+		ok, err := matchFilter(query.Get("filter"), eventAttribute(event))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "E0000031", "Invalid search criteria.")
+			return
+		}
+		if ok {
+			events = append(events, event)
+		}
+	}
+	if query.Get("sortOrder") == "DESCENDING" {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+	s.writePage(w, r, events, func(i int) string { return events[i].(*Event).UUID })
+}
+
+// writePage writes the page of items requested by the limit and after query
+// parameters, after is the ID of the last item of the previous page. Links to
+// the page and to the next page are set like Okta does.
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, items []interface{}, id func(int) string) {
+	query := r.URL.Query()
+	limit := s.PageSize
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: limit")
+			return
+		}
+		limit = parsed
+	}
+
+	start := 0
+	if after := query.Get("after"); after != "" {
+		for i := range items {
+			if id(i) == after {
+				start = i + 1
+			}
+		}
+	}
+	end := start + limit
+	if end > len(items) {
+		end = len(items)
+	}
+
+	w.Header().Add("Link", "<"+pageURL(r, query)+`>; rel="self"`)
+	if end < len(items) {
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("after", id(end-1))
+		w.Header().Add("Link", "<"+pageURL(r, query)+`>; rel="next"`)
+	}
+	writeJSON(w, items[start:end])
+}
+
+func pageURL(r *http.Request, query gourl.Values) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	url := scheme + "://" + r.Host + r.URL.Path
+	if encoded := query.Encode(); encoded != "" {
+		url += "?" + encoded
+	}
+	return url
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error in the format of Okta errors
+func writeError(w http.ResponseWriter, status int, code, summary string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errorCode":    code,
+		"errorSummary": summary,
+		"errorLink":    code,
+		"errorId":      "oae" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		"errorCauses":  []interface{}{},
+	})
+}
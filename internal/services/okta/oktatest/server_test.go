@@ -0,0 +1,191 @@
+package oktatest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	gourl "net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, url string, result interface{}) *http.Response {
+	res, err := http.Get(url) //nolint:gosec,noctx // URL of the test server
+	require.NoError(t, err)
+	defer res.Body.Close()
+	if result != nil && res.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(result))
+	}
+	return res
+}
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	fake := NewServer()
+	require.NoError(t, fake.Load([]byte(`{
+		"users": [
+			{"profile": {"login": "alice@kiwi.com", "email": "alice@kiwi.com"}},
+			{"profile": {"login": "bob@kiwi.com", "email": "bob@kiwi.com"}, "status": "SUSPENDED"},
+			{"profile": {"login": "carol@kiwi.com", "email": "carol@kiwi.com"}}
+		],
+		"groups": [
+			{"profile": {"name": "iam-service.read"}, "members": ["alice@kiwi.com", "bob@kiwi.com"]},
+			{"profile": {"name": "vpn-users"}, "members": ["alice@kiwi.com"]}
+		]
+	}`)))
+	return fake, httptest.NewServer(fake)
+}
+
+func TestPaging(t *testing.T) {
+	_, ts := newTestServer(t)
+	defer ts.Close()
+
+	var users []User
+	res := get(t, ts.URL+"/api/v1/users?limit=2", &users)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Len(t, users, 2)
+	assert.Equal(t, "alice@kiwi.com", users[0].Profile["login"])
+
+	links := res.Header["Link"]
+	require.Len(t, links, 2)
+	assert.Equal(t, "<"+ts.URL+`/api/v1/users?limit=2>; rel="self"`, links[0])
+	next := ts.URL + "/api/v1/users?after=" + gourl.QueryEscape(users[1].ID) + "&limit=2"
+	assert.Equal(t, "<"+next+`>; rel="next"`, links[1])
+
+	res = get(t, next, &users)
+	require.Len(t, users, 1)
+	assert.Equal(t, "carol@kiwi.com", users[0].Profile["login"])
+	assert.Len(t, res.Header["Link"], 1, "The last page has no next link")
+}
+
+func TestResources(t *testing.T) {
+	fake, ts := newTestServer(t)
+	defer ts.Close()
+
+	var user User
+	get(t, ts.URL+"/api/v1/users/alice@kiwi.com", &user)
+	assert.Equal(t, "ACTIVE", user.Status)
+	assert.Equal(t, http.StatusNotFound, get(t, ts.URL+"/api/v1/users/dave@kiwi.com", nil).StatusCode)
+
+	var groups []Group
+	get(t, ts.URL+"/api/v1/users/"+user.ID+"/groups", &groups)
+	require.Len(t, groups, 2)
+	get(t, ts.URL+"/api/v1/groups?q=iam-", &groups)
+	require.Len(t, groups, 1)
+	assert.Equal(t, "iam-service.read", groups[0].Profile.Name)
+
+	var members []User
+	get(t, ts.URL+"/api/v1/groups/"+groups[0].ID+"/users", &members)
+	assert.Len(t, members, 2)
+
+	require.NoError(t, fake.RemoveMember(groups[0].ID, "bob@kiwi.com"))
+	get(t, ts.URL+"/groups/"+groups[0].ID+"/users", &members)
+	assert.Len(t, members, 1, "Paths are served without the API prefix too")
+	assert.Equal(t, ErrUserNotFound, fake.AddMember(groups[0].ID, "dave@kiwi.com"))
+}
+
+func TestFilters(t *testing.T) {
+	fake, ts := newTestServer(t)
+	defer ts.Close()
+
+	var users []User
+	get(t, ts.URL+"/api/v1/users?filter="+gourl.QueryEscape(`status eq "SUSPENDED"`), &users)
+	require.Len(t, users, 1)
+	assert.Equal(t, "bob@kiwi.com", users[0].Profile["login"])
+
+	since := time.Now().UTC()
+	time.Sleep(time.Millisecond)
+	require.NoError(t, fake.UpdateUser(users[0].ID, map[string]interface{}{"firstName": "Bob"}))
+	filter := `lastUpdated gt "` + since.Format(time.RFC3339Nano) + `"`
+	get(t, ts.URL+"/api/v1/users?filter="+gourl.QueryEscape(filter), &users)
+	require.Len(t, users, 1)
+	assert.Equal(t, "Bob", users[0].Profile["firstName"])
+
+	res := get(t, ts.URL+"/api/v1/users?filter="+gourl.QueryEscape(`status eq`), nil)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	var events []Event
+	get(t, ts.URL+"/api/v1/logs?sortOrder=DESCENDING&filter="+gourl.QueryEscape(`eventType sw "user.account."`), &events)
+	require.Len(t, events, 1)
+	assert.Equal(t, "user.account.update_profile", events[0].EventType)
+}
+
+func TestEvents(t *testing.T) {
+	fake := NewServer()
+	published := time.Date(2020, 7, 15, 10, 0, 0, 0, time.UTC)
+	fake.Now = func() time.Time { return published }
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	user := fake.AddUser(User{Profile: map[string]interface{}{"login": "alice@kiwi.com"}})
+	group := fake.AddGroup(Group{Profile: GroupProfile{Name: "iam-service.read"}})
+	require.NoError(t, fake.AddMember(group.ID, user.ID))
+	published = published.Add(time.Minute)
+	require.NoError(t, fake.SetUserStatus(user.ID, "SUSPENDED"))
+	require.NoError(t, fake.SetUserStatus(user.ID, "ACTIVE"))
+
+	var events []Event
+	get(t, ts.URL+"/api/v1/logs?since=2020-07-15T10:00:30.0Z&until=2020-07-15T11:00:00.0Z", &events)
+	require.Len(t, events, 2)
+	assert.Equal(t, "user.lifecycle.suspend", events[0].EventType)
+	assert.Equal(t, "user.lifecycle.unsuspend", events[1].EventType)
+	assert.Equal(t, []EventTarget{{ID: user.ID, Type: "User", AlternateID: "alice@kiwi.com", DisplayName: "alice@kiwi.com"}},
+		events[0].Target)
+}
+
+func TestFaults(t *testing.T) {
+	fake, ts := newTestServer(t)
+	defer ts.Close()
+
+	fake.AddFault(Fault{Path: "/groups", Status: http.StatusServiceUnavailable, Times: 2})
+	assert.Equal(t, http.StatusOK, get(t, ts.URL+"/api/v1/users", nil).StatusCode, "Faults apply to their path")
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, ts.URL+"/api/v1/groups", nil).StatusCode)
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, ts.URL+"/api/v1/groups", nil).StatusCode)
+	assert.Equal(t, http.StatusOK, get(t, ts.URL+"/api/v1/groups", nil).StatusCode, "Faults apply a number of times")
+
+	fake.AddFault(Fault{Status: http.StatusTooManyRequests})
+	res := get(t, ts.URL+"/api/v1/users", nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "0", res.Header.Get("X-Rate-Limit-Remaining"))
+	fake.ClearFaults()
+
+	fake.AddFault(Fault{Latency: 20 * time.Millisecond})
+	start := time.Now()
+	assert.Equal(t, http.StatusOK, get(t, ts.URL+"/api/v1/users", nil).StatusCode)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond, "Requests are delayed")
+}
+
+func TestRateLimit(t *testing.T) {
+	fake, ts := newTestServer(t)
+	defer ts.Close()
+	fake.RateLimit = 2
+
+	var users []User
+	res := get(t, ts.URL+"/api/v1/users/alice@kiwi.com", nil)
+	assert.Equal(t, "2", res.Header.Get("X-Rate-Limit-Limit"))
+	assert.Equal(t, "1", res.Header.Get("X-Rate-Limit-Remaining"))
+	get(t, ts.URL+"/api/v1/users/bob@kiwi.com", nil)
+	res = get(t, ts.URL+"/api/v1/users/carol@kiwi.com", nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode, "Users share the rate limit of the endpoint")
+
+	res = get(t, ts.URL+"/api/v1/users", &users)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "Endpoints have their own rate limit")
+}
+
+func TestToken(t *testing.T) {
+	fake, ts := newTestServer(t)
+	defer ts.Close()
+	fake.Token = "secret"
+
+	assert.Equal(t, http.StatusUnauthorized, get(t, ts.URL+"/api/v1/users", nil).StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/users", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "SSWS secret")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"GET /users", "GET /users"}, fake.Requests())
+}
@@ -1,6 +1,8 @@
 package okta
 
 import (
+	"errors"
+	"net/http"
 	"regexp"
 	"strings"
 )
@@ -8,11 +10,16 @@ import (
 var oktaLinkPattern = regexp.MustCompile(`(?:<)(.*)(?:>)`)
 
 func (c *Client) fetchResource(url string) (*Response, error) {
+	token, err := c.authorization()
+	if err != nil {
+		return &Response{}, err
+	}
+
 	var request = Request{
 		Method: "GET",
 		URL:    url,
 		Body:   nil,
-		Token:  c.authToken,
+		Token:  token,
 	}
 
 	httpResponse, err := c.fetch(request)
@@ -20,37 +27,87 @@ func (c *Client) fetchResource(url string) (*Response, error) {
 		return &Response{}, err
 	}
 
+	if httpResponse.StatusCode == http.StatusUnauthorized && c.oauth != nil {
+		// The access token might have been revoked, request a new one next time.
+		c.oauth.invalidate()
+	}
+
 	return httpResponse, nil
 }
 
-func (c *Client) fetchPagedResource(url string) ([]string, error) {
-	var responses []string
-	hasNext := true
+// pageIterator walks through a paged Okta collection one page at a time, each
+// page is decoded as it arrives so only the current page is held in memory.
+type pageIterator struct {
+	client *Client
+	url    string
+	err    error
+}
 
-	for hasNext {
-		hasNext = false
+// iteratePages returns an iterator over the pages of the collection at url.
+func (c *Client) iteratePages(url string) *pageIterator {
+	return &pageIterator{client: c, url: url}
+}
 
-		response, err := c.fetchResource(url)
-		if err != nil {
-			return nil, err
-		}
+// Next fetches the next page and decodes it into page, which should be a
+// pointer to a slice. It returns false once all pages were read, or when
+// fetching a page failed, in which case Err returns the error.
+func (it *pageIterator) Next(page interface{}) bool {
+	if it.url == "" || it.err != nil {
+		return false
+	}
 
-		linkHeader := response.Header["Link"]
-		for _, link := range linkHeader {
-			if strings.Contains(link, "rel=\"next\"") {
-				url = oktaLinkPattern.FindStringSubmatch(link)[1]
-				if url != "" {
-					hasNext = true
-				}
-			}
-		}
+	response, err := it.client.fetchResource(it.url)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	if response.StatusCode != http.StatusOK {
+		discard(response)
+		it.err = errors.New("GET " + it.url + " returned error: " + response.Status)
+		return false
+	}
 
-		str, err := response.String()
-		if err != nil {
-			return nil, err
-		}
-		responses = append(responses, str)
+	next := nextPageURL(response.Header)
+	if err := response.JSON(page); err != nil {
+		it.err = err
+		return false
+	}
+
+	it.url = next
+	return true
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (it *pageIterator) Err() error {
+	return it.err
+}
+
+// Cursor returns the URL of the next page to be fetched, or an empty string
+// when all pages were read. After a failure it points to the page which
+// failed, so the iteration can be resumed with iteratePages(Cursor()).
+func (it *pageIterator) Cursor() string {
+	return it.url
+}
+
+// fetchPage fetches the page at url into page, and returns the URL of the next
+// page, or an empty string for the last page.
+func (c *Client) fetchPage(url string, page interface{}) (string, error) {
+	pages := c.iteratePages(url)
+	if !pages.Next(page) {
+		return "", pages.Err()
 	}
+	return pages.Cursor(), nil
+}
 
-	return responses, nil
+// nextPageURL returns the URL of the next page from the Link headers of an Okta
+// response, or an empty string for the last page.
+func nextPageURL(header http.Header) string {
+	for _, link := range header["Link"] {
+		if strings.Contains(link, "rel=\"next\"") {
+			if match := oktaLinkPattern.FindStringSubmatch(link); match != nil {
+				return match[1]
+			}
+		}
+	}
+	return ""
 }
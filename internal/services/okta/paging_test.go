@@ -0,0 +1,95 @@
+package okta
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagedHandler serves the given pages, linking each page to the next one with
+// an Okta style Link header. Pages listed in failing respond with an error.
+func pagedHandler(pages []string, failing map[string]bool, requests *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("after")
+		if requests != nil {
+			*requests = append(*requests, page)
+		}
+		if failing[page] {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		index := 0
+		if page != "" {
+			index = int(page[0] - '0')
+		}
+		if index+1 < len(pages) {
+			next := "http://" + r.Host + r.URL.Path + "?after=" + string(rune('0'+index+1))
+			w.Header().Add("Link", "<http://"+r.Host+r.URL.String()+">; rel=\"self\"")
+			w.Header().Add("Link", "<"+next+">; rel=\"next\"")
+		}
+		_, _ = w.Write([]byte(pages[index]))
+	}
+}
+
+func TestPageIterator(t *testing.T) {
+	ts := httptest.NewServer(pagedHandler([]string{`["a","b"]`, `["c"]`, `[]`}, nil, nil))
+	defer ts.Close()
+	c := NewClient(&ClientOpts{BaseURL: ts.URL})
+
+	var all []string
+	var page []string
+	pages := c.iteratePages(ts.URL)
+	for pages.Next(&page) {
+		all = append(all, page...)
+	}
+
+	assert.NoError(t, pages.Err())
+	assert.Equal(t, []string{"a", "b", "c"}, all)
+	assert.Equal(t, "", pages.Cursor(), "Cursor is empty after the last page")
+	assert.False(t, pages.Next(&page), "Iterator is exhausted")
+}
+
+func TestPageIteratorResume(t *testing.T) {
+	failing := map[string]bool{"1": true}
+	ts := httptest.NewServer(pagedHandler([]string{`["a","b"]`, `["c"]`, `["d"]`}, failing, nil))
+	defer ts.Close()
+	c := NewClient(&ClientOpts{BaseURL: ts.URL})
+
+	var all []string
+	var page []string
+	pages := c.iteratePages(ts.URL)
+	for pages.Next(&page) {
+		all = append(all, page...)
+	}
+
+	require.Error(t, pages.Err())
+	assert.Equal(t, []string{"a", "b"}, all)
+	assert.Equal(t, ts.URL+"/?after=1", pages.Cursor(), "Cursor points to the page that failed")
+
+	delete(failing, "1")
+	pages = c.iteratePages(pages.Cursor())
+	for pages.Next(&page) {
+		all = append(all, page...)
+	}
+
+	assert.NoError(t, pages.Err())
+	assert.Equal(t, []string{"a", "b", "c", "d"}, all)
+}
+
+func TestListGroupsPagesDoNotLeak(t *testing.T) {
+	ts := httptest.NewServer(pagedHandler([]string{
+		`[{"id":"1","profile":{"name":"iam-service.read","description":"Read"}}]`,
+		`[{"id":"2","profile":{"name":"iam-service.write"}}]`,
+	}, nil, nil))
+	defer ts.Close()
+	c := NewClient(&ClientOpts{BaseURL: ts.URL})
+
+	groups, err := c.ListGroups()
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, "", groups[1].Description, "Fields missing in a page are not taken from the previous one")
+}
@@ -0,0 +1,189 @@
+package okta
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Types Okta profile attributes can be coerced to
+const (
+	AttributeString  = "string"
+	AttributeInt     = "int"
+	AttributeFloat   = "float"
+	AttributeBool    = "bool"
+	AttributeStrings = "strings"
+)
+
+// Visibility of mapped profile attributes
+const (
+	// VisibilityPublic attributes are returned by the REST and gRPC APIs
+	VisibilityPublic = "public"
+	// VisibilityPrivate attributes are only used internally
+	VisibilityPrivate = "private"
+)
+
+// ProfileAttribute maps an Okta profile attribute to an attribute of users
+type ProfileAttribute struct {
+	// Name of the attribute in user attributes
+	Name string `json:"name"`
+	// Source is the name of the attribute in the Okta profile
+	Source string `json:"source"`
+	// Type the value is coerced to, string by default
+	Type string `json:"type"`
+	// Default is used when the Okta attribute is missing or can't be coerced
+	Default interface{} `json:"default"`
+	// Visibility is public by default
+	Visibility string `json:"visibility"`
+}
+
+// ParseProfileAttributes parses a JSON list of profile attribute mappings,
+// ie. [{"name": "costCenter", "source": "SF_costCenter", "type": "int"}]
+func ParseProfileAttributes(mapping string) ([]ProfileAttribute, error) {
+	if strings.TrimSpace(mapping) == "" {
+		return nil, nil
+	}
+
+	var attributes []ProfileAttribute
+	if err := json.Unmarshal([]byte(mapping), &attributes); err != nil {
+		return nil, errors.New("invalid profile attributes: " + err.Error())
+	}
+
+	names := make(map[string]bool, len(attributes))
+	for i := range attributes {
+		attribute := &attributes[i]
+		if attribute.Name == "" || attribute.Source == "" {
+			return nil, errors.New("profile attribute " + strconv.Itoa(i) + " needs a name and a source")
+		}
+		if names[attribute.Name] {
+			return nil, errors.New("duplicate profile attribute " + attribute.Name)
+		}
+		names[attribute.Name] = true
+
+		if attribute.Type == "" {
+			attribute.Type = AttributeString
+		}
+		switch attribute.Type {
+		case AttributeString, AttributeInt, AttributeFloat, AttributeBool, AttributeStrings:
+		default:
+			return nil, errors.New("profile attribute " + attribute.Name + " has an unknown type " + attribute.Type)
+		}
+
+		if attribute.Visibility == "" {
+			attribute.Visibility = VisibilityPublic
+		}
+		if attribute.Visibility != VisibilityPublic && attribute.Visibility != VisibilityPrivate {
+			return nil, errors.New("profile attribute " + attribute.Name + " has an unknown visibility " + attribute.Visibility)
+		}
+
+		if attribute.Default != nil {
+			value, ok := coerceAttribute(attribute.Default, attribute.Type)
+			if !ok {
+				return nil, errors.New("default of profile attribute " + attribute.Name + " is not a " + attribute.Type)
+			}
+			attribute.Default = value
+		}
+	}
+
+	return attributes, nil
+}
+
+// mapProfileAttributes returns the public and private attributes of a user
+// given by the mapping.
+func mapProfileAttributes(mapping []ProfileAttribute, profile map[string]interface{}) (public, private map[string]interface{}) {
+	for i := range mapping {
+		attribute := &mapping[i]
+
+		value, ok := coerceAttribute(profile[attribute.Source], attribute.Type)
+		if !ok {
+			if attribute.Default == nil {
+				continue
+			}
+			value = attribute.Default
+		}
+
+		if attribute.Visibility == VisibilityPrivate {
+			if private == nil {
+				private = make(map[string]interface{})
+			}
+			private[attribute.Name] = value
+		} else {
+			if public == nil {
+				public = make(map[string]interface{})
+			}
+			public[attribute.Name] = value
+		}
+	}
+	return public, private
+}
+
+// coerceAttribute converts a value decoded from JSON to the given type. It
+// returns false for missing values and values which can't be converted.
+func coerceAttribute(value interface{}, attributeType string) (interface{}, bool) {
+	if value == nil {
+		return nil, false
+	}
+
+	switch attributeType {
+	case AttributeString:
+		switch v := value.(type) {
+		case string:
+			return v, true
+		case float64, bool:
+			return fmt.Sprint(v), true
+		}
+
+	case AttributeInt:
+		switch v := value.(type) {
+		case float64:
+			if v == float64(int64(v)) {
+				return int64(v), true
+			}
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return i, true
+			}
+		}
+
+	case AttributeFloat:
+		switch v := value.(type) {
+		case float64:
+			return v, true
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, true
+			}
+		}
+
+	case AttributeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, true
+			}
+		}
+
+	case AttributeStrings:
+		switch v := value.(type) {
+		case string:
+			return []string{v}, true
+		case []string:
+			return v, true
+		case []interface{}:
+			values := make([]string, 0, len(v))
+			for _, item := range v {
+				s, ok := coerceAttribute(item, AttributeString)
+				if !ok {
+					return nil, false
+				}
+				values = append(values, s.(string))
+			}
+			return values, true
+		}
+	}
+
+	return nil, false
+}
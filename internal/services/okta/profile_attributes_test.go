@@ -0,0 +1,94 @@
+package okta
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProfileAttributes(t *testing.T) {
+	attributes, err := ParseProfileAttributes("")
+	assert.NoError(t, err)
+	assert.Nil(t, attributes)
+
+	attributes, err = ParseProfileAttributes(`[
+		{"name": "costCenter", "source": "SF_costCenter", "type": "int", "default": "0"},
+		{"name": "nickName", "source": "nickName", "visibility": "private"}
+	]`)
+	require.NoError(t, err)
+	assert.Equal(t, []ProfileAttribute{
+		{Name: "costCenter", Source: "SF_costCenter", Type: AttributeInt, Default: int64(0), Visibility: VisibilityPublic},
+		{Name: "nickName", Source: "nickName", Type: AttributeString, Visibility: VisibilityPrivate},
+	}, attributes, "Defaults are coerced and types and visibility defaulted")
+
+	invalid := []string{
+		`{"name": "notAList"}`,
+		`[{"name": "noSource"}]`,
+		`[{"name": "a", "source": "a"}, {"name": "a", "source": "b"}]`,
+		`[{"name": "a", "source": "a", "type": "date"}]`,
+		`[{"name": "a", "source": "a", "visibility": "secret"}]`,
+		`[{"name": "a", "source": "a", "type": "int", "default": "zero"}]`,
+	}
+	for _, mapping := range invalid {
+		_, err := ParseProfileAttributes(mapping)
+		assert.Error(t, err, mapping)
+	}
+}
+
+func TestCoerceAttribute(t *testing.T) {
+	tests := []struct {
+		value         interface{}
+		attributeType string
+		want          interface{}
+		ok            bool
+	}{
+		{"text", AttributeString, "text", true},
+		{float64(12), AttributeString, "12", true},
+		{float64(12), AttributeInt, int64(12), true},
+		{" 12 ", AttributeInt, int64(12), true},
+		{float64(1.5), AttributeInt, nil, false},
+		{"1.5", AttributeFloat, 1.5, true},
+		{"true", AttributeBool, true, true},
+		{"yes", AttributeBool, nil, false},
+		{"one", AttributeStrings, []string{"one"}, true},
+		{[]interface{}{"one", float64(2)}, AttributeStrings, []string{"one", "2"}, true},
+		{nil, AttributeString, nil, false},
+	}
+
+	for _, test := range tests {
+		got, ok := coerceAttribute(test.value, test.attributeType)
+		assert.Equal(t, test.ok, ok, "%v as %s", test.value, test.attributeType)
+		assert.Equal(t, test.want, got, "%v as %s", test.value, test.attributeType)
+	}
+}
+
+func TestFetchUserProfileAttributes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"00u1","status":"ACTIVE","profile":{
+			"email":"test@kiwi.com",
+			"SF_costCenter":"1234",
+			"remote":"maybe",
+			"nickName":"Tester"
+		}}`))
+	}))
+	defer ts.Close()
+
+	mapping, err := ParseProfileAttributes(`[
+		{"name": "costCenter", "source": "SF_costCenter", "type": "int"},
+		{"name": "remote", "source": "remote", "type": "bool", "default": false},
+		{"name": "building", "source": "building"},
+		{"name": "nickName", "source": "nickName", "visibility": "private"}
+	]`)
+	require.NoError(t, err)
+	client := NewClient(&ClientOpts{BaseURL: ts.URL, ProfileAttributes: mapping})
+
+	user, err := client.GetUser("test@kiwi.com")
+	require.NoError(t, err)
+	assert.Equal(t, "test@kiwi.com", user.Email, "Known attributes are still decoded")
+	assert.Equal(t, map[string]interface{}{"costCenter": int64(1234), "remote": false}, user.Attributes,
+		"Invalid values are replaced by defaults, missing values without a default are left out")
+	assert.Equal(t, map[string]interface{}{"nickName": "Tester"}, user.PrivateAttributes)
+}
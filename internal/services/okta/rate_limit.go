@@ -0,0 +1,286 @@
+package okta
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cfg "github.com/kiwicom/iam/configs"
+	"github.com/kiwicom/iam/internal/monitoring"
+)
+
+// Headers used by Okta to report the rate limit of an endpoint.
+// https://developer.okta.com/docs/reference/rl-best-practices/
+const (
+	rateLimitLimitHeader     = "X-Rate-Limit-Limit"
+	rateLimitRemainingHeader = "X-Rate-Limit-Remaining"
+	rateLimitResetHeader     = "X-Rate-Limit-Reset"
+)
+
+// oktaResources are path segments which are part of an endpoint name, any other
+// segment is considered to be an ID and does not create a new rate limit bucket.
+var oktaResources = map[string]bool{
+	"api":    true,
+	"v1":     true,
+	"users":  true,
+	"groups": true,
+	"logs":   true,
+	"oauth2": true,
+	"token":  true,
+}
+
+// rateLimitBucket holds the last known rate limit of an Okta endpoint.
+type rateLimitBucket struct {
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// rateLimiter keeps track of the rate limits reported by Okta, and delays
+// requests which would use more than the allowed share of an endpoint budget.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]rateLimitBucket
+	share   float64
+	metrics *monitoring.Metrics
+	now     func() time.Time
+}
+
+func newRateLimiter(share float64, metrics *monitoring.Metrics) *rateLimiter {
+	if share <= 0 || share > 1 {
+		share = 1
+	}
+
+	return &rateLimiter{
+		buckets: make(map[string]rateLimitBucket),
+		share:   share,
+		metrics: metrics,
+		now:     time.Now,
+	}
+}
+
+// reserve returns how long a request to the given endpoint has to wait before
+// being sent. When no wait is needed, the request is counted against the
+// remaining budget so that concurrent requests are paced as well.
+func (l *rateLimiter) reserve(endpoint string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[endpoint]
+	if !ok {
+		return 0
+	}
+
+	now := l.now()
+	if !now.Before(bucket.reset) {
+		// The rate limit window is over, the budget is replenished.
+		delete(l.buckets, endpoint)
+		return 0
+	}
+
+	reserved := int(math.Round(float64(bucket.limit) * (1 - l.share)))
+	if bucket.remaining <= reserved {
+		return bucket.reset.Sub(now)
+	}
+
+	bucket.remaining--
+	l.buckets[endpoint] = bucket
+	return 0
+}
+
+// update stores the rate limit reported by Okta in the response headers.
+func (l *rateLimiter) update(endpoint string, header http.Header) {
+	limit, limitErr := strconv.Atoi(header.Get(rateLimitLimitHeader))
+	remaining, remainingErr := strconv.Atoi(header.Get(rateLimitRemainingHeader))
+	reset, resetErr := strconv.ParseInt(header.Get(rateLimitResetHeader), 10, 64)
+	if limitErr != nil || remainingErr != nil || resetErr != nil {
+		return
+	}
+
+	l.mu.Lock()
+	l.buckets[endpoint] = rateLimitBucket{
+		limit:     limit,
+		remaining: remaining,
+		reset:     time.Unix(reset, 0),
+	}
+	l.mu.Unlock()
+
+	l.metrics.Gauge("okta.rate_limit.remaining", float64(remaining), monitoring.Tag("endpoint", endpoint))
+}
+
+// resetIn returns the time left until the rate limit window of the given
+// endpoint is over, or 0 if it's unknown.
+func (l *rateLimiter) resetIn(endpoint string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[endpoint]
+	if !ok {
+		return 0
+	}
+	if wait := bucket.reset.Sub(l.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// rateLimitEndpoint returns the name of the rate limit bucket for a URL,
+// ie. /api/v1/users/{id}/groups
+func rateLimitEndpoint(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, segment := range segments {
+		if !oktaResources[segment] {
+			segments[i] = "{id}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// retryPolicy defines how requests failing with a transient error are retried.
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	// maxWait bounds the total time a request spends waiting for the rate
+	// limit or between retries, 0 means no bound.
+	maxWait time.Duration
+	sleep   func(time.Duration)
+}
+
+func newRetryPolicy(oktaConfig *cfg.OktaConfig) retryPolicy {
+	policy := retryPolicy{sleep: time.Sleep}
+	if oktaConfig != nil {
+		policy.maxRetries = oktaConfig.MaxRetries
+		policy.baseDelay = oktaConfig.RetryBaseDelay
+		policy.maxDelay = oktaConfig.RetryMaxDelay
+		policy.maxWait = oktaConfig.MaxWait
+	}
+	return policy
+}
+
+// backoff returns a jittered exponential delay for the given attempt.
+func (p *retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay << uint(attempt)
+	if delay <= 0 || (p.maxDelay > 0 && delay > p.maxDelay) {
+		delay = p.maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	// Equal jitter: wait at least half of the delay, so retries don't get too
+	// aggressive, and randomize the rest to spread retries from many requests.
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1)) //nolint:gosec // jitter doesn't need crypto/rand
+}
+
+func isIdempotent(method string) bool {
+	return method == "" || method == http.MethodGet || method == http.MethodHead
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryReason returns why a request should be retried, or an empty string when
+// it shouldn't.
+func retryReason(res *Response, err error) string {
+	if err != nil {
+		if isTimeout(err) {
+			return "timeout"
+		}
+		return ""
+	}
+	if res == nil || res.Response == nil {
+		return ""
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		return "rate_limit"
+	}
+	if res.StatusCode >= http.StatusInternalServerError {
+		return "server_error"
+	}
+	return ""
+}
+
+// discard reads and closes the body of a response which won't be used.
+func discard(res *Response) {
+	if res == nil || res.Response == nil || res.Body == nil {
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+}
+
+// exceedsMaxWait returns whether waiting another delay after having waited
+// for waited already goes over the maximum wait of the policy.
+func (p *retryPolicy) exceedsMaxWait(waited, delay time.Duration) bool {
+	return p.maxWait > 0 && waited+delay > p.maxWait
+}
+
+// rateLimitedFetcher wraps a Fetcher to stay within the Okta rate limits, and
+// to retry idempotent requests failing with 429, 5xx or a timeout. Requests
+// which would wait longer than the maximum wait of the policy fail with
+// ErrUpstreamUnavailable instead, so callers don't outlive their own deadline.
+func rateLimitedFetcher(fetch Fetcher, limiter *rateLimiter, policy retryPolicy, metrics *monitoring.Metrics) Fetcher {
+	return func(req Request) (*Response, error) {
+		endpoint := rateLimitEndpoint(req.URL)
+		var waited time.Duration
+
+		for attempt := 0; ; attempt++ {
+			if wait := limiter.reserve(endpoint); wait > 0 {
+				if policy.exceedsMaxWait(waited, wait) {
+					metrics.Incr("okta.rate_limit.exhausted", monitoring.Tag("endpoint", endpoint))
+					return nil, fmt.Errorf("okta rate limit budget used for %s, resets in %s: %w", endpoint, wait, ErrUpstreamUnavailable)
+				}
+				log.Println("Okta rate limit budget used for", endpoint, "waiting", wait)
+				metrics.Incr("okta.rate_limit.wait", monitoring.Tag("endpoint", endpoint))
+				policy.sleep(wait)
+				waited += wait
+			}
+
+			res, err := fetch(req)
+			if err == nil && res != nil && res.Response != nil {
+				limiter.update(endpoint, res.Header)
+			}
+
+			reason := retryReason(res, err)
+			if reason == "" || attempt >= policy.maxRetries || !isIdempotent(req.Method) {
+				return res, err
+			}
+
+			delay := policy.backoff(attempt)
+			if reason == "rate_limit" {
+				if resetIn := limiter.resetIn(endpoint); resetIn > delay {
+					delay = resetIn
+				}
+			}
+
+			discard(res)
+			if policy.exceedsMaxWait(waited, delay) {
+				return nil, fmt.Errorf("%s %s not retried in %s (%s): %w", req.Method, req.URL, delay, reason, ErrUpstreamUnavailable)
+			}
+			log.Println("Retrying", req.Method, req.URL, "in", delay, "reason:", reason)
+			metrics.Incr("outgoing.retries", monitoring.Tag("service", "okta"), monitoring.Tag("reason", reason))
+			policy.sleep(delay)
+			waited += delay
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package okta
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/kiwicom/iam/configs"
+)
+
+func TestRateLimitEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"https://example.okta.com/api/v1/users/":                          "/api/v1/users",
+		"https://example.okta.com/api/v1/users/user@kiwi.com":             "/api/v1/users/{id}",
+		"https://example.okta.com/api/v1/users/00u1/groups?filter=x":      "/api/v1/users/{id}/groups",
+		"https://example.okta.com/api/v1/groups/00g1/users?after=00u1&x=": "/api/v1/groups/{id}/users",
+	}
+
+	for rawURL, expected := range tests {
+		assert.Equal(t, expected, rateLimitEndpoint(rawURL), rawURL)
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := newRateLimiter(0.8, nil)
+	limiter.now = func() time.Time { return now }
+
+	assert.Equal(t, time.Duration(0), limiter.reserve("/users"), "Unknown budget doesn't delay requests")
+
+	header := http.Header{}
+	header.Set(rateLimitLimitHeader, "100")
+	header.Set(rateLimitRemainingHeader, "21")
+	header.Set(rateLimitResetHeader, "1030")
+	limiter.update("/users", header)
+
+	assert.Equal(t, time.Duration(0), limiter.reserve("/users"), "Budget share is not used yet")
+	assert.Equal(t, 30*time.Second, limiter.reserve("/users"), "Waits until reset once the share is used")
+	assert.Equal(t, time.Duration(0), limiter.reserve("/groups"), "Endpoints have separate budgets")
+
+	now = time.Unix(1030, 0)
+	assert.Equal(t, time.Duration(0), limiter.reserve("/users"), "Budget is replenished after reset")
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+
+	for attempt, expected := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		delay := policy.backoff(attempt)
+		assert.True(t, delay >= expected*time.Millisecond/2, "attempt %d: %s", attempt, delay)
+		assert.True(t, delay <= expected*time.Millisecond, "attempt %d: %s", attempt, delay)
+	}
+}
+
+func newRetryTestClient(ts *httptest.Server) (*Client, *[]time.Duration) {
+	var sleeps []time.Duration
+	policy := newRetryPolicy(&cfg.OktaConfig{
+		MaxRetries:     2,
+		RetryBaseDelay: 10 * time.Millisecond,
+		RetryMaxDelay:  100 * time.Millisecond,
+	})
+	policy.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+
+	c := NewClient(&ClientOpts{BaseURL: ts.URL})
+	c.fetch = rateLimitedFetcher(defaultFetcher("", "okta", nil), newRateLimiter(1, nil), policy, nil)
+	return c, &sleeps
+}
+
+func TestRetriesTransientErrors(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("Okay"))
+	}))
+	defer ts.Close()
+	c, sleeps := newRetryTestClient(ts)
+
+	res, err := c.fetchResource(c.baseURL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 3, calls)
+	assert.Len(t, *sleeps, 2)
+}
+
+func TestRetriesGiveUp(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	c, _ := newRetryTestClient(ts)
+
+	res, err := c.fetchResource(c.baseURL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode, "Last response is returned")
+	assert.Equal(t, 3, calls, "Request is attempted once plus the number of retries")
+}
+
+func TestRetriesRateLimitUntilReset(t *testing.T) {
+	reset := time.Now().Add(time.Minute).Unix()
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set(rateLimitLimitHeader, "600")
+		w.Header().Set(rateLimitResetHeader, strconv.FormatInt(reset, 10))
+		if calls == 1 {
+			w.Header().Set(rateLimitRemainingHeader, "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set(rateLimitRemainingHeader, "599")
+		_, _ = w.Write([]byte("Okay"))
+	}))
+	defer ts.Close()
+	c, sleeps := newRetryTestClient(ts)
+
+	res, err := c.fetchResource(c.baseURL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	require.NotEmpty(t, *sleeps)
+	assert.True(t, (*sleeps)[0] > 50*time.Second, "Waits for the rate limit reset instead of the backoff")
+}
+
+func TestNoRetriesForNonIdempotentRequests(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	c, sleeps := newRetryTestClient(ts)
+
+	res, err := c.fetch(Request{Method: http.MethodPost, URL: c.baseURL})
+	require.NoError(t, err)
+	discard(res)
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, *sleeps)
+}
+
+func TestRateLimitWaitIsBounded(t *testing.T) {
+	reset := time.Now().Add(time.Minute).Unix()
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set(rateLimitLimitHeader, "600")
+		w.Header().Set(rateLimitRemainingHeader, "0")
+		w.Header().Set(rateLimitResetHeader, strconv.FormatInt(reset, 10))
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	c, sleeps := newRetryTestClient(ts)
+	c.fetch = rateLimitedFetcher(defaultFetcher("", "okta", nil), newRateLimiter(1, nil), retryPolicy{
+		maxRetries: 2,
+		baseDelay:  10 * time.Millisecond,
+		maxWait:    5 * time.Second,
+		sleep:      func(d time.Duration) { *sleeps = append(*sleeps, d) },
+	}, nil)
+
+	_, err := c.fetchResource(c.baseURL)
+	assert.True(t, errors.Is(err, ErrUpstreamUnavailable), "Fails instead of waiting for the reset")
+	assert.Empty(t, *sleeps)
+	assert.Equal(t, 1, calls)
+
+	_, err = c.fetchResource(c.baseURL)
+	assert.True(t, errors.Is(err, ErrUpstreamUnavailable), "Used budget isn't waited for either")
+	assert.Equal(t, 1, calls, "Request is not sent while the budget is used")
+}
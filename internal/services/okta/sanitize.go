@@ -0,0 +1,211 @@
+package okta
+
+import (
+	"bytes"
+	gojson "encoding/json"
+	"net/http"
+	gourl "net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CassetteHost replaces the host of the Okta organization in cassettes
+const CassetteHost = "okta.example.com"
+
+// redacted replaces secrets in cassettes
+const redacted = "REDACTED"
+
+// DefaultPIIAttributes are the attributes of Okta resources replaced by
+// pseudonyms in cassettes. Emails are replaced wherever they appear.
+var DefaultPIIAttributes = []string{
+	"firstName", "lastName", "middleName", "displayName", "nickName",
+	"manager", "managerId", "boocsek_team_manager", "boocsek_kiwibase_id",
+	"employeeNumber", "mobilePhone", "primaryPhone",
+	"streetAddress", "city", "zipCode", "postalAddress",
+}
+
+// secretAttributes are the attributes of Okta resources replaced by a
+// placeholder in cassettes.
+var secretAttributes = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"token":         true,
+	"client_secret": true,
+	"password":      true,
+}
+
+// recordedHeaders are the response headers kept in cassettes
+var recordedHeaders = []string{
+	"Content-Type",
+	"Link",
+	rateLimitLimitHeader,
+	rateLimitRemainingHeader,
+	rateLimitResetHeader,
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@([A-Za-z0-9\-]+\.)+[A-Za-z]{2,}`)
+
+// sanitizer replaces personal data with pseudonyms. The same value is always
+// replaced by the same pseudonym, so references between recorded resources,
+// like group members and users, are kept.
+type sanitizer struct {
+	mu            sync.Mutex
+	piiAttributes map[string]bool
+	pseudonyms    map[string]string
+	hosts         map[string]bool
+}
+
+func newSanitizer(piiAttributes []string) *sanitizer {
+	s := &sanitizer{
+		piiAttributes: make(map[string]bool),
+		pseudonyms:    make(map[string]string),
+		hosts:         make(map[string]bool),
+	}
+	for _, attribute := range append(DefaultPIIAttributes, piiAttributes...) {
+		s.piiAttributes[attribute] = true
+	}
+	return s
+}
+
+// pseudonym returns the pseudonym of a value, numbers are replaced by numbers
+func (s *sanitizer) pseudonym(value string, number bool) string {
+	key := value
+	if number {
+		key = "number:" + value
+	}
+	if pseudonym, ok := s.pseudonyms[key]; ok {
+		return pseudonym
+	}
+	n := strconv.Itoa(len(s.pseudonyms) + 1)
+	pseudonym := "person-" + n
+	if number {
+		pseudonym = n
+	}
+	s.pseudonyms[key] = pseudonym
+	return pseudonym
+}
+
+func (s *sanitizer) email(email string) string {
+	domain := email[strings.LastIndexByte(email, '@'):]
+	key := strings.ToLower(email)
+	if pseudonym, ok := s.pseudonyms[key]; ok {
+		return pseudonym
+	}
+	pseudonym := "user-" + strconv.Itoa(len(s.pseudonyms)+1) + strings.ToLower(domain)
+	s.pseudonyms[key] = pseudonym
+	return pseudonym
+}
+
+// text replaces emails and Okta hosts in free text
+func (s *sanitizer) text(text string) string {
+	text = emailPattern.ReplaceAllStringFunc(text, s.email)
+	for host := range s.hosts {
+		text = strings.Replace(text, host, CassetteHost, -1)
+	}
+	return text
+}
+
+// URL sanitizes a request URL, the Okta host is replaced and query parameters
+// are sorted.
+func (s *sanitizer) URL(rawURL string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := gourl.Parse(rawURL)
+	if err != nil {
+		return s.text(rawURL)
+	}
+	if u.Host != "" {
+		s.hosts[u.Host] = true
+		u.Host = CassetteHost
+	}
+	u.Path = s.text(u.Path)
+	u.RawPath = ""
+	query := u.Query()
+	for name, values := range query {
+		for i := range values {
+			values[i] = s.text(values[i])
+		}
+		query[name] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Header returns the sanitized headers kept in cassettes
+func (s *sanitizer) Header(header http.Header) http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sanitized := http.Header{}
+	for _, name := range recordedHeaders {
+		for _, value := range header[http.CanonicalHeaderKey(name)] {
+			sanitized.Add(name, s.text(value))
+		}
+	}
+	return sanitized
+}
+
+// Body sanitizes a response body. Attributes of JSON bodies holding personal
+// data or secrets are replaced, other bodies are sanitized as text.
+func (s *sanitizer) Body(body []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return s.text(string(body))
+	}
+
+	sanitized, err := json.Marshal(s.value("", value))
+	if err != nil {
+		return s.text(string(body))
+	}
+	return string(sanitized)
+}
+
+func (s *sanitizer) value(attribute string, value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		// Attributes are sanitized in order, so pseudonyms don't change between
+		// recordings of the same data.
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value[name] = s.value(name, value[name])
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = s.value(attribute, item)
+		}
+		return value
+	case string:
+		switch {
+		case secretAttributes[attribute]:
+			return redacted
+		case s.piiAttributes[attribute] && value != "":
+			if emailPattern.MatchString(value) {
+				return s.text(value)
+			}
+			return s.pseudonym(value, false)
+		}
+		return s.text(value)
+	case gojson.Number:
+		// Numbers are decoded by jsoniter as numbers of encoding/json
+		if s.piiAttributes[attribute] {
+			return gojson.Number(s.pseudonym(value.String(), true))
+		}
+		return value
+	}
+	return value
+}
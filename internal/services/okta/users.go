@@ -2,8 +2,13 @@ package okta
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	gourl "net/url"
+	"time"
+
+	"github.com/kiwicom/iam/internal/services/directory"
 )
 
 type oktaUserProfile struct {
@@ -28,13 +33,33 @@ type oktaUserProfile struct {
 	BoocsekKiwibaseID  int32    `json:"boocsek_kiwibase_id"`
 	BoocsekSubstate    string   `json:"boocsek_substate"`
 	BoocsekSkills      []string `json:"boocsek_skills"`
+
+	// attributes contains the whole profile, for attributes mapped in config
+	attributes map[string]interface{}
+}
+
+// UnmarshalJSON decodes the known attributes of a profile and keeps all of them
+// for the configured profile attribute mapping.
+func (p *oktaUserProfile) UnmarshalJSON(data []byte) error {
+	type profile oktaUserProfile
+	if err := json.Unmarshal(data, (*profile)(p)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &p.attributes)
+}
+
+// oktaUser is a user as returned by the Okta API
+type oktaUser struct {
+	ID      string
+	Status  string
+	Profile oktaUserProfile
 }
 
-func formatUser(oktaID string, user *oktaUserProfile) User {
+func formatUser(oktaID, status string, user *oktaUserProfile, mapping []ProfileAttribute) directory.User {
 	teamMembership := append(make([]string, 0), user.SfOrgStructure) // Deprecated
 	skills := append(make([]string, 0), user.BoocsekSkills...)
 
-	boocsekAttributes := BoocsekAttributes{
+	boocsekAttributes := directory.BoocsekAttributes{
 		Site:        user.BoocsekSite,
 		Position:    user.BoocsekPosition,
 		Channel:     user.BoocsekChannel,
@@ -48,8 +73,11 @@ func formatUser(oktaID string, user *oktaUserProfile) User {
 		Skills:      skills,
 	}
 
-	return User{
-		OktaID:                oktaID,
+	attributes, privateAttributes := mapProfileAttributes(mapping, user.attributes)
+
+	return directory.User{
+		ID:                    oktaID,
+		Status:                status,
 		EmployeeNumber:        user.EmployeeNumber,
 		FirstName:             user.FirstName,
 		LastName:              user.LastName,
@@ -62,85 +90,71 @@ func formatUser(oktaID string, user *oktaUserProfile) User {
 		OrganizationStructure: user.SfOrgStructure,
 		Manager:               user.Manager,
 		BoocsekAttributes:     boocsekAttributes,
+		Attributes:            attributes,
+		PrivateAttributes:     privateAttributes,
 	}
 }
 
-// ErrUserNotFound is returned when a user is not present in Okta
-var ErrUserNotFound = errors.New("user not found")
-
-// fetchUser retrieves a user from Okta by email
-func (c *Client) fetchUser(email string) (User, error) {
+// GetUser retrieves a user from Okta by email
+func (c *Client) GetUser(email string) (directory.User, error) {
 	userURL, err := joinURL(c.baseURL, "/users/", email)
 	if err != nil {
-		return User{}, err
-	}
-
-	var response struct {
-		ID      string
-		Profile oktaUserProfile
-	}
-	var request = Request{
-		Method: "GET",
-		URL:    userURL,
-		Body:   nil,
-		Token:  c.authToken,
+		return directory.User{}, err
 	}
 
-	httpResponse, err := c.fetch(request)
+	var response oktaUser
+	httpResponse, err := c.fetchResource(userURL)
 	if err != nil {
-		return User{}, err
+		return directory.User{}, err
 	}
 	if httpResponse.StatusCode == http.StatusNotFound {
-		return User{}, ErrUserNotFound
+		discard(httpResponse)
+		return directory.User{}, directory.ErrUserNotFound
 	}
 	if httpResponse.StatusCode != http.StatusOK {
 		var errorMessage = "GET " + userURL + " returned error: " + httpResponse.Status
 		log.Println(errorMessage)
-		return User{}, errors.New(errorMessage)
+		discard(httpResponse)
+		return directory.User{}, errors.New(errorMessage)
 	}
 
 	jsonErr := httpResponse.JSON(&response)
 	if jsonErr != nil {
-		return User{}, jsonErr
+		return directory.User{}, jsonErr
 	}
 
-	var user = formatUser(response.ID, &response.Profile)
+	var user = formatUser(response.ID, response.Status, &response.Profile, c.profileAttributes)
 	return user, nil
 }
 
-// fetchAllUsers retrieves all Okta users
-func (c *Client) fetchAllUsers() ([]User, error) {
-	var allUsers []User
-
-	url, err := joinURL(c.baseURL, "/users/")
-	if err != nil {
-		return nil, err
+// ListUsers returns a page of Okta users. The cursor is the URL of the page.
+func (c *Client) ListUsers(since time.Time, cursor string) ([]directory.User, string, error) {
+	if cursor == "" {
+		url, err := joinURL(c.baseURL, "/users/")
+		if err != nil {
+			return nil, "", err
+		}
+		if !since.IsZero() {
+			url += "?filter=" + gourl.QueryEscape("lastUpdated gt \""+oktaTimeFormat(since)+"\"")
+		}
+		cursor = url
 	}
 
-	responses, err := c.fetchPagedResource(url)
+	var resources []oktaUser
+	next, err := c.fetchPage(cursor, &resources)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	for _, response := range responses {
-		var resources []struct {
-			ID      string
-			Profile oktaUserProfile
-		}
-
-		jsonErr := json.UnmarshalFromString(response, &resources)
-		if jsonErr != nil {
-			return nil, jsonErr
-		}
-
-		users := make([]User, len(resources))
-		for i := range resources {
-			user := &resources[i]
-			users[i] = formatUser(user.ID, &user.Profile)
-		}
-
-		allUsers = append(allUsers, users...)
+	users := make([]directory.User, len(resources))
+	for i := range resources {
+		users[i] = formatUser(resources[i].ID, resources[i].Status, &resources[i].Profile, c.profileAttributes)
 	}
+	return users, next, nil
+}
 
-	return allUsers, err
+func oktaTimeFormat(t time.Time) string {
+	return fmt.Sprintf("%d-%02d-%02dT%02d:%02d:%02d.0Z",
+		t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second())
 }
@@ -0,0 +1,62 @@
+package okta
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kiwicom/iam/internal/services/directory"
+)
+
+func TestListUsers(t *testing.T) {
+	pages := []string{
+		`[{"id":"1","status":"ACTIVE","profile":{"email":"user1@kiwi.com"}}]`,
+		`[{"id":"2","status":"DEPROVISIONED","profile":{"email":"user2@kiwi.com"}}]`,
+	}
+	failing := map[string]bool{"1": true}
+	var requests []string
+	ts := httptest.NewServer(pagedHandler(pages, failing, &requests))
+	defer ts.Close()
+	client := NewClient(&ClientOpts{BaseURL: ts.URL})
+
+	users, cursor, err := client.ListUsers(time.Time{}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []directory.User{formatUser("1", directory.StatusActive, &oktaUserProfile{Email: "user1@kiwi.com"}, nil)}, users)
+	assert.Equal(t, ts.URL+"/users?after=1", cursor, "Cursor is the URL of the next page")
+
+	_, _, err = client.ListUsers(time.Time{}, cursor)
+	assert.Error(t, err)
+
+	delete(failing, "1")
+	users, cursor, err = client.ListUsers(time.Time{}, cursor)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "user2@kiwi.com", users[0].Email)
+	assert.Equal(t, directory.StatusDeprovisioned, users[0].Status)
+	assert.Equal(t, "", cursor, "Cursor is empty after the last page")
+	assert.Equal(t, []string{"", "1", "1"}, requests)
+}
+
+func TestListUsersSince(t *testing.T) {
+	var filters []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filters = append(filters, r.URL.Query().Get("filter"))
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer ts.Close()
+	client := NewClient(&ClientOpts{BaseURL: ts.URL})
+
+	_, _, err := client.ListUsers(time.Time{}, "")
+	require.NoError(t, err)
+	_, _, err = client.ListUsers(time.Date(2020, 7, 15, 10, 36, 33, 0, time.UTC), "")
+	require.NoError(t, err)
+
+	require.Len(t, filters, 2)
+	assert.Equal(t, "", filters[0], "All users are listed without a time")
+	assert.True(t, strings.HasPrefix(filters[1], `lastUpdated gt "2020-07-15T10:36:33`), "Only updated users are listed")
+}
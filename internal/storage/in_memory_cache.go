@@ -57,6 +57,16 @@ func (c InMemoryCache) Set(key string, value interface{}, ttl time.Duration) err
 	return nil
 }
 
+// SetNX writes data to cache only if the key is not present, it returns
+// whether the data was written. `key` is case insensitive.
+func (c InMemoryCache) SetNX(key string, value interface{}, ttl time.Duration) (bool, error) {
+	data, ok := c[strings.ToLower(key)]
+	if ok && (data.expiration.IsZero() || time.Now().Before(data.expiration)) {
+		return false, nil
+	}
+	return true, c.Set(key, value, ttl)
+}
+
 // Del deletes an item from cache
 func (c InMemoryCache) Del(key string) error {
 	lowerKey := strings.ToLower(key)
@@ -83,3 +83,23 @@ func TestExpiration(t *testing.T) {
 		assert.Equal(t, ErrNotFound, err)
 	}
 }
+
+func TestSetNX(t *testing.T) {
+	var value string
+	cache := NewInMemoryCache()
+
+	set, err := cache.SetNX("Key", "first", time.Millisecond*50)
+	assert.NoError(t, err)
+	assert.True(t, set)
+
+	set, err = cache.SetNX("key", "second", 0)
+	assert.NoError(t, err)
+	assert.False(t, set, "Present items are not overwritten")
+	assert.NoError(t, cache.Get("key", &value))
+	assert.Equal(t, "first", value)
+
+	time.Sleep(time.Millisecond * 50)
+	set, err = cache.SetNX("key", "third", 0)
+	assert.NoError(t, err)
+	assert.True(t, set, "Expired items are overwritten")
+}
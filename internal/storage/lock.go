@@ -13,16 +13,23 @@ type cache interface {
 	Del(key string) error
 }
 
+// lockCache is a cache able to set an item only if it's not present, so that
+// only one instance can create a lock
+type lockCache interface {
+	cache
+	SetNX(key string, value interface{}, ttl time.Duration) (bool, error)
+}
+
 // LockManager manage locks using Cache, to prevent multiple expensive actions to
 // be run at the same time.
 type LockManager struct {
-	cache      cache
+	cache      lockCache
 	retryDelay time.Duration
 	expiration time.Duration
 }
 
 // NewLockManager initializes and returns a LockManager for Redis
-func NewLockManager(cache cache, retryDelay, expiration time.Duration) *LockManager {
+func NewLockManager(cache lockCache, retryDelay, expiration time.Duration) *LockManager {
 	return &LockManager{
 		cache:      cache,
 		retryDelay: retryDelay,
@@ -39,33 +46,61 @@ var ErrLockExists = errors.New("lock was not created because one was already pre
 // the function will not create one, it will wait until the existing one is
 // deleted or expired before returning ErrLockExists.
 func (l *LockManager) Create(name string) error {
+	created, err := l.create(name)
+	if created || err != nil {
+		return nil
+	}
+
+	// Wait for the existing lock to expire or be deleted.
 	var lock time.Time
-	var exists bool
 	key := "lock:" + name
-
-	// Check if a lock already exists.
-	err := l.cache.Get(key, &lock)
-	for err == nil {
-		// If it does, wait for it to expire or be deleted.
-		exists = true
+	for err = l.cache.Get(key, &lock); err == nil; err = l.cache.Get(key, &lock) {
 		time.Sleep(l.retryDelay)
-		err = l.cache.Get(key, &lock)
-	}
-	if exists {
-		return ErrLockExists
 	}
-	if err != ErrNotFound {
-		err = errors.Wrap(err, "error checking if a lock exists")
-		raven.CaptureError(err, nil)
+	return ErrLockExists
+}
+
+// minLockBackoff is the first delay between attempts to acquire a lock
+const minLockBackoff = 10 * time.Millisecond
+
+// Acquire creates a lock, waiting until other instances holding it delete it
+// or it expires. Attempts are spaced by an exponential backoff up to the retry
+// delay.
+func (l *LockManager) Acquire(name string) {
+	delay := minLockBackoff
+	for {
+		created, err := l.create(name)
+		if created || err != nil {
+			return
+		}
+		if delay > l.retryDelay {
+			delay = l.retryDelay
+		}
+		time.Sleep(delay)
+		delay *= 2
 	}
+}
 
-	lock = time.Now()
-	err = l.cache.Set(key, lock, l.expiration)
+// create sets the lock only if it's not present, it returns whether it was
+// created. Errors are reported and returned, callers go on without the lock
+// rather than blocking when the cache is failing.
+func (l *LockManager) create(name string) (bool, error) {
+	created, err := l.cache.SetNX("lock:"+name, time.Now(), l.expiration)
 	if err != nil {
 		err = errors.Wrap(err, "error creating lock")
 		raven.CaptureError(err, nil)
 	}
-	return nil
+	return created, err
+}
+
+// Refresh sets the expiration of a lock held by the caller to ttl from now,
+// so that it's not lost by long-running work. It must not be called for a
+// lock held by someone else.
+func (l *LockManager) Refresh(name string, ttl time.Duration) {
+	if err := l.cache.Set("lock:"+name, time.Now(), ttl); err != nil {
+		err = errors.Wrap(err, "error refreshing lock")
+		raven.CaptureError(err, nil)
+	}
 }
 
 // Delete removes a lock for the provided name.
@@ -38,6 +38,12 @@ func (c *mockCache) Set(key string, value interface{}, ttl time.Duration) error
 	}
 	return nil
 }
+func (c *mockCache) SetNX(key string, value interface{}, ttl time.Duration) (bool, error) {
+	if v, ok := c.data[key]; ok && time.Since(v.expiration) <= 0 {
+		return false, nil
+	}
+	return true, c.Set(key, value, ttl)
+}
 func (c *mockCache) Del(key string) error {
 	delete(c.data, key)
 	return nil
@@ -76,6 +82,29 @@ func TestCreate(t *testing.T) {
 	assert.Equal(t, false, ok)
 }
 
+func TestAcquire(t *testing.T) {
+	cache, lock := newMocks()
+
+	lock.Acquire("test")
+	_, ok := cache.data["lock:test"]
+	assert.True(t, ok)
+
+	// The lock is acquired once the existing one expires
+	start := time.Now()
+	lock.Acquire("test")
+	assert.True(t, time.Since(start) >= 50*time.Millisecond, "Waits for the existing lock")
+	_, ok = cache.data["lock:test"]
+	assert.True(t, ok, "Lock is created after waiting")
+}
+
+func TestRefresh(t *testing.T) {
+	cache, lock := newMocks()
+
+	assert.Nil(t, lock.Create("test"))
+	lock.Refresh("test", time.Hour)
+	assert.True(t, time.Until(cache.data["lock:test"].expiration) > time.Minute, "Expiration is extended")
+}
+
 func TestDelete(t *testing.T) {
 	cache, lock := newMocks()
 
@@ -84,6 +84,25 @@ func (c *RedisCache) Set(key string, value interface{}, ttl time.Duration) error
 	return err
 }
 
+// SetNX writes data to cache with the specified lifespan only if the key is
+// not present, it returns whether the data was written.
+// `key` is case insensitive.
+func (c *RedisCache) SetNX(key string, value interface{}, ttl time.Duration) (bool, error) {
+	strVal, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+
+	lowerKey := c.cacheKey(key)
+	set, err := c.client.SetNX(lowerKey, strVal, ttl).Result()
+	if shouldUseRedisBackup(err) {
+		log.Println("Redis down using inMemory SETNX")
+		raven.CaptureMessage("Redis down using inMemory SETNX", nil)
+		return c.backup.SetNX(key, value, ttl)
+	}
+	return set, err
+}
+
 // Del deletes an item from cache
 func (c *RedisCache) Del(key string) error {
 	lowerKey := c.cacheKey(key)
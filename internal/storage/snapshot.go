@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"strconv"
+
+	"github.com/getsentry/raven-go"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// SnapshotManager keeps generation-numbered snapshots of a set of keys. Each
+// snapshot is written in its own generation namespace and published by
+// flipping a single pointer, so readers see either the old or the new
+// snapshot, never a mix of both. The last published generations are retained
+// to roll back to.
+type SnapshotManager struct {
+	cache  cache
+	name   string
+	retain int
+}
+
+// snapshotPointer is the value of the pointer to the published generation
+type snapshotPointer struct {
+	Current int64 `json:"current"`
+	// Generations are the retained generations, newest first
+	Generations []int64 `json:"generations"`
+}
+
+// ErrNoSnapshot is returned when rolling back without an older generation.
+var ErrNoSnapshot = errors.New("no snapshot to roll back to")
+
+// NewSnapshotManager initializes and returns a SnapshotManager. Keys of the
+// snapshots are prefixed with name, retain is the number of published
+// generations which are kept.
+func NewSnapshotManager(cache cache, name string, retain int) *SnapshotManager {
+	if retain < 1 {
+		retain = 1
+	}
+	return &SnapshotManager{
+		cache:  cache,
+		name:   name,
+		retain: retain,
+	}
+}
+
+// Generation returns the published generation, or 0 if no snapshot was
+// published yet.
+func (m *SnapshotManager) Generation() (int64, error) {
+	pointer, err := m.pointer()
+	return pointer.Current, err
+}
+
+// Key returns the key of an item in the given generation. Generation 0 uses
+// plain keys, so data written before snapshots were used is still readable.
+func (m *SnapshotManager) Key(generation int64, key string) string {
+	if generation == 0 {
+		return key
+	}
+	return m.name + ":" + strconv.FormatInt(generation, 10) + ":" + key
+}
+
+func (m *SnapshotManager) pointer() (snapshotPointer, error) {
+	var pointer snapshotPointer
+	err := m.cache.Get(m.name+":generation", &pointer)
+	if err == ErrNotFound {
+		return snapshotPointer{}, nil
+	}
+	return pointer, err
+}
+
+func (m *SnapshotManager) manifestKey(generation int64) string {
+	return m.name + ":manifest:" + strconv.FormatInt(generation, 10)
+}
+
+// Begin starts a new snapshot. Snapshots are not meant to be written
+// concurrently, callers should hold a lock while writing one.
+func (m *SnapshotManager) Begin() (*Snapshot, error) {
+	var latest int64
+	if err := m.cache.Get(m.name+":latest", &latest); err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	// The generation counter is kept apart from the pointer, so that the
+	// generation of a discarded or rolled back snapshot is never reused.
+	latest++
+	if err := m.cache.Set(m.name+":latest", latest, 0); err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{
+		manager:    m,
+		Generation: latest,
+		keys:       make(map[string]bool),
+	}, nil
+}
+
+// BeginCopy starts a new snapshot holding all items of the published one, so
+// that they can be changed without modifying a published or retained
+// generation. Generation 0 has no list of its keys, nothing is copied from it.
+func (m *SnapshotManager) BeginCopy() (*Snapshot, error) {
+	current, err := m.Generation()
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := m.Begin()
+	if err != nil || current == 0 {
+		return snapshot, err
+	}
+
+	var keys []string
+	if err := m.cache.Get(m.manifestKey(current), &keys); err != nil && err != ErrNotFound {
+		snapshot.Discard()
+		return nil, err
+	}
+	for _, key := range keys {
+		var value jsoniter.RawMessage
+		if err := m.cache.Get(m.Key(current, key), &value); err != nil {
+			if err == ErrNotFound {
+				continue
+			}
+			snapshot.Discard()
+			return nil, err
+		}
+		if err := snapshot.Set(key, value); err != nil {
+			snapshot.Discard()
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// Rollback publishes the generation retained before the current one. The
+// generation rolled back from is removed, so it can't be published again by a
+// later rollback.
+func (m *SnapshotManager) Rollback() (int64, error) {
+	pointer, err := m.pointer()
+	if err != nil {
+		return 0, err
+	}
+	if len(pointer.Generations) < 2 {
+		return 0, ErrNoSnapshot
+	}
+
+	dropped := pointer.Generations[0]
+	pointer = snapshotPointer{Current: pointer.Generations[1], Generations: pointer.Generations[1:]}
+	if err := m.cache.Set(m.name+":generation", pointer, 0); err != nil {
+		return 0, err
+	}
+
+	m.pruneReported(dropped)
+	return pointer.Current, nil
+}
+
+// prune removes all keys of a generation.
+func (m *SnapshotManager) prune(generation int64) error {
+	var keys []string
+	if err := m.cache.Get(m.manifestKey(generation), &keys); err != nil && err != ErrNotFound {
+		return err
+	}
+	for _, key := range keys {
+		if err := m.cache.Del(m.Key(generation, key)); err != nil {
+			return err
+		}
+	}
+	return m.cache.Del(m.manifestKey(generation))
+}
+
+// pruneReported removes all keys of a generation which isn't published anymore,
+// failures are only reported as the keys are not read again.
+func (m *SnapshotManager) pruneReported(generation int64) {
+	if err := m.prune(generation); err != nil {
+		err = errors.Wrap(err, "error removing snapshot "+strconv.FormatInt(generation, 10))
+		raven.CaptureError(err, nil)
+	}
+}
+
+// Snapshot is a generation being written. It's not visible to readers until
+// it's published.
+type Snapshot struct {
+	manager    *SnapshotManager
+	Generation int64
+	keys       map[string]bool
+}
+
+// Get reads an item of the snapshot.
+func (s *Snapshot) Get(key string, value interface{}) error {
+	return s.manager.cache.Get(s.manager.Key(s.Generation, key), value)
+}
+
+// Set writes an item of the snapshot. Items don't expire, they are kept for as
+// long as their generation is retained.
+func (s *Snapshot) Set(key string, value interface{}) error {
+	s.keys[key] = true
+	return s.manager.cache.Set(s.manager.Key(s.Generation, key), value, 0)
+}
+
+// Del removes an item from the snapshot.
+func (s *Snapshot) Del(key string) error {
+	delete(s.keys, key)
+	return s.manager.cache.Del(s.manager.Key(s.Generation, key))
+}
+
+// Publish makes the snapshot the one seen by readers, and removes generations
+// which are not retained anymore.
+func (s *Snapshot) Publish() error {
+	m := s.manager
+	keys := make([]string, 0, len(s.keys))
+	for key := range s.keys {
+		keys = append(keys, key)
+	}
+	if err := m.cache.Set(m.manifestKey(s.Generation), keys, 0); err != nil {
+		return err
+	}
+
+	pointer, err := m.pointer()
+	if err != nil {
+		return err
+	}
+	generations := append([]int64{s.Generation}, pointer.Generations...)
+	var pruned []int64
+	if len(generations) > m.retain {
+		pruned = generations[m.retain:]
+		generations = generations[:m.retain]
+	}
+
+	pointer = snapshotPointer{Current: s.Generation, Generations: generations}
+	if err := m.cache.Set(m.name+":generation", pointer, 0); err != nil {
+		return err
+	}
+
+	for _, generation := range pruned {
+		m.pruneReported(generation)
+	}
+	return nil
+}
+
+// Discard removes everything written to an unpublished snapshot.
+func (s *Snapshot) Discard() {
+	for key := range s.keys {
+		if err := s.Del(key); err != nil {
+			raven.CaptureError(err, nil)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readSnapshotValue(t *testing.T, m *SnapshotManager, cache InMemoryCache, key string) string {
+	generation, err := m.Generation()
+	require.NoError(t, err)
+	var value string
+	if err := cache.Get(m.Key(generation, key), &value); err != nil {
+		return ""
+	}
+	return value
+}
+
+func TestSnapshotPublish(t *testing.T) {
+	cache := NewInMemoryCache()
+	m := NewSnapshotManager(cache, "test", 2)
+	require.NoError(t, cache.Set("key", "legacy", 0))
+	assert.Equal(t, "legacy", readSnapshotValue(t, m, cache, "key"), "Plain keys are read before anything is published")
+
+	snapshot, err := m.Begin()
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Set("key", "first"))
+	assert.Equal(t, "legacy", readSnapshotValue(t, m, cache, "key"), "Snapshot is not visible before it's published")
+
+	require.NoError(t, snapshot.Publish())
+	assert.Equal(t, "first", readSnapshotValue(t, m, cache, "key"))
+	assert.True(t, cache[m.Key(snapshot.Generation, "key")].expiration.IsZero(), "Retained snapshots don't expire")
+
+	failed, err := m.Begin()
+	require.NoError(t, err)
+	require.NoError(t, failed.Set("key", "failed"))
+	failed.Discard()
+	var value string
+	assert.Equal(t, ErrNotFound, cache.Get(m.Key(failed.Generation, "key"), &value), "Discarded snapshot is removed")
+
+	for _, v := range []string{"second", "third"} {
+		snapshot, err := m.Begin()
+		require.NoError(t, err)
+		require.NoError(t, snapshot.Set("key", v))
+		require.NoError(t, snapshot.Publish())
+	}
+	assert.Equal(t, "third", readSnapshotValue(t, m, cache, "key"))
+	assert.Equal(t, ErrNotFound, cache.Get(m.Key(1, "key"), &value), "Old generations are pruned")
+}
+
+func TestSnapshotRollback(t *testing.T) {
+	cache := NewInMemoryCache()
+	m := NewSnapshotManager(cache, "test", 2)
+
+	_, err := m.Rollback()
+	assert.Equal(t, ErrNoSnapshot, err)
+
+	for _, v := range []string{"first", "second"} {
+		snapshot, err := m.Begin()
+		require.NoError(t, err)
+		require.NoError(t, snapshot.Set("key", v))
+		require.NoError(t, snapshot.Publish())
+	}
+
+	generation, err := m.Rollback()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), generation)
+	assert.Equal(t, "first", readSnapshotValue(t, m, cache, "key"))
+	var value string
+	assert.Equal(t, ErrNotFound, cache.Get(m.Key(2, "key"), &value), "Generation rolled back from is removed")
+
+	_, err = m.Rollback()
+	assert.Equal(t, ErrNoSnapshot, err, "Only retained generations can be rolled back to")
+
+	snapshot, err := m.Begin()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), snapshot.Generation, "Generations are not reused after a rollback")
+}
+
+func TestSnapshotBeginCopy(t *testing.T) {
+	cache := NewInMemoryCache()
+	m := NewSnapshotManager(cache, "test", 2)
+
+	empty, err := m.BeginCopy()
+	require.NoError(t, err)
+	var value string
+	assert.Equal(t, ErrNotFound, empty.Get("key", &value), "Nothing is copied before a snapshot is published")
+	empty.Discard()
+
+	snapshot, err := m.Begin()
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Set("key", "first"))
+	require.NoError(t, snapshot.Set("other", map[string]bool{"a": true}))
+	require.NoError(t, snapshot.Publish())
+
+	copied, err := m.BeginCopy()
+	require.NoError(t, err)
+	require.NoError(t, copied.Set("key", "changed"))
+	assert.Equal(t, "first", readSnapshotValue(t, m, cache, "key"), "Published snapshot is not modified")
+
+	require.NoError(t, copied.Publish())
+	assert.Equal(t, "changed", readSnapshotValue(t, m, cache, "key"))
+	var other map[string]bool
+	require.NoError(t, cache.Get(m.Key(copied.Generation, "other"), &other))
+	assert.Equal(t, map[string]bool{"a": true}, other, "Other items are copied")
+}